@@ -1,9 +1,48 @@
 package main
 
-import "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/api"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+)
 
 func main() {
-	api := api.NewSMDSApi()
+	config, err := smdsconfig.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	exitOnStartupError(err)
+	//Already validated so this can't fail
+	smdsconfig.ConfigureLogging(config.Logging())
+	api, err := api.NewSMDSApi(config)
+	exitOnStartupError(err)
+
+	if err := api.ListenAndServe(config.ListenAddress()); err != nil {
+		var configErr *smdsconfig.ConfigError
+		if errors.As(err, &configErr) {
+			exitOnStartupError(err)
+		}
+		logging.ForSubsystem(logging.SubsystemApi).Error("Server failed", "err", err)
+		os.Exit(1)
+	}
+}
 
-	api.ListenAndServe(":8080")
+//exitOnStartupError lists every problem when err is a *smdsconfig.ConfigError so they can all be fixed at once
+func exitOnStartupError(err error) {
+	var configErr *smdsconfig.ConfigError
+	if errors.As(err, &configErr) {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:")
+		for _, curProblem := range configErr.Problems {
+			fmt.Fprintln(os.Stderr, "  -", curProblem)
+		}
+		os.Exit(2)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 }
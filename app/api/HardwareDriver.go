@@ -0,0 +1,235 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	diagnosticsapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/diagnostics"
+	faultapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/fault"
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	simulatorapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/simulator"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/environment"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+	env "github.com/ZacharyDuve/apireg/environment"
+	"github.com/gorilla/mux"
+)
+
+const (
+	mockRxDataPath        string = "/switchmachine/mockrxdata"
+	mockRxDataDriverQuery string = "driver"
+
+	driverMountProblemMessage string = "driver mount %q %v"
+)
+
+type mockMount struct {
+	name    string
+	driver  tortoise.MockHardwareDriver
+	trigger chan time.Time
+}
+
+//newHardwareDriver creates every configured driver and mounts them into one composite driver. Any mock drivers get
+//their rx data endpoint registered on rtr and simulators get their control api when those features are on. Mounts
+//that can't be started are all returned together as a *smdsconfig.ConfigError and none of the drivers are left open
+func newHardwareDriver(rtr *mux.Router, config smdsconfig.SMDSConfig) (composite.CompositeDriver, error) {
+	mountConfigs := config.DriverMounts()
+	features := config.Features()
+	mounts := make([]composite.Mount, 0, len(mountConfigs))
+	mockMounts := make([]*mockMount, 0)
+	simulators := make(map[string]tortoise.SimulatorDriver)
+	faultInjectors := make(map[string]tortoise.FaultInjector)
+	busMounts := make([]diagnosticsapi.BusMount, 0, len(mountConfigs))
+	problems := make([]string, 0)
+	for _, curConfig := range mountConfigs {
+		var driver hardware.Driver
+		var err error
+		switch resolveDriverType(curConfig.Type) {
+		case smdsconfig.DriverTypePi:
+			driver, err = tortoise.NewPiTortoiseControllerDriverWithSPIDevPathAndPollInterval(curConfig.SPITxDevPath, curConfig.SPIRxDevPath, config.BusPollInterval())
+		case smdsconfig.DriverTypeMock:
+			mMount := &mockMount{name: curConfig.Name, trigger: make(chan time.Time)}
+			mMount.driver = tortoise.NewMockTortoiseControllerDriverWithExternalRXTrigger(mMount.trigger)
+			mockMounts = append(mockMounts, mMount)
+			faultInjectors[curConfig.Name] = mMount.driver
+			driver = mMount.driver
+		case smdsconfig.DriverTypeSimulator:
+			sim := tortoise.NewSimulatorTortoiseControllerDriver(config.BusPollInterval(),
+				time.Duration(curConfig.SimulatedTravelTimeMillis)*time.Millisecond)
+			driver = sim
+			for id := uint16(0); id < curConfig.SimulatedMachines && err == nil; id++ {
+				err = sim.AttachMachine(switchmachine.Id(id), switchmachine.Position0)
+			}
+			simulators[curConfig.Name] = sim
+			faultInjectors[curConfig.Name] = sim
+		case smdsconfig.DriverTypeReplay:
+			driver, err = newReplayDriver(curConfig)
+		default:
+			err = fmt.Errorf("has unknown type %q", curConfig.Type)
+		}
+		if err == nil && curConfig.BusRecordingPath != "" {
+			err = startBusRecording(driver, curConfig)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf(driverMountProblemMessage, curConfig.Name, err))
+			if driver != nil {
+				driver.Close()
+			}
+			continue
+		}
+		if labelable, canLabel := driver.(tortoise.BusMetricsLabelable); canLabel {
+			labelable.SetBusMetricsMount(curConfig.Name)
+		}
+		if curConfig.VerifyTx {
+			if verifiable, canVerify := driver.(tortoise.TxVerifiable); canVerify {
+				verifiable.SetTxVerification(true, curConfig.TxVerifyRetries)
+			} else {
+				logger.Warn("Driver mount is not able to verify tx", "mount", curConfig.Name)
+			}
+		}
+		if curConfig.TxRefreshIntervalMillis > 0 {
+			if refreshable, canRefresh := driver.(tortoise.TxRefreshable); canRefresh {
+				refreshable.SetTxRefreshInterval(time.Duration(curConfig.TxRefreshIntervalMillis) * time.Millisecond)
+			} else {
+				logger.Warn("Driver mount is not able to refresh tx", "mount", curConfig.Name)
+			}
+		}
+		if curConfig.MaxQueuedUpdates > 0 {
+			if queueable, canQueue := driver.(tortoise.UpdateQueueable); canQueue {
+				queueable.SetMaxQueuedUpdates(curConfig.MaxQueuedUpdates)
+			} else {
+				logger.Warn("Driver mount does not queue updates", "mount", curConfig.Name)
+			}
+		}
+		if diagnosable, canDiagnose := driver.(tortoise.BusDiagnosable); canDiagnose {
+			busMounts = append(busMounts, diagnosticsapi.BusMount{
+				Name:     curConfig.Name,
+				IdOffset: switchmachine.Id(curConfig.IdOffset),
+				NumIds:   curConfig.NumIds(),
+				Driver:   diagnosable,
+			})
+		}
+		mounts = append(mounts, composite.Mount{
+			Name:     curConfig.Name,
+			IdOffset: switchmachine.Id(curConfig.IdOffset),
+			NumIds:   curConfig.NumIds(),
+			Driver:   driver,
+		})
+	}
+
+	var compositeDriver composite.CompositeDriver
+	if len(problems) == 0 {
+		var err error
+		compositeDriver, err = composite.NewCompositeDriver(mounts...)
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		for _, curMount := range mounts {
+			curMount.Driver.Close()
+		}
+		return nil, &smdsconfig.ConfigError{Problems: problems}
+	}
+	if len(mockMounts) > 0 && features.MockRxDataApi {
+		registerMockRxDataHandler(rtr, mockMounts)
+	}
+	if len(simulators) > 0 && features.SimulatorApi {
+		simulatorapi.NewSimulatorHandler(rtr, simulators, features.FaultInjectionApi)
+	}
+	if len(faultInjectors) > 0 && features.FaultInjectionApi {
+		faultapi.NewFaultHandler(rtr, faultInjectors)
+	}
+	diagnosticsapi.NewDiagnosticsHandler(rtr, busMounts)
+	return compositeDriver, nil
+}
+
+func newReplayDriver(mountConfig smdsconfig.DriverMountConfig) (hardware.Driver, error) {
+	replayFile, err := os.Open(mountConfig.ReplayPath)
+	if err != nil {
+		return nil, err
+	}
+	defer replayFile.Close()
+	_, records, err := tortoise.ReadBusRecording(replayFile)
+	if err != nil {
+		return nil, fmt.Errorf("replayPath %q is not a bus recording: %w", mountConfig.ReplayPath, err)
+	}
+	replayDriver := tortoise.NewReplayTortoiseControllerDriver(records, mountConfig.ReplaySpeed)
+	go func() {
+		<-replayDriver.Done()
+		mismatches := replayDriver.TxMismatches()
+		logger.Info("Replay finished", "mount", mountConfig.Name, "path", mountConfig.ReplayPath, "txMismatches", len(mismatches))
+		for _, curMismatch := range mismatches {
+			logger.Warn("Replay tx mismatch", "mount", mountConfig.Name, "index", curMismatch.Index, "expected", curMismatch.Expected, "actual", curMismatch.Actual)
+		}
+	}()
+	return replayDriver, nil
+}
+
+//startBusRecording records to the configured path with the start time added before the extension so runs don't overwrite each other
+func startBusRecording(driver hardware.Driver, mountConfig smdsconfig.DriverMountConfig) error {
+	recordable, canRecord := driver.(tortoise.BusRecordable)
+	if !canRecord {
+		logger.Warn("Driver mount is not able to record bus traffic", "mount", mountConfig.Name)
+		return nil
+	}
+	ext := filepath.Ext(mountConfig.BusRecordingPath)
+	recordingPath := strings.TrimSuffix(mountConfig.BusRecordingPath, ext) + "-" + time.Now().Format("20060102-150405") + ext
+	recordingFile, err := os.Create(recordingPath)
+	if err != nil {
+		return fmt.Errorf("busRecordingPath can't be created: %w", err)
+	}
+	if err = recordable.StartBusRecording(recordingFile); err != nil {
+		recordingFile.Close()
+		return err
+	}
+	logger.Info("Recording bus traffic", "mount", mountConfig.Name, "path", recordingPath)
+	return nil
+}
+
+func resolveDriverType(driverType string) string {
+	if driverType != smdsconfig.DriverTypeDefault {
+		return driverType
+	}
+	if environment.GetCurrent() == env.Prod {
+		return smdsconfig.DriverTypePi
+	}
+	return smdsconfig.DriverTypeMock
+}
+
+//registerMockRxDataHandler allows posting hex rx data to a mock driver. ?driver=name picks the mount, otherwise the first mock is used
+func registerMockRxDataHandler(rtr *mux.Router, mockMounts []*mockMount) {
+	rtr.Path(mockRxDataPath).Methods(http.MethodPost).HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		target := mockMounts[0]
+		if name := r.URL.Query().Get(mockRxDataDriverQuery); name != "" {
+			target = nil
+			for _, curMock := range mockMounts {
+				if curMock.name == name {
+					target = curMock
+					break
+				}
+			}
+			if target == nil {
+				apiModel.WriteError(rw, apiModel.NewError(apiModel.ErrorCodeNotFound, "No mock driver mount named "+name))
+				return
+			}
+		}
+		rxData, err := ioutil.ReadAll(hex.NewDecoder(r.Body))
+
+		if err != nil {
+			apiModel.WriteError(rw, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		} else {
+			target.driver.SetRXData(rxData)
+			logger.Debug("Sent mock rx data", "mount", target.name, "rx", rxData)
+			target.trigger <- time.Now()
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+	"github.com/gorilla/mux"
+)
+
+func TestNewHardwareDriverReportsMountsThatCantStartAsConfigError(t *testing.T) {
+	dir := t.TempDir()
+	replayPath := filepath.Join(dir, "not-a-recording.smdsrec")
+	if err := os.WriteFile(replayPath, []byte("not a recording"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "server-config.json")
+	config := `{"id":"a","driverMounts":[{"name":"sim","type":"simulator","simulatedMachines":1},
+		{"name":"r","type":"replay","idOffset":32,"replayPath":"` + replayPath + `"}]}`
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	smdsConfig, err := smdsconfig.Load([]string{"-config", configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver, err := newHardwareDriver(mux.NewRouter(), smdsConfig)
+
+	var configErr *smdsconfig.ConfigError
+	if driver != nil || !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/metrics"
+	"github.com/gorilla/mux"
+)
+
+const (
+	metricsPath string = "/metrics"
+	//Used when a request has no route template so unknown paths can't each get their own series
+	unknownRouteLabel string = "unknown"
+)
+
+var (
+	httpRequests        = metrics.NewCounterVec("smds_http_requests_total", "HTTP requests handled by route, method and status code", "route", "method", "code")
+	httpRequestDuration = metrics.NewHistogramVec("smds_http_request_duration_seconds", "How long HTTP requests take to handle by route and method", nil, "route", "method")
+)
+
+func registerMetricsHandler(rtr *mux.Router) {
+	rtr.Path(metricsPath).Methods(http.MethodGet).HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", metrics.ContentType)
+		if err := metrics.Default.WriteText(rw); err != nil {
+			logger.Warn("Unable to write metrics", "err", err)
+		}
+	})
+}
+
+//httpMetricsMiddleware labels requests with the template of the route they matched rather than their path as paths
+//contain ids
+func httpMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		route := unknownRouteLabel
+		if curRoute := mux.CurrentRoute(r); curRoute != nil {
+			if template, err := curRoute.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r)
+		httpRequestDuration.With(route, r.Method).ObserveDuration(time.Since(start))
+		httpRequests.With(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+	})
+}
+
+//statusRecorder remembers the status code written so it can be counted after the handler returns
+type statusRecorder struct {
+	http.ResponseWriter
+	status          int
+	isHeaderWritten bool
+}
+
+func (this *statusRecorder) WriteHeader(status int) {
+	if !this.isHeaderWritten {
+		this.status = status
+		this.isHeaderWritten = true
+	}
+	this.ResponseWriter.WriteHeader(status)
+}
+
+func (this *statusRecorder) Write(b []byte) (int, error) {
+	this.isHeaderWritten = true
+	return this.ResponseWriter.Write(b)
+}
+
+func (this *statusRecorder) Flush() {
+	if flusher, canFlush := this.ResponseWriter.(http.Flusher); canFlush {
+		flusher.Flush()
+	}
+}
+
+//Hijack lets the event websocket take over the connection. Upgraded requests are counted with 101
+func (this *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, canHijack := this.ResponseWriter.(http.Hijacker)
+	if !canHijack {
+		return nil, nil, errors.New("ResponseWriter does not support hijacking")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		this.status = http.StatusSwitchingProtocols
+		this.isHeaderWritten = true
+	}
+	return conn, rw, err
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	openAPIPath string = "/openapi.json"
+)
+
+//openAPIDocument describes every route under /api. OpenAPI_test.go calls each route against it so it can't drift
+//from the handlers
+//go:embed openapi.json
+var openAPIDocument []byte
+
+func registerOpenAPIHandler(rtr *mux.Router) {
+	rtr.Path(openAPIPath).Methods(http.MethodGet).HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write(openAPIDocument)
+	})
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+	"github.com/gorilla/mux"
+)
+
+//Turns {id:[0-9]+} into {id} so route templates read like OpenAPI paths
+var routeVariablePattern = regexp.MustCompile(`\{(\w+):[^}]+\}`)
+
+//Mounts one of each driver that has its own api so all of their routes are registered
+const openAPITestConfig string = `{
+	"id": "openapi-test",
+	"webContentDir": "%s",
+	"calibrationFilePath": "%s",
+	"timings": {"busPollIntervalMillis": 5, "motorRunTimeMillis": 100},
+	"features": {"faultInjectionApi": true, "simulatorApi": true, "mockRxDataApi": true, "configEditApi": true, "configFileWatch": false, "systemdNotify": false},
+	"driverMounts": [
+		{"name": "sim", "type": "simulator", "idOffset": 0, "boards": 1, "simulatedMachines": 2, "simulatedTravelTimeMillis": 20},
+		{"name": "mock", "type": "mock", "idOffset": 32, "boards": 1}
+	],
+	"logging": {"level": "error"}
+}`
+
+const openAPITestMachines int = 2
+
+type openAPIOperation struct {
+	method    string
+	path      string
+	url       string
+	operation map[string]interface{}
+}
+
+//Every route has to be in the document and every operation in the document has to be a route. Each operation is
+//then called and has to answer with a status code it documents, with a body that matches its schema
+func TestOpenAPIDocumentMatchesRoutes(t *testing.T) {
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatal(err)
+	}
+	api := newOpenAPITestApi(t)
+	defer api.Shutdown(context.Background())
+
+	routes := make(map[string]bool)
+	api.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		//Path prefixes that only hold sub routers have no handler, and / is the web content
+		if route.GetHandler() == nil || err != nil || template == "/" {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			//Routes without methods, such as the event websocket, are only ever used with GET
+			methods = []string{http.MethodGet}
+		}
+		for _, curMethod := range methods {
+			routes[curMethod+" "+routeVariablePattern.ReplaceAllString(template, "{$1}")] = true
+		}
+		return nil
+	})
+
+	operations := readOpenAPIOperations(doc)
+	for _, curOp := range operations {
+		if !routes[curOp.method+" "+curOp.url] {
+			t.Errorf("%s %s is in the document but is not a route", curOp.method, curOp.path)
+		}
+		delete(routes, curOp.method+" "+curOp.url)
+	}
+	for curRoute := range routes {
+		t.Errorf("%s is a route but is not in the document", curRoute)
+	}
+
+	for _, curOp := range operations {
+		callOpenAPIOperation(t, api, doc, curOp)
+	}
+}
+
+func TestOpenAPIDocumentIsServed(t *testing.T) {
+	rtr := mux.NewRouter()
+	registerOpenAPIHandler(rtr)
+	rec := httptest.NewRecorder()
+
+	rtr.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, openAPIPath, nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" || !bytes.Equal(rec.Body.Bytes(), openAPIDocument) {
+		t.Fail()
+	}
+}
+
+func newOpenAPITestApi(t *testing.T) *smdsAPI {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "server-config.json")
+	config := fmt.Sprintf(openAPITestConfig, filepath.Join(dir, "web-content"), filepath.Join(dir, "calibration.json"))
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	smdsConfig, err := smdsconfig.Load([]string{"-config", configPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := NewSMDSApi(smdsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Responses are only checked against their schemas if there are switch machines to put in them
+	for deadline := time.Now().Add(5 * time.Second); len(api.controller.GetSwitchMachines()) < openAPITestMachines; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Simulated switch machines were never attached")
+		}
+	}
+	return api
+}
+
+//readOpenAPIOperations returns the operations sorted by path then method so they are always called in the same order
+func readOpenAPIOperations(doc map[string]interface{}) []openAPIOperation {
+	docServer := doc["servers"].([]interface{})[0].(map[string]interface{})["url"].(string)
+	operations := make([]openAPIOperation, 0)
+	for path, curPathItem := range doc["paths"].(map[string]interface{}) {
+		pathItem := curPathItem.(map[string]interface{})
+		server := docServer
+		if servers, hasServers := pathItem["servers"].([]interface{}); hasServers {
+			server = servers[0].(map[string]interface{})["url"].(string)
+		}
+		for method, curOperation := range pathItem {
+			operation, isOperation := curOperation.(map[string]interface{})
+			if !isOperation {
+				continue
+			}
+			operations = append(operations, openAPIOperation{
+				method:    strings.ToUpper(method),
+				path:      path,
+				url:       strings.TrimSuffix(server, "/") + path,
+				operation: operation,
+			})
+		}
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].path != operations[j].path {
+			return operations[i].path < operations[j].path
+		}
+		return operations[i].method < operations[j].method
+	})
+	return operations
+}
+
+//callOpenAPIOperation fills in the path parameters and request body from the examples in the document
+func callOpenAPIOperation(t *testing.T, api *smdsAPI, doc map[string]interface{}, op openAPIOperation) {
+	name := op.method + " " + op.path
+	url := op.url
+	parameters, _ := op.operation["parameters"].([]interface{})
+	for _, curParameter := range parameters {
+		parameter := curParameter.(map[string]interface{})
+		if parameter["in"] != "path" {
+			continue
+		}
+		example, hasExample := parameter["example"]
+		if !hasExample {
+			t.Errorf("%s path parameter %s has no example", name, parameter["name"])
+			return
+		}
+		url = strings.Replace(url, "{"+parameter["name"].(string)+"}", fmt.Sprint(example), 1)
+	}
+
+	var body io.Reader
+	contentType := ""
+	if requestBody, hasBody := op.operation["requestBody"].(map[string]interface{}); hasBody {
+		for curType, curMedia := range requestBody["content"].(map[string]interface{}) {
+			media := curMedia.(map[string]interface{})
+			example, hasExample := media["example"]
+			if !hasExample {
+				t.Errorf("%s request body has no example", name)
+				return
+			}
+			contentType = curType
+			if curType == "application/json" {
+				validateOpenAPISchema(t, doc, media["schema"].(map[string]interface{}), example, name+" request body")
+				exampleJSON, _ := json.Marshal(example)
+				body = bytes.NewReader(exampleJSON)
+			} else {
+				body = strings.NewReader(example.(string))
+			}
+		}
+	}
+	req := httptest.NewRequest(op.method, url, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+
+	api.router.ServeHTTP(rec, req)
+
+	response, isDocumented := op.operation["responses"].(map[string]interface{})[strconv.Itoa(rec.Code)].(map[string]interface{})
+	if !isDocumented {
+		t.Errorf("%s answered with %d which is not documented: %s", name, rec.Code, rec.Body.String())
+		return
+	}
+	content, _ := response["content"].(map[string]interface{})
+	jsonMedia, isJSON := content["application/json"].(map[string]interface{})
+	if !isJSON {
+		return
+	}
+	var value interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &value); err != nil {
+		t.Errorf("%s answered %d with a body that is not json: %v", name, rec.Code, err)
+		return
+	}
+	validateOpenAPISchema(t, doc, jsonMedia["schema"].(map[string]interface{}), value, fmt.Sprintf("%s %d", name, rec.Code))
+}
+
+//validateOpenAPISchema covers the parts of a schema the document uses. Properties that aren't in a schema that lists
+//its properties are reported as they are what drift looks like
+func validateOpenAPISchema(t *testing.T, doc map[string]interface{}, schema map[string]interface{}, value interface{}, at string) {
+	schema = resolveOpenAPISchema(doc, schema)
+	if enum, hasEnum := schema["enum"].([]interface{}); hasEnum {
+		isInEnum := false
+		for _, curValue := range enum {
+			isInEnum = isInEnum || reflect.DeepEqual(curValue, value)
+		}
+		if !isInEnum {
+			t.Errorf("%s: %v is not one of %v", at, value, enum)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			t.Errorf("%s: %v is not an object", at, value)
+			return
+		}
+		properties, hasProperties := schema["properties"].(map[string]interface{})
+		additional, hasAdditional := schema["additionalProperties"].(map[string]interface{})
+		for key, curValue := range object {
+			if property, isProperty := properties[key].(map[string]interface{}); isProperty {
+				validateOpenAPISchema(t, doc, property, curValue, at+"."+key)
+			} else if hasAdditional {
+				validateOpenAPISchema(t, doc, additional, curValue, at+"."+key)
+			} else if hasProperties {
+				t.Errorf("%s: %s is not in the schema", at, key)
+			}
+		}
+	case "array":
+		array, isArray := value.([]interface{})
+		if !isArray {
+			t.Errorf("%s: %v is not an array", at, value)
+			return
+		}
+		for i, curValue := range array {
+			validateOpenAPISchema(t, doc, schema["items"].(map[string]interface{}), curValue, fmt.Sprintf("%s[%d]", at, i))
+		}
+	case "string":
+		if _, isString := value.(string); !isString {
+			t.Errorf("%s: %v is not a string", at, value)
+		}
+	case "integer":
+		if number, isNumber := value.(float64); !isNumber || number != float64(int64(number)) {
+			t.Errorf("%s: %v is not an integer", at, value)
+		}
+	case "number":
+		if _, isNumber := value.(float64); !isNumber {
+			t.Errorf("%s: %v is not a number", at, value)
+		}
+	case "boolean":
+		if _, isBool := value.(bool); !isBool {
+			t.Errorf("%s: %v is not a boolean", at, value)
+		}
+	}
+}
+
+//resolveOpenAPISchema follows $ref and merges allOf into one object schema
+func resolveOpenAPISchema(doc map[string]interface{}, schema map[string]interface{}) map[string]interface{} {
+	if ref, isRef := schema["$ref"].(string); isRef {
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		return resolveOpenAPISchema(doc, schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]interface{}))
+	}
+	allOf, isAllOf := schema["allOf"].([]interface{})
+	if !isAllOf {
+		return schema
+	}
+	properties := make(map[string]interface{})
+	for _, curSchema := range allOf {
+		part := resolveOpenAPISchema(doc, curSchema.(map[string]interface{}))
+		for name, curProperty := range part["properties"].(map[string]interface{}) {
+			properties[name] = curProperty
+		}
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
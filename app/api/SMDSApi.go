@@ -1,13 +1,35 @@
 package api
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	boardapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/board"
+	calibrationapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/calibration"
+	configapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/config"
+	driverapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/driver"
+	healthapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/health"
+	loggingapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/logging"
+	operationapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/operation"
+	selftestapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/selftest"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/persistance"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/selftest"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/environment"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/health"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
 	"github.com/ZacharyDuve/apireg"
 	"github.com/ZacharyDuve/apireg/api"
 	"github.com/ZacharyDuve/serverid"
@@ -21,49 +43,206 @@ const (
 	ApiVersionBugFix uint   = 0
 )
 
+var logger = logging.ForSubsystem(logging.SubsystemApi)
+
 type smdsAPI struct {
 	router *mux.Router
 	//apiSubRouter *mux.Router
 	apiRegistry apireg.ApiRegistry
+	driver      composite.CompositeDriver
+	controller  controller.TortoiseController
+	operations  *operation.Tracker
+	eventServer switchmachine.EventBroadcaster
+	selfTest    *selftest.Runner
+	httpServer  *http.Server
+	webContent  *webContentHandler
+	health      *health.Checker
+	//Settings that a config reload can change are guarded as they are read from other goroutines
+	settingsMutex sync.RWMutex
+	shutdown      smdsconfig.ShutdownConfig
+	//Closed to stop watching the config file
+	stopConfigWatch chan struct{}
+	//Removes applyReloadedConfig from the reload listeners so a shut down api isn't kept around by them
+	removeReloadListener func()
+	watchesConfig        bool
+	notifiesSystemd      bool
 }
 
-func NewSMDSApi() *smdsAPI {
-	log.Println("Start Creating NewSMDSApi")
+//NewSMDSApi returns a *smdsconfig.ConfigError when the config can't be used to start the hardware, listing all of the
+//problems found
+func NewSMDSApi(config smdsconfig.SMDSConfig) (*smdsAPI, error) {
 	api := &smdsAPI{}
-	reg, err := apireg.NewRegistry(environment.GetCurrent())
-	if err != nil {
-		panic(err)
-	}
-	api.apiRegistry = reg
 	api.router = mux.NewRouter()
+	api.router.Use(httpMetricsMiddleware)
+	registerMetricsHandler(api.router)
 	//Need an API sub router to separate from web
 	apiSubRouter := api.router.PathPrefix("/api").Subrouter()
 	//DO WE WANT ONLY FOR NON PRODS?
 	apiSubRouter.Use(mux.CORSMethodMiddleware(apiSubRouter))
 	sIdSvc, err := serverid.NewFileServerIdService("")
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	//Make it so that we can get the server id
 	apiSubRouter.HandleFunc(serverid.GetHandlerFuncFromServerIdService(sIdSvc))
+	registerOpenAPIHandler(apiSubRouter)
+	api.driver, err = newHardwareDriver(apiSubRouter, config)
+	if err != nil {
+		return nil, err
+	}
+	calibrations, err := persistance.NewFileCalibrationStore(config.CalibrationFilePath())
+	if err != nil {
+		api.driver.Close()
+		return nil, &smdsconfig.ConfigError{Problems: []string{fmt.Sprintf("calibrationFile %q %v", config.CalibrationFilePath(), err)}}
+	}
+	api.controller = controller.NewTortoiseControllerWithCalibrations(api.driver, config.MotorRunTime(), calibrations)
+	api.shutdown = config.Shutdown()
+	if api.shutdown.StateFilePath != "" {
+		restoreStateFile(api.controller, api.shutdown.StateFilePath)
+	}
 	//Register the switch machine handler with the api sub router
-	switchmachine.NewSwitchMachineHandler(apiSubRouter)
+	api.operations = operation.NewTracker(api.controller)
+	locateOp := operationapi.NewOperationHandler(apiSubRouter, api.operations)
+	api.eventServer = switchmachine.NewSwitchMachineHandler(apiSubRouter, api.controller, api.operations, locateOp)
+	driverapi.NewDriverHandler(apiSubRouter, api.driver)
+	boardapi.NewBoardHandler(apiSubRouter, api.driver, api.controller, api.operations)
+	api.selfTest = selftest.NewRunner(api.controller)
+	selftestapi.NewSelfTestHandler(apiSubRouter, api.selfTest, api.eventServer)
+	calibrationapi.NewCalibrationHandler(apiSubRouter, api.controller)
+	api.health = health.NewChecker(api.driver)
+	healthapi.NewHealthHandler(apiSubRouter, api.health)
+	configapi.NewConfigHandler(apiSubRouter, !config.Features().ConfigEditApi)
+	loggingapi.NewLoggingHandler(apiSubRouter)
 	//Need to serve any non api routes as web pages
-	api.router.PathPrefix("/").Handler(http.FileServer(http.Dir("web-content")))
-	log.Println("End Creating NewSMDSApi")
-	return api
+	api.webContent = &webContentHandler{}
+	api.webContent.set(config.WebContentDir(), config.Features().WebContent)
+	api.router.PathPrefix("/").Handler(api.webContent)
+	api.stopConfigWatch = make(chan struct{})
+	api.watchesConfig = config.Features().ConfigFileWatch
+	api.notifiesSystemd = config.Features().SystemdNotify
+	api.removeReloadListener = smdsconfig.AddReloadListener(api.applyReloadedConfig)
+	return api, nil
+}
+
+//applyReloadedConfig picks up the settings that are safe to change while running
+func (this *smdsAPI) applyReloadedConfig(config smdsconfig.SMDSConfig) {
+	if err := smdsconfig.ConfigureLogging(config.Logging()); err != nil {
+		logger.Error("Unable to apply reloaded logging config", "err", err)
+	}
+	this.controller.SetMotorRunTime(config.MotorRunTime())
+	this.webContent.set(config.WebContentDir(), config.Features().WebContent)
+	this.settingsMutex.Lock()
+	this.shutdown = config.Shutdown()
+	this.settingsMutex.Unlock()
 }
 
-func (this *smdsAPI) ListenAndServe(addr string) {
+func (this *smdsAPI) shutdownSettings() smdsconfig.ShutdownConfig {
+	this.settingsMutex.RLock()
+	defer this.settingsMutex.RUnlock()
+	return this.shutdown
+}
+
+//ListenAndServe serves until SIGINT or SIGTERM and then shuts down, reloading the config on SIGHUP. It only returns
+//an error if the server could not be started or stopped on its own, shutdown problems are logged. An addr that can't
+//be served on is a *smdsconfig.ConfigError
+func (this *smdsAPI) ListenAndServe(addr string) error {
 	_, portString, err := net.SplitHostPort(addr)
 	if err != nil {
-		panic(err)
+		return &smdsconfig.ConfigError{Problems: []string{fmt.Sprintf("listenAddress %q is not a host:port", addr)}}
 	}
 	port, err := strconv.Atoi(portString)
 	if err != nil {
-		panic(err)
+		return &smdsconfig.ConfigError{Problems: []string{fmt.Sprintf("listenAddress %q needs a numeric port", addr)}}
+	}
+	//The registry is only made once serving so an api that is never served doesn't announce itself
+	reg, err := apireg.NewRegistry(environment.GetCurrent())
+	if err != nil {
+		return err
 	}
+	this.apiRegistry = reg
 	this.apiRegistry.RegisterApi(ApiName, &api.Version{Major: ApiVersionMajor, Minor: ApiVersionMinor, BugFix: ApiVersionBugFix}, port)
-	log.Println("Server up and waiting for requests")
-	log.Fatal(http.ListenAndServe(addr, this.router))
+	this.httpServer = &http.Server{Addr: addr, Handler: this.router}
+	stopSignals := make(chan os.Signal, 1)
+	signal.Notify(stopSignals, syscall.SIGINT, syscall.SIGTERM)
+	reloadSignals := make(chan os.Signal, 1)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
+	defer signal.Stop(reloadSignals)
+	if this.watchesConfig {
+		go smdsconfig.WatchConfigFile(smdsconfig.DefaultConfigWatchInterval, this.stopConfigWatch)
+	}
+	serveErrs := make(chan error, 1)
+	//Listening before serving means the server really is up when systemd is told it is ready
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		serveErrs <- err
+	} else {
+		go func() {
+			serveErrs <- this.httpServer.Serve(listener)
+		}()
+		logger.Info("Server up and waiting for requests", "addr", addr)
+	}
+	notifier := this.startSystemdNotify(err == nil)
+
+	var serveErr error
+	isServing := true
+	for isServing {
+		select {
+		case serveErr = <-serveErrs:
+			logger.Error("Server stopped", "err", serveErr)
+			isServing = false
+		case sig := <-stopSignals:
+			logger.Info("Received signal, shutting down", "signal", sig)
+			isServing = false
+		case <-reloadSignals:
+			logger.Info("Received SIGHUP, reloading config")
+			smdsconfig.Reload()
+		}
+	}
+	close(this.stopConfigWatch)
+	if notifier != nil {
+		notifier.Notify(health.NotifyStopping)
+	}
+	//A second signal kills the process straight away in case shutdown gets stuck
+	signal.Stop(stopSignals)
+
+	timeout := time.Duration(this.shutdownSettings().TimeoutMillis) * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	shutdownDone := make(chan error, 1)
+	go func() {
+		shutdownDone <- this.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			logger.Error("Shutdown did not complete cleanly", "err", err)
+		} else {
+			logger.Info("Shutdown complete")
+		}
+	case <-ctx.Done():
+		logger.Error("Shutdown took too long, exiting anyway", "timeout", timeout)
+	}
+	return serveErr
+}
+
+//startSystemdNotify tells systemd the server is ready and starts feeding its watchdog when the server was started by
+//systemd. The watchdog stops with the config watch. Returns nil when systemd isn't being told anything
+func (this *smdsAPI) startSystemdNotify(isServing bool) *health.Notifier {
+	if !this.notifiesSystemd {
+		return nil
+	}
+	notifier, err := health.NewNotifierFromEnvironment()
+	if err != nil {
+		return nil
+	}
+	if isServing {
+		if err = notifier.Notify(health.NotifyReady); err != nil {
+			logger.Warn("Unable to tell systemd the server is ready", "err", err)
+		}
+	}
+	if interval := health.WatchdogInterval(); interval > 0 {
+		logger.Info("Feeding the systemd watchdog", "interval", interval)
+		go health.RunWatchdog(notifier, this.health, interval, this.stopConfigWatch)
+	}
+	return notifier
 }
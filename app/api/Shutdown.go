@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/atomicfile"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+)
+
+//Shutdown stops taking requests, cancels any self test, disconnects event clients, parks the switch machines, saves
+//their state and then closes the driver. Each step is attempted even if an earlier one failed and the first error is returned
+func (this *smdsAPI) Shutdown(ctx context.Context) error {
+	var firstErr error
+	keepFirstErr := func(step string, err error) {
+		if err != nil {
+			logger.Error("Error during shutdown", "step", step, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if this.httpServer != nil {
+		keepFirstErr("stopping http server", this.httpServer.Shutdown(ctx))
+	}
+	this.removeReloadListener()
+	keepFirstErr("stopping self test", this.selfTest.Close())
+	keepFirstErr("closing event clients", this.eventServer.Close())
+	shutdown := this.shutdownSettings()
+	//Taken before parking so the safe gpio isn't what is restored next start
+	lastStates := this.controller.GetSwitchMachines()
+	keepFirstErr("parking switch machines", this.controller.Shutdown(ctx, newShutdownOptions(shutdown)))
+	if shutdown.StateFilePath != "" {
+		keepFirstErr("saving switch machine state", writeStateFile(shutdown.StateFilePath, lastStates))
+	}
+	keepFirstErr("closing driver", this.driver.Close())
+	return firstErr
+}
+
+func newShutdownOptions(shutdown smdsconfig.ShutdownConfig) controller.ShutdownOptions {
+	options := controller.ShutdownOptions{ThrowWait: time.Duration(shutdown.ThrowWaitMillis) * time.Millisecond}
+	if shutdown.SafeGPIO != nil {
+		options.SafeGPIO = &controller.SafeGPIOState{
+			GPIO0: switchmachine.GPIOState(shutdown.SafeGPIO.GPIO0),
+			GPIO1: switchmachine.GPIOState(shutdown.SafeGPIO.GPIO1),
+		}
+	}
+	return options
+}
+
+//writeStateFile replaces the file atomically so a crash part way through never leaves a half written file
+func writeStateFile(path string, states []switchmachine.State) error {
+	apiStates := make([]*apiModel.SwitchMachine, 0, len(states))
+	for _, curState := range states {
+		apiStates = append(apiStates, apiModel.NewAPISwitchMachineFromModel(curState))
+	}
+	contents, err := json.Marshal(apiStates)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, append(contents, '\n'))
+}
+
+//restoreStateFile gives the switch machines back the gpio they had when the state file was last written. A missing
+//file is a first start so only a file that can't be read is logged
+func restoreStateFile(c controller.TortoiseController, path string) {
+	stateFile, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Warn("Unable to read switch machine state file, gpio will not be restored", "path", path, "err", err)
+		}
+		return
+	}
+	defer stateFile.Close()
+	apiStates := make([]*apiModel.SwitchMachine, 0)
+	err = json.NewDecoder(stateFile).Decode(&apiStates)
+	if err != nil {
+		logger.Warn("Unable to read switch machine state file, gpio will not be restored", "path", path, "err", err)
+		return
+	}
+	states := make([]switchmachine.State, 0, len(apiStates))
+	for _, curState := range apiStates {
+		states = append(states, curState)
+	}
+	logger.Info("Restoring switch machine gpio from state file", "path", path, "switchMachines", len(states))
+	c.RestoreGPIO(states)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestStateFileWrittenAtShutdownRestoresGPIO(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	saved := []switchmachine.State{
+		switchmachine.NewState(0, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF),
+		switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOn),
+	}
+	if err := writeStateFile(statePath, saved); err != nil {
+		t.Fatal(err)
+	}
+	sim := tortoise.NewSimulatorTortoiseControllerDriver(time.Millisecond, time.Second)
+	t.Cleanup(func() {
+		sim.Close()
+	})
+	sim.AttachMachine(0, switchmachine.Position0)
+	c := controller.NewTortoiseController(sim)
+
+	restoreStateFile(c, statePath)
+	sim.AttachMachine(1, switchmachine.Position0)
+
+	for start := time.Now(); len(c.GetSwitchMachines()) < 2; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Controller only has %d of 2 switch machines", len(c.GetSwitchMachines()))
+		}
+	}
+	for _, curSaved := range saved {
+		if curState, _ := c.GetSwitchMachineById(curSaved.Id()); curState.GPIO0State() != curSaved.GPIO0State() || curState.GPIO1State() != curSaved.GPIO1State() {
+			t.Errorf("Switch machine %d has the wrong gpio", curSaved.Id())
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+)
+
+//webContentHandler serves the web pages from a directory that can be changed or turned off by a config reload
+type webContentHandler struct {
+	mutex     sync.RWMutex
+	dir       string
+	isEnabled bool
+}
+
+func (this *webContentHandler) set(dir string, isEnabled bool) {
+	this.mutex.Lock()
+	this.dir = dir
+	this.isEnabled = isEnabled
+	this.mutex.Unlock()
+}
+
+func (this *webContentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	this.mutex.RLock()
+	dir, isEnabled := this.dir, this.isEnabled
+	this.mutex.RUnlock()
+	if !isEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+}
@@ -0,0 +1,235 @@
+package board
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+	"github.com/gorilla/mux"
+)
+
+const (
+	boardHandlerPath string = "/board"
+	boardRequestKey  string = "board"
+	gpioSubPath      string = "/gpio"
+	idleSubPath      string = "/idle"
+	//How long a request waits for the driver to take its updates before giving up
+	updateTimeout time.Duration = time.Second * 5
+
+	boardNotFoundErrorMessage    string = "No board %d on any driver mount"
+	invalidBoardGPIOErrorMessage string = "Invalid board gpio update"
+)
+
+var logger = logging.ForSubsystem(logging.SubsystemApi)
+
+type boardHandler struct {
+	driver     composite.CompositeDriver
+	controller controller.TortoiseController
+	operations *operation.Tracker
+}
+
+//boardLocation is where a board sits on its mount
+type boardLocation struct {
+	number     uint
+	mount      composite.Mount
+	mountBoard uint
+	firstId    switchmachine.Id
+}
+
+//NewBoardHandler exposes the switch machines grouped by the main controller board they are on. Boards are worked out
+//from the driver mounts the same way the driver does, SwitchMachinesPerBoard ids to a board. Updates to every switch
+//machine on a board are made as operations in operations
+func NewBoardHandler(rtr *mux.Router, driver composite.CompositeDriver, c controller.TortoiseController, operations *operation.Tracker) {
+	bHandler := &boardHandler{driver: driver, controller: c, operations: operations}
+	subRtr := rtr.PathPrefix(boardHandlerPath).Subrouter()
+	boardPath := "/{" + boardRequestKey + ":[0-9]+}"
+	subRtr.Path(boardPath).Methods(http.MethodGet).HandlerFunc(bHandler.handleGetBoard)
+	subRtr.Path(boardPath + gpioSubPath).Methods(http.MethodPut).HandlerFunc(bHandler.handleSetBoardGPIO)
+	subRtr.Path(boardPath + idleSubPath).Methods(http.MethodPost).HandlerFunc(bHandler.handleIdleBoard)
+	subRtr.Methods(http.MethodGet).HandlerFunc(bHandler.handleGetBoards)
+}
+
+func (this *boardHandler) handleGetBoards(w http.ResponseWriter, r *http.Request) {
+	locations := this.boardLocations()
+	apiBoards := make([]*apiModel.Board, 0, len(locations))
+	diagnostics := make(map[string]*tortoise.BusDiagnostics)
+	for _, curLocation := range locations {
+		//Each mount's bus is only looked at once for all of its boards
+		mountDiagnostics, isLoaded := diagnostics[curLocation.mount.Name]
+		if !isLoaded {
+			mountDiagnostics = getBusDiagnostics(curLocation.mount)
+			diagnostics[curLocation.mount.Name] = mountDiagnostics
+		}
+		apiBoards = append(apiBoards, this.newAPIBoard(curLocation, mountDiagnostics))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiBoards)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *boardHandler) handleGetBoard(w http.ResponseWriter, r *http.Request) {
+	location, isFound := this.findBoardFromRequest(w, r)
+	if !isFound {
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(this.newAPIBoard(location, getBusDiagnostics(location.mount)))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//handleSetBoardGPIO sets the gpio of every switch machine attached to the board. Switch machines that are moving keep
+//moving to where they are heading
+func (this *boardHandler) handleSetBoardGPIO(w http.ResponseWriter, r *http.Request) {
+	location, isFound := this.findBoardFromRequest(w, r)
+	if !isFound {
+		return
+	}
+	boardGPIO := &apiModel.BoardGPIO{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(boardGPIO)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	if problems := boardGPIO.UpdateProblems(); len(problems) > 0 {
+		apiErr := apiModel.NewError(apiModel.ErrorCodeInvalidValue, invalidBoardGPIOErrorMessage)
+		apiErr.Problems = problems
+		apiModel.WriteError(w, apiErr)
+		return
+	}
+	logger.Debug("Board gpio update requested", "board", location.number)
+	this.updateBoard(w, r, location, func(ctx context.Context, curState switchmachine.State) (*operation.Operation, error) {
+		smReq := boardGPIO.ApplyTo(curState)
+		wouldUpdate, err := this.controller.WouldUpdateSwitchMachine(smReq)
+		if err != nil || !wouldUpdate {
+			return nil, err
+		}
+		op, err := this.operations.Update(ctx, smReq)
+		return &op, err
+	})
+}
+
+//handleIdleBoard stops every motor on the board where it is
+func (this *boardHandler) handleIdleBoard(w http.ResponseWriter, r *http.Request) {
+	location, isFound := this.findBoardFromRequest(w, r)
+	if !isFound {
+		return
+	}
+	logger.Debug("Board idle requested", "board", location.number)
+	this.updateBoard(w, r, location, func(ctx context.Context, curState switchmachine.State) (*operation.Operation, error) {
+		if curState.MotorState() == switchmachine.MotorStateIdle {
+			return nil, nil
+		}
+		op, err := this.operations.Idle(ctx, curState.Id())
+		return &op, err
+	})
+}
+
+//updateBoard calls update for every switch machine attached to the board and answers with the result of each by port.
+//update returns a nil operation when there was nothing to change. It is 207 Multi-Status when any were rejected
+func (this *boardHandler) updateBoard(w http.ResponseWriter, r *http.Request, location boardLocation, update func(context.Context, switchmachine.State) (*operation.Operation, error)) {
+	ctx, cancel := context.WithTimeout(r.Context(), updateTimeout)
+	defer cancel()
+	status := http.StatusOK
+	results := make([]*apiModel.SwitchMachineUpdateResult, 0, tortoise.SwitchMachinesPerBoard)
+	for port, curState := range this.portStates(location) {
+		if curState == nil {
+			continue
+		}
+		result := &apiModel.SwitchMachineUpdateResult{Index: port, SMId: apiModel.SwitchMachineId(curState.Id()), Status: apiModel.UpdateNoOp}
+		op, err := update(ctx, curState)
+		if err != nil {
+			logger.Warn("Unable to update switch machine on board", "board", location.number, "id", curState.Id(), "err", err)
+			result.Status = apiModel.UpdateRejected
+			result.Error = apiModel.NewAPIErrorFromModel(err)
+			status = http.StatusMultiStatus
+		} else if op != nil {
+			result.Status = apiModel.UpdateAccepted
+			result.OperationId = uint64(op.Id)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
+}
+
+//findBoardFromRequest writes the error and returns false when the board in the request isn't on any mount
+func (this *boardHandler) findBoardFromRequest(w http.ResponseWriter, r *http.Request) (boardLocation, bool) {
+	number, err := strconv.ParseUint(mux.Vars(r)[boardRequestKey], 10, 16)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed board in request"))
+		return boardLocation{}, false
+	}
+	for _, curLocation := range this.boardLocations() {
+		if curLocation.number == uint(number) {
+			return curLocation, true
+		}
+	}
+	apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, fmt.Sprintf(boardNotFoundErrorMessage, number)))
+	return boardLocation{}, false
+}
+
+//boardLocations are in the order of the mounts. A board is numbered by the id on its first port, which config
+//validation makes sure is a multiple of SwitchMachinesPerBoard
+func (this *boardHandler) boardLocations() []boardLocation {
+	locations := make([]boardLocation, 0)
+	for _, curMount := range this.driver.Mounts() {
+		for mountBoard := uint(0); mountBoard*tortoise.SwitchMachinesPerBoard < curMount.NumIds; mountBoard++ {
+			firstId := curMount.IdOffset + switchmachine.Id(mountBoard*tortoise.SwitchMachinesPerBoard)
+			locations = append(locations, boardLocation{
+				number:     uint(firstId) / tortoise.SwitchMachinesPerBoard,
+				mount:      curMount,
+				mountBoard: mountBoard,
+				firstId:    firstId,
+			})
+		}
+	}
+	return locations
+}
+
+//portStates has the state of the switch machine on each port, nil for ports that have none
+func (this *boardHandler) portStates(location boardLocation) []switchmachine.State {
+	states := make([]switchmachine.State, tortoise.SwitchMachinesPerBoard)
+	for port := range states {
+		//Not existing is what leaves the port empty
+		states[port], _ = this.controller.GetSwitchMachineById(location.firstId + switchmachine.Id(port))
+	}
+	return states
+}
+
+func (this *boardHandler) newAPIBoard(location boardLocation, mountDiagnostics *tortoise.BusDiagnostics) *apiModel.Board {
+	var boardDiagnostics *tortoise.BoardDiagnostics
+	if mountDiagnostics != nil && location.mountBoard < uint(len(mountDiagnostics.Boards)) {
+		boardDiagnostics = &mountDiagnostics.Boards[location.mountBoard]
+	}
+	return apiModel.NewAPIBoardFromModel(location.number, location.mount.Name, location.mountBoard, location.firstId, this.portStates(location), boardDiagnostics)
+}
+
+//getBusDiagnostics is nil for mounts that can't show their bus
+func getBusDiagnostics(mount composite.Mount) *tortoise.BusDiagnostics {
+	diagnosable, canDiagnose := mount.Driver.(tortoise.BusDiagnosable)
+	if !canDiagnose {
+		return nil
+	}
+	diagnostics := diagnosable.BusDiagnostics()
+	return &diagnostics
+}
@@ -0,0 +1,175 @@
+package board
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+//Long enough that a thrown switch machine is still moving for the whole test
+const testMotorRunTime time.Duration = 10 * time.Second
+
+//newTestRouter serves the board routes for two simulator mounts. Mount a has boards 0 and 1 with switch machines 0, 1
+//and 5. Mount b has board 2 with switch machine 8
+func newTestRouter(t *testing.T) (*mux.Router, controller.TortoiseController) {
+	simA := tortoise.NewSimulatorTortoiseControllerDriver(time.Millisecond, testMotorRunTime)
+	simB := tortoise.NewSimulatorTortoiseControllerDriver(time.Millisecond, testMotorRunTime)
+	for _, curId := range []switchmachine.Id{0, 1, 5} {
+		simA.AttachMachine(curId, switchmachine.Position0)
+	}
+	simB.AttachMachine(0, switchmachine.Position0)
+	driver, err := composite.NewCompositeDriver(
+		composite.Mount{Name: "a", IdOffset: 0, NumIds: 8, Driver: simA},
+		composite.Mount{Name: "b", IdOffset: 8, NumIds: 4, Driver: simB},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := controller.NewTortoiseControllerWithMotorRunTime(driver, testMotorRunTime)
+	t.Cleanup(func() {
+		driver.Close()
+	})
+	for start := time.Now(); len(c.GetSwitchMachines()) < 4; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Controller only has %d of 4 switch machines", len(c.GetSwitchMachines()))
+		}
+	}
+	rtr := mux.NewRouter()
+	NewBoardHandler(rtr, driver, c, operation.NewTracker(c))
+	return rtr, c
+}
+
+func serveTestRequest(rtr http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	rtr.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func checkTestError(t *testing.T, rec *httptest.ResponseRecorder, code apiModel.ErrorCode) {
+	t.Helper()
+	if rec.Code != code.Status() {
+		t.Errorf("Expected status %d but was %d: %s", code.Status(), rec.Code, rec.Body.String())
+	}
+	apiErr := &apiModel.Error{}
+	if err := json.NewDecoder(rec.Body).Decode(apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if apiErr.Code != code {
+		t.Errorf("Expected code %s but was %s", code, apiErr.Code)
+	}
+}
+
+//checkTestResults fails unless rec is a 200 with a result for each attached port, in port order
+func checkTestResults(t *testing.T, rec *httptest.ResponseRecorder, expected map[int]apiModel.SwitchMachineUpdateStatus) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but was %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	results := make([]apiModel.SwitchMachineUpdateResult, 0)
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results but got %+v", len(expected), results)
+	}
+	for i, curResult := range results {
+		if i > 0 && curResult.Index <= results[i-1].Index {
+			t.Errorf("Results are not in port order %+v", results)
+		}
+		if status, isExpected := expected[curResult.Index]; !isExpected || curResult.Status != status {
+			t.Errorf("Port %d was %s but expected %s", curResult.Index, curResult.Status, status)
+		}
+	}
+}
+
+func TestGetBoards(t *testing.T) {
+	rtr, _ := newTestRouter(t)
+	rec := serveTestRequest(rtr, http.MethodGet, "/board", "")
+	boards := make([]apiModel.Board, 0)
+	if err := json.NewDecoder(rec.Body).Decode(&boards); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		mount      string
+		mountBoard uint
+		firstId    apiModel.SwitchMachineId
+		attached   []bool
+	}{
+		{"a", 0, 0, []bool{true, true, false, false}},
+		{"a", 1, 4, []bool{false, true, false, false}},
+		{"b", 0, 8, []bool{true, false, false, false}},
+	}
+	if len(boards) != len(expected) {
+		t.Fatalf("Expected %d boards but got %d", len(expected), len(boards))
+	}
+	for i, curBoard := range boards {
+		if curBoard.Board != uint(i) || curBoard.Mount != expected[i].mount || curBoard.MountBoard != expected[i].mountBoard || curBoard.FirstId != expected[i].firstId || !curBoard.Attached {
+			t.Errorf("Board %d was %+v", i, curBoard)
+		}
+		for port, curPort := range curBoard.Ports {
+			if curPort.SMId != curBoard.FirstId+apiModel.SwitchMachineId(port) || curPort.Attached != expected[i].attached[port] || (curPort.SwitchMachine != nil) != curPort.Attached {
+				t.Errorf("Board %d port %d was %+v", i, port, curPort)
+			}
+		}
+	}
+}
+
+func TestGetUnknownBoardIsNotFound(t *testing.T) {
+	rtr, _ := newTestRouter(t)
+	checkTestError(t, serveTestRequest(rtr, http.MethodGet, "/board/3", ""), apiModel.ErrorCodeNotFound)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/board/3/gpio", `{"gpio0": "on"}`), apiModel.ErrorCodeNotFound)
+	checkTestError(t, serveTestRequest(rtr, http.MethodGet, "/board/65536", ""), apiModel.ErrorCodeMalformedRequest)
+}
+
+func TestSetBoardGPIOOnlyChangesSwitchMachinesOnTheBoard(t *testing.T) {
+	rtr, c := newTestRouter(t)
+	rec := serveTestRequest(rtr, http.MethodPut, "/board/0/gpio", `{"gpio0": "on"}`)
+	checkTestResults(t, rec, map[int]apiModel.SwitchMachineUpdateStatus{0: apiModel.UpdateAccepted, 1: apiModel.UpdateAccepted})
+	for _, curId := range []switchmachine.Id{0, 1, 5, 8} {
+		expected := switchmachine.GPIOOFF
+		if curId < 4 {
+			expected = switchmachine.GPIOOn
+		}
+		if state, _ := c.GetSwitchMachineById(curId); state.GPIO0State() != expected || state.GPIO1State() != switchmachine.GPIOOFF {
+			t.Errorf("Switch machine %d has the wrong gpio", curId)
+		}
+	}
+	rec = serveTestRequest(rtr, http.MethodPut, "/board/0/gpio", `{"gpio0": "on"}`)
+	checkTestResults(t, rec, map[int]apiModel.SwitchMachineUpdateStatus{0: apiModel.UpdateNoOp, 1: apiModel.UpdateNoOp})
+}
+
+func TestSetBoardGPIOWithInvalidValueIsInvalid(t *testing.T) {
+	rtr, _ := newTestRouter(t)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/board/0/gpio", `{"gpio0": "dim"}`), apiModel.ErrorCodeInvalidValue)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/board/0/gpio", `{"gpio2": "on"}`), apiModel.ErrorCodeMalformedRequest)
+}
+
+func TestIdleBoardOnlyStopsMovingSwitchMachines(t *testing.T) {
+	rtr, c := newTestRouter(t)
+	if err := c.Throw(context.Background(), 1, switchmachine.Position1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Throw(context.Background(), 5, switchmachine.Position1); err != nil {
+		t.Fatal(err)
+	}
+	rec := serveTestRequest(rtr, http.MethodPost, "/board/0/idle", "")
+	checkTestResults(t, rec, map[int]apiModel.SwitchMachineUpdateStatus{0: apiModel.UpdateNoOp, 1: apiModel.UpdateAccepted})
+	if state, _ := c.GetSwitchMachineById(1); state.MotorState() != switchmachine.MotorStateIdle {
+		t.Error("Switch machine 1 is still moving")
+	}
+	if state, _ := c.GetSwitchMachineById(5); state.MotorState() == switchmachine.MotorStateIdle {
+		t.Error("Switch machine 5 on another board was stopped")
+	}
+}
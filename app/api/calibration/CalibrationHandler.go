@@ -0,0 +1,107 @@
+package calibration
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+const (
+	calibrationHandlerPath string = "/calibration"
+	idRequestKey           string = "id"
+)
+
+type calibrationHandler struct {
+	controller controller.TortoiseController
+}
+
+//NewCalibrationHandler exposes the wiring calibration of each switch machine along with the ones the controller
+//thinks are wired the wrong way around
+func NewCalibrationHandler(rtr *mux.Router, c controller.TortoiseController) {
+	cHandler := &calibrationHandler{controller: c}
+	subRtr := rtr.PathPrefix(calibrationHandlerPath).Subrouter()
+	subRtr.Path("/suggestion").Methods(http.MethodGet).HandlerFunc(cHandler.handleGetSuggestions)
+	subRtr.Path("/{" + idRequestKey + ":[0-9]+}").Methods(http.MethodGet).HandlerFunc(cHandler.handleGetCalibration)
+	subRtr.Path("/{" + idRequestKey + ":[0-9]+}").Methods(http.MethodPut).HandlerFunc(cHandler.handleSetCalibration)
+	subRtr.Methods(http.MethodGet).HandlerFunc(cHandler.handleGetCalibrations)
+}
+
+//handleGetCalibrations only lists switch machines that aren't wired the normal way
+func (this *calibrationHandler) handleGetCalibrations(w http.ResponseWriter, r *http.Request) {
+	calibrations := this.controller.Calibrations()
+	apiCalibrations := make([]*apiModel.Calibration, 0, len(calibrations))
+	for curId, curCalibration := range calibrations {
+		apiCalibrations = append(apiCalibrations, apiModel.NewAPICalibrationFromModel(curId, curCalibration))
+	}
+	sort.Slice(apiCalibrations, func(i, j int) bool { return apiCalibrations[i].SMId < apiCalibrations[j].SMId })
+
+	encodeErr := json.NewEncoder(w).Encode(apiCalibrations)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *calibrationHandler) handleGetCalibration(w http.ResponseWriter, r *http.Request) {
+	id, isValid := readId(w, r)
+	if !isValid {
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPICalibrationFromModel(id, this.controller.Calibrations()[id]))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *calibrationHandler) handleSetCalibration(w http.ResponseWriter, r *http.Request) {
+	id, isValid := readId(w, r)
+	if !isValid {
+		return
+	}
+	apiCalibration := &apiModel.Calibration{}
+	if err := json.NewDecoder(r.Body).Decode(apiCalibration); err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	calibration := apiModel.NewModelCalibrationFromAPI(apiCalibration)
+	err := this.controller.SetCalibration(id, calibration)
+	if err != nil {
+		//Ids without a driver mount aren't found, while a calibration that couldn't be saved is an internal error
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(apiModel.NewAPICalibrationFromModel(id, calibration))
+}
+
+func (this *calibrationHandler) handleGetSuggestions(w http.ResponseWriter, r *http.Request) {
+	suggestions := this.controller.CalibrationSuggestions()
+	apiSuggestions := make([]*apiModel.CalibrationSuggestion, 0, len(suggestions))
+	for _, curSuggestion := range suggestions {
+		apiSuggestions = append(apiSuggestions, apiModel.NewAPICalibrationSuggestionFromModel(curSuggestion))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiSuggestions)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//readId writes the error response itself when the path doesn't hold a valid id
+func readId(w http.ResponseWriter, r *http.Request) (switchmachine.Id, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)[idRequestKey], 10, 16)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed id in request"))
+		return 0, false
+	}
+	return switchmachine.Id(id), true
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+	"github.com/gorilla/mux"
+)
+
+const (
+	configHandlerPath string = "/config"
+	reloadPath        string = "/reload"
+	schemaPath        string = "/schema"
+	//Config files are small so anything bigger than this is a mistake
+	maxConfigBodyBytes int64 = 1 << 20
+
+	readOnlyErrorMessage        string = "Config can not be changed through the api on this server"
+	missingIfMatchErrorMessage  string = "If-Match header with the version being edited is required"
+	versionMismatchErrorMessage string = "Config has changed since it was read, get it again and reapply the edit"
+)
+
+type configHandler struct {
+	isReadOnly bool
+}
+
+//NewConfigHandler exposes reading, editing and reloading the server config. When isReadOnly the config can still be
+//read and reloaded from its file but not changed
+func NewConfigHandler(rtr *mux.Router, isReadOnly bool) {
+	cHandler := &configHandler{isReadOnly: isReadOnly}
+	subRtr := rtr.PathPrefix(configHandlerPath).Subrouter()
+	subRtr.Path(reloadPath).Methods(http.MethodPost).HandlerFunc(cHandler.handleReload)
+	subRtr.Path(schemaPath).Methods(http.MethodGet).HandlerFunc(cHandler.handleGetSchema)
+	subRtr.Methods(http.MethodGet).HandlerFunc(cHandler.handleGetConfig)
+	subRtr.Methods(http.MethodPut).HandlerFunc(cHandler.handleUpdateConfig)
+}
+
+func (this *configHandler) handleReload(w http.ResponseWriter, r *http.Request) {
+	result, err := smdsconfig.Reload()
+	if err != nil {
+		apiModel.WriteError(w, newInvalidConfigError(err))
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPIConfigReloadResultFromModel(result))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *configHandler) handleGetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write([]byte(smdsconfig.Schema))
+}
+
+func (this *configHandler) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	file, err := smdsconfig.ReadConfigFile()
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInternal, err.Error()))
+		return
+	}
+	w.Header().Set("ETag", quoteETag(file.Version))
+
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPIConfigFromModel(file, smdsconfig.Effective(), this.isReadOnly))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//handleUpdateConfig replaces the config file with the request body. The If-Match header has to hold the version the
+//edit was based on so edits made at the same time don't overwrite each other
+func (this *configHandler) handleUpdateConfig(w http.ResponseWriter, r *http.Request) {
+	if this.isReadOnly {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeForbidden, readOnlyErrorMessage))
+		return
+	}
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodePreconditionRequired, missingIfMatchErrorMessage))
+		return
+	}
+	contents, err := io.ReadAll(io.LimitReader(r.Body, maxConfigBodyBytes))
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+
+	file, result, err := smdsconfig.SaveConfigFile(contents, unquoteETag(ifMatch))
+	var configErr *smdsconfig.ConfigError
+	if errors.Is(err, smdsconfig.ErrVersionMismatch) {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodePreconditionFailed, versionMismatchErrorMessage))
+		return
+	} else if errors.As(err, &configErr) {
+		apiModel.WriteError(w, newInvalidConfigError(configErr))
+		return
+	} else if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInternal, err.Error()))
+		return
+	}
+	w.Header().Set("ETag", quoteETag(file.Version))
+
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPIConfigUpdateResultFromModel(file, result))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//newInvalidConfigError lists every problem with the config when err says what they are
+func newInvalidConfigError(err error) *apiModel.Error {
+	apiErr := apiModel.NewError(apiModel.ErrorCodeInvalidValue, err.Error())
+	var configErr *smdsconfig.ConfigError
+	if errors.As(err, &configErr) {
+		apiErr.Problems = configErr.Problems
+	}
+	return apiErr
+}
+
+func quoteETag(version string) string {
+	return `"` + version + `"`
+}
+
+//unquoteETag also accepts weak tags and bare versions as the version is only ever compared for equality
+func unquoteETag(eTag string) string {
+	return strings.Trim(strings.TrimPrefix(strings.TrimSpace(eTag), "W/"), `"`)
+}
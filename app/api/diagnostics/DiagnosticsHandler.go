@@ -0,0 +1,55 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+const (
+	busDiagnosticsPath string = "/diagnostics/bus"
+	mountQueryKey      string = "mount"
+)
+
+//BusMount is a driver mount that can show its bus along with where its ids sit in the global id space
+type BusMount struct {
+	Name     string
+	IdOffset switchmachine.Id
+	NumIds   uint
+	Driver   tortoise.BusDiagnosable
+}
+
+type diagnosticsHandler struct {
+	mounts []BusMount
+}
+
+//NewDiagnosticsHandler exposes the raw bus buffers of each mount for chasing wiring problems. ?mount=name limits the
+//response to one mount
+func NewDiagnosticsHandler(rtr *mux.Router, mounts []BusMount) {
+	dHandler := &diagnosticsHandler{mounts: mounts}
+	rtr.Path(busDiagnosticsPath).Methods(http.MethodGet).HandlerFunc(dHandler.handleGetBusDiagnostics)
+}
+
+func (this *diagnosticsHandler) handleGetBusDiagnostics(w http.ResponseWriter, r *http.Request) {
+	mountName := r.URL.Query().Get(mountQueryKey)
+	apiDiagnostics := make([]*apiModel.BusDiagnostics, 0, len(this.mounts))
+	for _, curMount := range this.mounts {
+		if mountName == "" || mountName == curMount.Name {
+			apiDiagnostics = append(apiDiagnostics, apiModel.NewAPIBusDiagnosticsFromModel(curMount.Name, curMount.IdOffset, curMount.NumIds, curMount.Driver.BusDiagnostics()))
+		}
+	}
+	if mountName != "" && len(apiDiagnostics) == 0 {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, "No driver mount named "+mountName+" that can show its bus"))
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiDiagnostics)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
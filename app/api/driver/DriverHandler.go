@@ -0,0 +1,38 @@
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/gorilla/mux"
+)
+
+const (
+	driverHandlerPath string = "/driver"
+)
+
+type driverHandler struct {
+	driver composite.CompositeDriver
+}
+
+//NewDriverHandler exposes the health of each mounted hardware driver
+func NewDriverHandler(rtr *mux.Router, driver composite.CompositeDriver) {
+	dHandler := &driverHandler{driver: driver}
+	rtr.Path(driverHandlerPath).Methods(http.MethodGet).HandlerFunc(dHandler.handleGetDriverHealth)
+}
+
+func (this *driverHandler) handleGetDriverHealth(w http.ResponseWriter, r *http.Request) {
+	mountHealths := this.driver.MountHealth()
+	apiHealths := make([]*apiModel.DriverMountHealth, 0, len(mountHealths))
+	for _, curHealth := range mountHealths {
+		apiHealths = append(apiHealths, apiModel.NewAPIDriverMountHealthFromModel(curHealth))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiHealths)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
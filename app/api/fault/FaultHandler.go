@@ -0,0 +1,133 @@
+package fault
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/gorilla/mux"
+)
+
+const (
+	driverRequestKey  string = "driver"
+	faultIdRequestKey string = "faultId"
+	faultHandlerPath  string = "/fault/{" + driverRequestKey + "}"
+)
+
+type faultHandler struct {
+	injectors map[string]tortoise.FaultInjector
+}
+
+//NewFaultHandler registers the fault injection api for each driver keyed by its driver mount name. Only meant for non production
+func NewFaultHandler(rtr *mux.Router, injectors map[string]tortoise.FaultInjector) {
+	fHandler := &faultHandler{injectors: injectors}
+	subRtr := rtr.PathPrefix(faultHandlerPath).Subrouter()
+	subRtr.Path("/seed").Methods(http.MethodPut).HandlerFunc(fHandler.handleSetSeed)
+	subRtr.Path("/{" + faultIdRequestKey + "}").Methods(http.MethodDelete).HandlerFunc(fHandler.handleClearFault)
+	subRtr.Methods(http.MethodGet).HandlerFunc(fHandler.handleGetFaults)
+	subRtr.Methods(http.MethodPost).HandlerFunc(fHandler.handleScheduleFault)
+	subRtr.Methods(http.MethodDelete).HandlerFunc(fHandler.handleClearFaults)
+}
+
+func (this *faultHandler) handleGetFaults(w http.ResponseWriter, r *http.Request) {
+	injector, ok := this.getInjectorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	faults := injector.Faults()
+	apiFaults := make([]*apiModel.Fault, 0, len(faults))
+	for _, curFault := range faults {
+		apiFaults = append(apiFaults, apiModel.NewAPIFaultFromModel(curFault))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiFaults)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *faultHandler) handleScheduleFault(w http.ResponseWriter, r *http.Request) {
+	injector, ok := this.getInjectorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	apiFault := &apiModel.Fault{}
+	if err := json.NewDecoder(r.Body).Decode(apiFault); err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	f, err := apiFault.ToModel()
+	var faultId tortoise.FaultId
+	if err == nil {
+		faultId, err = injector.ScheduleFault(f)
+	}
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInvalidValue, err.Error()))
+		return
+	}
+
+	scheduled := tortoise.ScheduledFault{Id: faultId, Fault: f}
+	for _, curFault := range injector.Faults() {
+		if curFault.Id == faultId {
+			scheduled = curFault
+		}
+	}
+	w.WriteHeader(http.StatusCreated)
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPIFaultFromModel(scheduled))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *faultHandler) handleClearFault(w http.ResponseWriter, r *http.Request) {
+	injector, ok := this.getInjectorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	faultId, err := strconv.ParseUint(mux.Vars(r)[faultIdRequestKey], 10, 64)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed fault id in request"))
+		return
+	}
+
+	err = injector.ClearFault(tortoise.FaultId(faultId))
+
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, err.Error()))
+	}
+}
+
+func (this *faultHandler) handleClearFaults(w http.ResponseWriter, r *http.Request) {
+	injector, ok := this.getInjectorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	injector.ClearFaults()
+}
+
+func (this *faultHandler) handleSetSeed(w http.ResponseWriter, r *http.Request) {
+	injector, ok := this.getInjectorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	seed := &apiModel.FaultSeed{}
+	err := json.NewDecoder(r.Body).Decode(seed)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed seed in request"))
+		return
+	}
+	injector.SetSeed(seed.Seed)
+}
+
+func (this *faultHandler) getInjectorFromRequest(w http.ResponseWriter, r *http.Request) (tortoise.FaultInjector, bool) {
+	name := mux.Vars(r)[driverRequestKey]
+	injector, ok := this.injectors[name]
+	if !ok {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, "No fault injectable driver mount named "+name))
+	}
+	return injector, ok
+}
@@ -0,0 +1,45 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/health"
+	"github.com/gorilla/mux"
+)
+
+const (
+	healthHandlerPath string = "/health"
+	readyHandlerPath  string = "/ready"
+)
+
+type healthHandler struct {
+	checker *health.Checker
+}
+
+//NewHealthHandler exposes liveness and readiness for monitoring. Both answer 503 when a check fails so they can be
+//used without reading the body
+func NewHealthHandler(rtr *mux.Router, checker *health.Checker) {
+	hHandler := &healthHandler{checker: checker}
+	rtr.Path(healthHandlerPath).Methods(http.MethodGet).HandlerFunc(hHandler.handleGetHealth)
+	rtr.Path(readyHandlerPath).Methods(http.MethodGet).HandlerFunc(hHandler.handleGetReady)
+}
+
+func (this *healthHandler) handleGetHealth(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, this.checker.Liveness())
+}
+
+func (this *healthHandler) handleGetReady(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, this.checker.Readiness())
+}
+
+func writeReport(w http.ResponseWriter, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	//Monitoring should always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.Ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(apiModel.NewAPIHealthReportFromModel(report))
+}
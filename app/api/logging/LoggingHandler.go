@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+	"github.com/gorilla/mux"
+)
+
+const (
+	loggingHandlerPath  string = "/logging"
+	subsystemRequestKey string = "subsystem"
+
+	unknownSubsystemErrorMessage string = "No logging subsystem named "
+)
+
+type loggingHandler struct {
+}
+
+//NewLoggingHandler exposes the log levels so they can be turned up while chasing a problem. Changes last until the
+//config is next loaded
+func NewLoggingHandler(rtr *mux.Router) {
+	lHandler := &loggingHandler{}
+	subRtr := rtr.PathPrefix(loggingHandlerPath).Subrouter()
+	subRtr.Path("/{" + subsystemRequestKey + "}").Methods(http.MethodPut).HandlerFunc(lHandler.handleSetSubsystemLevel)
+	subRtr.Methods(http.MethodPut).HandlerFunc(lHandler.handleSetDefaultLevel)
+	subRtr.Methods(http.MethodGet).HandlerFunc(lHandler.handleGetLogging)
+}
+
+func (this *loggingHandler) handleGetLogging(w http.ResponseWriter, r *http.Request) {
+	apiLogging := apiModel.NewAPILoggingFromModel(logging.CurrentFormat(), logging.DefaultLevel(), logging.Levels())
+
+	encodeErr := json.NewEncoder(w).Encode(apiLogging)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *loggingHandler) handleSetDefaultLevel(w http.ResponseWriter, r *http.Request) {
+	level, isValid := readLevel(w, r)
+	if !isValid {
+		return
+	}
+	logging.SetDefaultLevel(level)
+	this.handleGetLogging(w, r)
+}
+
+func (this *loggingHandler) handleSetSubsystemLevel(w http.ResponseWriter, r *http.Request) {
+	subsystem := mux.Vars(r)[subsystemRequestKey]
+	if !logging.IsSubsystem(subsystem) {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, unknownSubsystemErrorMessage+subsystem))
+		return
+	}
+	level, isValid := readLevel(w, r)
+	if !isValid {
+		return
+	}
+	logging.SetLevel(subsystem, level)
+	this.handleGetLogging(w, r)
+}
+
+//readLevel writes the error response itself when the request doesn't hold a valid level
+func readLevel(w http.ResponseWriter, r *http.Request) (logging.Level, bool) {
+	apiLevel := &apiModel.LogLevel{}
+	if err := json.NewDecoder(r.Body).Decode(apiLevel); err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return logging.LevelInfo, false
+	}
+	level, err := logging.ParseLevel(apiLevel.Level)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInvalidValue, err.Error()))
+		return logging.LevelInfo, false
+	}
+	return level, true
+}
@@ -0,0 +1,102 @@
+package model
+
+import (
+	"encoding/hex"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Board is one main controller board along with the switch machines on its ports. Board numbers count across every
+//driver mount the same as ids do
+type Board struct {
+	Board uint `json:"board"`
+
+	Mount string `json:"mount"`
+
+	//MountBoard is where the board is in the chain of its own mount
+	MountBoard uint `json:"mountBoard"`
+
+	FirstId SwitchMachineId `json:"firstId"`
+
+	//Attached is whether a switch machine is attached to any of its ports
+	Attached bool `json:"attached"`
+
+	//Hex of the bytes of the bus buffers that belong to this board. Empty when the mount can't show its bus or hasn't
+	//used it yet
+	TxBytes string `json:"txBytes"`
+
+	RxBytes string `json:"rxBytes"`
+
+	PrevRxBytes string `json:"prevRxBytes"`
+
+	Ports []*BoardPort `json:"ports"`
+}
+
+type BoardPort struct {
+	Port uint `json:"port"`
+
+	SMId SwitchMachineId `json:"id"`
+
+	Attached bool `json:"attached"`
+
+	//Only set when a switch machine is attached
+	SwitchMachine *SwitchMachine `json:"switchMachine,omitempty"`
+
+	//Only set when the mount can show its bus
+	Bus *PortDiagnostics `json:"bus,omitempty"`
+}
+
+//BoardGPIO sets the gpio of every switch machine on a board. Only the ones that are sent are changed
+type BoardGPIO struct {
+	Gpio0 *GPIOState `json:"gpio0,omitempty"`
+
+	Gpio1 *GPIOState `json:"gpio1,omitempty"`
+}
+
+//NewAPIBoardFromModel is given the state of the switch machine on each port, nil for ports without one, and the bus
+//diagnostics of the board when its mount has them
+func NewAPIBoardFromModel(number uint, mount string, mountBoard uint, firstId switchmachine.Id, portStates []switchmachine.State, diagnostics *tortoise.BoardDiagnostics) *Board {
+	apiBoard := &Board{Board: number, Mount: mount, MountBoard: mountBoard, FirstId: SwitchMachineId(firstId)}
+	apiBoard.Ports = make([]*BoardPort, 0, len(portStates))
+	for port, curState := range portStates {
+		curId := firstId + switchmachine.Id(port)
+		apiPort := &BoardPort{Port: uint(port), SMId: SwitchMachineId(curId), Attached: curState != nil}
+		if curState != nil {
+			apiPort.SwitchMachine = NewAPISwitchMachineFromModel(curState)
+			apiBoard.Attached = true
+		}
+		if diagnostics != nil && port < len(diagnostics.Ports) {
+			//Diagnostics ids are local to the mount
+			apiPort.Bus = newAPIPortDiagnosticsFromModel(curId-diagnostics.Ports[port].Id, diagnostics.Ports[port])
+		}
+		apiBoard.Ports = append(apiBoard.Ports, apiPort)
+	}
+	if diagnostics != nil {
+		apiBoard.TxBytes = hex.EncodeToString(diagnostics.TxBytes)
+		apiBoard.RxBytes = hex.EncodeToString(diagnostics.RxBytes)
+		apiBoard.PrevRxBytes = hex.EncodeToString(diagnostics.PrevRxBytes)
+	}
+	return apiBoard
+}
+
+//UpdateProblems lists everything that stops this from being sent to a board
+func (this *BoardGPIO) UpdateProblems() []string {
+	problems := make([]string, 0)
+	if this.Gpio0 == nil && this.Gpio1 == nil {
+		problems = append(problems, "gpio0 or gpio1 is required")
+	}
+	if this.Gpio0 != nil {
+		problems = appendGPIOProblems(problems, "gpio0", *this.Gpio0)
+	}
+	if this.Gpio1 != nil {
+		problems = appendGPIOProblems(problems, "gpio1", *this.Gpio1)
+	}
+	return problems
+}
+
+//ApplyTo is the update that sets the gpio of curState, which keeps moving to where it is heading
+func (this *BoardGPIO) ApplyTo(curState switchmachine.State) *SwitchMachine {
+	patch := &SwitchMachinePatch{Gpio0: this.Gpio0, Gpio1: this.Gpio1}
+	return patch.ApplyTo(curState)
+}
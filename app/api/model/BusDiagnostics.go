@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+const (
+	PositionEncodingPort03 string = "port03"
+	PositionEncodingPort12 string = "port12"
+)
+
+//BusDiagnostics shows the raw bus buffers of one driver mount as hex along with what they decode to. Ids are in the
+//global id space
+type BusDiagnostics struct {
+	Mount string `json:"mount"`
+
+	IdOffset SwitchMachineId `json:"idOffset"`
+
+	TxBuffer string `json:"txBuffer"`
+
+	RxBuffer string `json:"rxBuffer"`
+
+	PrevRxBuffer string `json:"prevRxBuffer"`
+
+	LastTxTimeMillis int64 `json:"lastTxTimeMillis,omitempty"`
+
+	LastRxTimeMillis int64 `json:"lastRxTimeMillis,omitempty"`
+
+	Boards []*BoardDiagnostics `json:"boards"`
+}
+
+type BoardDiagnostics struct {
+	Board uint `json:"board"`
+
+	Ports []*PortDiagnostics `json:"ports"`
+}
+
+//PortDiagnostics has each group of bits written out in binary so they can be compared against the wiring
+type PortDiagnostics struct {
+	SMId SwitchMachineId `json:"id"`
+
+	Port uint `json:"port"`
+
+	MotorBits string `json:"motorBits"`
+
+	GPIOBits string `json:"gpioBits"`
+
+	Motor SwitchMachineMotorState `json:"motorState"`
+
+	Gpio0 GPIOState `json:"gpio0"`
+
+	Gpio1 GPIOState `json:"gpio1"`
+
+	PositionBits string `json:"positionBits"`
+
+	PrevPositionBits string `json:"prevPositionBits"`
+
+	PositionEncoding string `json:"positionEncoding"`
+
+	Attached bool `json:"attached"`
+
+	Pos SwitchMachinePosition `json:"position"`
+
+	TxChangeTimeMillis int64 `json:"txChangeTimeMillis,omitempty"`
+
+	RxChangeTimeMillis int64 `json:"rxChangeTimeMillis,omitempty"`
+}
+
+//NewAPIBusDiagnosticsFromModel only includes the boards that hold ids within the numIds of the mount
+func NewAPIBusDiagnosticsFromModel(mount string, idOffset switchmachine.Id, numIds uint, diagnostics tortoise.BusDiagnostics) *BusDiagnostics {
+	apiDiagnostics := &BusDiagnostics{Mount: mount, IdOffset: SwitchMachineId(idOffset)}
+	apiDiagnostics.TxBuffer = hex.EncodeToString(diagnostics.TxBuffer)
+	apiDiagnostics.RxBuffer = hex.EncodeToString(diagnostics.RxBuffer)
+	apiDiagnostics.PrevRxBuffer = hex.EncodeToString(diagnostics.PrevRxBuffer)
+	apiDiagnostics.LastTxTimeMillis = unixMilliOrZero(diagnostics.LastTxTime)
+	apiDiagnostics.LastRxTimeMillis = unixMilliOrZero(diagnostics.LastRxTime)
+	apiDiagnostics.Boards = make([]*BoardDiagnostics, 0, len(diagnostics.Boards))
+	for _, curBoard := range diagnostics.Boards {
+		if len(curBoard.Ports) == 0 || uint(curBoard.Ports[0].Id) >= numIds {
+			continue
+		}
+		apiBoard := &BoardDiagnostics{Board: curBoard.Number, Ports: make([]*PortDiagnostics, 0, len(curBoard.Ports))}
+		for _, curPort := range curBoard.Ports {
+			apiBoard.Ports = append(apiBoard.Ports, newAPIPortDiagnosticsFromModel(idOffset, curPort))
+		}
+		apiDiagnostics.Boards = append(apiDiagnostics.Boards, apiBoard)
+	}
+	return apiDiagnostics
+}
+
+func newAPIPortDiagnosticsFromModel(idOffset switchmachine.Id, port tortoise.PortDiagnostics) *PortDiagnostics {
+	apiPort := &PortDiagnostics{}
+	apiPort.SMId = SwitchMachineId(idOffset + port.Id)
+	apiPort.Port = port.Port
+	//Motor bits sit above the gpio bits so are shifted down to read on their own
+	apiPort.MotorBits = fmt.Sprintf("%02b", port.MotorBits>>2)
+	apiPort.GPIOBits = fmt.Sprintf("%02b", port.GPIOBits)
+	apiPort.Motor = MapModelMStateToAPIMState(port.MotorState)
+	apiPort.Gpio0 = MapModelGPIOToAPI(port.GPIO0)
+	apiPort.Gpio1 = MapModelGPIOToAPI(port.GPIO1)
+	apiPort.PositionBits = fmt.Sprintf("%02b", port.RxBits)
+	apiPort.PrevPositionBits = fmt.Sprintf("%02b", port.PrevRxBits)
+	apiPort.PositionEncoding = PositionEncodingPort12
+	if port.IsPort03Encoding {
+		apiPort.PositionEncoding = PositionEncodingPort03
+	}
+	apiPort.Attached = port.Attached
+	apiPort.Pos = MapModelPosToApiPos(port.Position)
+	apiPort.TxChangeTimeMillis = unixMilliOrZero(port.TxChangeTime)
+	apiPort.RxChangeTimeMillis = unixMilliOrZero(port.RxChangeTime)
+	return apiPort
+}
+
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
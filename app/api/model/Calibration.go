@@ -0,0 +1,55 @@
+package model
+
+import (
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Calibration is how a switch machine's wiring is corrected. Id is ignored when setting one as it comes from the path
+type Calibration struct {
+	SMId SwitchMachineId `json:"id"`
+
+	SwapMotor bool `json:"swapMotor"`
+
+	SwapFeedback bool `json:"swapFeedback"`
+}
+
+type CalibrationSuggestion struct {
+	SMId SwitchMachineId `json:"id"`
+
+	Target SwitchMachinePosition `json:"target"`
+
+	ObservedPosition SwitchMachinePosition `json:"observedPosition"`
+
+	Count int `json:"count"`
+
+	FirstSeenMillis int64 `json:"firstSeenMillis"`
+
+	LastSeenMillis int64 `json:"lastSeenMillis"`
+
+	Current *Calibration `json:"current"`
+
+	Suggested *Calibration `json:"suggested"`
+
+	Message string `json:"message"`
+}
+
+func NewAPICalibrationFromModel(id switchmachine.Id, calibration hardware.Calibration) *Calibration {
+	return &Calibration{SMId: SwitchMachineId(id), SwapMotor: calibration.SwapMotor, SwapFeedback: calibration.SwapFeedback}
+}
+
+func NewModelCalibrationFromAPI(apiCalibration *Calibration) hardware.Calibration {
+	return hardware.Calibration{SwapMotor: apiCalibration.SwapMotor, SwapFeedback: apiCalibration.SwapFeedback}
+}
+
+func NewAPICalibrationSuggestionFromModel(suggestion controller.CalibrationSuggestion) *CalibrationSuggestion {
+	apiSuggestion := &CalibrationSuggestion{SMId: SwitchMachineId(suggestion.Id), Count: suggestion.Count, Message: suggestion.Message}
+	apiSuggestion.Target = MapModelPosToApiPos(suggestion.Target)
+	apiSuggestion.ObservedPosition = MapModelPosToApiPos(suggestion.ObservedPosition)
+	apiSuggestion.FirstSeenMillis = suggestion.FirstSeen.UnixMilli()
+	apiSuggestion.LastSeenMillis = suggestion.LastSeen.UnixMilli()
+	apiSuggestion.Current = NewAPICalibrationFromModel(suggestion.Id, suggestion.Current)
+	apiSuggestion.Suggested = NewAPICalibrationFromModel(suggestion.Id, suggestion.Suggested)
+	return apiSuggestion
+}
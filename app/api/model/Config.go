@@ -0,0 +1,32 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+)
+
+//Config is the config file as it can be edited along with the config the server is actually running with
+type Config struct {
+	Version string `json:"version"`
+
+	ReadOnly bool `json:"readOnly"`
+
+	File json.RawMessage `json:"file"`
+
+	Effective smdsconfig.SMDSConfig `json:"effective"`
+}
+
+type ConfigUpdateResult struct {
+	Version string `json:"version"`
+
+	ConfigReloadResult
+}
+
+func NewAPIConfigFromModel(file smdsconfig.ConfigFile, effective smdsconfig.SMDSConfig, readOnly bool) *Config {
+	return &Config{Version: file.Version, ReadOnly: readOnly, File: json.RawMessage(file.Contents), Effective: effective}
+}
+
+func NewAPIConfigUpdateResultFromModel(file smdsconfig.ConfigFile, result smdsconfig.ReloadResult) *ConfigUpdateResult {
+	return &ConfigUpdateResult{Version: file.Version, ConfigReloadResult: *NewAPIConfigReloadResultFromModel(result)}
+}
@@ -0,0 +1,20 @@
+package model
+
+import "github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+
+type ConfigReloadResult struct {
+	Applied []string `json:"applied"`
+
+	RestartRequired []string `json:"restartRequired"`
+}
+
+func NewAPIConfigReloadResultFromModel(result smdsconfig.ReloadResult) *ConfigReloadResult {
+	apiResult := &ConfigReloadResult{Applied: result.Applied, RestartRequired: result.RestartRequired}
+	if apiResult.Applied == nil {
+		apiResult.Applied = make([]string, 0)
+	}
+	if apiResult.RestartRequired == nil {
+		apiResult.RestartRequired = make([]string, 0)
+	}
+	return apiResult
+}
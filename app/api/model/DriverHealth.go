@@ -0,0 +1,45 @@
+package model
+
+import (
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+)
+
+type DriverMountHealth struct {
+	Name string `json:"name"`
+
+	FirstId SwitchMachineId `json:"firstId"`
+
+	LastId SwitchMachineId `json:"lastId"`
+
+	Reported bool `json:"reported"`
+
+	Healthy bool `json:"healthy"`
+
+	Running bool `json:"running"`
+
+	LastLoopTimeMillis int64 `json:"lastLoopTimeMillis,omitempty"`
+
+	LastBusReadTimeMillis int64 `json:"lastBusReadTimeMillis,omitempty"`
+
+	LastError string `json:"lastError,omitempty"`
+}
+
+func NewAPIDriverMountHealthFromModel(mHealth composite.MountHealth) *DriverMountHealth {
+	apiHealth := &DriverMountHealth{}
+	apiHealth.Name = mHealth.Name
+	apiHealth.FirstId = SwitchMachineId(mHealth.IdOffset)
+	apiHealth.LastId = SwitchMachineId(uint(mHealth.IdOffset) + mHealth.NumIds - 1)
+	apiHealth.Reported = mHealth.Reported
+	apiHealth.Healthy = mHealth.Health.Healthy
+	apiHealth.Running = mHealth.Health.Running
+	if !mHealth.Health.LastLoopTime.IsZero() {
+		apiHealth.LastLoopTimeMillis = mHealth.Health.LastLoopTime.UnixMilli()
+	}
+	if !mHealth.Health.LastBusReadTime.IsZero() {
+		apiHealth.LastBusReadTimeMillis = mHealth.Health.LastBusReadTime.UnixMilli()
+	}
+	if mHealth.Health.LastError != nil {
+		apiHealth.LastError = mHealth.Health.LastError.Error()
+	}
+	return apiHealth
+}
@@ -1,7 +1,97 @@
 package model
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/selftest"
+)
+
+//ErrorCode says what went wrong in a way clients can check without parsing the message. Each code is always sent
+//with the same http status
+type ErrorCode string
+
+const (
+	ErrorCodeMalformedRequest     ErrorCode = "malformedRequest"
+	ErrorCodeForbidden            ErrorCode = "forbidden"
+	ErrorCodeNotFound             ErrorCode = "notFound"
+	ErrorCodeConflict             ErrorCode = "conflict"
+	ErrorCodePreconditionFailed   ErrorCode = "preconditionFailed"
+	ErrorCodeInvalidValue         ErrorCode = "invalidValue"
+	ErrorCodePreconditionRequired ErrorCode = "preconditionRequired"
+	ErrorCodeInternal             ErrorCode = "internal"
+	ErrorCodeUnavailable          ErrorCode = "unavailable"
+)
+
+var errorCodeStatuses = map[ErrorCode]int{
+	ErrorCodeMalformedRequest:     http.StatusBadRequest,
+	ErrorCodeForbidden:            http.StatusForbidden,
+	ErrorCodeNotFound:             http.StatusNotFound,
+	ErrorCodeConflict:             http.StatusConflict,
+	ErrorCodePreconditionFailed:   http.StatusPreconditionFailed,
+	ErrorCodeInvalidValue:         http.StatusUnprocessableEntity,
+	ErrorCodePreconditionRequired: http.StatusPreconditionRequired,
+	ErrorCodeInternal:             http.StatusInternalServerError,
+	ErrorCodeUnavailable:          http.StatusServiceUnavailable,
+}
+
+//Status is the http status the code is sent with
+func (this ErrorCode) Status() int {
+	status, isKnown := errorCodeStatuses[this]
+	if !isKnown {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
 type Error struct {
+	Code ErrorCode `json:"code"`
+
 	Message string `json:"message,omitempty"`
 
+	//Problems lists everything wrong with a value when there is more than one
+	Problems []string `json:"problems,omitempty"`
+
 	Causedby *Error `json:"causedby,omitempty"`
 }
+
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+//NewAPIErrorFromModel picks the code from the errors the controller and drivers return. Anything it doesn't know
+//about is an internal error
+func NewAPIErrorFromModel(err error) *Error {
+	code := ErrorCodeInternal
+	switch {
+	case errors.Is(err, controller.ErrSwitchMachineNotExist), errors.Is(err, composite.ErrNoMountForId):
+		code = ErrorCodeNotFound
+	case errors.Is(err, selftest.ErrRunInProgress), errors.Is(err, controller.ErrSwitchMachinePositionUnknown):
+		code = ErrorCodeConflict
+	case errors.Is(err, controller.ErrSwitchMachineVersionMismatch):
+		code = ErrorCodePreconditionFailed
+	case errors.Is(err, hardware.ErrNotCalibratable), errors.Is(err, selftest.ErrNoSwitchMachines):
+		code = ErrorCodeInvalidValue
+	case errors.Is(err, controller.ErrControllerShuttingDown), errors.Is(err, hardware.ErrDriverClosed),
+		errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		code = ErrorCodeUnavailable
+	}
+	return NewError(code, err.Error())
+}
+
+//WriteError sends apiErr as json with the status of its code
+func WriteError(w http.ResponseWriter, apiErr *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code.Status())
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+//WriteErrorFromModel is WriteError for errors returned by the controller or drivers
+func WriteErrorFromModel(w http.ResponseWriter, err error) {
+	WriteError(w, NewAPIErrorFromModel(err))
+}
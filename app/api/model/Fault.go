@@ -0,0 +1,64 @@
+package model
+
+import (
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+type Fault struct {
+	Id uint64 `json:"id,omitempty"`
+
+	Kind string `json:"kind"`
+
+	SMId *SwitchMachineId `json:"switchMachineId,omitempty"`
+
+	Board *uint `json:"board,omitempty"`
+
+	StartAfterReads uint64 `json:"startAfterReads,omitempty"`
+
+	DurationReads uint64 `json:"durationReads,omitempty"`
+
+	Probability float64 `json:"probability,omitempty"`
+
+	DelayMillis int64 `json:"delayMillis,omitempty"`
+
+	Active bool `json:"active"`
+}
+
+type FaultSeed struct {
+	Seed int64 `json:"seed"`
+}
+
+func NewAPIFaultFromModel(f tortoise.ScheduledFault) *Fault {
+	apiFault := &Fault{}
+	apiFault.Id = uint64(f.Id)
+	apiFault.Kind = string(f.Kind)
+	if f.SMId != nil {
+		smId := SwitchMachineId(*f.SMId)
+		apiFault.SMId = &smId
+	}
+	apiFault.Board = f.Board
+	apiFault.StartAfterReads = f.StartAfterReads
+	apiFault.DurationReads = f.DurationReads
+	apiFault.Probability = f.Probability
+	apiFault.DelayMillis = f.Delay.Milliseconds()
+	apiFault.Active = f.Active
+	return apiFault
+}
+
+func (this *Fault) ToModel() (tortoise.Fault, error) {
+	kind, err := tortoise.ParseFaultKind(this.Kind)
+	f := tortoise.Fault{Kind: kind}
+	if this.SMId != nil {
+		smId := switchmachine.Id(*this.SMId)
+		f.SMId = &smId
+	}
+	f.Board = this.Board
+	f.StartAfterReads = this.StartAfterReads
+	f.DurationReads = this.DurationReads
+	f.Probability = this.Probability
+	f.Delay = time.Duration(this.DelayMillis) * time.Millisecond
+	return f, err
+}
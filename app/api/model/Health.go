@@ -0,0 +1,43 @@
+package model
+
+import "github.com/ZacharyDuve/SwitchMachineDriverServer/app/health"
+
+const (
+	HealthStatusOk   string = "ok"
+	HealthStatusFail string = "fail"
+)
+
+type HealthCheck struct {
+	Name string `json:"name"`
+
+	Mount string `json:"mount,omitempty"`
+
+	Ok bool `json:"ok"`
+
+	Message string `json:"message"`
+}
+
+//HealthReport is either the liveness or readiness of the server along with the health of each mount it was based on
+type HealthReport struct {
+	Status string `json:"status"`
+
+	Checks []*HealthCheck `json:"checks"`
+
+	Mounts []*DriverMountHealth `json:"mounts"`
+}
+
+func NewAPIHealthReportFromModel(report health.Report) *HealthReport {
+	apiReport := &HealthReport{Status: HealthStatusOk}
+	if !report.Ok {
+		apiReport.Status = HealthStatusFail
+	}
+	apiReport.Checks = make([]*HealthCheck, 0, len(report.Checks))
+	for _, curCheck := range report.Checks {
+		apiReport.Checks = append(apiReport.Checks, &HealthCheck{Name: curCheck.Name, Mount: curCheck.Mount, Ok: curCheck.Ok, Message: curCheck.Message})
+	}
+	apiReport.Mounts = make([]*DriverMountHealth, 0, len(report.Mounts))
+	for _, curMount := range report.Mounts {
+		apiReport.Mounts = append(apiReport.Mounts, NewAPIDriverMountHealthFromModel(curMount))
+	}
+	return apiReport
+}
@@ -0,0 +1,24 @@
+package model
+
+import "github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+
+//Logging is how much each subsystem is logging. Level is used by every subsystem not given its own
+type Logging struct {
+	Format string `json:"format"`
+
+	Level string `json:"level"`
+
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+func NewAPILoggingFromModel(format logging.Format, defaultLevel logging.Level, levels map[string]logging.Level) *Logging {
+	apiLogging := &Logging{Format: string(format), Level: defaultLevel.String(), Subsystems: make(map[string]string, len(levels))}
+	for name, level := range levels {
+		apiLogging.Subsystems[name] = level.String()
+	}
+	return apiLogging
+}
@@ -0,0 +1,39 @@
+package model
+
+import "github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+
+type Operation struct {
+	OperationId uint64 `json:"operationId"`
+
+	SMId SwitchMachineId `json:"id"`
+
+	Status string `json:"status"`
+
+	//Left out until the driver has taken the update
+	TargetPosition SwitchMachinePosition `json:"targetPosition,omitempty"`
+
+	//Left out until it has finished
+	Position SwitchMachinePosition `json:"position,omitempty"`
+
+	Message string `json:"message,omitempty"`
+
+	CreateTimeMillis int64 `json:"createTimeMillis"`
+
+	StartTimeMillis int64 `json:"startTimeMillis,omitempty"`
+
+	EndTimeMillis int64 `json:"endTimeMillis,omitempty"`
+}
+
+func NewAPIOperationFromModel(op operation.Operation) *Operation {
+	apiOp := &Operation{OperationId: uint64(op.Id), SMId: SwitchMachineId(op.SMId), Status: string(op.Status), Message: op.Message}
+	if !op.StartTime.IsZero() {
+		apiOp.TargetPosition = MapModelPosToApiPos(op.Target)
+	}
+	if op.IsFinished() {
+		apiOp.Position = MapModelPosToApiPos(op.Position)
+	}
+	apiOp.CreateTimeMillis = op.CreateTime.UnixMilli()
+	apiOp.StartTimeMillis = unixMilliOrZero(op.StartTime)
+	apiOp.EndTimeMillis = unixMilliOrZero(op.EndTime)
+	return apiOp
+}
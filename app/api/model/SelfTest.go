@@ -0,0 +1,137 @@
+package model
+
+import (
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/selftest"
+)
+
+//SelfTestProgressEventType is sent over the event websocket alongside the switch machine events
+const SelfTestProgressEventType string = "SelfTestProgress"
+
+type SelfTestRun struct {
+	RunId uint64 `json:"runId"`
+
+	Ids []SwitchMachineId `json:"ids"`
+
+	Status string `json:"status"`
+
+	Passed int `json:"passed"`
+
+	Failed int `json:"failed"`
+
+	Ports []*SelfTestPortReport `json:"ports"`
+
+	StartTimeMillis int64 `json:"startTimeMillis"`
+
+	EndTimeMillis int64 `json:"endTimeMillis,omitempty"`
+}
+
+type SelfTestPortReport struct {
+	SMId SwitchMachineId `json:"id"`
+
+	Result string `json:"result"`
+
+	Message string `json:"message,omitempty"`
+
+	Throws []*SelfTestThrowStep `json:"throws"`
+
+	GPIOs []*SelfTestGPIOStep `json:"gpios"`
+
+	StartTimeMillis int64 `json:"startTimeMillis"`
+
+	EndTimeMillis int64 `json:"endTimeMillis"`
+}
+
+type SelfTestThrowStep struct {
+	Target SwitchMachinePosition `json:"target"`
+
+	StartPosition SwitchMachinePosition `json:"startPosition"`
+
+	EndPosition SwitchMachinePosition `json:"endPosition"`
+
+	Outcome string `json:"outcome"`
+
+	TravelTimeMillis int64 `json:"travelTimeMillis,omitempty"`
+
+	Message string `json:"message,omitempty"`
+}
+
+type SelfTestGPIOStep struct {
+	GPIO uint `json:"gpio"`
+
+	Ok bool `json:"ok"`
+
+	Message string `json:"message,omitempty"`
+}
+
+//SelfTestEvent is progress of a self test. Port is only set once a switch machine has finished being tested
+type SelfTestEvent struct {
+	EventType string `json:"eventType"`
+
+	RunId uint64 `json:"runId"`
+
+	Stage string `json:"stage"`
+
+	SMId SwitchMachineId `json:"id"`
+
+	Message string `json:"message,omitempty"`
+
+	PortsDone int `json:"portsDone"`
+
+	PortsTotal int `json:"portsTotal"`
+
+	Port *SelfTestPortReport `json:"port,omitempty"`
+}
+
+func NewAPISelfTestRunFromModel(run selftest.Run) *SelfTestRun {
+	apiRun := &SelfTestRun{RunId: uint64(run.Id), Status: string(run.Status)}
+	apiRun.Ids = make([]SwitchMachineId, 0, len(run.Ids))
+	for _, curId := range run.Ids {
+		apiRun.Ids = append(apiRun.Ids, SwitchMachineId(curId))
+	}
+	apiRun.Ports = make([]*SelfTestPortReport, 0, len(run.Ports))
+	for _, curPort := range run.Ports {
+		if curPort.Result == selftest.PortResultPass {
+			apiRun.Passed++
+		} else {
+			apiRun.Failed++
+		}
+		apiRun.Ports = append(apiRun.Ports, NewAPISelfTestPortReportFromModel(curPort))
+	}
+	apiRun.StartTimeMillis = run.StartTime.UnixMilli()
+	apiRun.EndTimeMillis = unixMilliOrZero(run.EndTime)
+	return apiRun
+}
+
+func NewAPISelfTestPortReportFromModel(report selftest.PortReport) *SelfTestPortReport {
+	apiReport := &SelfTestPortReport{SMId: SwitchMachineId(report.Id), Result: string(report.Result), Message: report.Message}
+	apiReport.Throws = make([]*SelfTestThrowStep, 0, len(report.Throws))
+	for _, curStep := range report.Throws {
+		apiReport.Throws = append(apiReport.Throws, &SelfTestThrowStep{
+			Target:           MapModelPosToApiPos(curStep.Target),
+			StartPosition:    MapModelPosToApiPos(curStep.StartPosition),
+			EndPosition:      MapModelPosToApiPos(curStep.EndPosition),
+			Outcome:          string(curStep.Outcome),
+			TravelTimeMillis: curStep.TravelTime.Milliseconds(),
+			Message:          curStep.Message,
+		})
+	}
+	apiReport.GPIOs = make([]*SelfTestGPIOStep, 0, len(report.GPIOs))
+	for _, curStep := range report.GPIOs {
+		apiReport.GPIOs = append(apiReport.GPIOs, &SelfTestGPIOStep{GPIO: curStep.GPIO, Ok: curStep.Ok, Message: curStep.Message})
+	}
+	apiReport.StartTimeMillis = report.StartTime.UnixMilli()
+	apiReport.EndTimeMillis = report.EndTime.UnixMilli()
+	return apiReport
+}
+
+func NewAPISelfTestEventFromModel(progress selftest.Progress) *SelfTestEvent {
+	apiEvent := &SelfTestEvent{EventType: SelfTestProgressEventType, RunId: uint64(progress.RunId), Stage: string(progress.Stage)}
+	apiEvent.SMId = SwitchMachineId(progress.Id)
+	apiEvent.Message = progress.Message
+	apiEvent.PortsDone = progress.PortsDone
+	apiEvent.PortsTotal = progress.PortsTotal
+	if progress.Port != nil {
+		apiEvent.Port = NewAPISelfTestPortReportFromModel(*progress.Port)
+	}
+	return apiEvent
+}
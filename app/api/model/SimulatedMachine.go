@@ -0,0 +1,45 @@
+package model
+
+import (
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+)
+
+type SimulatedMachine struct {
+	SMId SwitchMachineId `json:"id"`
+
+	Travel float64 `json:"travel"`
+
+	Pos SwitchMachinePosition `json:"position"`
+
+	Motor SwitchMachineMotorState `json:"motorState"`
+
+	Gpio0 GPIOState `json:"gpio0"`
+
+	Gpio1 GPIOState `json:"gpio1"`
+
+	Fault string `json:"fault"`
+}
+
+type SimulatedMachineAttachRequest struct {
+	Pos SwitchMachinePosition `json:"position"`
+}
+
+type SimulatedFaultRequest struct {
+	Fault string `json:"fault"`
+}
+
+type SimulatorTravelTime struct {
+	TravelTimeMillis int64 `json:"travelTimeMillis"`
+}
+
+func NewAPISimulatedMachineFromModel(sm tortoise.SimulatedMachine) *SimulatedMachine {
+	apiSM := &SimulatedMachine{}
+	apiSM.SMId = SwitchMachineId(sm.Id)
+	apiSM.Travel = sm.Travel
+	apiSM.Pos = MapModelPosToApiPos(sm.Position)
+	apiSM.Motor = MapModelMStateToAPIMState(sm.Motor)
+	apiSM.Gpio0 = MapModelGPIOToAPI(sm.GPIO0)
+	apiSM.Gpio1 = MapModelGPIOToAPI(sm.GPIO1)
+	apiSM.Fault = string(sm.Fault)
+	return apiSM
+}
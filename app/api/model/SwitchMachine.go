@@ -1,8 +1,12 @@
 package model
 
 import (
+	"fmt"
+	"hash/fnv"
+	"math"
 	"time"
 
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
 	"github.com/ZacharyDuve/serverid"
 	"github.com/google/uuid"
@@ -34,6 +38,9 @@ type SwitchMachine struct {
 	UpdTimeMillis int64 `json:"updateTimeMillis"`
 
 	OriginServerId uuid.UUID `json:"originServerId"`
+
+	//Sent back on updates so they are only made if the switch machine is still at this version, 0 skips the check
+	Ver uint64 `json:"version"`
 }
 
 func NewAPISwitchMachineFromModel(modelSM switchmachine.State) *SwitchMachine {
@@ -45,9 +52,30 @@ func NewAPISwitchMachineFromModel(modelSM switchmachine.State) *SwitchMachine {
 	apiSM.Motor = MapModelMStateToAPIMState(modelSM.MotorState())
 	apiSM.UpdTimeMillis = modelSM.UpdateTime().UnixMilli()
 	apiSM.OriginServerId = serveridSrv.GetServerId()
+	apiSM.Ver = modelSM.Version()
 	return apiSM
 }
 
+//UpdatedSwitchMachine is sent back for an update of a single switch machine
+type UpdatedSwitchMachine struct {
+	//OperationId can be followed until the switch machine has finished moving
+	OperationId uint64 `json:"operationId"`
+
+	SwitchMachine
+}
+
+func NewAPIUpdatedSwitchMachineFromModel(modelSM switchmachine.State, opId operation.Id) *UpdatedSwitchMachine {
+	return &UpdatedSwitchMachine{OperationId: uint64(opId), SwitchMachine: *NewAPISwitchMachineFromModel(modelSM)}
+}
+
+//NewETagFromModel is the quoted ETag of a switch machine. The version is kept in front so it can be read off, the rest
+//is from the state and its update time so tags from before a restart, when versions start again, don't match
+func NewETagFromModel(modelSM switchmachine.State) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s %d", switchmachine.StateToString(modelSM), modelSM.UpdateTime().UnixNano())
+	return fmt.Sprintf(`"%d-%x"`, modelSM.Version(), hash.Sum64())
+}
+
 func (this *SwitchMachine) Id() switchmachine.Id {
 	return switchmachine.Id(this.SMId)
 }
@@ -87,6 +115,46 @@ func (this *SwitchMachine) UpdateTime() time.Time {
 	return time.UnixMilli(this.UpdTimeMillis)
 }
 
+func (this *SwitchMachine) Version() uint64 {
+	return this.Ver
+}
+
+//UpdateProblems lists everything that stops this from being sent as an update. The motor state isn't used by updates
+//so it is only checked when it is set
+func (this *SwitchMachine) UpdateProblems() []string {
+	problems := make([]string, 0)
+	if this.SMId < 0 || this.SMId > math.MaxUint16 {
+		problems = append(problems, fmt.Sprintf("id %d is not between 0 and %d", this.SMId, math.MaxUint16))
+	}
+	problems = appendPositionProblems(problems, this.Pos)
+	problems = appendMotorStateProblems(problems, this.Motor)
+	problems = appendGPIOProblems(problems, "gpio0", this.Gpio0)
+	return appendGPIOProblems(problems, "gpio1", this.Gpio1)
+}
+
+func appendPositionProblems(problems []string, pos SwitchMachinePosition) []string {
+	if pos != Position0 && pos != Position1 {
+		problems = append(problems, fmt.Sprintf("position %q is not %q or %q", pos, Position0, Position1))
+	}
+	return problems
+}
+
+func appendMotorStateProblems(problems []string, motor SwitchMachineMotorState) []string {
+	switch motor {
+	case "", IDLE, TO_POSITION_0, TO_POSITION_1, BRAKE:
+		return problems
+	default:
+		return append(problems, fmt.Sprintf("motorState %q is not a motor state", motor))
+	}
+}
+
+func appendGPIOProblems(problems []string, name string, gpio GPIOState) []string {
+	if gpio != ON && gpio != OFF {
+		problems = append(problems, fmt.Sprintf("%s %q is not %q or %q", name, gpio, ON, OFF))
+	}
+	return problems
+}
+
 func mapAPIGPIOStateToHardwareState(apiState GPIOState) switchmachine.GPIOState {
 	if apiState == ON {
 		return switchmachine.GPIOOn
@@ -13,6 +13,8 @@ const (
 type SwitchMachineEvent struct {
 	EventType          SwitchMachineEventType `json:"eventType"`
 	SwitchMachineState *SwitchMachine         `json:"switchMachineState"`
+	//OperationId is the operation the event is part of, left out when it isn't part of one
+	OperationId uint64 `json:"operationId,omitempty"`
 }
 
 func MapSMEventToAPISMEventType(e event.SwitchMachineEvent) SwitchMachineEventType {
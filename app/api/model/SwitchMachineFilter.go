@@ -0,0 +1,117 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+const (
+	BoardFilterKey        string = "board"
+	PositionFilterKey     string = "position"
+	MotorStateFilterKey   string = "motorState"
+	GPIO0FilterKey        string = "gpio0"
+	GPIO1FilterKey        string = "gpio1"
+	ChangedSinceFilterKey string = "changedSince"
+)
+
+//SwitchMachineFilter picks switch machines out of the collection. Each field that is set has to match and a switch
+//machine matches a field when it matches any of its values
+type SwitchMachineFilter struct {
+	//Boards count across every driver mount the same as ids do
+	Boards      map[uint]bool
+	Positions   map[SwitchMachinePosition]bool
+	MotorStates map[SwitchMachineMotorState]bool
+	Gpio0       map[GPIOState]bool
+	Gpio1       map[GPIOState]bool
+	//Only switch machines updated at or after this, nil when not filtering on it
+	ChangedSinceMillis *int64
+}
+
+//NewSwitchMachineFilterFromQuery reads the filter from query parameters. Each can be given more than once or hold a
+//comma separated list. The problems list every value that couldn't be read, the filter is nil when there are any
+func NewSwitchMachineFilterFromQuery(query url.Values) (*SwitchMachineFilter, []string) {
+	filter := &SwitchMachineFilter{
+		Boards:      make(map[uint]bool),
+		Positions:   make(map[SwitchMachinePosition]bool),
+		MotorStates: make(map[SwitchMachineMotorState]bool),
+	}
+	problems := make([]string, 0)
+	for _, curValue := range splitQueryValues(query, BoardFilterKey) {
+		board, err := strconv.ParseUint(curValue, 10, 16)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not a board number", BoardFilterKey, curValue))
+			continue
+		}
+		filter.Boards[uint(board)] = true
+	}
+	for _, curValue := range splitQueryValues(query, PositionFilterKey) {
+		pos := SwitchMachinePosition(curValue)
+		if pos != Position0 && pos != Position1 && pos != Unknown {
+			problems = append(problems, fmt.Sprintf("%s %q is not %q, %q or %q", PositionFilterKey, pos, Position0, Position1, Unknown))
+			continue
+		}
+		filter.Positions[pos] = true
+	}
+	for _, curValue := range splitQueryValues(query, MotorStateFilterKey) {
+		motor := SwitchMachineMotorState(curValue)
+		if motor != IDLE && motor != TO_POSITION_0 && motor != TO_POSITION_1 && motor != BRAKE {
+			problems = append(problems, fmt.Sprintf("%s %q is not a motor state", MotorStateFilterKey, motor))
+			continue
+		}
+		filter.MotorStates[motor] = true
+	}
+	filter.Gpio0, problems = readGPIOFilter(query, GPIO0FilterKey, problems)
+	filter.Gpio1, problems = readGPIOFilter(query, GPIO1FilterKey, problems)
+	if sinceStr := query.Get(ChangedSinceFilterKey); sinceStr != "" {
+		since, err := strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s %q is not a time in milliseconds like updateTimeMillis", ChangedSinceFilterKey, sinceStr))
+		} else {
+			filter.ChangedSinceMillis = &since
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return filter, problems
+}
+
+func readGPIOFilter(query url.Values, key string, problems []string) (map[GPIOState]bool, []string) {
+	states := make(map[GPIOState]bool)
+	for _, curValue := range splitQueryValues(query, key) {
+		gpio := GPIOState(curValue)
+		if gpioProblems := appendGPIOProblems(make([]string, 0), key, gpio); len(gpioProblems) > 0 {
+			problems = append(problems, gpioProblems...)
+			continue
+		}
+		states[gpio] = true
+	}
+	return states, problems
+}
+
+func splitQueryValues(query url.Values, key string) []string {
+	values := make([]string, 0)
+	for _, curParam := range query[key] {
+		for _, curValue := range strings.Split(curParam, ",") {
+			values = append(values, strings.TrimSpace(curValue))
+		}
+	}
+	return values
+}
+
+func (this *SwitchMachineFilter) Matches(modelSM switchmachine.State) bool {
+	if this.ChangedSinceMillis != nil && modelSM.UpdateTime().UnixMilli() < *this.ChangedSinceMillis {
+		return false
+	}
+	return (len(this.Boards) == 0 || this.Boards[uint(modelSM.Id())/tortoise.SwitchMachinesPerBoard]) &&
+		(len(this.Positions) == 0 || this.Positions[MapModelPosToApiPos(modelSM.Position())]) &&
+		(len(this.MotorStates) == 0 || this.MotorStates[MapModelMStateToAPIMState(modelSM.MotorState())]) &&
+		(len(this.Gpio0) == 0 || this.Gpio0[MapModelGPIOToAPI(modelSM.GPIO0State())]) &&
+		(len(this.Gpio1) == 0 || this.Gpio1[MapModelGPIOToAPI(modelSM.GPIO1State())])
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/google/uuid"
+)
+
+//SwitchMachinePatch is an update to one switch machine where only the fields that are sent are changed
+type SwitchMachinePatch struct {
+	SMId *SwitchMachineId `json:"id,omitempty"`
+
+	Pos *SwitchMachinePosition `json:"position,omitempty"`
+
+	Motor *SwitchMachineMotorState `json:"motorState,omitempty"`
+
+	Gpio0 *GPIOState `json:"gpio0,omitempty"`
+
+	Gpio1 *GPIOState `json:"gpio1,omitempty"`
+
+	//Only here so a switch machine that was read can be sent back as is. They are never used
+	UpdTimeMillis *int64 `json:"updateTimeMillis,omitempty"`
+
+	OriginServerId *uuid.UUID `json:"originServerId,omitempty"`
+
+	Ver *uint64 `json:"version,omitempty"`
+}
+
+//MissingProblems lists the fields that a full update has to send
+func (this *SwitchMachinePatch) MissingProblems() []string {
+	problems := make([]string, 0)
+	if this.Pos == nil {
+		problems = append(problems, "position is required")
+	}
+	if this.Gpio0 == nil {
+		problems = append(problems, "gpio0 is required")
+	}
+	if this.Gpio1 == nil {
+		problems = append(problems, "gpio1 is required")
+	}
+	return problems
+}
+
+//ApplyTo fills in what wasn't sent from curState. A switch machine that is moving keeps moving to where it is heading
+//and one that doesn't know its position is left that way
+func (this *SwitchMachinePatch) ApplyTo(curState switchmachine.State) *SwitchMachine {
+	apiSM := NewAPISwitchMachineFromModel(curState)
+	apiSM.Pos = MapModelPosToApiPos(switchmachine.TargetPosition(curState))
+	//The patch is made against curState so it only cares about the version if one was sent
+	apiSM.Ver = 0
+	if this.Ver != nil {
+		apiSM.Ver = *this.Ver
+	}
+	if this.Pos != nil {
+		apiSM.Pos = *this.Pos
+	}
+	if this.Motor != nil {
+		apiSM.Motor = *this.Motor
+	}
+	if this.Gpio0 != nil {
+		apiSM.Gpio0 = *this.Gpio0
+	}
+	if this.Gpio1 != nil {
+		apiSM.Gpio1 = *this.Gpio1
+	}
+	return apiSM
+}
+
+//UpdateProblems lists everything wrong with the fields that were sent. id has to be the switch machine being updated
+func (this *SwitchMachinePatch) UpdateProblems(id switchmachine.Id) []string {
+	problems := make([]string, 0)
+	if this.SMId != nil && *this.SMId != SwitchMachineId(id) {
+		problems = append(problems, fmt.Sprintf("id %d does not match switch machine %d", *this.SMId, id))
+	}
+	if this.Pos != nil {
+		problems = appendPositionProblems(problems, *this.Pos)
+	}
+	if this.Motor != nil {
+		problems = appendMotorStateProblems(problems, *this.Motor)
+	}
+	if this.Gpio0 != nil {
+		problems = appendGPIOProblems(problems, "gpio0", *this.Gpio0)
+	}
+	if this.Gpio1 != nil {
+		problems = appendGPIOProblems(problems, "gpio1", *this.Gpio1)
+	}
+	return problems
+}
@@ -0,0 +1,28 @@
+package model
+
+type SwitchMachineUpdateStatus string
+
+// List of SwitchMachineUpdateStatus
+const (
+	UpdateAccepted SwitchMachineUpdateStatus = "accepted"
+	UpdateNoOp     SwitchMachineUpdateStatus = "noOp"
+	UpdateRejected SwitchMachineUpdateStatus = "rejected"
+	//UpdateAborted is a valid update that wasn't sent because another one in an atomic batch was rejected
+	UpdateAborted SwitchMachineUpdateStatus = "aborted"
+)
+
+//SwitchMachineUpdateResult is what happened to one switch machine of a batch update. Error is only set when it was rejected
+type SwitchMachineUpdateResult struct {
+	//Index is where the update was in the request so results can be matched to updates without a usable id. For board
+	//updates it is the port
+	Index int `json:"index"`
+
+	SMId SwitchMachineId `json:"id"`
+
+	Status SwitchMachineUpdateStatus `json:"status"`
+
+	//OperationId can be followed until the switch machine has finished moving. Only set when it was accepted
+	OperationId uint64 `json:"operationId,omitempty"`
+
+	Error *Error `json:"error,omitempty"`
+}
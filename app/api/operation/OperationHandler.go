@@ -0,0 +1,81 @@
+package operation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/gorilla/mux"
+)
+
+const (
+	operationHandlerPath string = "/operations"
+	opIdRequestKey       string = "opId"
+	waitQueryKey         string = "wait"
+	//How long a wait=true request is held before answering with the operation as it is
+	maxWait time.Duration = time.Second * 30
+)
+
+//Locator gives the url an operation can be followed at
+type Locator func(operation.Id) string
+
+type operationHandler struct {
+	tracker *operation.Tracker
+	opRoute *mux.Route
+}
+
+//NewOperationHandler registers the route to follow operations. The returned Locator is for the Location header of
+//requests that start one
+func NewOperationHandler(rtr *mux.Router, tracker *operation.Tracker) Locator {
+	opHandler := &operationHandler{tracker: tracker}
+	opHandler.opRoute = rtr.Path(operationHandlerPath + "/{" + opIdRequestKey + ":[0-9]+}").Methods(http.MethodGet).HandlerFunc(opHandler.handleGetOperation)
+	return opHandler.location
+}
+
+func (this *operationHandler) location(id operation.Id) string {
+	location, err := this.opRoute.URL(opIdRequestKey, strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		return ""
+	}
+	return location.String()
+}
+
+//handleGetOperation holds on to the request until the operation has finished when wait=true, for up to maxWait
+func (this *operationHandler) handleGetOperation(w http.ResponseWriter, r *http.Request) {
+	opId, err := strconv.ParseUint(mux.Vars(r)[opIdRequestKey], 10, 64)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed operation id in request"))
+		return
+	}
+	isWait := false
+	if waitStr := r.URL.Query().Get(waitQueryKey); waitStr != "" {
+		isWait, err = strconv.ParseBool(waitStr)
+		if err != nil {
+			apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed wait in request, expected true or false"))
+			return
+		}
+	}
+	var op operation.Operation
+	var exists bool
+	if isWait {
+		ctx, cancel := context.WithTimeout(r.Context(), maxWait)
+		defer cancel()
+		op, exists = this.tracker.Wait(ctx, operation.Id(opId))
+	} else {
+		op, exists = this.tracker.Operation(operation.Id(opId))
+	}
+	if !exists {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, "No operation with id "+strconv.FormatUint(opId, 10)))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPIOperationFromModel(op))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
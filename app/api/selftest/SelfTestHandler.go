@@ -0,0 +1,113 @@
+package selftest
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/selftest"
+	smModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+const (
+	selfTestHandlerPath string = "/selftest"
+	idRequestKey        string = "id"
+	boardRequestKey     string = "board"
+	runIdRequestKey     string = "runId"
+	runRouteName        string = "selfTestRun"
+)
+
+type selfTestHandler struct {
+	runner   *selftest.Runner
+	runRoute *mux.Route
+}
+
+//NewSelfTestHandler registers the commissioning routes. Progress of every run is broadcast to the event websocket
+func NewSelfTestHandler(rtr *mux.Router, runner *selftest.Runner, events switchmachine.EventBroadcaster) {
+	stHandler := &selfTestHandler{runner: runner}
+	runner.SetProgressListenerFunc(func(p selftest.Progress) {
+		events.Broadcast(apiModel.NewAPISelfTestEventFromModel(p))
+	})
+	subRtr := rtr.PathPrefix(selfTestHandlerPath).Subrouter()
+	stHandler.runRoute = subRtr.Path("/run/{" + runIdRequestKey + ":[0-9]+}").Methods(http.MethodGet).HandlerFunc(stHandler.handleGetRun).Name(runRouteName)
+	subRtr.Path("/run").Methods(http.MethodGet).HandlerFunc(stHandler.handleGetRuns)
+	subRtr.Path("/board/{" + boardRequestKey + ":[0-9]+}").Methods(http.MethodPost).HandlerFunc(stHandler.handleTestBoard)
+	subRtr.Path("/{" + idRequestKey + ":[0-9]+}").Methods(http.MethodPost).HandlerFunc(stHandler.handleTestSwitchMachine)
+}
+
+func (this *selfTestHandler) handleTestSwitchMachine(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)[idRequestKey], 10, 16)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed id in request"))
+		return
+	}
+	this.startRun(w, []smModel.Id{smModel.Id(id)})
+}
+
+func (this *selfTestHandler) handleTestBoard(w http.ResponseWriter, r *http.Request) {
+	//Ids are global so a board number counts boards across every driver mount
+	numIdsPerBoard := uint64(tortoise.SwitchMachinesPerBoard)
+	board, err := strconv.ParseUint(mux.Vars(r)[boardRequestKey], 10, 16)
+	if err != nil || board*numIdsPerBoard+numIdsPerBoard-1 > math.MaxUint16 {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed board in request"))
+		return
+	}
+	ids := make([]smModel.Id, 0, numIdsPerBoard)
+	for port := uint64(0); port < numIdsPerBoard; port++ {
+		ids = append(ids, smModel.Id(board*numIdsPerBoard+port))
+	}
+	this.startRun(w, ids)
+}
+
+//startRun replies straight away with where the run can be followed as a self test takes several seconds per port
+func (this *selfTestHandler) startRun(w http.ResponseWriter, ids []smModel.Id) {
+	run, err := this.runner.Start(ids)
+	if err != nil {
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	if location, err := this.runRoute.URL(runIdRequestKey, strconv.FormatUint(uint64(run.Id), 10)); err == nil {
+		w.Header().Set("Location", location.String())
+	}
+	w.WriteHeader(http.StatusAccepted)
+
+	json.NewEncoder(w).Encode(apiModel.NewAPISelfTestRunFromModel(run))
+}
+
+func (this *selfTestHandler) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	runId, err := strconv.ParseUint(mux.Vars(r)[runIdRequestKey], 10, 64)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, "Malformed run id in request"))
+		return
+	}
+	run, exists := this.runner.Run(selftest.RunId(runId))
+	if !exists {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, "No self test run with id "+strconv.FormatUint(runId, 10)))
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPISelfTestRunFromModel(run))
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *selfTestHandler) handleGetRuns(w http.ResponseWriter, r *http.Request) {
+	runs := this.runner.Runs()
+	apiRuns := make([]*apiModel.SelfTestRun, 0, len(runs))
+	for _, curRun := range runs {
+		apiRuns = append(apiRuns, apiModel.NewAPISelfTestRunFromModel(curRun))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiRuns)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,182 @@
+package simulator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+const (
+	driverRequestKey     string = "driver"
+	idRequestKey         string = "id"
+	simulatorHandlerPath string = "/simulator/{" + driverRequestKey + "}"
+
+	idOutOfRangeErrorMessage          string = "Simulated switch machine id %d is outside of the drivers %d ids"
+	travelTimeNotPositiveErrorMessage string = "travelTimeMillis must be greater than 0"
+)
+
+type simulatorHandler struct {
+	simulators map[string]tortoise.SimulatorDriver
+}
+
+//NewSimulatorHandler registers the control api for each simulator driver keyed by its driver mount name. Setting
+//faults is only registered when hasFaults, so it is allowed in the same places as the fault injection api
+func NewSimulatorHandler(rtr *mux.Router, simulators map[string]tortoise.SimulatorDriver, hasFaults bool) {
+	sHandler := &simulatorHandler{simulators: simulators}
+	subRtr := rtr.PathPrefix(simulatorHandlerPath).Subrouter()
+	subRtr.Path("/traveltime").Methods(http.MethodGet).HandlerFunc(sHandler.handleGetTravelTime)
+	subRtr.Path("/traveltime").Methods(http.MethodPut).HandlerFunc(sHandler.handleSetTravelTime)
+	subRtr.Path("/machine").Methods(http.MethodGet).HandlerFunc(sHandler.handleGetMachines)
+	subRtr.Path("/machine/{" + idRequestKey + "}").Methods(http.MethodPost).HandlerFunc(sHandler.handleAttachMachine)
+	subRtr.Path("/machine/{" + idRequestKey + "}").Methods(http.MethodDelete).HandlerFunc(sHandler.handleDetachMachine)
+	if hasFaults {
+		subRtr.Path("/machine/{" + idRequestKey + "}/fault").Methods(http.MethodPut).HandlerFunc(sHandler.handleSetFault)
+	}
+}
+
+func (this *simulatorHandler) handleGetMachines(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	machines := sim.Machines()
+	apiMachines := make([]*apiModel.SimulatedMachine, 0, len(machines))
+	for _, curMachine := range machines {
+		apiMachines = append(apiMachines, apiModel.NewAPISimulatedMachineFromModel(curMachine))
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(apiMachines)
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *simulatorHandler) handleAttachMachine(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	smId, err := getSMIdFromRequest(r)
+	attachReq := &apiModel.SimulatedMachineAttachRequest{Pos: apiModel.Position0}
+	if err == nil && r.ContentLength != 0 {
+		err = json.NewDecoder(r.Body).Decode(attachReq)
+	}
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	if uint(smId) >= tortoise.MaxNumberSwitchMachines {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInvalidValue, fmt.Sprintf(idOutOfRangeErrorMessage, smId, tortoise.MaxNumberSwitchMachines)))
+		return
+	}
+
+	err = sim.AttachMachine(smId, apiModel.MapApiPosToModelPos(attachReq.Pos))
+
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeConflict, err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (this *simulatorHandler) handleDetachMachine(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	smId, err := getSMIdFromRequest(r)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+
+	err = sim.DetachMachine(smId)
+
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, err.Error()))
+	}
+}
+
+func (this *simulatorHandler) handleSetFault(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	smId, err := getSMIdFromRequest(r)
+	faultReq := &apiModel.SimulatedFaultRequest{}
+	if err == nil {
+		err = json.NewDecoder(r.Body).Decode(faultReq)
+	}
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	fault, err := tortoise.ParseSimulatedFault(faultReq.Fault)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInvalidValue, err.Error()))
+		return
+	}
+
+	err = sim.SetFault(smId, fault)
+
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, err.Error()))
+	}
+}
+
+func (this *simulatorHandler) handleGetTravelTime(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	encodeErr := json.NewEncoder(w).Encode(&apiModel.SimulatorTravelTime{TravelTimeMillis: sim.TravelTime().Milliseconds()})
+
+	if encodeErr != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (this *simulatorHandler) handleSetTravelTime(w http.ResponseWriter, r *http.Request) {
+	sim, ok := this.getSimulatorFromRequest(w, r)
+	if !ok {
+		return
+	}
+	travelTime := &apiModel.SimulatorTravelTime{}
+	if err := json.NewDecoder(r.Body).Decode(travelTime); err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	if travelTime.TravelTimeMillis <= 0 {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeInvalidValue, travelTimeNotPositiveErrorMessage))
+		return
+	}
+
+	sim.SetTravelTime(time.Duration(travelTime.TravelTimeMillis) * time.Millisecond)
+}
+
+func (this *simulatorHandler) getSimulatorFromRequest(w http.ResponseWriter, r *http.Request) (tortoise.SimulatorDriver, bool) {
+	name := mux.Vars(r)[driverRequestKey]
+	sim, ok := this.simulators[name]
+	if !ok {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeNotFound, "No simulator driver mount named "+name))
+	}
+	return sim, ok
+}
+
+func getSMIdFromRequest(r *http.Request) (switchmachine.Id, error) {
+	smIdInt, err := strconv.ParseUint(mux.Vars(r)[idRequestKey], 10, 16)
+	if err != nil {
+		return 0, errors.New("Malformed id in request")
+	}
+	return switchmachine.Id(smIdInt), nil
+}
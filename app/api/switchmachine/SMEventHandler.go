@@ -1,32 +1,53 @@
 package switchmachine
 
 import (
-	"log"
+	"io"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/event"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/metrics"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 )
 
 const (
 	eventHandlerSubPath string = "/event"
+	//How long to wait on a client when telling it the server is going away
+	closeMessageTimeout time.Duration = time.Second
+	//How long a client gets to take each event before it is dropped so one slow client can't hold up the rest
+	clientWriteTimeout time.Duration = time.Second
 )
 
-func RegsiterEventHandler(r *mux.Router, c controller.TortoiseController) {
+var websocketClients = metrics.NewGauge("smds_websocket_clients", "Clients connected to the switch machine event websocket")
+
+//EventBroadcaster sends events to every client of the event websocket. Closing it disconnects the clients
+type EventBroadcaster interface {
+	io.Closer
+	//Broadcast sends event as json
+	Broadcast(event interface{})
+}
+
+//RegsiterEventHandler passes every controller event through operations so that events are sent with the operation
+//they are part of
+func RegsiterEventHandler(r *mux.Router, c controller.TortoiseController, operations *operation.Tracker) EventBroadcaster {
 	eventServer := newEventServer()
 
 	c.SetSwitchMachineEventListenerFunc(func(sme event.SwitchMachineEvent) {
 		smEvent := &model.SwitchMachineEvent{}
 		smEvent.SwitchMachineState = model.NewAPISwitchMachineFromModel(sme.State())
 		smEvent.EventType = model.MapSMEventToAPISMEventType(sme)
+		if opId, hasOp := operations.HandleSwitchMachineEvent(sme); hasOp {
+			smEvent.OperationId = uint64(opId)
+		}
 		eventServer.SendSwitchMachineEvent(smEvent)
 	})
-	r.HandleFunc(eventHandlerSubPath, eventServer.ServeHTTP)
-
+	r.Path(eventHandlerSubPath).Methods(http.MethodGet).HandlerFunc(eventServer.ServeHTTP)
+	return eventServer
 }
 
 type eventServer struct {
@@ -40,6 +61,7 @@ func newEventServer() *eventServer {
 	eS.upgrader.CheckOrigin = func(r *http.Request) bool {
 		return true
 	}
+	eS.upgrader.Error = writeUpgradeError
 	eS.clients = make([]*websocket.Conn, 0)
 	eS.clientsMutex = &sync.Mutex{}
 	return eS
@@ -48,37 +70,48 @@ func newEventServer() *eventServer {
 func (this *eventServer) Close() error {
 	this.clientsMutex.Lock()
 	for _, curClient := range this.clients {
+		curClient.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shutting down"), time.Now().Add(closeMessageTimeout))
 		curClient.Close()
 	}
+	this.clients = make([]*websocket.Conn, 0)
+	websocketClients.Set(0)
 	this.clientsMutex.Unlock()
 	return nil
 }
 func (this *eventServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c, err := this.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		//The upgrader has already answered the request
 		return
 	}
 	this.clientsMutex.Lock()
 	this.clients = append(this.clients, c)
+	websocketClients.Set(float64(len(this.clients)))
 	this.clientsMutex.Unlock()
 }
 
 func (this *eventServer) SendSwitchMachineEvent(sme *model.SwitchMachineEvent) {
 	if sme != nil {
+		this.Broadcast(sme)
+	}
+}
+
+func (this *eventServer) Broadcast(event interface{}) {
+	if event != nil {
 		var deadClients []*websocket.Conn
 		this.clientsMutex.Lock()
 		for _, curClient := range this.clients {
-			err := curClient.WriteJSON(sme)
+			err := curClient.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			if err == nil {
+				err = curClient.WriteJSON(event)
+			}
 			if err != nil {
-				log.Println(err)
-				if websocket.IsUnexpectedCloseError(err) {
-					if deadClients == nil {
-						deadClients = make([]*websocket.Conn, 0)
-					}
-					deadClients = append(deadClients, curClient)
+				logger.Warn("Unable to send event to client, dropping it", "err", err)
+				//A connection can't be written to again after a failed write
+				if deadClients == nil {
+					deadClients = make([]*websocket.Conn, 0)
 				}
+				deadClients = append(deadClients, curClient)
 			}
 		}
 		if len(deadClients) > 0 {
@@ -88,8 +121,8 @@ func (this *eventServer) SendSwitchMachineEvent(sme *model.SwitchMachineEvent) {
 	}
 }
 
+//removeDeadClients expects clientsMutex to already be held
 func (this *eventServer) removeDeadClients(dClients []*websocket.Conn) {
-	this.clientsMutex.Lock()
 	newClientsSlice := make([]*websocket.Conn, 0, len(this.clients)-len(dClients))
 	for _, curClient := range this.clients {
 		isDead := false
@@ -101,8 +134,19 @@ func (this *eventServer) removeDeadClients(dClients []*websocket.Conn) {
 		}
 		if !isDead {
 			newClientsSlice = append(newClientsSlice, curClient)
+		} else {
+			curClient.Close()
 		}
 	}
+	this.clients = newClientsSlice
+	websocketClients.Set(float64(len(this.clients)))
+}
 
-	this.clientsMutex.Unlock()
+//writeUpgradeError is only ever given a bad request, unless the connection couldn't be taken over
+func writeUpgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	code := model.ErrorCodeInternal
+	if status == http.StatusBadRequest {
+		code = model.ErrorCodeMalformedRequest
+	}
+	model.WriteError(w, model.NewError(code, reason.Error()))
 }
@@ -1,78 +1,89 @@
 package switchmachine
 
 import (
-	"encoding/hex"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	operationapi "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/operation"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
-	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
-	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
-	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/environment"
-	env "github.com/ZacharyDuve/apireg/environment"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
 	"github.com/gorilla/mux"
 )
 
+var logger = logging.ForSubsystem(logging.SubsystemApi)
+
 type switchMachineHandler struct {
 	controller controller.TortoiseController
+	operations *operation.Tracker
+	locateOp   operationapi.Locator
 }
 
 const (
 	idRequestKey  string = "id"
 	smHandlerPath string = "/switchmachine"
+	//Query parameter that makes a batch update all or nothing
+	atomicQueryKey string = "atomic"
+	toggleSubPath  string = "/toggle"
+	//How long a request waits for the driver to take its updates before giving up
+	updateTimeout time.Duration = time.Second * 5
+	//How many more times a patch without a version is applied again when the switch machine changes underneath it
+	patchRetries int = 3
+
+	invalidUpdateErrorMessage   string = "Invalid switch machine update"
+	invalidFilterErrorMessage   string = "Invalid switch machine filter"
+	duplicateIdErrorMessage     string = "Switch machine %d is already updated earlier in the request"
+	malformedAtomicErrorMessage string = "Malformed atomic %q in request, expected true or false"
+	eTagMismatchErrorMessage    string = "Switch machine %d has changed since it was read, its ETag is %s"
 )
 
-func NewSwitchMachineHandler(rtr *mux.Router) {
+//NewSwitchMachineHandler registers the switch machine routes. Updates are made as operations in operations which
+//locateOp gives the url of. The returned broadcaster can send other events to the event clients and disconnects them
+//when closed
+func NewSwitchMachineHandler(rtr *mux.Router, c controller.TortoiseController, operations *operation.Tracker, locateOp operationapi.Locator) EventBroadcaster {
 	smHandler := &switchMachineHandler{}
 	subRtr := rtr.PathPrefix(smHandlerPath).Subrouter()
-	var driver hardware.Driver
-	if environment.GetCurrent() == env.Prod {
-		var err error
-		driver, err = tortoise.NewPiTortoiseControllerDriver()
-		if err != nil {
-			panic(err)
-		}
-	} else {
-		trigger := make(chan time.Time)
-		mockDriver := tortoise.NewMockTortoiseControllerDriverWithExternalRXTrigger(trigger)
-		driver = mockDriver
-		//txOut := make([]byte, 0)
-		subRtr.PathPrefix("/mockrxdata").Methods(http.MethodPost).HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
-			rxData, err := ioutil.ReadAll(hex.NewDecoder(r.Body))
-
-			if err != nil {
-				rw.WriteHeader(http.StatusBadRequest)
-				rw.Write([]byte(err.Error()))
-			} else {
-				mockDriver.SetRXData(rxData)
-				log.Println("Sent RX data", rxData)
-				trigger <- time.Now()
-			}
-		})
-	}
-	smHandler.controller = controller.NewTortoiseController(driver)
-	RegsiterEventHandler(subRtr, smHandler.controller)
-	subRtr.PathPrefix("/{" + idRequestKey + "}").Methods(http.MethodGet).HandlerFunc(smHandler.handleGetSwitchMachine)
+	smHandler.controller = c
+	smHandler.operations = operations
+	smHandler.locateOp = locateOp
+	eventServer := RegsiterEventHandler(subRtr, smHandler.controller, smHandler.operations)
+	subRtr.Path("/{" + idRequestKey + "}").Methods(http.MethodGet).HandlerFunc(smHandler.handleGetSwitchMachine)
+	//Only plain numbers so nothing that changes a switch machine can be sent somewhere it wasn't meant for
+	idPath := "/{" + idRequestKey + ":[0-9]+}"
+	subRtr.Path(idPath).Methods(http.MethodPut).HandlerFunc(smHandler.handleReplaceSwitchMachine)
+	subRtr.Path(idPath).Methods(http.MethodPatch).HandlerFunc(smHandler.handlePatchSwitchMachine)
+	subRtr.Path(idPath + toggleSubPath).Methods(http.MethodPost).HandlerFunc(smHandler.handleToggleSwitchMachine)
 	//For updating a switch machine we are just going to put to the base
 	subRtr.Methods(http.MethodPut).HandlerFunc(smHandler.handleUpdateSwitchMachine)
 
 	subRtr.Methods(http.MethodGet).HandlerFunc(smHandler.handleGetSwitchMachines)
+	return eventServer
 }
 
+//handleGetSwitchMachines answers with the switch machines that match the filter in the query, ordered by id
 func (this *switchMachineHandler) handleGetSwitchMachines(w http.ResponseWriter, r *http.Request) {
+	filter, problems := apiModel.NewSwitchMachineFilterFromQuery(r.URL.Query())
+	if len(problems) > 0 {
+		apiErr := apiModel.NewError(apiModel.ErrorCodeMalformedRequest, invalidFilterErrorMessage)
+		apiErr.Problems = problems
+		apiModel.WriteError(w, apiErr)
+		return
+	}
 	switchMachines := this.controller.GetSwitchMachines()
-	//TODO optimize the creation of the slice
-	apiSMs := make([]apiModel.SwitchMachine, 0)
+	apiSMs := make([]apiModel.SwitchMachine, 0, len(switchMachines))
 	for _, curSM := range switchMachines {
-		apiSMs = append(apiSMs, *apiModel.NewAPISwitchMachineFromModel(curSM))
+		if filter.Matches(curSM) {
+			apiSMs = append(apiSMs, *apiModel.NewAPISwitchMachineFromModel(curSM))
+		}
 	}
 
 	encodeErr := json.NewEncoder(w).Encode(apiSMs)
@@ -84,50 +95,300 @@ func (this *switchMachineHandler) handleGetSwitchMachines(w http.ResponseWriter,
 
 func (this *switchMachineHandler) handleGetSwitchMachine(w http.ResponseWriter, r *http.Request) {
 	smId, err := getSMIdFromRequest(r)
-	sm, err := this.controller.GetSwitchMachineById(smId)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	this.writeSwitchMachine(w, smId)
+}
 
+//handleUpdateSwitchMachine checks every switch machine before any are sent and answers with the result of each in the
+//order they were sent. It is 207 Multi-Status when any of them were rejected. With atomic=true nothing is sent if any
+//are rejected and it is 422 with the valid ones aborted. Each accepted one has an operation, which is also the
+//Location when there is only one
+func (this *switchMachineHandler) handleUpdateSwitchMachine(w http.ResponseWriter, r *http.Request) {
+	isAtomic, err := getAtomicFromRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
 		return
 	}
-	encodeErr := json.NewEncoder(w).Encode(apiModel.NewAPISwitchMachineFromModel(sm))
+	items := make([]json.RawMessage, 0)
+	err = json.NewDecoder(r.Body).Decode(&items)
+	logger.Debug("Switch machine update requested", "count", len(items), "atomic", isAtomic)
 
-	if encodeErr != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	results, switchMachines := this.validateSwitchMachineUpdates(items)
+	hasRejected := false
+	for _, curResult := range results {
+		hasRejected = hasRejected || curResult.Status == apiModel.UpdateRejected
 	}
+
+	status := http.StatusOK
+	if hasRejected && isAtomic {
+		for _, curResult := range results {
+			if curResult.Status != apiModel.UpdateRejected {
+				curResult.Status = apiModel.UpdateAborted
+			}
+		}
+		status = http.StatusUnprocessableEntity
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), updateTimeout)
+		defer cancel()
+		opIds := make([]operation.Id, 0, len(results))
+		for i, curResult := range results {
+			if curResult.Status != apiModel.UpdateAccepted {
+				continue
+			}
+			if logger.Enabled(logging.LevelDebug) {
+				logger.Debug("Updating switch machine", "state", switchmachine.StateToString(switchMachines[i]))
+			}
+			op, err := this.operations.Update(ctx, switchMachines[i])
+
+			if err != nil {
+				logger.Warn("Unable to update switch machine", "id", switchMachines[i].Id(), "err", err)
+				curResult.Status = apiModel.UpdateRejected
+				curResult.Error = apiModel.NewAPIErrorFromModel(err)
+			} else {
+				curResult.OperationId = uint64(op.Id)
+				opIds = append(opIds, op.Id)
+			}
+		}
+		if len(opIds) == 1 {
+			this.setOperationLocation(w, opIds[0])
+		}
+		for _, curResult := range results {
+			if curResult.Status == apiModel.UpdateRejected {
+				status = http.StatusMultiStatus
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(results)
 }
 
-func (this *switchMachineHandler) handleUpdateSwitchMachine(w http.ResponseWriter, r *http.Request) {
+//validateSwitchMachineUpdates decodes each item on its own so one bad item doesn't hide the others. Items that would
+//not change anything are a noOp and everything still accepted is safe to send to the driver
+func (this *switchMachineHandler) validateSwitchMachineUpdates(items []json.RawMessage) ([]*apiModel.SwitchMachineUpdateResult, []*apiModel.SwitchMachine) {
+	results := make([]*apiModel.SwitchMachineUpdateResult, len(items))
+	switchMachines := make([]*apiModel.SwitchMachine, len(items))
+	seenIds := make(map[apiModel.SwitchMachineId]bool)
+	for i, curItem := range items {
+		result := &apiModel.SwitchMachineUpdateResult{Index: i, Status: apiModel.UpdateRejected}
+		results[i] = result
+		curSMReq := &apiModel.SwitchMachine{}
+		decoder := json.NewDecoder(bytes.NewReader(curItem))
+		decoder.DisallowUnknownFields()
+		err := decoder.Decode(curSMReq)
+		if err != nil {
+			result.Error = apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error())
+			continue
+		}
+		result.SMId = curSMReq.SMId
+		switchMachines[i] = curSMReq
 
-	switchMachines := make([]*apiModel.SwitchMachine, 0)
-	err := json.NewDecoder(r.Body).Decode(&switchMachines)
-	log.Println("DEBUG -", len(switchMachines))
-	for _, cur := range switchMachines {
-		log.Println("DEBUG -", cur)
+		if problems := curSMReq.UpdateProblems(); len(problems) > 0 {
+			result.Error = apiModel.NewError(apiModel.ErrorCodeInvalidValue, invalidUpdateErrorMessage)
+			result.Error.Problems = problems
+			continue
+		}
+		if seenIds[curSMReq.SMId] {
+			result.Error = apiModel.NewError(apiModel.ErrorCodeInvalidValue, fmt.Sprintf(duplicateIdErrorMessage, curSMReq.SMId))
+			continue
+		}
+		seenIds[curSMReq.SMId] = true
+
+		wouldUpdate, err := this.controller.WouldUpdateSwitchMachine(curSMReq)
+		if err != nil {
+			result.Error = apiModel.NewAPIErrorFromModel(err)
+		} else if wouldUpdate {
+			result.Status = apiModel.UpdateAccepted
+		} else {
+			result.Status = apiModel.UpdateNoOp
+		}
 	}
+	return results, switchMachines
+}
 
+//handleReplaceSwitchMachine needs position, gpio0 and gpio1 so nothing is left to a default
+func (this *switchMachineHandler) handleReplaceSwitchMachine(w http.ResponseWriter, r *http.Request) {
+	this.updateOneSwitchMachine(w, r, true)
+}
+
+//handlePatchSwitchMachine only changes the fields that are sent
+func (this *switchMachineHandler) handlePatchSwitchMachine(w http.ResponseWriter, r *http.Request) {
+	this.updateOneSwitchMachine(w, r, false)
+}
+
+//updateOneSwitchMachine answers with the switch machine once the driver has taken the update. Sending what it already
+//is is not an error
+func (this *switchMachineHandler) updateOneSwitchMachine(w http.ResponseWriter, r *http.Request, isFull bool) {
+	smId, err := getSMIdFromRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
 		return
 	}
-	errors := make([]error, 0)
-	for _, curSMReq := range switchMachines {
-		log.Println("DEBUG -", curSMReq)
-		err = this.controller.UpdateSwitchMachine(curSMReq)
-
+	patch := &apiModel.SwitchMachinePatch{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(patch)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	curState, err := this.controller.GetSwitchMachineById(smId)
+	if err != nil {
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	if !checkIfMatch(w, r, curState) {
+		return
+	}
+	problems := patch.UpdateProblems(smId)
+	if isFull {
+		problems = append(problems, patch.MissingProblems()...)
+	}
+	if len(problems) > 0 {
+		apiErr := apiModel.NewError(apiModel.ErrorCodeInvalidValue, invalidUpdateErrorMessage)
+		apiErr.Problems = problems
+		apiModel.WriteError(w, apiErr)
+		return
+	}
+	hasIfMatch := r.Header.Get("If-Match") != ""
+	//A patch carries every field over from curState so it is always made against its version, otherwise a patch
+	//racing another update would undo it. Without a version from the client it is applied again to the new state
+	canRetry := !isFull && !hasIfMatch && patch.Ver == nil
+	ctx, cancel := context.WithTimeout(r.Context(), updateTimeout)
+	defer cancel()
+	var op operation.Operation
+	for attempt := 0; ; attempt++ {
+		smReq := patch.ApplyTo(curState)
+		if smReq.Ver == 0 && (hasIfMatch || !isFull) {
+			//Has the controller check again in case it changes before the update is made
+			smReq.Ver = curState.Version()
+		}
+		if logger.Enabled(logging.LevelDebug) {
+			logger.Debug("Updating switch machine", "state", switchmachine.StateToString(smReq))
+		}
+		op, err = this.operations.Update(ctx, smReq)
+		if !canRetry || attempt == patchRetries || !errors.Is(err, controller.ErrSwitchMachineVersionMismatch) {
+			break
+		}
+		curState, err = this.controller.GetSwitchMachineById(smId)
 		if err != nil {
-			errors = append(errors, err)
-			log.Println(err)
+			break
 		}
 	}
+	if err != nil {
+		logger.Warn("Unable to update switch machine", "id", smId, "err", err)
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	this.writeUpdatedSwitchMachine(w, op)
+}
 
-	if len(errors) > 0 {
+//handleToggleSwitchMachine throws the switch machine to the other position, keeping its gpio as they are
+func (this *switchMachineHandler) handleToggleSwitchMachine(w http.ResponseWriter, r *http.Request) {
+	smId, err := getSMIdFromRequest(r)
+	if err != nil {
+		apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodeMalformedRequest, err.Error()))
+		return
+	}
+	curState, err := this.controller.GetSwitchMachineById(smId)
+	if err != nil {
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	if !checkIfMatch(w, r, curState) {
+		return
+	}
+	var version uint64
+	if r.Header.Get("If-Match") != "" {
+		//Has the controller check again in case it changes before the toggle is made
+		version = curState.Version()
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), updateTimeout)
+	defer cancel()
+	op, err := this.operations.Toggle(ctx, smId, version)
+	if err != nil {
+		logger.Warn("Unable to toggle switch machine", "id", smId, "err", err)
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	logger.Debug("Toggled switch machine", "id", smId, "position", apiModel.MapModelPosToApiPos(op.Target), "operation", op.Id)
+	this.writeUpdatedSwitchMachine(w, op)
+}
+
+func (this *switchMachineHandler) setOperationLocation(w http.ResponseWriter, opId operation.Id) {
+	if location := this.locateOp(opId); location != "" {
+		w.Header().Set("Location", location)
+	}
+}
+
+func (this *switchMachineHandler) writeSwitchMachine(w http.ResponseWriter, smId switchmachine.Id) {
+	sm, err := this.controller.GetSwitchMachineById(smId)
+	if err != nil {
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	writeSwitchMachineBody(w, sm, apiModel.NewAPISwitchMachineFromModel(sm))
+}
+
+//writeUpdatedSwitchMachine sends the switch machine along with the operation for its update, the same as each result
+//of a batch update has
+func (this *switchMachineHandler) writeUpdatedSwitchMachine(w http.ResponseWriter, op operation.Operation) {
+	this.setOperationLocation(w, op.Id)
+	sm, err := this.controller.GetSwitchMachineById(op.SMId)
+	if err != nil {
+		//It was removed since it was updated
+		apiModel.WriteErrorFromModel(w, err)
+		return
+	}
+	writeSwitchMachineBody(w, sm, apiModel.NewAPIUpdatedSwitchMachineFromModel(sm, op.Id))
+}
+
+func writeSwitchMachineBody(w http.ResponseWriter, sm switchmachine.State, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", apiModel.NewETagFromModel(sm))
+	encodeErr := json.NewEncoder(w).Encode(body)
+
+	if encodeErr != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(fmt.Sprint(errors)))
 	}
+}
+
+//checkIfMatch writes a 412 and returns false when the request has an If-Match header that curState doesn't match.
+//Without the header every state matches
+func checkIfMatch(w http.ResponseWriter, r *http.Request, curState switchmachine.State) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	eTag := apiModel.NewETagFromModel(curState)
+	for _, curTag := range strings.Split(ifMatch, ",") {
+		curTag = strings.TrimPrefix(strings.TrimSpace(curTag), "W/")
+		if curTag == "*" || curTag == eTag {
+			return true
+		}
+	}
+	apiModel.WriteError(w, apiModel.NewError(apiModel.ErrorCodePreconditionFailed, fmt.Sprintf(eTagMismatchErrorMessage, curState.Id(), eTag)))
+	return false
+}
 
+func getAtomicFromRequest(r *http.Request) (bool, error) {
+	atomicStr := r.URL.Query().Get(atomicQueryKey)
+	if atomicStr == "" {
+		return false, nil
+	}
+	isAtomic, err := strconv.ParseBool(atomicStr)
+	if err != nil {
+		return false, fmt.Errorf(malformedAtomicErrorMessage, atomicStr)
+	}
+	return isAtomic, nil
 }
 
 func getSMIdFromRequest(r *http.Request) (switchmachine.Id, error) {
@@ -138,8 +399,9 @@ func getSMIdFromRequest(r *http.Request) (switchmachine.Id, error) {
 	if !hasSMId {
 		err = errors.New("Request is missing id")
 	} else {
-		var smIdInt int64
-		smIdInt, err = strconv.ParseInt(smIdStr, 10, 0)
+		var smIdInt uint64
+		//Ids that don't fit are malformed rather than wrapped around onto another switch machine
+		smIdInt, err = strconv.ParseUint(smIdStr, 10, 16)
 
 		if err != nil {
 			err = errors.New("Malformed id in request")
@@ -0,0 +1,377 @@
+package switchmachine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	apiModel "github.com/ZacharyDuve/SwitchMachineDriverServer/app/api/model"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/operation"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/gorilla/mux"
+)
+
+//newTestRouter serves the switch machine routes for a simulated switch machine at each of positions, the first one
+//being id 0. It returns once the controller has all of them
+func newTestRouter(t *testing.T, positions ...switchmachine.Position) (*mux.Router, controller.TortoiseController) {
+	sim := tortoise.NewSimulatorTortoiseControllerDriver(time.Millisecond, 20*time.Millisecond)
+	for i, curPos := range positions {
+		if err := sim.AttachMachine(switchmachine.Id(i), curPos); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := controller.NewTortoiseControllerWithMotorRunTime(sim, 50*time.Millisecond)
+	t.Cleanup(func() {
+		sim.Close()
+	})
+	for start := time.Now(); len(c.GetSwitchMachines()) < len(positions); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 2*time.Second {
+			t.Fatalf("Controller only has %d of %d switch machines", len(c.GetSwitchMachines()), len(positions))
+		}
+	}
+	rtr := mux.NewRouter()
+	NewSwitchMachineHandler(rtr, c, operation.NewTracker(c), func(operation.Id) string {
+		return ""
+	})
+	return rtr, c
+}
+
+//serveTestRequest sends the If-Match header when ifMatch isn't empty
+func serveTestRequest(rtr http.Handler, method, target, body, ifMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	rtr.ServeHTTP(rec, req)
+	return rec
+}
+
+//checkTestError fails unless rec is an error with code sent with its status
+func checkTestError(t *testing.T, rec *httptest.ResponseRecorder, code apiModel.ErrorCode) *apiModel.Error {
+	t.Helper()
+	if rec.Code != code.Status() {
+		t.Errorf("Expected status %d but was %d: %s", code.Status(), rec.Code, rec.Body.String())
+	}
+	apiErr := &apiModel.Error{}
+	if err := json.NewDecoder(rec.Body).Decode(apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if apiErr.Code != code {
+		t.Errorf("Expected code %s but was %s", code, apiErr.Code)
+	}
+	return apiErr
+}
+
+func decodeTestResults(t *testing.T, rec *httptest.ResponseRecorder) []apiModel.SwitchMachineUpdateResult {
+	t.Helper()
+	results := make([]apiModel.SwitchMachineUpdateResult, 0)
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestGetUnknownSwitchMachineIsNotFound(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	checkTestError(t, serveTestRequest(rtr, http.MethodGet, "/switchmachine/9", "", ""), apiModel.ErrorCodeNotFound)
+}
+
+func TestGetMalformedSwitchMachineIdIsMalformedRequest(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	checkTestError(t, serveTestRequest(rtr, http.MethodGet, "/switchmachine/abc", "", ""), apiModel.ErrorCodeMalformedRequest)
+}
+
+func TestToggleSwitchMachineWithUnknownPositionIsConflict(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.PositionUnknown)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPost, "/switchmachine/0/toggle", "", ""), apiModel.ErrorCodeConflict)
+}
+
+func TestBatchUpdateReportsEverySwitchMachine(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position0, switchmachine.Position0)
+	body := `[
+		{"id": 0, "position": "position 1", "gpio0": "off", "gpio1": "off"},
+		{"id": 9, "position": "position 1", "gpio0": "off", "gpio1": "off"},
+		{"id": 1, "position": "sideways", "gpio0": "off", "gpio1": "off"},
+		{"id": 1, "position": "position 0", "gpio0": "off", "gpio1": "off"}
+	]`
+	rec := serveTestRequest(rtr, http.MethodPut, "/switchmachine", body, "")
+	if rec.Code != http.StatusMultiStatus {
+		t.Errorf("Expected status %d but was %d", http.StatusMultiStatus, rec.Code)
+	}
+	results := decodeTestResults(t, rec)
+	expected := []struct {
+		status apiModel.SwitchMachineUpdateStatus
+		code   apiModel.ErrorCode
+	}{
+		{apiModel.UpdateAccepted, ""},
+		{apiModel.UpdateRejected, apiModel.ErrorCodeNotFound},
+		{apiModel.UpdateRejected, apiModel.ErrorCodeInvalidValue},
+		{apiModel.UpdateNoOp, ""},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results but got %d", len(expected), len(results))
+	}
+	for i, curResult := range results {
+		if curResult.Index != i || curResult.Status != expected[i].status {
+			t.Errorf("Result %d was %s at index %d but expected %s", i, curResult.Status, curResult.Index, expected[i].status)
+		}
+		if expected[i].code == "" && curResult.Error != nil {
+			t.Errorf("Result %d has error %s", i, curResult.Error.Code)
+		} else if expected[i].code != "" && (curResult.Error == nil || curResult.Error.Code != expected[i].code) {
+			t.Errorf("Result %d expected error %s", i, expected[i].code)
+		}
+	}
+	if results[0].OperationId == 0 {
+		t.Error("Accepted update has no operation")
+	}
+	if state, _ := c.GetSwitchMachineById(0); switchmachine.TargetPosition(state) != switchmachine.Position1 {
+		t.Error("Accepted update was not sent to switch machine 0")
+	}
+}
+
+func TestAtomicBatchUpdateWithRejectedSwitchMachineIsUnprocessable(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position0)
+	body := `[
+		{"id": 0, "position": "position 1", "gpio0": "off", "gpio1": "off"},
+		{"id": 9, "position": "position 1", "gpio0": "off", "gpio1": "off"}
+	]`
+	rec := serveTestRequest(rtr, http.MethodPut, "/switchmachine?atomic=true", body, "")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d but was %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	results := decodeTestResults(t, rec)
+	if len(results) != 2 || results[0].Status != apiModel.UpdateAborted || results[1].Status != apiModel.UpdateRejected {
+		t.Errorf("Expected aborted and rejected but got %+v", results)
+	}
+	if state, _ := c.GetSwitchMachineById(0); switchmachine.TargetPosition(state) != switchmachine.Position0 {
+		t.Error("Aborted update was still sent")
+	}
+}
+
+func decodeTestSwitchMachine(t *testing.T, rec *httptest.ResponseRecorder) *apiModel.SwitchMachine {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but was %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	apiSM := &apiModel.SwitchMachine{}
+	if err := json.NewDecoder(rec.Body).Decode(apiSM); err != nil {
+		t.Fatal(err)
+	}
+	return apiSM
+}
+
+//decodeTestUpdatedSwitchMachine fails unless rec is a 200 with the switch machine and the operation of its update
+func decodeTestUpdatedSwitchMachine(t *testing.T, rec *httptest.ResponseRecorder) *apiModel.UpdatedSwitchMachine {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but was %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	updated := &apiModel.UpdatedSwitchMachine{}
+	if err := json.NewDecoder(rec.Body).Decode(updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.OperationId == 0 {
+		t.Errorf("Expected the operation of the update but got %+v", updated)
+	}
+	return updated
+}
+
+func TestReplaceSwitchMachine(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position0)
+	body := `{"position": "position 1", "gpio0": "on", "gpio1": "off"}`
+	apiSM := decodeTestUpdatedSwitchMachine(t, serveTestRequest(rtr, http.MethodPut, "/switchmachine/0", body, ""))
+	if apiSM.SMId != 0 || apiSM.Gpio0 != apiModel.ON || apiSM.Gpio1 != apiModel.OFF {
+		t.Errorf("Unexpected switch machine %+v", apiSM)
+	}
+	if state, _ := c.GetSwitchMachineById(0); switchmachine.TargetPosition(state) != switchmachine.Position1 {
+		t.Error("Switch machine was not thrown to position 1")
+	}
+}
+
+func TestReplaceSwitchMachineWithMissingFieldsIsInvalid(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	apiErr := checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/switchmachine/0", `{"position": "position 1"}`, ""), apiModel.ErrorCodeInvalidValue)
+	if len(apiErr.Problems) != 2 {
+		t.Errorf("Expected gpio0 and gpio1 to be missing but got %v", apiErr.Problems)
+	}
+}
+
+func TestPatchSwitchMachineOnlyChangesWhatIsSent(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position1)
+	apiSM := decodeTestUpdatedSwitchMachine(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio1": "on"}`, ""))
+	if apiSM.Pos != apiModel.Position1 || apiSM.Gpio0 != apiModel.OFF || apiSM.Gpio1 != apiModel.ON {
+		t.Errorf("Unexpected switch machine %+v", apiSM)
+	}
+	if state, _ := c.GetSwitchMachineById(0); state.MotorState() != switchmachine.MotorStateIdle {
+		t.Error("Changing gpio moved the switch machine")
+	}
+}
+
+//racingController has another update made to a switch machine straight after the handler first reads it
+type racingController struct {
+	controller.TortoiseController
+	hasRaced bool
+	race     func(curState switchmachine.State)
+}
+
+func (this *racingController) GetSwitchMachineById(id switchmachine.Id) (switchmachine.State, error) {
+	curState, err := this.TortoiseController.GetSwitchMachineById(id)
+	if err == nil && !this.hasRaced {
+		this.hasRaced = true
+		this.race(curState)
+	}
+	return curState, err
+}
+
+func TestPatchRacingAnotherUpdateKeepsBothChanges(t *testing.T) {
+	_, c := newTestRouter(t, switchmachine.Position0)
+	racing := &racingController{TortoiseController: c, race: func(curState switchmachine.State) {
+		otherUpdate := switchmachine.NewState(0, curState.Position(), curState.MotorState(), curState.GPIO0State(), switchmachine.GPIOOn)
+		if err := c.UpdateSwitchMachine(context.Background(), otherUpdate); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	rtr := mux.NewRouter()
+	NewSwitchMachineHandler(rtr, racing, operation.NewTracker(c), func(operation.Id) string {
+		return ""
+	})
+
+	apiSM := decodeTestSwitchMachine(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio0": "on"}`, ""))
+
+	if apiSM.Gpio0 != apiModel.ON || apiSM.Gpio1 != apiModel.ON {
+		t.Errorf("Expected both gpio on but got %+v", apiSM)
+	}
+}
+
+func TestPatchSwitchMachineWithInvalidValueIsInvalid(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio0": "dim"}`, ""), apiModel.ErrorCodeInvalidValue)
+}
+
+func TestPatchUnknownSwitchMachineIsNotFound(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/9", `{"gpio0": "on"}`, ""), apiModel.ErrorCodeNotFound)
+}
+
+func TestSwitchMachineIdsThatDontFitAreMalformed(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/65536", `{"gpio0": "on"}`, ""), apiModel.ErrorCodeMalformedRequest)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/switchmachine/65536", `{"position": "position 1", "gpio0": "on", "gpio1": "off"}`, ""), apiModel.ErrorCodeMalformedRequest)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPost, "/switchmachine/65536/toggle", "", ""), apiModel.ErrorCodeMalformedRequest)
+}
+
+func TestToggleSwitchMachine(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position1)
+	decodeTestUpdatedSwitchMachine(t, serveTestRequest(rtr, http.MethodPost, "/switchmachine/0/toggle", "", ""))
+	if state, _ := c.GetSwitchMachineById(0); switchmachine.TargetPosition(state) != switchmachine.Position0 {
+		t.Error("Switch machine was not thrown to position 0")
+	}
+}
+
+//getTestETag fails if the switch machine is sent without an ETag
+func getTestETag(t *testing.T, rtr http.Handler, target string) string {
+	t.Helper()
+	rec := serveTestRequest(rtr, http.MethodGet, target, "", "")
+	eTag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || eTag == "" {
+		t.Fatalf("Expected an ETag but got status %d with %q", rec.Code, eTag)
+	}
+	return eTag
+}
+
+func TestUpdatesWithMatchingETagAreMade(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	eTag := getTestETag(t, rtr, "/switchmachine/0")
+	rec := serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio0": "on"}`, eTag)
+	decodeTestSwitchMachine(t, rec)
+	if newETag := rec.Header().Get("ETag"); newETag == "" || newETag == eTag {
+		t.Errorf("Expected a new ETag after the update but got %q", newETag)
+	}
+	decodeTestSwitchMachine(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio1": "on"}`, "*"))
+}
+
+func TestUpdatesWithOldETagAreRejected(t *testing.T) {
+	rtr, c := newTestRouter(t, switchmachine.Position0)
+	oldETag := getTestETag(t, rtr, "/switchmachine/0")
+	decodeTestSwitchMachine(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio0": "on"}`, ""))
+	before, _ := c.GetSwitchMachineById(0)
+
+	checkTestError(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/0", `{"gpio1": "on"}`, oldETag), apiModel.ErrorCodePreconditionFailed)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPut, "/switchmachine/0", `{"position": "position 1", "gpio0": "off", "gpio1": "off"}`, oldETag), apiModel.ErrorCodePreconditionFailed)
+	checkTestError(t, serveTestRequest(rtr, http.MethodPost, "/switchmachine/0/toggle", "", oldETag), apiModel.ErrorCodePreconditionFailed)
+	if after, _ := c.GetSwitchMachineById(0); after.Version() != before.Version() {
+		t.Error("Switch machine was changed by a request with an old ETag")
+	}
+}
+
+//getTestIds is the ids of the switch machines that target lists
+func getTestIds(t *testing.T, rtr http.Handler, target string) []apiModel.SwitchMachineId {
+	t.Helper()
+	rec := serveTestRequest(rtr, http.MethodGet, target, "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d but was %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	apiSMs := make([]apiModel.SwitchMachine, 0)
+	if err := json.NewDecoder(rec.Body).Decode(&apiSMs); err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]apiModel.SwitchMachineId, 0, len(apiSMs))
+	for _, curSM := range apiSMs {
+		ids = append(ids, curSM.SMId)
+	}
+	return ids
+}
+
+func checkTestIds(t *testing.T, rtr http.Handler, target string, expected ...apiModel.SwitchMachineId) {
+	t.Helper()
+	ids := getTestIds(t, rtr, target)
+	if len(ids) != len(expected) {
+		t.Errorf("%s expected %v but got %v", target, expected, ids)
+		return
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("%s expected %v but got %v", target, expected, ids)
+			return
+		}
+	}
+}
+
+func TestGetSwitchMachinesFilters(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0, switchmachine.Position1, switchmachine.PositionUnknown, switchmachine.Position0, switchmachine.Position1)
+	checkTestIds(t, rtr, "/switchmachine", 0, 1, 2, 3, 4)
+	checkTestIds(t, rtr, "/switchmachine?position=position%201", 1, 4)
+	checkTestIds(t, rtr, "/switchmachine?position=position%200,unknown", 0, 2, 3)
+	checkTestIds(t, rtr, "/switchmachine?board=1", 4)
+	checkTestIds(t, rtr, "/switchmachine?board=0&position=position%201", 1)
+	checkTestIds(t, rtr, "/switchmachine?motorState=idle&gpio0=off", 0, 1, 2, 3, 4)
+	checkTestIds(t, rtr, "/switchmachine?gpio1=on")
+}
+
+func TestGetSwitchMachinesChangedSince(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0, switchmachine.Position0)
+	checkTestIds(t, rtr, "/switchmachine?changedSince=0", 0, 1)
+	//Update times are in milliseconds so switch machine 0 has to have been added in an earlier one than since
+	time.Sleep(2 * time.Millisecond)
+	since := time.Now().UnixMilli()
+	decodeTestSwitchMachine(t, serveTestRequest(rtr, http.MethodPatch, "/switchmachine/1", `{"gpio0": "on"}`, ""))
+	checkTestIds(t, rtr, "/switchmachine?changedSince="+strconv.FormatInt(since, 10), 1)
+	checkTestIds(t, rtr, "/switchmachine?changedSince="+strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10))
+}
+
+func TestGetSwitchMachinesWithBadFilterIsMalformed(t *testing.T) {
+	rtr, _ := newTestRouter(t, switchmachine.Position0)
+	rec := serveTestRequest(rtr, http.MethodGet, "/switchmachine?board=x&position=sideways&motorState=spinning&gpio0=dim&changedSince=yesterday", "", "")
+	apiErr := checkTestError(t, rec, apiModel.ErrorCodeMalformedRequest)
+	if len(apiErr.Problems) != 5 {
+		t.Errorf("Expected a problem for each bad value but got %v", apiErr.Problems)
+	}
+}
@@ -0,0 +1,57 @@
+//Package atomicfile replaces files so that a crash or power cut part way through leaves either the old contents or the
+//new ones, never a mix
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+//DefaultMode is given to files that don't exist yet. Files that do keep their permissions
+const DefaultMode os.FileMode = 0644
+
+//WriteFile writes contents to a temporary file next to path, syncs it and renames it over path. The directory is
+//synced too so the rename itself survives a power cut
+func WriteFile(path string, contents []byte) error {
+	mode := DefaultMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(contents)
+	if err == nil {
+		err = tmpFile.Chmod(mode)
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err == nil {
+		err = syncDir(dir)
+	}
+	return err
+}
+
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = dirFile.Sync()
+	closeErr := dirFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
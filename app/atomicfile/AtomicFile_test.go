@@ -0,0 +1,38 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileReplacesContentsAndKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.json")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	entries, _ := os.ReadDir(dir)
+	if string(contents) != "new" || info.Mode().Perm() != 0600 || len(entries) != 1 {
+		t.Errorf("Got %q with mode %v and %d files", contents, info.Mode().Perm(), len(entries))
+	}
+}
+
+func TestWriteFileCreatesMissingFileWithDefaultMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.json")
+
+	if err := WriteFile(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != DefaultMode {
+		t.Fail()
+	}
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//ErrCalibrationNotSaved is returned when a calibration was valid but couldn't be saved, so it wasn't applied
+var ErrCalibrationNotSaved = errors.New("Calibration could not be saved")
+
+const reversedWiringSuggestionMessage string = "Switch machine moved away from the position it was thrown to. Swapping the motor is suggested, " +
+	"although swapped feedback leads look the same so try swapping the feedback if the motor doesn't fix it"
+
+//CalibrationSuggestion is a switch machine that has been seen moving away from the position it was thrown to,
+//which is what a machine with its motor or feedback leads swapped does
+type CalibrationSuggestion struct {
+	Id switchmachine.Id
+	//Where the switch machine was last being thrown to
+	Target switchmachine.Position
+	//Where it said it was instead
+	ObservedPosition switchmachine.Position
+	//How many times it has been seen since its calibration was last set
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Current   hardware.Calibration
+	Suggested hardware.Calibration
+	Message   string
+}
+
+//applyStoredCalibrations is called before the driver starts so no machine is ever driven with the wrong calibration
+func (this *tortoiseControllerImpl) applyStoredCalibrations() {
+	calibrations := this.calibrations.GetAll()
+	if len(calibrations) == 0 {
+		return
+	}
+	calibratable, isCalibratable := this.driver.(hardware.Calibratable)
+	if !isCalibratable {
+		logger.Warn("Driver can't calibrate switch machines, ignoring saved calibrations", "count", len(calibrations))
+		return
+	}
+	for curId, curCalibration := range calibrations {
+		if err := calibratable.SetCalibration(curId, curCalibration); err != nil {
+			logger.Warn("Unable to apply saved calibration", "id", curId, "err", err)
+		}
+	}
+}
+
+//SetCalibration puts the driver back how it was if the calibration can't be saved
+func (this *tortoiseControllerImpl) SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error {
+	calibratable, isCalibratable := this.driver.(hardware.Calibratable)
+	if !isCalibratable {
+		return hardware.ErrNotCalibratable
+	}
+	prevCalibration := this.calibrations.Get(id)
+	if err := calibratable.SetCalibration(id, calibration); err != nil {
+		return err
+	}
+	if err := this.calibrations.Set(id, calibration); err != nil {
+		if restoreErr := calibratable.SetCalibration(id, prevCalibration); restoreErr != nil {
+			logger.Error("Unable to restore calibration after it couldn't be saved", "id", id, "err", restoreErr)
+		}
+		return fmt.Errorf("%w: %v", ErrCalibrationNotSaved, err)
+	}
+	logger.Info("Calibration set", "id", id, "swapMotor", calibration.SwapMotor, "swapFeedback", calibration.SwapFeedback)
+	this.suggestionsMutex.Lock()
+	delete(this.suggestions, id)
+	this.suggestionsMutex.Unlock()
+	return nil
+}
+
+func (this *tortoiseControllerImpl) Calibrations() map[switchmachine.Id]hardware.Calibration {
+	return this.calibrations.GetAll()
+}
+
+//CalibrationSuggestions are in id order
+func (this *tortoiseControllerImpl) CalibrationSuggestions() []CalibrationSuggestion {
+	this.suggestionsMutex.Lock()
+	suggestions := make([]CalibrationSuggestion, 0, len(this.suggestions))
+	for _, curSuggestion := range this.suggestions {
+		suggestions = append(suggestions, *curSuggestion)
+	}
+	this.suggestionsMutex.Unlock()
+	sort.Slice(suggestions, func(i, j int) bool { return suggestions[i].Id < suggestions[j].Id })
+	return suggestions
+}
+
+//checkForReversedWiring suggests a calibration when a machine arrives at the opposite position to the one its motor
+//is running towards
+func (this *tortoiseControllerImpl) checkForReversedWiring(prevState switchmachine.State, newPosition switchmachine.Position) {
+	var target switchmachine.Position
+	switch prevState.MotorState() {
+	case switchmachine.MotorStateToPos0:
+		target = switchmachine.Position0
+	case switchmachine.MotorStateToPos1:
+		target = switchmachine.Position1
+	default:
+		return
+	}
+	if newPosition != hardware.OppositePosition(target) {
+		return
+	}
+	now := time.Now()
+	current := this.calibrations.Get(prevState.Id())
+	this.suggestionsMutex.Lock()
+	suggestion, exists := this.suggestions[prevState.Id()]
+	if !exists {
+		suggestion = &CalibrationSuggestion{Id: prevState.Id(), FirstSeen: now, Message: reversedWiringSuggestionMessage}
+		this.suggestions[prevState.Id()] = suggestion
+	}
+	suggestion.Target = target
+	suggestion.ObservedPosition = newPosition
+	suggestion.Count++
+	suggestion.LastSeen = now
+	suggestion.Current = current
+	suggestion.Suggested = hardware.Calibration{SwapMotor: !current.SwapMotor, SwapFeedback: current.SwapFeedback}
+	count := suggestion.Count
+	this.suggestionsMutex.Unlock()
+	logger.Warn("Switch machine moved away from the position it was thrown to, its wiring may be reversed",
+		"id", prevState.Id(), "target", target, "observedPosition", newPosition, "count", count)
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/persistance"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestStoredCalibrationsAreAppliedToDriverBeforeStart(t *testing.T) {
+	driver := &mockCalibratableDriver{}
+	store := persistance.NewCalibrationStore()
+	store.Set(6, hardware.Calibration{SwapFeedback: true})
+
+	NewTortoiseControllerWithCalibrations(driver, time.Second, store)
+
+	if !driver.wasCalibratedBeforeStart || driver.calibrated[6] != (hardware.Calibration{SwapFeedback: true}) {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationOnDriverThatCantCalibrateReturnsErrNotCalibratable(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+
+	if err := c.SetCalibration(1, hardware.Calibration{SwapMotor: true}); !errors.Is(err, hardware.ErrNotCalibratable) {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationIsAppliedToDriverAndStored(t *testing.T) {
+	c := newTortoiseController()
+	driver := &mockCalibratableDriver{}
+	c.driver = driver
+
+	err := c.SetCalibration(2, hardware.Calibration{SwapMotor: true})
+
+	if err != nil || !driver.calibrated[2].SwapMotor || !c.Calibrations()[2].SwapMotor {
+		t.Fail()
+	}
+}
+
+func TestMachineMovingAwayFromThrowIsSuggestedForCalibration(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockCalibratableDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.Throw(context.Background(), sm.Id(), switchmachine.Position0)
+
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), switchmachine.NewState(sm.Id(), switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)))
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), switchmachine.NewState(sm.Id(), switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)))
+
+	suggestions := c.CalibrationSuggestions()
+	if len(suggestions) != 1 || suggestions[0].Id != sm.Id() || suggestions[0].Target != switchmachine.Position0 || !suggestions[0].Suggested.SwapMotor {
+		t.Fail()
+	}
+}
+
+func TestMachineReachingThrowTargetIsNotSuggestedForCalibration(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockCalibratableDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.Throw(context.Background(), sm.Id(), switchmachine.Position0)
+
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), switchmachine.NewState(sm.Id(), switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)))
+
+	if len(c.CalibrationSuggestions()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationClearsSuggestion(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockCalibratableDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.checkForReversedWiring(sm, switchmachine.Position0)
+
+	c.SetCalibration(sm.Id(), hardware.Calibration{SwapMotor: true})
+
+	if len(c.CalibrationSuggestions()) != 0 {
+		t.Fail()
+	}
+}
+
+type mockCalibratableDriver struct {
+	mockHardwareDriver
+	isStarted                bool
+	wasCalibratedBeforeStart bool
+	calibrated               map[switchmachine.Id]hardware.Calibration
+}
+
+func (this *mockCalibratableDriver) Start(hardware.DriverEventListener) {
+	this.isStarted = true
+}
+
+func (this *mockCalibratableDriver) SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error {
+	if this.calibrated == nil {
+		this.calibrated = make(map[switchmachine.Id]hardware.Calibration)
+	}
+	this.calibrated[id] = calibration
+	this.wasCalibratedBeforeStart = !this.isStarted
+	return nil
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//RestoreGPIO gives each switch machine in states the gpio it has there. Those already attached get it straight away
+//and the rest when they are added, so the driver can be started before the states have been read
+func (this *tortoiseControllerImpl) RestoreGPIO(states []switchmachine.State) {
+	for _, curState := range states {
+		this.restoreGPIO(curState.Id(), SafeGPIOState{GPIO0: curState.GPIO0State(), GPIO1: curState.GPIO1State()})
+	}
+}
+
+func (this *tortoiseControllerImpl) restoreGPIO(id switchmachine.Id, gpio SafeGPIOState) {
+	defer this.lockId(id)()
+	curState := this.existingSMStates.GetSwitchMachineById(id)
+	if curState == nil {
+		this.restoreMutex.Lock()
+		this.restoredGPIO[id] = gpio
+		this.restoreMutex.Unlock()
+		return
+	}
+	newState := withGPIO(curState, gpio)
+	if areGPIOEqual(curState, newState) {
+		return
+	}
+	err := this.updateDriverWithTimeout(newState)
+	if err != nil {
+		logger.Error("Unable to restore gpio of switch machine", "id", id, "err", err)
+		return
+	}
+	this.storeAndSendUpdate(newState)
+}
+
+//withRestoredGPIO sets the restored gpio of a switch machine that is being added on the driver. addedState is
+//returned as it is when there isn't any or it couldn't be set. Expects the lock of the id to already be held
+func (this *tortoiseControllerImpl) withRestoredGPIO(addedState switchmachine.State) switchmachine.State {
+	this.restoreMutex.Lock()
+	gpio, hasGPIO := this.restoredGPIO[addedState.Id()]
+	delete(this.restoredGPIO, addedState.Id())
+	this.restoreMutex.Unlock()
+	if !hasGPIO {
+		return addedState
+	}
+	newState := withGPIO(addedState, gpio)
+	if areGPIOEqual(addedState, newState) {
+		return addedState
+	}
+	err := this.updateDriverWithTimeout(newState)
+	if err != nil {
+		logger.Error("Unable to restore gpio of switch machine", "id", addedState.Id(), "err", err)
+		return addedState
+	}
+	return newState
+}
+
+func withGPIO(s switchmachine.State, gpio SafeGPIOState) switchmachine.State {
+	return switchmachine.NewState(s.Id(), s.Position(), s.MotorState(), gpio.GPIO0, gpio.GPIO1)
+}
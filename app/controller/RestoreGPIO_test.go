@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestRestoreGPIOIsAppliedWhenSwitchMachineIsAdded(t *testing.T) {
+	c := newTortoiseController()
+	var driverState switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(s switchmachine.State) {
+		driverState = s
+	}}
+	c.RestoreGPIO([]switchmachine.State{switchmachine.NewState(2, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)})
+
+	sm := switchmachine.NewState(2, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	stored, _ := c.GetSwitchMachineById(sm.Id())
+	if stored.GPIO0State() != switchmachine.GPIOOn || stored.Position() != switchmachine.Position0 {
+		t.Errorf("Stored state was %v", stored)
+	}
+	if driverState == nil || driverState.GPIO0State() != switchmachine.GPIOOn || driverState.MotorState() != switchmachine.MotorStateIdle {
+		t.Errorf("Driver was given %v", driverState)
+	}
+}
+
+func TestRestoreGPIOIsAppliedToAttachedSwitchMachineOnlyOnce(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(2, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	c.RestoreGPIO([]switchmachine.State{switchmachine.NewState(2, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOn)})
+	if stored, _ := c.GetSwitchMachineById(sm.Id()); stored.GPIO1State() != switchmachine.GPIOOn {
+		t.Errorf("Stored state was %v", stored)
+	}
+
+	//Coming back after dropping off the chain isn't a restart so it keeps what the driver reports
+	c.HandleDriverEvent(hardware.NewSwitchMachineRemovedEvent(sm.Id()))
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	if stored, _ := c.GetSwitchMachineById(sm.Id()); stored.GPIO1State() != switchmachine.GPIOOFF {
+		t.Errorf("Stored state was %v", stored)
+	}
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/metrics"
+)
+
+var (
+	throwsTotal        = metrics.NewCounterVec("smds_switch_machine_throws_total", "Throws started for each switch machine", "id")
+	throwFailuresTotal = metrics.NewCounterVec("smds_switch_machine_throw_failures_total", "Throws that could not be started or did not reach their position before the motor was stopped", "id")
+	throwDuration      = metrics.NewHistogram("smds_switch_machine_throw_duration_seconds", "Time from starting a throw until the switch machine reports its new position", []float64{0.5, 1, 1.5, 2, 2.5, 3, 3.5, 4, 5, 7.5, 10})
+	attachedMachines   = metrics.NewGauge("smds_switch_machines_attached", "Switch machines currently attached")
+)
+
+//throwTracker times each throw from when its motor is started until the driver reports the new position
+type throwTracker struct {
+	mutex   sync.Mutex
+	pending map[switchmachine.Id]pendingThrow
+	now     func() time.Time
+}
+
+type pendingThrow struct {
+	target  switchmachine.Position
+	started time.Time
+}
+
+func newThrowTracker() *throwTracker {
+	return &throwTracker{pending: make(map[switchmachine.Id]pendingThrow), now: time.Now}
+}
+
+func idLabel(id switchmachine.Id) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+//throwStarted replaces any throw still pending for id as the machine is now heading somewhere else
+func (this *throwTracker) throwStarted(id switchmachine.Id, target switchmachine.Position) {
+	throwsTotal.With(idLabel(id)).Inc()
+	this.mutex.Lock()
+	this.pending[id] = pendingThrow{target: target, started: this.now()}
+	this.mutex.Unlock()
+}
+
+func (this *throwTracker) throwNotStarted(id switchmachine.Id) {
+	throwFailuresTotal.With(idLabel(id)).Inc()
+}
+
+func (this *throwTracker) positionChanged(id switchmachine.Id, position switchmachine.Position) {
+	this.mutex.Lock()
+	throw, isPending := this.pending[id]
+	if isPending && throw.target == position {
+		delete(this.pending, id)
+	}
+	this.mutex.Unlock()
+	if isPending && throw.target == position {
+		throwDuration.ObserveDuration(this.now().Sub(throw.started))
+	}
+}
+
+//motorStopped counts the pending throw as failed if it has had runTime to finish. Throws started more recently
+//belong to a later stop
+func (this *throwTracker) motorStopped(id switchmachine.Id, runTime time.Duration) {
+	this.mutex.Lock()
+	throw, isPending := this.pending[id]
+	hasFailed := isPending && this.now().Sub(throw.started) >= runTime
+	if hasFailed {
+		delete(this.pending, id)
+	}
+	this.mutex.Unlock()
+	if hasFailed {
+		throwFailuresTotal.With(idLabel(id)).Inc()
+	}
+}
+
+func (this *throwTracker) removed(id switchmachine.Id) {
+	this.mutex.Lock()
+	delete(this.pending, id)
+	this.mutex.Unlock()
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Metrics are shared by the whole package and kept between runs so each test uses its own ids and checks how much
+//things changed
+
+func newTestThrowTracker(now *time.Time) *throwTracker {
+	tracker := newThrowTracker()
+	tracker.now = func() time.Time {
+		return *now
+	}
+	return tracker
+}
+
+func TestThrowReachingItsPositionIsTimedAndNotFailed(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestThrowTracker(&now)
+	id := switchmachine.Id(1001)
+	throwsBefore, failuresBefore := throwsTotal.With(idLabel(id)).Value(), throwFailuresTotal.With(idLabel(id)).Value()
+	durationCount, durationSum := throwDuration.Count(), throwDuration.Sum()
+
+	tracker.throwStarted(id, switchmachine.Position1)
+	now = now.Add(1500 * time.Millisecond)
+	tracker.positionChanged(id, switchmachine.Position1)
+	tracker.motorStopped(id, time.Second)
+
+	if throws := throwsTotal.With(idLabel(id)).Value() - throwsBefore; throws != 1 {
+		t.Errorf("Expected 1 throw but got %v", throws)
+	}
+	if count := throwDuration.Count() - durationCount; count != 1 {
+		t.Fatalf("Expected 1 throw duration but got %d", count)
+	}
+	if sum := throwDuration.Sum() - durationSum; math.Abs(sum-1.5) > 1e-9 {
+		t.Errorf("Expected throw to take 1.5s but got %v", sum)
+	}
+	if failures := throwFailuresTotal.With(idLabel(id)).Value() - failuresBefore; failures != 0 {
+		t.Errorf("Expected no failures but got %v", failures)
+	}
+}
+
+func TestThrowNotReachingItsPositionBeforeMotorStopsIsFailed(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestThrowTracker(&now)
+	id := switchmachine.Id(1002)
+	failuresBefore := throwFailuresTotal.With(idLabel(id)).Value()
+	durationCount := throwDuration.Count()
+
+	tracker.throwStarted(id, switchmachine.Position1)
+	now = now.Add(time.Second)
+	tracker.positionChanged(id, switchmachine.Position0)
+	tracker.motorStopped(id, time.Second)
+
+	if failures := throwFailuresTotal.With(idLabel(id)).Value() - failuresBefore; failures != 1 {
+		t.Errorf("Expected 1 failure but got %v", failures)
+	}
+	if count := throwDuration.Count() - durationCount; count != 0 {
+		t.Errorf("Expected failed throw not to be timed but got %d durations", count)
+	}
+}
+
+func TestMotorStopForEarlierThrowDoesNotFailLaterThrow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tracker := newTestThrowTracker(&now)
+	id := switchmachine.Id(1003)
+	throwsBefore, failuresBefore := throwsTotal.With(idLabel(id)).Value(), throwFailuresTotal.With(idLabel(id)).Value()
+
+	tracker.throwStarted(id, switchmachine.Position1)
+	now = now.Add(500 * time.Millisecond)
+	tracker.throwStarted(id, switchmachine.Position0)
+	now = now.Add(500 * time.Millisecond)
+	//The stop for the first throw arrives while the second still has time left
+	tracker.motorStopped(id, time.Second)
+
+	if failures := throwFailuresTotal.With(idLabel(id)).Value() - failuresBefore; failures != 0 {
+		t.Errorf("Expected no failures but got %v", failures)
+	}
+	if throws := throwsTotal.With(idLabel(id)).Value() - throwsBefore; throws != 2 {
+		t.Errorf("Expected 2 throws but got %v", throws)
+	}
+}
+
+func TestThrowDriverErrorIsFailed(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{updateErr: hardware.ErrDriverClosed}
+	id := switchmachine.Id(1004)
+	throwsBefore, failuresBefore := throwsTotal.With(idLabel(id)).Value(), throwFailuresTotal.With(idLabel(id)).Value()
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(id, switchmachine.NewState(id, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)))
+
+	if err := c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(id, switchmachine.Position1, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF)); err == nil {
+		t.Fatal("Expected driver error to be returned")
+	}
+	if failures := throwFailuresTotal.With(idLabel(id)).Value() - failuresBefore; failures != 1 {
+		t.Errorf("Expected 1 failure but got %v", failures)
+	}
+	if throws := throwsTotal.With(idLabel(id)).Value() - throwsBefore; throws != 0 {
+		t.Errorf("Expected no throws to be counted but got %v", throws)
+	}
+}
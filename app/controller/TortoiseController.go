@@ -1,44 +1,137 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/event"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/persistance"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
 )
 
 const (
-	switchMachineNotExistErrorMessage string        = "Switch Machine with matching Id %d does not exist"
-	defaultMotorRunTime               time.Duration = time.Second * 4
+	switchMachineNotExistErrorMessage string = "%w: %d"
+	invalidThrowPositionErrorMessage  string = "Switch machine %d can only be thrown to position 0 or 1"
+	positionUnknownErrorMessage       string = "%w: %d"
+	versionMismatchErrorMessage       string = "%w: %d is at version %d"
+	//DefaultMotorRunTime is how long a motor is driven for each throw unless told otherwise
+	DefaultMotorRunTime time.Duration = time.Second * 4
+	//How long updates that the controller makes on its own wait for the driver
+	driverUpdateTimeout time.Duration = time.Second * 5
+	//How often shutdown checks whether running throws have finished
+	shutdownPollInterval time.Duration = time.Millisecond * 50
 )
 
+//ErrSwitchMachineNotExist is returned for ids that have no switch machine attached
+var ErrSwitchMachineNotExist = errors.New("Switch machine does not exist")
+
+//ErrSwitchMachinePositionUnknown is returned when toggling a switch machine that doesn't know where it is
+var ErrSwitchMachinePositionUnknown = errors.New("Switch machine position is unknown")
+
+//ErrSwitchMachineVersionMismatch is returned for updates made against a version the switch machine is no longer at
+var ErrSwitchMachineVersionMismatch = errors.New("Switch machine version does not match")
+
+//ErrControllerShuttingDown is returned for updates requested once Shutdown has been called
+var ErrControllerShuttingDown = errors.New("Controller is shutting down")
+
+var logger = logging.ForSubsystem(logging.SubsystemController)
+
+//ShutdownOptions controls how Shutdown leaves the switch machines
+type ShutdownOptions struct {
+	//How long running throws get to finish before they are braked
+	ThrowWait time.Duration
+	//When set every switch machine has its gpio set to this
+	SafeGPIO *SafeGPIOState
+}
+
+type SafeGPIOState struct {
+	GPIO0 switchmachine.GPIOState
+	GPIO1 switchmachine.GPIOState
+}
+
 type TortoiseController interface {
-	UpdateSwitchMachine(switchmachine.State) error
+	UpdateSwitchMachine(context.Context, switchmachine.State) error
+	//WouldUpdateSwitchMachine checks an update without making it. It returns whether UpdateSwitchMachine would change
+	//anything, or the error it would return before reaching the driver
+	WouldUpdateSwitchMachine(switchmachine.State) (bool, error)
+	//GetSwitchMachines is ordered by id
 	GetSwitchMachines() []switchmachine.State
 	GetSwitchMachineById(id switchmachine.Id) (switchmachine.State, error)
 	SetSwitchMachineEventListenerFunc(func(event.SwitchMachineEvent))
 	HandleDriverEvent(dE hardware.DriverEvent)
+	//Throw runs the motor towards position even when the switch machine already reports being there, which
+	//UpdateSwitchMachine never does. It is for checking wiring where the reported position can't be trusted
+	Throw(ctx context.Context, id switchmachine.Id, position switchmachine.Position) error
+	//Toggle throws the switch machine to the opposite of the position it is at or, while it is moving, heading to.
+	//It returns the position it was thrown to. A version other than 0 has to match the same as it does for
+	//UpdateSwitchMachine
+	Toggle(ctx context.Context, id switchmachine.Id, version uint64) (switchmachine.Position, error)
+	//Idle stops the motor of a switch machine where it is, keeping its gpio as they are. A motor that is already idle
+	//is left alone
+	Idle(ctx context.Context, id switchmachine.Id) error
+	SetMotorRunTime(time.Duration)
+	MotorRunTime() time.Duration
+	//SetCalibration corrects the wiring of a switch machine on the driver and saves it so it is applied again next start
+	SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error
+	//Calibrations only holds switch machines that aren't wired the normal way
+	Calibrations() map[switchmachine.Id]hardware.Calibration
+	CalibrationSuggestions() []CalibrationSuggestion
+	//RestoreGPIO gives switch machines back the gpio they had in states, such as those saved at the last shutdown
+	RestoreGPIO(states []switchmachine.State)
+	//Shutdown stops taking updates and parks the outputs. It does not close the driver
+	Shutdown(context.Context, ShutdownOptions) error
 }
 
 type tortoiseControllerImpl struct {
-	driver              hardware.Driver
-	existingSMStates    persistance.SwitchMachineStore
+	driver           hardware.Driver
+	existingSMStates persistance.SwitchMachineStore
+	//The listener can be set after the driver has started sending events
+	listenerMutex       sync.RWMutex
 	smEventListenerFunc func(event.SwitchMachineEvent)
+	//A time.Duration that is read and written atomically as it can be changed while throws are being started
+	motorRunTime   int64
+	shutdownMutex  sync.RWMutex
+	isShuttingDown bool
+	throws         *throwTracker
+	calibrations   persistance.CalibrationStore
+	//Switch machines that have been seen moving away from where they were thrown to
+	suggestionsMutex sync.Mutex
+	suggestions      map[switchmachine.Id]*CalibrationSuggestion
+	//One for each switch machine id that has been seen
+	idLocksMutex sync.Mutex
+	idLocks      map[switchmachine.Id]*idLock
+	//Gpio from RestoreGPIO for switch machines that haven't been added yet
+	restoreMutex sync.Mutex
+	restoredGPIO map[switchmachine.Id]SafeGPIOState
 }
 
 //Wrapping the internal testable call as an external facing interface to restrict functions
 func NewTortoiseController(driver hardware.Driver) TortoiseController {
+	return NewTortoiseControllerWithMotorRunTime(driver, DefaultMotorRunTime)
+}
+
+//NewTortoiseControllerWithMotorRunTime uses DefaultMotorRunTime when motorRunTime is 0 or less
+func NewTortoiseControllerWithMotorRunTime(driver hardware.Driver, motorRunTime time.Duration) TortoiseController {
+	return NewTortoiseControllerWithCalibrations(driver, motorRunTime, persistance.NewCalibrationStore())
+}
+
+//NewTortoiseControllerWithCalibrations applies every calibration in calibrations to the driver before starting it
+func NewTortoiseControllerWithCalibrations(driver hardware.Driver, motorRunTime time.Duration, calibrations persistance.CalibrationStore) TortoiseController {
 	if driver == nil {
 		panic("driver is required for NewTortoiseController")
 	}
 	controller := newTortoiseController()
 
 	controller.driver = driver
+	controller.calibrations = calibrations
+	controller.SetMotorRunTime(motorRunTime)
+	controller.applyStoredCalibrations()
 	driver.Start(controller)
 
 	return controller
@@ -48,20 +141,90 @@ func NewTortoiseController(driver hardware.Driver) TortoiseController {
 func newTortoiseController() *tortoiseControllerImpl {
 	controller := &tortoiseControllerImpl{}
 	controller.existingSMStates = persistance.NewSwitchMachineStore()
+	controller.motorRunTime = int64(DefaultMotorRunTime)
+	controller.throws = newThrowTracker()
+	controller.calibrations = persistance.NewCalibrationStore()
+	controller.suggestions = make(map[switchmachine.Id]*CalibrationSuggestion)
+	controller.idLocks = make(map[switchmachine.Id]*idLock)
+	controller.restoredGPIO = make(map[switchmachine.Id]SafeGPIOState)
 
 	return controller
 }
 
-func (this *tortoiseControllerImpl) UpdateSwitchMachine(requestState switchmachine.State) error {
-	log.Println("tortoiseControllerImpl-UpdateSwitchMachine called")
+//idLock is held from reading a switch machine's state until what was decided from it has been stored, so two
+//updates made against the same version can't both go through. Events are queued while it is held and only sent once
+//it is released, so a slow listener never holds up updates
+type idLock struct {
+	sync.Mutex
+	eventsMutex   sync.Mutex
+	pendingEvents []event.SwitchMachineEvent
+	isSending     bool
+}
+
+func (this *tortoiseControllerImpl) getIdLock(id switchmachine.Id) *idLock {
+	this.idLocksMutex.Lock()
+	defer this.idLocksMutex.Unlock()
+	curLock, exists := this.idLocks[id]
+	if !exists {
+		curLock = &idLock{}
+		this.idLocks[id] = curLock
+	}
+	return curLock
+}
+
+//lockId returns the function that unlocks it again and then sends the events queued for the switch machine
+func (this *tortoiseControllerImpl) lockId(id switchmachine.Id) func() {
+	curLock := this.getIdLock(id)
+	curLock.Lock()
+	return func() {
+		curLock.Unlock()
+		this.sendPendingEvents(curLock)
+	}
+}
+
+//queueEvent expects the lock of the switch machine's id to be held so events are queued in the order they were stored
+func (this *tortoiseControllerImpl) queueEvent(id switchmachine.Id, sme event.SwitchMachineEvent) {
+	curLock := this.getIdLock(id)
+	curLock.eventsMutex.Lock()
+	curLock.pendingEvents = append(curLock.pendingEvents, sme)
+	curLock.eventsMutex.Unlock()
+}
+
+//sendPendingEvents leaves the events to whoever is already sending them for the switch machine so they stay in order
+func (this *tortoiseControllerImpl) sendPendingEvents(curLock *idLock) {
+	curLock.eventsMutex.Lock()
+	if curLock.isSending {
+		curLock.eventsMutex.Unlock()
+		return
+	}
+	curLock.isSending = true
+	for len(curLock.pendingEvents) > 0 {
+		sme := curLock.pendingEvents[0]
+		curLock.pendingEvents = curLock.pendingEvents[1:]
+		curLock.eventsMutex.Unlock()
+		this.sendSMEventToListener(sme)
+		curLock.eventsMutex.Lock()
+	}
+	curLock.isSending = false
+	curLock.eventsMutex.Unlock()
+}
+
+func (this *tortoiseControllerImpl) UpdateSwitchMachine(ctx context.Context, requestState switchmachine.State) error {
+	if this.hasShutdownStarted() {
+		return ErrControllerShuttingDown
+	}
+	defer this.lockId(requestState.Id())()
 	var err error
 	curState := this.existingSMStates.GetSwitchMachineById(requestState.Id())
-	log.Println("requestState:", switchmachine.StateToString(requestState))
-	log.Println("curState:", switchmachine.StateToString(curState))
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("Update requested", "requestState", switchmachine.StateToString(requestState), "curState", switchmachine.StateToString(curState))
+	}
 	if curState == nil {
 		//We don't have a switchmachine for this id
 		err = newSwitchMachineNotExistError(requestState.Id())
-	} else if !areUpdateableFieldsEqual(curState, requestState) || isMotorRunningToOppositePosition(requestState, curState) {
+	} else if !isVersionMatched(curState, requestState) {
+		err = newVersionMismatchError(curState)
+	} else if isUpdateNeeded(curState, requestState) {
 		//Figure out if we need to set a new motor state
 		newMotorState := switchmachine.MotorStateIdle
 		if curState.Position() != requestState.Position() {
@@ -73,20 +236,143 @@ func (this *tortoiseControllerImpl) UpdateSwitchMachine(requestState switchmachi
 		}
 
 		newState := switchmachine.NewState(requestState.Id(), curState.Position(), newMotorState, requestState.GPIO0State(), requestState.GPIO1State())
-		log.Println("newState:", switchmachine.StateToString(newState))
-		this.driver.UpdateSwitchMachine(newState)
-		//If we just told it to change position then we need to stop it at some point
-		if curState.MotorState() != newState.MotorState() {
-			this.createStopMotorCallback(curState.Id())
-		}
-		if !areGPIOEqual(curState, newState) || curState.MotorState() != newState.MotorState() {
-			this.existingSMStates.UpdateSwitchMachine(newState)
-			this.sendSMEventToListener(event.NewSwitchMachineUpdatedEvent(newState))
-		}
+		err = this.writeState(ctx, curState, newState, requestState.Position())
 	}
 	return err
 }
 
+func (this *tortoiseControllerImpl) WouldUpdateSwitchMachine(requestState switchmachine.State) (bool, error) {
+	if this.hasShutdownStarted() {
+		return false, ErrControllerShuttingDown
+	}
+	curState := this.existingSMStates.GetSwitchMachineById(requestState.Id())
+	if curState == nil {
+		return false, newSwitchMachineNotExistError(requestState.Id())
+	}
+	if !isVersionMatched(curState, requestState) {
+		return false, newVersionMismatchError(curState)
+	}
+	return isUpdateNeeded(curState, requestState), nil
+}
+
+func (this *tortoiseControllerImpl) Throw(ctx context.Context, id switchmachine.Id, position switchmachine.Position) error {
+	if this.hasShutdownStarted() {
+		return ErrControllerShuttingDown
+	}
+	defer this.lockId(id)()
+	curState := this.existingSMStates.GetSwitchMachineById(id)
+	if curState == nil {
+		return newSwitchMachineNotExistError(id)
+	}
+	return this.throw(ctx, curState, position)
+}
+
+//throw expects the lock for curState's id to already be held
+func (this *tortoiseControllerImpl) throw(ctx context.Context, curState switchmachine.State, position switchmachine.Position) error {
+	id := curState.Id()
+	var motorState switchmachine.MotorState
+	switch position {
+	case switchmachine.Position0:
+		motorState = switchmachine.MotorStateToPos0
+	case switchmachine.Position1:
+		motorState = switchmachine.MotorStateToPos1
+	default:
+		return fmt.Errorf(invalidThrowPositionErrorMessage, id)
+	}
+	newState := switchmachine.NewState(id, curState.Position(), motorState, curState.GPIO0State(), curState.GPIO1State())
+	return this.writeState(ctx, curState, newState, position)
+}
+
+func (this *tortoiseControllerImpl) Toggle(ctx context.Context, id switchmachine.Id, version uint64) (switchmachine.Position, error) {
+	if this.hasShutdownStarted() {
+		return switchmachine.PositionUnknown, ErrControllerShuttingDown
+	}
+	defer this.lockId(id)()
+	curState := this.existingSMStates.GetSwitchMachineById(id)
+	if curState == nil {
+		return switchmachine.PositionUnknown, newSwitchMachineNotExistError(id)
+	}
+	if version != 0 && version != curState.Version() {
+		return switchmachine.PositionUnknown, newVersionMismatchError(curState)
+	}
+	var position switchmachine.Position
+	switch switchmachine.TargetPosition(curState) {
+	case switchmachine.Position0:
+		position = switchmachine.Position1
+	case switchmachine.Position1:
+		position = switchmachine.Position0
+	default:
+		return switchmachine.PositionUnknown, fmt.Errorf(positionUnknownErrorMessage, ErrSwitchMachinePositionUnknown, id)
+	}
+	//Throw as updating to the position it is at while it moves away from it only stops the motor
+	return position, this.throw(ctx, curState, position)
+}
+
+func (this *tortoiseControllerImpl) Idle(ctx context.Context, id switchmachine.Id) error {
+	if this.hasShutdownStarted() {
+		return ErrControllerShuttingDown
+	}
+	defer this.lockId(id)()
+	curState := this.existingSMStates.GetSwitchMachineById(id)
+	if curState == nil {
+		return newSwitchMachineNotExistError(id)
+	}
+	if curState.MotorState() == switchmachine.MotorStateIdle {
+		return nil
+	}
+	idleState := switchmachine.NewState(id, curState.Position(), switchmachine.MotorStateIdle, curState.GPIO0State(), curState.GPIO1State())
+	err := this.driver.UpdateSwitchMachine(ctx, idleState)
+	if err != nil {
+		return err
+	}
+	this.storeAndSendUpdate(idleState)
+	return nil
+}
+
+//writeState hands newState to the driver and, once it has taken it, keeps track of any throw that was started
+//towards target
+func (this *tortoiseControllerImpl) writeState(ctx context.Context, curState, newState switchmachine.State, target switchmachine.Position) error {
+	isThrow := switchmachine.IsMotorRunning(newState.MotorState()) && curState.MotorState() != newState.MotorState()
+	err := this.driver.UpdateSwitchMachine(ctx, newState)
+	if err != nil {
+		if isThrow {
+			this.throws.throwNotStarted(newState.Id())
+		}
+		//The driver never took the update so nothing has changed
+		return err
+	}
+	if isThrow {
+		this.throws.throwStarted(newState.Id(), target)
+	}
+	//If we just told it to change position then we need to stop it at some point
+	if curState.MotorState() != newState.MotorState() {
+		this.createStopMotorCallback(curState.Id())
+	}
+	if !areGPIOEqual(curState, newState) || curState.MotorState() != newState.MotorState() {
+		this.storeAndSendUpdate(newState)
+	}
+	return nil
+}
+
+//storeAndSendUpdate sends the state as it was stored so the event has its version. Nothing is sent for a switch
+//machine that was removed in the meantime. The lock for its id is expected to be held and the event is sent once it
+//is released
+func (this *tortoiseControllerImpl) storeAndSendUpdate(newState switchmachine.State) {
+	storedState, err := this.existingSMStates.UpdateSwitchMachine(newState)
+	if err == nil {
+		this.queueEvent(storedState.Id(), event.NewSwitchMachineUpdatedEvent(storedState))
+	}
+}
+
+//isVersionMatched is true for requests that don't care about the version
+func isVersionMatched(curState, requestState switchmachine.State) bool {
+	return requestState.Version() == 0 || requestState.Version() == curState.Version()
+}
+
+func isUpdateNeeded(curState, requestState switchmachine.State) bool {
+	return !areUpdateableFieldsEqual(curState, requestState) || isMotorRunningToOppositePosition(requestState, curState)
+}
+
 //Trying to cover the case were we are where we want but we are moving away from it
 func isMotorRunningToOppositePosition(newS, curS switchmachine.State) bool {
 	return newS.Position() == switchmachine.Position0 && curS.MotorState() == switchmachine.MotorStateToPos1 ||
@@ -108,78 +394,206 @@ func (this *tortoiseControllerImpl) GetSwitchMachineById(id switchmachine.Id) (s
 }
 
 func (this *tortoiseControllerImpl) createStopMotorCallback(id switchmachine.Id) {
-	go this.stopMotorCallbackFunc(id, defaultMotorRunTime)
+	go this.stopMotorCallbackFunc(id, time.Duration(atomic.LoadInt64(&this.motorRunTime)))
+}
+
+//SetMotorRunTime only affects throws started after it is called. 0 or less is ignored
+func (this *tortoiseControllerImpl) SetMotorRunTime(motorRunTime time.Duration) {
+	if motorRunTime > 0 {
+		atomic.StoreInt64(&this.motorRunTime, int64(motorRunTime))
+	}
+}
+
+func (this *tortoiseControllerImpl) MotorRunTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.motorRunTime))
 }
 
 func (this *tortoiseControllerImpl) stopMotorCallbackFunc(id switchmachine.Id, delay time.Duration) {
 	if delay > 0 {
 		time.Sleep(delay)
 	}
+	this.throws.motorStopped(id, delay)
+	defer this.lockId(id)()
 	stateBeforeMotorStop := this.existingSMStates.GetSwitchMachineById(id)
-	stoppedMotorState := switchmachine.NewState(id,
-		stateBeforeMotorStop.Position(),
-		switchmachine.MotorStateIdle,
-		stateBeforeMotorStop.GPIO0State(),
-		stateBeforeMotorStop.GPIO1State())
-	if this.existingSMStates.HasSwitchMachine(id) {
-		this.driver.UpdateSwitchMachine(stoppedMotorState)
-		this.existingSMStates.UpdateSwitchMachine(stoppedMotorState)
-		this.sendSMEventToListener(event.NewSwitchMachineUpdatedEvent(stoppedMotorState))
+	//The machine may have been removed or idled while its motor was running
+	if stateBeforeMotorStop != nil && stateBeforeMotorStop.MotorState() != switchmachine.MotorStateIdle {
+		stoppedMotorState := switchmachine.NewState(id,
+			stateBeforeMotorStop.Position(),
+			switchmachine.MotorStateIdle,
+			stateBeforeMotorStop.GPIO0State(),
+			stateBeforeMotorStop.GPIO1State())
+		if err := this.updateDriverWithTimeout(stoppedMotorState); err != nil {
+			logger.Error("Unable to stop motor", "id", id, "err", err)
+			return
+		}
+		this.storeAndSendUpdate(stoppedMotorState)
 	}
 }
 
 func (this *tortoiseControllerImpl) SetSwitchMachineEventListenerFunc(smEventListenFunc func(event.SwitchMachineEvent)) {
+	this.listenerMutex.Lock()
 	this.smEventListenerFunc = smEventListenFunc
+	this.listenerMutex.Unlock()
 }
 
 func (this *tortoiseControllerImpl) HandleDriverEvent(dE hardware.DriverEvent) {
+	if dE.Type() != hardware.DriverFault {
+		//Keeps what the driver reports from being stored in the middle of an update
+		defer this.lockId(dE.Id())()
+	}
 	var err error
 	var e event.SwitchMachineEvent
 	if dE.Type() == hardware.SwitchMachineAdded {
-		err = this.existingSMStates.AddSwitchMachine(dE.State())
+		var storedState switchmachine.State
+		storedState, err = this.existingSMStates.AddSwitchMachine(this.withRestoredGPIO(dE.State()))
 		if err == nil {
-			e = event.NewSwitchMachineAddedEvent(dE.State())
+			attachedMachines.Set(float64(len(this.existingSMStates.GetAll())))
+			e = event.NewSwitchMachineAddedEvent(storedState)
 		}
 	} else if dE.Type() == hardware.SwitchMachinePositionChanged {
 		//Need to pull GPIO data as the driver event doesn't contain accurate data
+		this.throws.positionChanged(dE.Id(), dE.State().Position())
 		prevState := this.existingSMStates.GetSwitchMachineById(dE.Id())
 		if prevState != nil {
+			this.checkForReversedWiring(prevState, dE.State().Position())
 			newState := switchmachine.NewState(prevState.Id(), dE.State().Position(), prevState.MotorState(), prevState.GPIO0State(), prevState.GPIO1State())
-			err = this.existingSMStates.UpdateSwitchMachine(newState)
+			var storedState switchmachine.State
+			storedState, err = this.existingSMStates.UpdateSwitchMachine(newState)
 			if err == nil {
-				e = event.NewSwitchMachineUpdatedEvent(newState)
+				e = event.NewSwitchMachineUpdatedEvent(storedState)
 			}
 		}
 
+	} else if dE.Type() == hardware.DriverFault {
+		//Nothing for the switch machines to do as the driver has already tried to recover
+		logger.Warn("Driver reported fault", "err", dE.Fault())
 	} else if dE.Type() == hardware.SwitchMachineRemoved {
 		var lastState switchmachine.State
 		lastState, err = this.existingSMStates.RemoveSwitchMachine(dE.Id())
 		if err == nil {
-			log.Println("Reseting output for switchmachine with id:", dE.Id())
-			this.driver.UpdateSwitchMachine(switchmachine.NewState(dE.Id(), lastState.Position(), switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+			this.throws.removed(dE.Id())
+			attachedMachines.Set(float64(len(this.existingSMStates.GetAll())))
+			logger.Info("Resetting output for removed switch machine", "id", dE.Id())
+			resetErr := this.updateDriverWithTimeout(switchmachine.NewState(dE.Id(), lastState.Position(), switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+			if resetErr != nil {
+				logger.Error("Unable to reset output for removed switch machine", "id", dE.Id(), "err", resetErr)
+			}
 			e = event.NewSwitchMachineRemovedEvent(lastState)
 		}
 	}
 
 	if err != nil {
-		panic(err)
+		//Driver events are handled in order so this is the driver reporting something that doesn't add up, which
+		//isn't worth stopping the server over
+		logger.Error("Unable to apply driver event", "type", dE.Type(), "id", dE.Id(), "err", err)
+		return
+	}
+
+	if e != nil {
+		//Sent once the lock is released
+		this.queueEvent(dE.Id(), e)
+	}
+}
+
+func (this *tortoiseControllerImpl) hasShutdownStarted() bool {
+	this.shutdownMutex.RLock()
+	defer this.shutdownMutex.RUnlock()
+	return this.isShuttingDown
+}
+
+//Shutdown waits up to options.ThrowWait for running throws to finish and brakes any that haven't, then sets the safe
+//gpio state if there is one. The first error is returned but every switch machine is still attempted
+func (this *tortoiseControllerImpl) Shutdown(ctx context.Context, options ShutdownOptions) error {
+	this.shutdownMutex.Lock()
+	this.isShuttingDown = true
+	this.shutdownMutex.Unlock()
+
+	throwDeadline := time.Now().Add(options.ThrowWait)
+	for this.hasRunningMotor() && time.Now().Before(throwDeadline) {
+		select {
+		case <-ctx.Done():
+			throwDeadline = time.Now()
+		case <-time.After(shutdownPollInterval):
+		}
+	}
+
+	var firstErr error
+	for _, curState := range this.existingSMStates.GetAll() {
+		err := this.park(ctx, curState.Id(), options)
+		if err != nil {
+			logger.Error("Unable to park switch machine", "id", curState.Id(), "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+//park brakes the switch machine if its motor is still running and sets the safe gpio state
+func (this *tortoiseControllerImpl) park(ctx context.Context, id switchmachine.Id, options ShutdownOptions) error {
+	defer this.lockId(id)()
+	curState := this.existingSMStates.GetSwitchMachineById(id)
+	if curState == nil {
+		//Removed since shutdown started
+		return nil
+	}
+	motorState := curState.MotorState()
+	if switchmachine.IsMotorRunning(motorState) {
+		logger.Warn("Braking switch machine as its throw did not finish before shutdown", "id", id)
+		motorState = switchmachine.MotorStateBrake
+	}
+	gpio0, gpio1 := curState.GPIO0State(), curState.GPIO1State()
+	if options.SafeGPIO != nil {
+		gpio0, gpio1 = options.SafeGPIO.GPIO0, options.SafeGPIO.GPIO1
+	}
+	if motorState == curState.MotorState() && gpio0 == curState.GPIO0State() && gpio1 == curState.GPIO1State() {
+		return nil
+	}
+	parkedState := switchmachine.NewState(id, curState.Position(), motorState, gpio0, gpio1)
+	err := this.driver.UpdateSwitchMachine(ctx, parkedState)
+	if err != nil {
+		return err
 	}
+	this.storeAndSendUpdate(parkedState)
+	return nil
+}
 
-	this.sendSMEventToListener(e)
+func (this *tortoiseControllerImpl) hasRunningMotor() bool {
+	for _, curState := range this.existingSMStates.GetAll() {
+		if switchmachine.IsMotorRunning(curState.MotorState()) {
+			return true
+		}
+	}
+	return false
+}
+
+//updateDriverWithTimeout is for updates the controller makes itself so there is no caller to take a context from
+func (this *tortoiseControllerImpl) updateDriverWithTimeout(newState switchmachine.State) error {
+	ctx, cancel := context.WithTimeout(context.Background(), driverUpdateTimeout)
+	defer cancel()
+	return this.driver.UpdateSwitchMachine(ctx, newState)
 }
 
 func (this *tortoiseControllerImpl) sendSMEventToListener(sme event.SwitchMachineEvent) {
-	if this.smEventListenerFunc != nil {
-		this.smEventListenerFunc(sme)
+	this.listenerMutex.RLock()
+	listenerFunc := this.smEventListenerFunc
+	this.listenerMutex.RUnlock()
+	if sme != nil && listenerFunc != nil {
+		listenerFunc(sme)
 	}
 }
 
 func newSwitchMachineNotExistError(id switchmachine.Id) error {
-	return errors.New(fmt.Sprintf(switchMachineNotExistErrorMessage, id))
+	return fmt.Errorf(switchMachineNotExistErrorMessage, ErrSwitchMachineNotExist, id)
+}
+
+func newVersionMismatchError(curState switchmachine.State) error {
+	return fmt.Errorf(versionMismatchErrorMessage, ErrSwitchMachineVersionMismatch, curState.Id(), curState.Version())
 }
 
 func IsSwitchMachineNotExistError(err error) bool {
-	return err.Error() == switchMachineNotExistErrorMessage
+	return errors.Is(err, ErrSwitchMachineNotExist)
 }
 
 func areUpdateableFieldsEqual(s0, s1 switchmachine.State) bool {
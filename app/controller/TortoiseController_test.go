@@ -1,9 +1,14 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/event"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
 )
 
@@ -11,7 +16,15 @@ func TestUpdateSwitchMachineReturnsErrorIfSwitchMachineIsUnknown(t *testing.T) {
 	c := newTortoiseController()
 	idUnderTest := switchmachine.Id(0)
 	sm := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
-	if c.UpdateSwitchMachine(sm) == nil {
+	if err := c.UpdateSwitchMachine(context.Background(), sm); !IsSwitchMachineNotExistError(err) {
+		t.Fail()
+	}
+}
+
+func TestGetSwitchMachineByIdReturnsErrSwitchMachineNotExistIfSwitchMachineIsUnknown(t *testing.T) {
+	c := newTortoiseController()
+
+	if _, err := c.GetSwitchMachineById(4); !errors.Is(err, ErrSwitchMachineNotExist) {
 		t.Fail()
 	}
 }
@@ -23,7 +36,22 @@ func TestUpdateSwitchMachineDoesNotReturnErrorIfSwitchMachineAttached(t *testing
 	sm := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
 	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(idUnderTest, sm))
 
-	if c.UpdateSwitchMachine(sm) != nil {
+	if c.UpdateSwitchMachine(context.Background(), sm) != nil {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineReturnsDriverErrorAndKeepsExistingState(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{updateErr: hardware.ErrDriverClosed}
+	idUnderTest := switchmachine.Id(1)
+	sm := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(idUnderTest, sm))
+
+	err := c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	stored, _ := c.GetSwitchMachineById(idUnderTest)
+	if err != hardware.ErrDriverClosed || stored.GPIO0State() != switchmachine.GPIOOFF {
 		t.Fail()
 	}
 }
@@ -40,7 +68,7 @@ func TestUpdateSwitchMachineDoesNotCallDriverUpdateSwitchMachineIfSwitchMachineD
 	c.driver = driver
 
 	sm := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
-	c.UpdateSwitchMachine(sm)
+	c.UpdateSwitchMachine(context.Background(), sm)
 
 	if wasDriverUpdateCalled {
 		t.Fail()
@@ -64,8 +92,8 @@ func TestUpdateSwitchMachineDoesNotCallDriverIfNewStateHasPositionAndGPIOEqualTo
 	smOrig := switchmachine.NewState(idUnderTest, positionUnderTest, switchmachine.MotorStateIdle, gpio0, gpio1)
 
 	smNext := switchmachine.NewState(idUnderTest, positionUnderTest, switchmachine.MotorStateIdle, gpio0, gpio1)
-	c.UpdateSwitchMachine(smOrig)
-	c.UpdateSwitchMachine(smNext)
+	c.UpdateSwitchMachine(context.Background(), smOrig)
+	c.UpdateSwitchMachine(context.Background(), smNext)
 
 	if wasDriverUpdateCalled {
 		t.Fail()
@@ -91,7 +119,7 @@ func TestUpdateSwitchMachineDoesNotCallDriverIfNewStateHasPositionAndGPIOEqualTo
 	//Here we are changing
 	smNext := switchmachine.NewState(idUnderTest, positionUnderTest, switchmachine.MotorStateBrake, gpio0, gpio1)
 	//c.SwitchMachineAdded(smOrig)
-	c.UpdateSwitchMachine(smNext)
+	c.UpdateSwitchMachine(context.Background(), smNext)
 
 	if wasDriverUpdateCalled {
 		t.Fail()
@@ -166,7 +194,7 @@ func TestUpdateSwitchMachineCallsDriverIfNewStateHasPositionAndGPIOEqualToExisti
 	smOrig := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateToPos1, gpio0, gpio1)
 	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(smOrig.Id(), smOrig))
 	smNext := switchmachine.NewState(idUnderTest, switchmachine.Position0, switchmachine.MotorStateToPos0, gpio0, gpio1)
-	c.UpdateSwitchMachine(smNext)
+	c.UpdateSwitchMachine(context.Background(), smNext)
 
 	if !wasDriverUpdateCalled {
 		t.Fail()
@@ -191,15 +219,200 @@ func TestThatCallbackSetsMotorToIdleWhilePreservingCurrentState(t *testing.T) {
 	}
 }
 
+func TestThatBoardDroppingOffChainRemovesItsSwitchMachines(t *testing.T) {
+	trigger := make(chan time.Time)
+	driver := tortoise.NewMockTortoiseControllerDriverWithExternalRXTrigger(trigger)
+	//Port 0 of board 0 in position 0
+	driver.SetRXData([]byte{0x20})
+	c := NewTortoiseController(driver)
+	smEvents := make(chan event.SwitchMachineEvent, 4)
+	c.SetSwitchMachineEventListenerFunc(func(e event.SwitchMachineEvent) {
+		smEvents <- e
+	})
+	trigger <- time.Now()
+	if e := <-smEvents; e.Type() != event.SwitchMachineAdded {
+		t.FailNow()
+	}
+
+	board := uint(0)
+	driver.ScheduleFault(tortoise.Fault{Kind: tortoise.FaultKindBoardDropOff, Board: &board})
+	trigger <- time.Now()
+
+	if e := <-smEvents; e.Type() != event.SwitchMachineRemoved || e.State().Id() != 0 {
+		t.FailNow()
+	}
+	if _, err := c.GetSwitchMachineById(0); err == nil {
+		t.Fail()
+	}
+}
+
+//---------------------------------- Shutdown ----------------------------------
+
+func TestShutdownBrakesMotorsStillRunningAfterThrowWait(t *testing.T) {
+	c := newTortoiseController()
+	var lastUpdate switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(s switchmachine.State) {
+		lastUpdate = s
+	}}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	err := c.Shutdown(context.Background(), ShutdownOptions{})
+
+	if err != nil || lastUpdate == nil || lastUpdate.MotorState() != switchmachine.MotorStateBrake || lastUpdate.GPIO0State() != switchmachine.GPIOOn {
+		t.Fail()
+	}
+}
+
+func TestShutdownWaitsForRunningThrowToFinish(t *testing.T) {
+	c := newTortoiseController()
+	updates := 0
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(s switchmachine.State) {
+		updates++
+	}}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	go c.stopMotorCallbackFunc(sm.Id(), shutdownPollInterval)
+
+	c.Shutdown(context.Background(), ShutdownOptions{ThrowWait: time.Second})
+
+	//Only the callback stopping the motor should have reached the driver
+	if curS, _ := c.GetSwitchMachineById(sm.Id()); updates != 1 || curS.MotorState() != switchmachine.MotorStateIdle {
+		t.Fail()
+	}
+}
+
+func TestListenerCanUpdateTheSwitchMachineItWasSentAnEventFor(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.SetSwitchMachineEventListenerFunc(func(e event.SwitchMachineEvent) {
+		if e.State().GPIO0State() == switchmachine.GPIOOn && e.State().GPIO1State() == switchmachine.GPIOOFF {
+			c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(sm.Id(), switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOn))
+		}
+	})
+
+	updateDone := make(chan error)
+	go func() {
+		updateDone <- c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(sm.Id(), switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	}()
+
+	select {
+	case err := <-updateDone:
+		if curS, _ := c.GetSwitchMachineById(sm.Id()); err != nil || curS.GPIO1State() != switchmachine.GPIOOn {
+			t.Errorf("Update returned %v leaving %s", err, switchmachine.StateToString(curS))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Update is still waiting on the listener")
+	}
+}
+
+func TestShutdownSetsSafeGPIOState(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	c.Shutdown(context.Background(), ShutdownOptions{SafeGPIO: &SafeGPIOState{GPIO0: switchmachine.GPIOOFF, GPIO1: switchmachine.GPIOOn}})
+
+	if curS, _ := c.GetSwitchMachineById(sm.Id()); curS.GPIO0State() != switchmachine.GPIOOFF || curS.GPIO1State() != switchmachine.GPIOOn {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineAfterShutdownReturnsError(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.Shutdown(context.Background(), ShutdownOptions{})
+
+	if c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(3, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)) != ErrControllerShuttingDown {
+		t.Fail()
+	}
+}
+
+func TestThrowRunsMotorEvenWhenAlreadyAtPosition(t *testing.T) {
+	c := newTortoiseController()
+	var written switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		written = sm
+	}}
+	sm := switchmachine.NewState(4, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	if c.Throw(context.Background(), sm.Id(), switchmachine.Position1) != nil {
+		t.FailNow()
+	}
+	if written == nil || written.MotorState() != switchmachine.MotorStateToPos1 || written.GPIO0State() != switchmachine.GPIOOn {
+		t.Fail()
+	}
+}
+
+func TestThrowToUnknownPositionReturnsError(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(5, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	if c.Throw(context.Background(), sm.Id(), switchmachine.PositionUnknown) == nil {
+		t.Fail()
+	}
+}
+
+func TestIdleStopsRunningMotorKeepingGPIO(t *testing.T) {
+	c := newTortoiseController()
+	var written switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		written = sm
+	}}
+	sm := switchmachine.NewState(4, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.Throw(context.Background(), sm.Id(), switchmachine.Position1)
+
+	err := c.Idle(context.Background(), sm.Id())
+	curState, _ := c.GetSwitchMachineById(sm.Id())
+
+	if err != nil || written.MotorState() != switchmachine.MotorStateIdle || written.GPIO0State() != switchmachine.GPIOOn ||
+		curState.MotorState() != switchmachine.MotorStateIdle || curState.Position() != switchmachine.Position0 {
+		t.Fail()
+	}
+}
+
+func TestIdleDoesNotCallDriverWhenAlreadyIdle(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(4, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	if c.Idle(context.Background(), sm.Id()) != nil || driverCalled {
+		t.Fail()
+	}
+}
+
+func TestIdleReturnsErrSwitchMachineNotExistIfSwitchMachineIsUnknown(t *testing.T) {
+	c := newTortoiseController()
+
+	if !errors.Is(c.Idle(context.Background(), 4), ErrSwitchMachineNotExist) {
+		t.Fail()
+	}
+}
+
 type mockHardwareDriver struct {
 	updateSwitchMachineFunc func(switchmachine.State)
+	updateErr               error
 	closeFunc               func() error
 }
 
-func (this *mockHardwareDriver) UpdateSwitchMachine(sm switchmachine.State) {
+func (this *mockHardwareDriver) UpdateSwitchMachine(ctx context.Context, sm switchmachine.State) error {
 	if this.updateSwitchMachineFunc != nil {
 		this.updateSwitchMachineFunc(sm)
 	}
+	return this.updateErr
 }
 
 func (this *mockHardwareDriver) Close() error {
@@ -212,3 +425,194 @@ func (this *mockHardwareDriver) Close() error {
 func (this *mockHardwareDriver) Start(hardware.DriverEventListener) {
 
 }
+
+func TestWouldUpdateSwitchMachineIsFalseForUnchangedStateAndDoesNotCallDriver(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	wouldUpdate, err := c.WouldUpdateSwitchMachine(sm)
+
+	if wouldUpdate || err != nil || driverCalled {
+		t.Fail()
+	}
+}
+
+func TestWouldUpdateSwitchMachineIsTrueForNewPositionAndDoesNotCallDriver(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	wouldUpdate, err := c.WouldUpdateSwitchMachine(switchmachine.NewState(6, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if !wouldUpdate || err != nil || driverCalled {
+		t.Fail()
+	}
+}
+
+func TestWouldUpdateSwitchMachineReturnsErrSwitchMachineNotExistIfSwitchMachineIsUnknown(t *testing.T) {
+	c := newTortoiseController()
+
+	_, err := c.WouldUpdateSwitchMachine(switchmachine.NewState(6, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	if !errors.Is(err, ErrSwitchMachineNotExist) {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineReturnsErrSwitchMachineVersionMismatchForOldVersion(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), sm))
+	requestState := switchmachine.WithVersion(switchmachine.NewState(6, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF), 1)
+
+	_, wouldErr := c.WouldUpdateSwitchMachine(requestState)
+	err := c.UpdateSwitchMachine(context.Background(), requestState)
+
+	if !errors.Is(wouldErr, ErrSwitchMachineVersionMismatch) || !errors.Is(err, ErrSwitchMachineVersionMismatch) || driverCalled {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineWithCurrentVersionIsMade(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	curState, _ := c.GetSwitchMachineById(sm.Id())
+
+	err := c.UpdateSwitchMachine(context.Background(), switchmachine.WithVersion(switchmachine.NewState(6, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF), curState.Version()))
+
+	if err != nil || curState.Version() == 0 || !driverCalled {
+		t.Fail()
+	}
+}
+
+func TestConcurrentUpdatesAgainstTheSameVersionOnlyMakeOne(t *testing.T) {
+	c := newTortoiseController()
+	driverEntered := make(chan bool, 2)
+	releaseDriver := make(chan bool)
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverEntered <- true
+		<-releaseDriver
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	curState, _ := c.GetSwitchMachineById(sm.Id())
+	requestState := switchmachine.WithVersion(switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF), curState.Version())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- c.UpdateSwitchMachine(context.Background(), requestState)
+		}()
+	}
+
+	<-driverEntered
+	select {
+	case <-driverEntered:
+		t.Error("Expected the second update to wait for the first to be stored")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(releaseDriver)
+	err0, err1 := <-errs, <-errs
+
+	if (err0 == nil) == (err1 == nil) || !(errors.Is(err0, ErrSwitchMachineVersionMismatch) || errors.Is(err1, ErrSwitchMachineVersionMismatch)) {
+		t.Errorf("Expected exactly one update to be made but got %v and %v", err0, err1)
+	}
+}
+
+func TestToggleReturnsErrSwitchMachineVersionMismatchForOldVersion(t *testing.T) {
+	c := newTortoiseController()
+	driverCalled := false
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		driverCalled = true
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), sm))
+	curState, _ := c.GetSwitchMachineById(sm.Id())
+
+	_, err := c.Toggle(context.Background(), sm.Id(), curState.Version()-1)
+
+	if !errors.Is(err, ErrSwitchMachineVersionMismatch) || driverCalled {
+		t.Fail()
+	}
+}
+
+func TestEventsCarryStoredVersion(t *testing.T) {
+	c := newTortoiseController()
+	versions := make([]uint64, 0)
+	c.SetSwitchMachineEventListenerFunc(func(e event.SwitchMachineEvent) {
+		versions = append(versions, e.State().Version())
+	})
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+	c.HandleDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(sm.Id(), switchmachine.NewState(6, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)))
+
+	if len(versions) != 2 || versions[0] != 1 || versions[1] != 2 {
+		t.Errorf("Expected versions 1 and 2 but got %v", versions)
+	}
+}
+
+func TestToggleThrowsToOppositePosition(t *testing.T) {
+	c := newTortoiseController()
+	var sentState switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		sentState = sm
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	position, err := c.Toggle(context.Background(), sm.Id(), 0)
+
+	if err != nil || position != switchmachine.Position1 || sentState == nil || sentState.MotorState() != switchmachine.MotorStateToPos1 ||
+		sentState.GPIO0State() != switchmachine.GPIOOn || sentState.GPIO1State() != switchmachine.GPIOOFF {
+		t.Fail()
+	}
+}
+
+func TestToggleWhileMovingThrowsBackToPositionBeingLeft(t *testing.T) {
+	c := newTortoiseController()
+	var sentState switchmachine.State
+	c.driver = &mockHardwareDriver{updateSwitchMachineFunc: func(sm switchmachine.State) {
+		sentState = sm
+	}}
+	sm := switchmachine.NewState(6, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	position, err := c.Toggle(context.Background(), sm.Id(), 0)
+
+	if err != nil || position != switchmachine.Position0 || sentState == nil || sentState.MotorState() != switchmachine.MotorStateToPos0 {
+		t.Fail()
+	}
+}
+
+func TestToggleReturnsErrSwitchMachinePositionUnknownIfPositionIsUnknown(t *testing.T) {
+	c := newTortoiseController()
+	c.driver = &mockHardwareDriver{}
+	sm := switchmachine.NewState(6, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+	c.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(sm.Id(), sm))
+
+	_, err := c.Toggle(context.Background(), sm.Id(), 0)
+
+	if !errors.Is(err, ErrSwitchMachinePositionUnknown) {
+		t.Fail()
+	}
+}
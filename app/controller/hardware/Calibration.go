@@ -0,0 +1,68 @@
+package hardware
+
+import (
+	"errors"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//ErrNotCalibratable is returned when calibrating a switch machine on a driver that can't correct its wiring
+var ErrNotCalibratable = errors.New("Driver is not able to calibrate switch machines")
+
+//Calibration corrects for a switch machine that is wired the wrong way around so that it doesn't have to be rewired.
+//The zero value is a machine wired the normal way
+type Calibration struct {
+	//Motor leads are swapped so driving towards position 0 moves the machine to position 1
+	SwapMotor bool
+	//Feedback leads are swapped so position 0 reads as position 1
+	SwapFeedback bool
+}
+
+//IsDefault is whether the calibration leaves the machine as it is wired
+func (this Calibration) IsDefault() bool {
+	return this == Calibration{}
+}
+
+//Motor gives the motor state to drive so the machine moves the way m asks
+func (this Calibration) Motor(m switchmachine.MotorState) switchmachine.MotorState {
+	if this.SwapMotor {
+		return oppositeMotorState(m)
+	}
+	return m
+}
+
+//Position gives where the machine really is when its feedback reads as pos
+func (this Calibration) Position(pos switchmachine.Position) switchmachine.Position {
+	if this.SwapFeedback {
+		return OppositePosition(pos)
+	}
+	return pos
+}
+
+//OppositePosition swaps position 0 and position 1. Anything else is left alone
+func OppositePosition(pos switchmachine.Position) switchmachine.Position {
+	switch pos {
+	case switchmachine.Position0:
+		return switchmachine.Position1
+	case switchmachine.Position1:
+		return switchmachine.Position0
+	}
+	return pos
+}
+
+func oppositeMotorState(m switchmachine.MotorState) switchmachine.MotorState {
+	switch m {
+	case switchmachine.MotorStateToPos0:
+		return switchmachine.MotorStateToPos1
+	case switchmachine.MotorStateToPos1:
+		return switchmachine.MotorStateToPos0
+	}
+	return m
+}
+
+//Calibratable is optionally implemented by a Driver that can correct switch machines wired the wrong way around
+type Calibratable interface {
+	//SetCalibration can be called before or after Start. A machine whose corrected position changes because of it has
+	//its position reported again
+	SetCalibration(id switchmachine.Id, calibration Calibration) error
+}
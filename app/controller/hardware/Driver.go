@@ -1,14 +1,21 @@
 package hardware
 
 import (
+	"context"
+	"errors"
 	"io"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
 )
 
+//ErrDriverClosed is returned when updating a driver that has not been started or has been closed
+var ErrDriverClosed = errors.New("Driver is closed")
+
 type Driver interface {
 	//Start checking for updates
 	Start(DriverEventListener)
-	UpdateSwitchMachine(switchmachine.State)
+	//UpdateSwitchMachine hands the new state to the driver to be written. It should not wait on the hardware, only
+	//on ctx if the driver is too busy to take the update
+	UpdateSwitchMachine(context.Context, switchmachine.State) error
 	io.Closer
 }
@@ -8,6 +8,8 @@ const (
 	SwitchMachineAdded           = 0
 	SwitchMachineRemoved         = 1
 	SwitchMachinePositionChanged = 2
+	//DriverFault is when the driver has found a problem with the hardware that it could not recover from itself
+	DriverFault = 3
 )
 
 type DriverEvent interface {
@@ -15,12 +17,15 @@ type DriverEvent interface {
 	Id() switchmachine.Id
 	//Could be nil
 	State() switchmachine.State
+	//Only set for DriverFault events
+	Fault() error
 }
 
 type driverEvent struct {
 	eventType DriverEventType
 	id        switchmachine.Id
 	state     switchmachine.State
+	fault     error
 }
 
 func (this *driverEvent) Type() DriverEventType {
@@ -35,6 +40,10 @@ func (this *driverEvent) State() switchmachine.State {
 	return this.state
 }
 
+func (this *driverEvent) Fault() error {
+	return this.fault
+}
+
 func NewSwitchMachineAddedEvent(id switchmachine.Id, state switchmachine.State) DriverEvent {
 	return &driverEvent{eventType: SwitchMachineAdded, id: id, state: state}
 }
@@ -47,6 +56,10 @@ func NewSwitchMachinePositionChangedEvent(id switchmachine.Id, state switchmachi
 	return &driverEvent{eventType: SwitchMachinePositionChanged, id: id, state: state}
 }
 
+func NewDriverFaultEvent(fault error) DriverEvent {
+	return &driverEvent{eventType: DriverFault, fault: fault}
+}
+
 type DriverEventListener interface {
 	HandleDriverEvent(DriverEvent)
 }
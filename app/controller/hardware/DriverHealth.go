@@ -0,0 +1,25 @@
+package hardware
+
+import "time"
+
+//LoopHeartbeatInterval is the longest a driver with a processing loop goes without recording a heartbeat, even when
+//it has nothing to do. A heartbeat much older than this means the loop is stuck
+const LoopHeartbeatInterval time.Duration = time.Second
+
+//DriverHealth is a snapshot of how a driver is doing talking to its hardware
+type DriverHealth struct {
+	Healthy bool
+	//Whether the driver has been started and not closed, so its bus devices are open
+	Running bool
+	//Last time the drivers processing loop did any work
+	LastLoopTime time.Time
+	//Last time the driver successfully read from the bus
+	LastBusReadTime time.Time
+	//Most recent error talking to the hardware. nil if the last bus transaction succeeded
+	LastError error
+}
+
+//HealthReporter is optionally implemented by a Driver that is able to report on its own health
+type HealthReporter interface {
+	Health() DriverHealth
+}
@@ -0,0 +1,216 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+const (
+	overlappingMountsErrorMessage string = "Driver mount %s ids %d-%d overlap with driver mount %s ids %d-%d"
+	noMountsErrorMessage          string = "At least one driver mount is required"
+	invalidMountErrorMessage      string = "Driver mount %s requires a driver and at least one id"
+	noMountForIdErrorMessage      string = "%w %d"
+	notCalibratableErrorMessage   string = "Driver mount %s: %w"
+)
+
+//ErrNoMountForId is returned for ids that aren't within any driver mount
+var ErrNoMountForId = errors.New("No driver mounted for switch machine id")
+
+var logger = logging.ForSubsystem(logging.SubsystemHardware)
+
+//Mount places a driver into the global id space. Ids IdOffset through IdOffset+NumIds-1 are routed to Driver as
+//ids 0 through NumIds-1
+type Mount struct {
+	Name     string
+	IdOffset switchmachine.Id
+	NumIds   uint
+	Driver   hardware.Driver
+}
+
+//MountHealth is the health of one mounted driver
+type MountHealth struct {
+	Name     string
+	IdOffset switchmachine.Id
+	NumIds   uint
+	//Whether the mounted driver is able to report health at all
+	Reported bool
+	Health   hardware.DriverHealth
+}
+
+//CompositeDriver is a hardware.Driver made up of several drivers that each own a range of ids
+type CompositeDriver interface {
+	hardware.Driver
+	hardware.HealthReporter
+	hardware.Calibratable
+	MountHealth() []MountHealth
+	//Mounts are in the order they were given
+	Mounts() []Mount
+}
+
+type compositeDriverImpl struct {
+	mounts []Mount
+}
+
+func NewCompositeDriver(mounts ...Mount) (CompositeDriver, error) {
+	if len(mounts) == 0 {
+		return nil, errors.New(noMountsErrorMessage)
+	}
+	for i, curMount := range mounts {
+		if curMount.Driver == nil || curMount.NumIds == 0 {
+			return nil, fmt.Errorf(invalidMountErrorMessage, curMount.Name)
+		}
+		for _, otherMount := range mounts[:i] {
+			if mountsOverlap(curMount, otherMount) {
+				return nil, fmt.Errorf(overlappingMountsErrorMessage,
+					curMount.Name, curMount.IdOffset, lastIdOfMount(curMount),
+					otherMount.Name, otherMount.IdOffset, lastIdOfMount(otherMount))
+			}
+		}
+	}
+	driver := &compositeDriverImpl{}
+	driver.mounts = make([]Mount, len(mounts))
+	copy(driver.mounts, mounts)
+	return driver, nil
+}
+
+func mountsOverlap(m0, m1 Mount) bool {
+	return uint(m0.IdOffset) <= uint(lastIdOfMount(m1)) && uint(m1.IdOffset) <= uint(lastIdOfMount(m0))
+}
+
+func lastIdOfMount(m Mount) switchmachine.Id {
+	return switchmachine.Id(uint(m.IdOffset) + m.NumIds - 1)
+}
+
+func (this *compositeDriverImpl) Start(listener hardware.DriverEventListener) {
+	for _, curMount := range this.mounts {
+		curMount.Driver.Start(&mountEventListener{mount: curMount, listener: listener})
+	}
+}
+
+func (this *compositeDriverImpl) UpdateSwitchMachine(ctx context.Context, newState switchmachine.State) error {
+	mount, isMounted := this.findMountForId(newState.Id())
+	if !isMounted {
+		return fmt.Errorf(noMountForIdErrorMessage, ErrNoMountForId, newState.Id())
+	}
+	return mount.Driver.UpdateSwitchMachine(ctx, stateWithId(newState, newState.Id()-mount.IdOffset))
+}
+
+func (this *compositeDriverImpl) SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error {
+	mount, isMounted := this.findMountForId(id)
+	if !isMounted {
+		return fmt.Errorf(noMountForIdErrorMessage, ErrNoMountForId, id)
+	}
+	calibratable, isCalibratable := mount.Driver.(hardware.Calibratable)
+	if !isCalibratable {
+		return fmt.Errorf(notCalibratableErrorMessage, mount.Name, hardware.ErrNotCalibratable)
+	}
+	return calibratable.SetCalibration(id-mount.IdOffset, calibration)
+}
+
+func (this *compositeDriverImpl) Close() error {
+	var firstErr error
+	for _, curMount := range this.mounts {
+		err := curMount.Driver.Close()
+		if err != nil {
+			logger.Error("Error closing driver mount", "mount", curMount.Name, "err", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+//Health is only healthy if every mount that is able to report is healthy
+func (this *compositeDriverImpl) Health() hardware.DriverHealth {
+	overall := hardware.DriverHealth{Healthy: true, Running: true}
+	for _, curMountHealth := range this.MountHealth() {
+		if !curMountHealth.Reported {
+			continue
+		}
+		curHealth := curMountHealth.Health
+		overall.Healthy = overall.Healthy && curHealth.Healthy
+		overall.Running = overall.Running && curHealth.Running
+		if overall.LastError == nil {
+			overall.LastError = curHealth.LastError
+		}
+		if overall.LastLoopTime.IsZero() || curHealth.LastLoopTime.Before(overall.LastLoopTime) {
+			overall.LastLoopTime = curHealth.LastLoopTime
+		}
+		if overall.LastBusReadTime.IsZero() || curHealth.LastBusReadTime.Before(overall.LastBusReadTime) {
+			overall.LastBusReadTime = curHealth.LastBusReadTime
+		}
+	}
+	return overall
+}
+
+func (this *compositeDriverImpl) MountHealth() []MountHealth {
+	healths := make([]MountHealth, 0, len(this.mounts))
+	for _, curMount := range this.mounts {
+		mHealth := MountHealth{Name: curMount.Name, IdOffset: curMount.IdOffset, NumIds: curMount.NumIds}
+		if reporter, canReport := curMount.Driver.(hardware.HealthReporter); canReport {
+			mHealth.Reported = true
+			mHealth.Health = reporter.Health()
+		}
+		healths = append(healths, mHealth)
+	}
+	return healths
+}
+
+func (this *compositeDriverImpl) Mounts() []Mount {
+	mounts := make([]Mount, len(this.mounts))
+	copy(mounts, this.mounts)
+	return mounts
+}
+
+func (this *compositeDriverImpl) findMountForId(id switchmachine.Id) (Mount, bool) {
+	for _, curMount := range this.mounts {
+		if id >= curMount.IdOffset && id <= lastIdOfMount(curMount) {
+			return curMount, true
+		}
+	}
+	return Mount{}, false
+}
+
+//mountEventListener translates the local ids of a mounted driver back into the global id space
+type mountEventListener struct {
+	mount    Mount
+	listener hardware.DriverEventListener
+}
+
+func (this *mountEventListener) HandleDriverEvent(dE hardware.DriverEvent) {
+	if dE.Type() == hardware.DriverFault {
+		this.listener.HandleDriverEvent(hardware.NewDriverFaultEvent(fmt.Errorf("Driver mount %s: %w", this.mount.Name, dE.Fault())))
+		return
+	}
+	if uint(dE.Id()) >= this.mount.NumIds {
+		logger.Warn("Driver mount sent event for id outside of its range", "mount", this.mount.Name, "id", dE.Id())
+		return
+	}
+	globalId := dE.Id() + this.mount.IdOffset
+	var globalEvent hardware.DriverEvent
+	switch dE.Type() {
+	case hardware.SwitchMachineAdded:
+		globalEvent = hardware.NewSwitchMachineAddedEvent(globalId, stateWithId(dE.State(), globalId))
+	case hardware.SwitchMachineRemoved:
+		globalEvent = hardware.NewSwitchMachineRemovedEvent(globalId)
+	case hardware.SwitchMachinePositionChanged:
+		globalEvent = hardware.NewSwitchMachinePositionChangedEvent(globalId, stateWithId(dE.State(), globalId))
+	default:
+		logger.Warn("Driver mount sent unknown event type", "mount", this.mount.Name, "type", dE.Type())
+		return
+	}
+	this.listener.HandleDriverEvent(globalEvent)
+}
+
+func stateWithId(s switchmachine.State, id switchmachine.Id) switchmachine.State {
+	if s == nil {
+		return nil
+	}
+	return switchmachine.NewState(id, s.Position(), s.MotorState(), s.GPIO0State(), s.GPIO1State())
+}
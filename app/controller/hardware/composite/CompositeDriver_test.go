@@ -0,0 +1,244 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Compile should fail if compositeDriverImpl doesn't implement hardware.Driver
+func TestCompositeDriverImplementsDriver(t *testing.T) {
+	var _ hardware.Driver = &compositeDriverImpl{}
+}
+
+func TestNewCompositeDriverReturnsErrorWithNoMounts(t *testing.T) {
+	if _, err := NewCompositeDriver(); err == nil {
+		t.Fail()
+	}
+}
+
+func TestNewCompositeDriverReturnsErrorWhenMountsOverlap(t *testing.T) {
+	_, err := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}},
+		Mount{Name: "b", IdOffset: 31, NumIds: 32, Driver: &mockDriver{}})
+	if err == nil {
+		t.Fail()
+	}
+}
+
+func TestNewCompositeDriverReturnsNoErrorWhenMountsAreAdjacent(t *testing.T) {
+	_, err := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}},
+		Mount{Name: "b", IdOffset: 32, NumIds: 32, Driver: &mockDriver{}})
+	if err != nil {
+		t.Fail()
+	}
+}
+
+func TestNewCompositeDriverReturnsErrorWhenMountHasNoDriver(t *testing.T) {
+	if _, err := NewCompositeDriver(Mount{Name: "a", NumIds: 32}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineIsRoutedToOwningDriverWithLocalId(t *testing.T) {
+	var firstUpdated, secondUpdated switchmachine.State
+	first := &mockDriver{updateFunc: func(s switchmachine.State) { firstUpdated = s }}
+	second := &mockDriver{updateFunc: func(s switchmachine.State) { secondUpdated = s }}
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: first},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: second})
+
+	d.UpdateSwitchMachine(context.Background(), switchmachine.NewState(105, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if firstUpdated != nil || secondUpdated == nil {
+		t.FailNow()
+	}
+	if secondUpdated.Id() != 5 || secondUpdated.MotorState() != switchmachine.MotorStateToPos1 || secondUpdated.GPIO0State() != switchmachine.GPIOOn {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineForUnmountedIdDoesNotCallAnyDriver(t *testing.T) {
+	wasCalled := false
+	d, _ := NewCompositeDriver(Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{updateFunc: func(s switchmachine.State) { wasCalled = true }}})
+
+	d.UpdateSwitchMachine(context.Background(), switchmachine.NewState(50, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	if wasCalled {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineForUnmountedIdReturnsError(t *testing.T) {
+	d, _ := NewCompositeDriver(Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}})
+
+	err := d.UpdateSwitchMachine(context.Background(), switchmachine.NewState(50, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	if !errors.Is(err, ErrNoMountForId) {
+		t.Fail()
+	}
+}
+
+func TestDriverEventsAreTranslatedToGlobalIds(t *testing.T) {
+	second := &mockDriver{}
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: second})
+	var received hardware.DriverEvent
+	d.Start(&mockListener{handleFunc: func(e hardware.DriverEvent) { received = e }})
+
+	second.listener.HandleDriverEvent(hardware.NewSwitchMachineAddedEvent(3, switchmachine.NewState(3, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)))
+
+	if received == nil || received.Type() != hardware.SwitchMachineAdded || received.Id() != 103 || received.State().Id() != 103 {
+		t.Fail()
+	}
+}
+
+func TestRemovedDriverEventIsTranslatedToGlobalId(t *testing.T) {
+	second := &mockDriver{}
+	d, _ := NewCompositeDriver(Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: second})
+	var received hardware.DriverEvent
+	d.Start(&mockListener{handleFunc: func(e hardware.DriverEvent) { received = e }})
+
+	second.listener.HandleDriverEvent(hardware.NewSwitchMachineRemovedEvent(7))
+
+	if received == nil || received.Type() != hardware.SwitchMachineRemoved || received.Id() != 107 {
+		t.Fail()
+	}
+}
+
+func TestMountHealthIsReportedPerMount(t *testing.T) {
+	unhealthy := &mockHealthDriver{health: hardware.DriverHealth{Healthy: false, LastError: errors.New("bus error")}}
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: unhealthy})
+
+	healths := d.MountHealth()
+	if len(healths) != 2 || healths[0].Reported || !healths[1].Reported || healths[1].Health.Healthy {
+		t.Fail()
+	}
+}
+
+func TestMountsAreInTheOrderGiven(t *testing.T) {
+	d, _ := NewCompositeDriver(
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: &mockDriver{}},
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}})
+
+	mounts := d.Mounts()
+	if len(mounts) != 2 || mounts[0].Name != "b" || mounts[1].IdOffset != 0 {
+		t.Fail()
+	}
+}
+
+func TestHealthIsUnhealthyIfAnyMountIsUnhealthy(t *testing.T) {
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockHealthDriver{health: hardware.DriverHealth{Healthy: true}}},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: &mockHealthDriver{health: hardware.DriverHealth{Healthy: false}}})
+
+	if d.Health().Healthy {
+		t.Fail()
+	}
+}
+
+func TestCloseClosesEveryMountAndReturnsFirstError(t *testing.T) {
+	closeErr := errors.New("close failed")
+	secondClosed := false
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{closeFunc: func() error { return closeErr }}},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: &mockDriver{closeFunc: func() error { secondClosed = true; return nil }}})
+
+	if d.Close() != closeErr || !secondClosed {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationIsRoutedToOwningDriverWithLocalId(t *testing.T) {
+	second := &mockCalibratableDriver{}
+	d, _ := NewCompositeDriver(
+		Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockCalibratableDriver{}},
+		Mount{Name: "b", IdOffset: 100, NumIds: 32, Driver: second})
+
+	err := d.SetCalibration(105, hardware.Calibration{SwapMotor: true})
+
+	if err != nil || second.calibrated[5] != (hardware.Calibration{SwapMotor: true}) {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationOnDriverThatCantCalibrateReturnsErrNotCalibratable(t *testing.T) {
+	d, _ := NewCompositeDriver(Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockDriver{}})
+
+	if err := d.SetCalibration(5, hardware.Calibration{SwapMotor: true}); !errors.Is(err, hardware.ErrNotCalibratable) {
+		t.Fail()
+	}
+}
+
+func TestSetCalibrationForUnmountedIdReturnsError(t *testing.T) {
+	d, _ := NewCompositeDriver(Mount{Name: "a", IdOffset: 0, NumIds: 32, Driver: &mockCalibratableDriver{}})
+
+	if err := d.SetCalibration(50, hardware.Calibration{SwapMotor: true}); !errors.Is(err, ErrNoMountForId) {
+		t.Fail()
+	}
+}
+
+//---------------------------------- mocks ----------------------------------
+
+type mockDriver struct {
+	listener   hardware.DriverEventListener
+	updateFunc func(switchmachine.State)
+	closeFunc  func() error
+}
+
+func (this *mockDriver) Start(l hardware.DriverEventListener) {
+	this.listener = l
+}
+
+func (this *mockDriver) UpdateSwitchMachine(ctx context.Context, s switchmachine.State) error {
+	if this.updateFunc != nil {
+		this.updateFunc(s)
+	}
+	return nil
+}
+
+func (this *mockDriver) Close() error {
+	if this.closeFunc != nil {
+		return this.closeFunc()
+	}
+	return nil
+}
+
+type mockHealthDriver struct {
+	mockDriver
+	health hardware.DriverHealth
+}
+
+func (this *mockHealthDriver) Health() hardware.DriverHealth {
+	return this.health
+}
+
+type mockCalibratableDriver struct {
+	mockDriver
+	calibrated map[switchmachine.Id]hardware.Calibration
+}
+
+func (this *mockCalibratableDriver) SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error {
+	if this.calibrated == nil {
+		this.calibrated = make(map[switchmachine.Id]hardware.Calibration)
+	}
+	this.calibrated[id] = calibration
+	return nil
+}
+
+type mockListener struct {
+	handleFunc func(hardware.DriverEvent)
+}
+
+func (this *mockListener) HandleDriverEvent(e hardware.DriverEvent) {
+	if this.handleFunc != nil {
+		this.handleFunc(e)
+	}
+}
@@ -1,14 +1,20 @@
 package tortoise
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"io"
+	"sync"
 	"time"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
 )
 
+var logger = logging.ForSubsystem(logging.SubsystemHardware)
+
 type bitOrder bool
 
 const (
@@ -24,6 +30,15 @@ const (
 	numRxBytesPerBoard uint = numDriverPortsPerBoard / numRxPortsPerByte
 	//MaxNumberAttachableMainControllerBoards is the limit of boards that one driver can control from one computer. This number is arbitrailily decided
 	MaxNumberAttachableMainControllerBoards uint = 8
+	//SwitchMachinesPerBoard is how many switch machines one main controller board drives
+	SwitchMachinesPerBoard uint = numDriverPortsPerBoard
+	//MaxNumberSwitchMachines is the number of switch machine ids that one driver covers starting at id 0
+	MaxNumberSwitchMachines uint = MaxNumberAttachableMainControllerBoards * numDriverPortsPerBoard
+	//DefaultMaxQueuedUpdates is how many switch machines can be waiting to be written before UpdateSwitchMachine blocks
+	DefaultMaxQueuedUpdates int = int(MaxNumberSwitchMachines)
+	//defaultUpdateBatchWindow is how long the runLoop waits after an update is queued before writing, so updates sent
+	//one after the other, like the switch machines of a route, go out in the same write
+	defaultUpdateBatchWindow time.Duration = time.Millisecond * 5
 	//DefaultThrowTime is the default time that tortoise driver board will active the motor for to throw a turnout
 	DefaultThrowTime time.Duration = time.Second * 2
 
@@ -68,6 +83,8 @@ const (
 	position0Port03      byte = 0x02
 	position1Port03      byte = 0x01
 	positionDisconnected byte = 0x00
+
+	updateQueueFullErrorMessage string = "Timed out queueing update for switch machine %d: %w"
 )
 
 type baseTortoiseControllerDriver struct {
@@ -82,35 +99,274 @@ type baseTortoiseControllerDriver struct {
 	//Function that handles writing data to device while also reading data from it. Can return error if something goes wrong
 	txFunc func(w, r []byte) error
 	rxFunc func(w, r []byte) error
+	//Optional function called by the runLoop once everything from a bus read has been handled
+	rxHandledFunc func()
 	//Channel to alert processLoop to exit
 	processLoopExitChan chan bool
-	//Channel to take in new SwitchMachine States to be processed.
-	newSMStateChan chan switchmachine.State
+	//Updates waiting for the runLoop. Only the latest state for each id is kept so a burst of updates becomes one write
+	queueMutex       sync.Mutex
+	queuedStates     map[switchmachine.Id]switchmachine.State
+	queuedIds        []switchmachine.Id
+	maxQueuedUpdates int
+	isClosed         bool
+	//Signals the runLoop that there are queued updates
+	updatesQueuedChan chan bool
+	updateBatchWindow time.Duration
+	//Signals waiting callers that the runLoop has taken the queued updates
+	queueSpaceChan chan bool
+	//Closed when the driver is closed so waiting callers give up
+	closedChan chan bool
+	//Closed by the runLoop once it has returned so Close knows the last write is done
+	loopExitedChan chan bool
 	//Channel that triggers bus updates when value appears
 	rxTrigger <-chan time.Time
+	//Guards the health tracking fields below as they are read from outside of the runLoop
+	healthMutex     sync.RWMutex
+	lastLoopTime    time.Time
+	lastBusReadTime time.Time
+	lastBusErr      error
+	//Optional recorder of every buffer that goes across the bus
+	recorderMutex sync.Mutex
+	recorder      *BusRecorder
+	//When enabled the bytes shifted back out of the board chain during a write are checked against what was written
+	//before, as that is what the chain was holding
+	verifyTx        bool
+	txVerifyRetries int
+	chainTxBuffer   []byte
+	isChainKnown    bool
+	isTxVerifyFault bool
+	//When above 0 the whole tx buffer is rewritten this often so a board that reset comes back to the right outputs
+	txRefreshInterval time.Duration
+	txRefreshTicker   *time.Ticker
+	txRefreshTrigger  <-chan time.Time
+	//Boards that have had anything attached. A machine being added on one of these means the board probably reset
+	seenBoards        []bool
+	isTxRefreshNeeded bool
+	//Events waiting to be handed to driverEventListener in the order they happened
+	eventsMutex         sync.Mutex
+	pendingEvents       []hardware.DriverEvent
+	isDispatchingEvents bool
+	//Name of the mount this driver is on, used to label its bus metrics
+	busMetricsMount string
+	//Copies of the buffers for diagnostics
+	busSnapshot busSnapshot
+	//Wiring corrections for each id. The tx and rx copies are what the buffers were last worked out with and are only
+	//touched by the runLoop
+	calibrationMutex sync.Mutex
+	calibrations     [MaxNumberSwitchMachines]hardware.Calibration
+	txCalibrations   [MaxNumberSwitchMachines]hardware.Calibration
+	rxCalibrations   [MaxNumberSwitchMachines]hardware.Calibration
+}
+
+//TxRefreshable is implemented by drivers able to periodically rewrite all of their outputs
+type TxRefreshable interface {
+	//SetTxRefreshInterval must be called before Start. 0 or less turns off the periodic refresh
+	SetTxRefreshInterval(interval time.Duration)
+}
+
+//TxVerifiable is implemented by drivers able to check their writes against what the board chain shifts back
+type TxVerifiable interface {
+	//SetTxVerification must be called before Start
+	SetTxVerification(enabled bool, retries int)
+}
+
+//UpdateQueueable is implemented by drivers that queue updates to be written in batches
+type UpdateQueueable interface {
+	//SetMaxQueuedUpdates must be called before Start. 0 or less uses DefaultMaxQueuedUpdates
+	SetMaxQueuedUpdates(max int)
+}
+
+//BusRecordable is implemented by drivers able to record their bus traffic
+type BusRecordable interface {
+	StartBusRecording(io.Writer) error
+	StopBusRecording() error
+}
+
+//UpdateSwitchMachine queues newState to be written with everything else queued within the batch window. It only blocks
+//while the queue is full, returning ctx's error if that takes too long
+func (this *baseTortoiseControllerDriver) UpdateSwitchMachine(ctx context.Context, newState switchmachine.State) error {
+	logger.Debug("Queueing switch machine update", "id", newState.Id())
+	for {
+		this.queueMutex.Lock()
+		if this.isClosed || this.queuedStates == nil {
+			this.queueMutex.Unlock()
+			return hardware.ErrDriverClosed
+		}
+		_, isQueued := this.queuedStates[newState.Id()]
+		if isQueued || len(this.queuedIds) < this.maxQueuedUpdates {
+			if !isQueued {
+				this.queuedIds = append(this.queuedIds, newState.Id())
+			}
+			this.queuedStates[newState.Id()] = newState
+			this.queueMutex.Unlock()
+			signal(this.updatesQueuedChan)
+			return nil
+		}
+		this.queueMutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(updateQueueFullErrorMessage, newState.Id(), ctx.Err())
+		case <-this.closedChan:
+			return hardware.ErrDriverClosed
+		case <-this.queueSpaceChan:
+		}
+	}
+}
+
+func (this *baseTortoiseControllerDriver) SetMaxQueuedUpdates(max int) {
+	this.maxQueuedUpdates = max
+}
+
+//sendDriverEvent queues dE for driverEventListener. A switch machine can drop off and come back within a few reads so
+//events have to be handled in order, a removal handled after the add that followed it would lose the switch machine
+func (this *baseTortoiseControllerDriver) sendDriverEvent(dE hardware.DriverEvent) {
+	if this.driverEventListener == nil {
+		return
+	}
+	this.eventsMutex.Lock()
+	this.pendingEvents = append(this.pendingEvents, dE)
+	wasDispatching := this.isDispatchingEvents
+	this.isDispatchingEvents = true
+	this.eventsMutex.Unlock()
+	if !wasDispatching {
+		go this.dispatchDriverEvents()
+	}
 }
 
-func (this *baseTortoiseControllerDriver) UpdateSwitchMachine(newState switchmachine.State) {
-	log.Println("BaseTortoiseControllerDriver-UpdateSwitchMachine called")
-	this.newSMStateChan <- newState
+//dispatchDriverEvents hands the queued events to the listener one at a time until there are none left. It can't be
+//the runLoop that does this as the listener updates the driver, which waits on the runLoop
+func (this *baseTortoiseControllerDriver) dispatchDriverEvents() {
+	for {
+		this.eventsMutex.Lock()
+		if len(this.pendingEvents) == 0 {
+			this.isDispatchingEvents = false
+			this.eventsMutex.Unlock()
+			return
+		}
+		dE := this.pendingEvents[0]
+		this.pendingEvents = this.pendingEvents[1:]
+		this.eventsMutex.Unlock()
+		this.driverEventListener.HandleDriverEvent(dE)
+	}
+}
+
+//signal wakes up whoever is waiting on c without blocking if they have already been woken
+func signal(c chan bool) {
+	select {
+	case c <- true:
+	default:
+	}
 }
 
 func (this *baseTortoiseControllerDriver) Start(driverEventListener hardware.DriverEventListener) {
-	log.Println("Starting the driver")
+	logger.Info("Starting the driver")
 	this.driverEventListener = driverEventListener
 	this.initChans()
 	this.initBuffers()
+	if this.txRefreshInterval > 0 {
+		this.txRefreshTicker = time.NewTicker(this.txRefreshInterval)
+		this.txRefreshTrigger = this.txRefreshTicker.C
+	}
 	go this.runLoop()
 }
 
+//Close writes any queued updates before stopping. Updates after Close return hardware.ErrDriverClosed
 func (this *baseTortoiseControllerDriver) Close() error {
+	this.queueMutex.Lock()
+	wasClosed := this.isClosed
+	wasStarted := this.queuedStates != nil
+	this.isClosed = true
+	this.queueMutex.Unlock()
+	if wasClosed {
+		return nil
+	}
+	if !wasStarted {
+		//A recording can be started before the driver is
+		this.StopBusRecording()
+		return this.closeFunc()
+	}
+	close(this.closedChan)
 	this.processLoopExitChan <- false
+	//Connections can't be closed while the runLoop is still writing the queued updates
+	<-this.loopExitedChan
+	if this.txRefreshTicker != nil {
+		this.txRefreshTicker.Stop()
+	}
+	this.StopBusRecording()
 	return this.closeFunc()
 }
 
+//StartBusRecording records every tx and rx buffer to w until StopBusRecording. w is closed on stop if it is an io.Closer
+func (this *baseTortoiseControllerDriver) StartBusRecording(w io.Writer) error {
+	recorder, err := NewBusRecorder(w)
+	if err != nil {
+		return err
+	}
+	this.recorderMutex.Lock()
+	oldRecorder := this.recorder
+	this.recorder = recorder
+	this.recorderMutex.Unlock()
+	if oldRecorder != nil {
+		oldRecorder.Close()
+	}
+	return nil
+}
+
+func (this *baseTortoiseControllerDriver) StopBusRecording() error {
+	this.recorderMutex.Lock()
+	recorder := this.recorder
+	this.recorder = nil
+	this.recorderMutex.Unlock()
+	if recorder == nil {
+		return nil
+	}
+	return recorder.Close()
+}
+
+func (this *baseTortoiseControllerDriver) recordBusTraffic(kind BusRecordKind, data []byte) {
+	this.recorderMutex.Lock()
+	recorder := this.recorder
+	this.recorderMutex.Unlock()
+	if recorder != nil {
+		if err := recorder.Record(kind, data); err != nil {
+			logger.Error("Error recording bus traffic, stopping recording", "err", err)
+			this.StopBusRecording()
+		}
+	}
+}
+
+func (this *baseTortoiseControllerDriver) Health() hardware.DriverHealth {
+	this.queueMutex.Lock()
+	isRunning := this.queuedStates != nil && !this.isClosed
+	this.queueMutex.Unlock()
+	this.healthMutex.RLock()
+	defer this.healthMutex.RUnlock()
+	return hardware.DriverHealth{
+		Healthy:         this.lastBusErr == nil && !this.lastLoopTime.IsZero(),
+		Running:         isRunning,
+		LastLoopTime:    this.lastLoopTime,
+		LastBusReadTime: this.lastBusReadTime,
+		LastError:       this.lastBusErr,
+	}
+}
+
 func (this *baseTortoiseControllerDriver) initChans() {
 	this.processLoopExitChan = make(chan bool)
-	this.newSMStateChan = make(chan switchmachine.State)
+	this.updatesQueuedChan = make(chan bool, 1)
+	this.queueSpaceChan = make(chan bool, 1)
+	this.closedChan = make(chan bool)
+	this.loopExitedChan = make(chan bool)
+	this.queueMutex.Lock()
+	this.queuedStates = make(map[switchmachine.Id]switchmachine.State)
+	this.queuedIds = make([]switchmachine.Id, 0)
+	if this.maxQueuedUpdates <= 0 {
+		this.maxQueuedUpdates = DefaultMaxQueuedUpdates
+	}
+	if this.updateBatchWindow <= 0 {
+		this.updateBatchWindow = defaultUpdateBatchWindow
+	}
+	this.queueMutex.Unlock()
 }
 
 func (this *baseTortoiseControllerDriver) initBuffers() {
@@ -120,29 +376,133 @@ func (this *baseTortoiseControllerDriver) initBuffers() {
 	this.rxBuffer = make([]byte, MaxNumberAttachableMainControllerBoards*numRxBytesPerBoard)
 	this.prevRxBuffer = make([]byte, len(this.rxBuffer))
 	this.rxWasteTxBuffer = make([]byte, len(this.rxBuffer))
+	this.seenBoards = make([]bool, MaxNumberAttachableMainControllerBoards)
 }
 
 func (this *baseTortoiseControllerDriver) runLoop() {
+	//Keeps the heartbeat going while there is nothing else to do so a quiet loop isn't mistaken for a stuck one
+	heartbeatTicker := time.NewTicker(hardware.LoopHeartbeatInterval)
+	defer heartbeatTicker.Stop()
+	defer close(this.loopExitedChan)
+	this.recordLoopHeartbeat()
+	//Only set while updates are waiting for the batch window to end
+	var batchTimer <-chan time.Time
 	for {
 		select {
 		case _ = <-this.processLoopExitChan:
+			this.processQueuedUpdates()
 			return
-		case _ = <-this.rxTrigger:
+		case dueTime := <-this.rxTrigger:
+			this.recordPollLag(dueTime)
 			this.handleBusRead()
-		case newSMState := <-this.newSMStateChan:
-			this.processSMStateUpdate(newSMState)
+		case _ = <-this.updatesQueuedChan:
+			if batchTimer == nil {
+				batchTimer = time.After(this.updateBatchWindow)
+			}
+		case _ = <-batchTimer:
+			batchTimer = nil
+			this.processQueuedUpdates()
+		case _ = <-this.txRefreshTrigger:
+			this.handleBusWrite()
+		case _ = <-heartbeatTicker.C:
 		}
+		this.recordLoopHeartbeat()
+	}
+}
+
+func (this *baseTortoiseControllerDriver) recordLoopHeartbeat() {
+	this.healthMutex.Lock()
+	this.lastLoopTime = time.Now()
+	this.healthMutex.Unlock()
+}
+
+func (this *baseTortoiseControllerDriver) recordBusResult(err error, wasRead bool) {
+	this.healthMutex.Lock()
+	this.lastBusErr = err
+	if err == nil && wasRead {
+		this.lastBusReadTime = time.Now()
+	}
+	this.healthMutex.Unlock()
+}
+
+func (this *baseTortoiseControllerDriver) SetTxVerification(enabled bool, retries int) {
+	if retries < 0 {
+		retries = 0
 	}
+	this.verifyTx = enabled
+	this.txVerifyRetries = retries
+}
+
+func (this *baseTortoiseControllerDriver) SetTxRefreshInterval(interval time.Duration) {
+	this.txRefreshInterval = interval
 }
 
 func (this *baseTortoiseControllerDriver) handleBusWrite() {
-	this.txFunc(this.txBuffer, this.txWasteRxBuffer)
+	err := this.busTransfer(busOpTx, this.txFunc, this.txBuffer, this.txWasteRxBuffer)
+	if err != nil {
+		logger.Error("Error writing to bus", "err", err)
+	} else {
+		this.recordBusTraffic(BusRecordTx, this.txBuffer)
+		this.busSnapshot.recordTx(this.txBuffer, time.Now())
+		if this.verifyTx {
+			err = this.verifyTxEcho()
+		}
+	}
+	this.recordBusResult(err, false)
+}
+
+//verifyTxEcho checks that the chain shifted back what it was holding before the write. If it didn't the write is
+//retried, and as the chain should now be holding this write the echo is expected to match it
+func (this *baseTortoiseControllerDriver) verifyTxEcho() error {
+	var err error
+	if this.isChainKnown && !bytes.Equal(this.txWasteRxBuffer, this.chainTxBuffer) {
+		err = newTxVerifyError(this.chainTxBuffer, this.txWasteRxBuffer)
+		logger.Warn("TX read back mismatch, retrying write", "err", err)
+		for attempt := 0; attempt < this.txVerifyRetries && err != nil; attempt++ {
+			err = this.busTransfer(busOpTx, this.txFunc, this.txBuffer, this.txWasteRxBuffer)
+			if err == nil && !bytes.Equal(this.txWasteRxBuffer, this.txBuffer) {
+				err = newTxVerifyError(this.txBuffer, this.txWasteRxBuffer)
+			}
+		}
+	}
+	if this.chainTxBuffer == nil {
+		this.chainTxBuffer = make([]byte, len(this.txBuffer))
+	}
+	copy(this.chainTxBuffer, this.txBuffer)
+	this.isChainKnown = true
+
+	if err != nil && !this.isTxVerifyFault {
+		//Only raise the fault once per run of failures
+		this.isTxVerifyFault = true
+		this.sendDriverEvent(hardware.NewDriverFaultEvent(err))
+	} else if err == nil && this.isTxVerifyFault {
+		logger.Info("TX read back recovered")
+		this.isTxVerifyFault = false
+	}
+	return err
 }
 
 func (this *baseTortoiseControllerDriver) handleBusRead() {
-	this.rxFunc(this.rxWasteTxBuffer, this.rxBuffer)
+	if this.rxHandledFunc != nil {
+		defer this.rxHandledFunc()
+	}
+	err := this.busTransfer(busOpRx, this.rxFunc, this.rxWasteTxBuffer, this.rxBuffer)
+	this.recordBusResult(err, true)
+	if err != nil {
+		logger.Error("Error reading from bus", "err", err)
+		//Don't trust a buffer from a failed read as it would look like everything got detached
+		return
+	}
+	this.recordBusTraffic(BusRecordRx, this.rxBuffer)
+	this.busSnapshot.recordRx(this.rxBuffer, this.prevRxBuffer, time.Now())
 	//Figure out what changed
 	this.processRxBufferChanges()
+	this.applyCalibrationChanges()
+	if this.isTxRefreshNeeded {
+		logger.Info("Switch machine added on a board that was seen before, refreshing outputs")
+		this.isTxRefreshNeeded = false
+		this.handleBusWrite()
+	}
 
 	//We need to swap rx buffers so cur becomes prev and we can reuse old prev for next read since it completely overwrites
 	this.swapRxBuffers()
@@ -154,8 +514,21 @@ func (this *baseTortoiseControllerDriver) processRxBufferChanges() {
 		//If the bytes are not equal then something changed
 		if prevRxByte != curRxByte {
 			this.handleRXByteChange(prevRxByte, curRxByte, curIndex)
+			//Only marked once the whole byte is handled so machines appearing together on a new board don't look like a reset
+			if hasAttachedPort(curRxByte) {
+				this.seenBoards[uint(curIndex)/numRxBytesPerBoard] = true
+			}
+		}
+	}
+}
+
+func hasAttachedPort(rxByte byte) bool {
+	for portNumber := 0; portNumber < int(numRxPortsPerByte); portNumber++ {
+		if isConnectedFromPositionBits(getRxBitsForPortNumber(rxByte, portNumber)) {
+			return true
 		}
 	}
+	return false
 }
 
 func (this *baseTortoiseControllerDriver) handleRXByteChange(prevRxByte, curRxByte byte, byteIndex int) {
@@ -165,23 +538,29 @@ func (this *baseTortoiseControllerDriver) handleRXByteChange(prevRxByte, curRxBy
 		curRxBits := getRxBitsForPortNumber(curRxByte, portNumber)
 		//If they are different then lets handle the change
 		if prevRxBits != curRxBits {
-			log.Println("Bytes don't match need to figure out what changed", prevRxBits, " ", curRxBits)
-
 			curSMId := switchmachine.Id(portNumber + byteIndex*int(numRxPortsPerByte))
 			wasAttached := isConnectedFromPositionBits(prevRxBits)
 			isAttached := isConnectedFromPositionBits(curRxBits)
 
-			log.Println("curSMId:", curSMId, "wasAttached:", wasAttached, "isAttached", isAttached)
+			logger.Debug("RX bits changed", "id", curSMId, "prevRxBits", prevRxBits, "curRxBits", curRxBits,
+				"wasAttached", wasAttached, "isAttached", isAttached)
 			//We know we are removed
 			var eventToSend hardware.DriverEvent
 			if wasAttached && !isAttached {
 				eventToSend = hardware.NewSwitchMachineRemovedEvent(curSMId)
 			} else {
-				position := getSMPositionFromRxBits(curRxBits, portNumber)
+				calibration := this.calibrationFor(curSMId)
+				this.rxCalibrations[curSMId] = calibration
+				position := calibration.Position(getSMPositionFromRxBits(curRxBits, portNumber))
 
 				state := switchmachine.NewState(curSMId, position, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
 
 				if !wasAttached {
+					//The board has come back after dropping off so its outputs need to be written again
+					boardNumber := uint(curSMId) / numDriverPortsPerBoard
+					if this.seenBoards[boardNumber] {
+						this.isTxRefreshNeeded = true
+					}
 					eventToSend = hardware.NewSwitchMachineAddedEvent(curSMId, state)
 				} else {
 					eventToSend = hardware.NewSwitchMachinePositionChangedEvent(curSMId, state)
@@ -189,7 +568,7 @@ func (this *baseTortoiseControllerDriver) handleRXByteChange(prevRxByte, curRxBy
 			}
 
 			if eventToSend != nil {
-				go this.driverEventListener.HandleDriverEvent(eventToSend)
+				this.sendDriverEvent(eventToSend)
 			}
 
 		}
@@ -214,6 +593,48 @@ func getRxBitsForPortNumber(rxByte byte, portNum int) byte {
 	return rxBits
 }
 
+//setRxBitsForPortNumber is the inverse of getRxBitsForPortNumber, replacing one ports worth of bits in rxByte
+func setRxBitsForPortNumber(rxByte, rxBits byte, portNum int) byte {
+	var mask byte
+	var offset uint
+	switch portNum {
+	case 0:
+		mask, offset = port0RxBitMask, port0RxBitOffset
+	case 1:
+		mask, offset = port1RxBitMask, port1RxBitOffset
+	case 2:
+		mask, offset = port2RxBitMask, port2RxBitOffset
+	case 3:
+		mask, offset = port3RxBitMask, port3RxBitOffset
+	default:
+		panic("Invalid Port number")
+	}
+
+	return (rxByte & ^mask) | ((rxBits << byte(offset)) & mask)
+}
+
+//getRxIndexAndPortFromId gives where in the rx buffer the feedback for a switch machine is read from
+func getRxIndexAndPortFromId(id switchmachine.Id) (int, int) {
+	return int(uint(id) / numRxPortsPerByte), int(uint(id) % numRxPortsPerByte)
+}
+
+//getRxBitsFromSMPosition is the inverse of getSMPositionFromRxBits
+func getRxBitsFromSMPosition(position switchmachine.Position, portNumber int) byte {
+	isPort03 := portNumber == 0 || portNumber == 3
+	switch {
+	case position == switchmachine.Position0 && isPort03:
+		return position0Port03
+	case position == switchmachine.Position0:
+		return position0Port12
+	case position == switchmachine.Position1 && isPort03:
+		return position1Port03
+	case position == switchmachine.Position1:
+		return position1Port12
+	default:
+		return positionUnknown
+	}
+}
+
 func getSMPositionFromRxBits(rxBits byte, portNumber int) switchmachine.Position {
 	var position switchmachine.Position
 	if rxBits == positionUnknown {
@@ -245,8 +666,31 @@ func (this *baseTortoiseControllerDriver) swapRxBuffers() {
 	this.rxBuffer = oldPrevRxBuff
 }
 
+//processQueuedUpdates applies every queued update to the tx buffer and then writes them all at once
+func (this *baseTortoiseControllerDriver) processQueuedUpdates() {
+	this.queueMutex.Lock()
+	queuedIds := this.queuedIds
+	queuedStates := this.queuedStates
+	this.queuedIds = make([]switchmachine.Id, 0, len(queuedIds))
+	this.queuedStates = make(map[switchmachine.Id]switchmachine.State, len(queuedStates))
+	this.queueMutex.Unlock()
+	if len(queuedIds) == 0 {
+		return
+	}
+	signal(this.queueSpaceChan)
+
+	for _, curId := range queuedIds {
+		this.applySMStateToTxBuffer(queuedStates[curId])
+	}
+	this.handleBusWrite()
+}
+
 func (this *baseTortoiseControllerDriver) processSMStateUpdate(newState switchmachine.State) {
-	log.Println("Getting update", switchmachine.StateToString(newState))
+	this.applySMStateToTxBuffer(newState)
+	this.handleBusWrite()
+}
+
+func (this *baseTortoiseControllerDriver) applySMStateToTxBuffer(newState switchmachine.State) {
 	var txBits byte
 
 	if newState.GPIO0State() {
@@ -256,7 +700,8 @@ func (this *baseTortoiseControllerDriver) processSMStateUpdate(newState switchma
 		txBits |= gpio1HighBit
 	}
 
-	switch newState.MotorState() {
+	calibration := this.calibrationFor(newState.Id())
+	switch calibration.Motor(newState.MotorState()) {
 	case switchmachine.MotorStateIdle:
 		txBits |= motorIdleBits
 	case switchmachine.MotorStateToPos0:
@@ -276,13 +721,41 @@ func (this *baseTortoiseControllerDriver) processSMStateUpdate(newState switchma
 	byteIndex := getTxIndexFromBufferLengthAndId(len(this.txBuffer), newState.Id())
 
 	this.txBuffer[byteIndex] = (this.txBuffer[byteIndex] & ^bitMask) | txBits
-	log.Println("this.txBuffer", this.txBuffer, "byteIndex", byteIndex, "bitMask", bitMask, "txBits", txBits)
-	this.handleBusWrite()
+	this.txCalibrations[newState.Id()] = calibration
+	if logger.Enabled(logging.LevelDebug) {
+		logger.Debug("Applied switch machine state to tx buffer", "state", switchmachine.StateToString(newState), "txBuffer", this.txBuffer, "byteIndex", byteIndex)
+	}
 }
 func getTxIndexFromBufferLengthAndId(bLen int, id switchmachine.Id) uint {
 	return uint(bLen-1) - calcTxByteOffsetFromId(id)
 }
 
+//getTxBitsForId pulls the motor and gpio bits for a switch machine back out of a tx buffer
+func getTxBitsForId(txBuffer []byte, id switchmachine.Id) byte {
+	txByte := txBuffer[getTxIndexFromBufferLengthAndId(len(txBuffer), id)]
+	if id%2 == 0 {
+		txByte = txByte >> 4
+	}
+	return txByte & dataBitMask
+}
+
+func getMotorStateFromTxBits(txBits byte) switchmachine.MotorState {
+	switch txBits & motorStateBitMask {
+	case motorToPos0Bits:
+		return switchmachine.MotorStateToPos0
+	case motorToPos1Bits:
+		return switchmachine.MotorStateToPos1
+	case motorBrakeBits:
+		return switchmachine.MotorStateBrake
+	default:
+		return switchmachine.MotorStateIdle
+	}
+}
+
+func getGPIOStatesFromTxBits(txBits byte) (switchmachine.GPIOState, switchmachine.GPIOState) {
+	return switchmachine.GPIOState(txBits&gpio0HighBit != 0), switchmachine.GPIOState(txBits&gpio1HighBit != 0)
+}
+
 func calcTxByteOffsetFromId(id switchmachine.Id) uint {
 	//This tells us which board we are on
 	boardNumber := uint(id) / numDriverPortsPerBoard
@@ -292,6 +765,41 @@ func calcTxByteOffsetFromId(id switchmachine.Id) uint {
 	return byteIndex
 }
 
+//TxVerifyError is when the bytes shifted back out of the board chain do not match what was expected
+type TxVerifyError struct {
+	Expected []byte
+	Actual   []byte
+	//Nothing but all 0s or all 1s coming back means the chain is broken rather than data being corrupted
+	IsChainBreak bool
+}
+
+func newTxVerifyError(expected, actual []byte) *TxVerifyError {
+	vErr := &TxVerifyError{}
+	vErr.Expected = make([]byte, len(expected))
+	copy(vErr.Expected, expected)
+	vErr.Actual = make([]byte, len(actual))
+	copy(vErr.Actual, actual)
+	vErr.IsChainBreak = isAllSameByte(actual, 0x00) || isAllSameByte(actual, 0xFF)
+	return vErr
+}
+
+func isAllSameByte(b []byte, v byte) bool {
+	for _, cur := range b {
+		if cur != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *TxVerifyError) Error() string {
+	problem := "corrupted"
+	if this.IsChainBreak {
+		problem = "chain break"
+	}
+	return fmt.Sprintf("TX read back %s expected % X got % X", problem, this.Expected, this.Actual)
+}
+
 type TurnoutNotAvailableError struct {
 	id switchmachine.Id
 }
@@ -1,6 +1,8 @@
 package tortoise
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -343,7 +345,7 @@ func TestThatUpdatingGPIO0OnPort0Causes0x10ToBeWrittenForCorrectByte(t *testing.
 		}
 		return nil
 	}
-	driver.UpdateSwitchMachine(switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
 
 	<-waitChan
 	if !wasTxWrittenAsExpected {
@@ -365,7 +367,7 @@ func TestThatUpdatingGPIO1OnPort0Causes0x20ToBeWrittenForCorrectByte(t *testing.
 		}
 		return nil
 	}
-	driver.UpdateSwitchMachine(switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOn))
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOn))
 
 	<-waitChan
 	if !wasTxWrittenAsExpected {
@@ -387,7 +389,7 @@ func TestThatUpdatingBothGPIOOnPort0Causes0x20ToBeWrittenForCorrectByte(t *testi
 		}
 		return nil
 	}
-	driver.UpdateSwitchMachine(switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOn))
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(idUnderTest, switchmachine.PositionUnknown, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOn))
 
 	<-waitChan
 	if !wasTxWrittenAsExpected {
@@ -395,6 +397,43 @@ func TestThatUpdatingBothGPIOOnPort0Causes0x20ToBeWrittenForCorrectByte(t *testi
 	}
 }
 
+func TestThatSwitchMachineDroppingOffAndComingBackHasItsEventsHandledInOrder(t *testing.T) {
+	const numReads int = 6
+	eventTrigger := make(chan time.Time)
+	events := make(chan hardware.DriverEventType, numReads)
+	driver := getBaseDriverWithAllNOOP()
+	reads := 0
+	driver.rxFunc = func(w, r []byte) error {
+		//Attached on every other read
+		if reads%2 == 0 {
+			copy(r, []byte{position0Port03 << (numBitsPerPort * port0RxBitIndex)})
+		} else {
+			copy(r, []byte{0})
+		}
+		reads++
+		return nil
+	}
+	driver.rxTrigger = eventTrigger
+	driver.Start(&mockDriverEventListener{eventHandlerFunc: func(de hardware.DriverEvent) {
+		//Slow enough that the next event is sent before this one has been handled
+		time.Sleep(time.Millisecond)
+		events <- de.Type()
+	}})
+	defer driver.Close()
+	for i := 0; i < numReads; i++ {
+		eventTrigger <- time.Now()
+	}
+	for i := 0; i < numReads; i++ {
+		var expected hardware.DriverEventType = hardware.SwitchMachineAdded
+		if i%2 == 1 {
+			expected = hardware.SwitchMachineRemoved
+		}
+		if eventType := <-events; eventType != expected {
+			t.Fatalf("Event %d was %v but expected %v", i, eventType, expected)
+		}
+	}
+}
+
 func getBaseDriverWithAllNOOP() *baseTortoiseControllerDriver {
 	driver := &baseTortoiseControllerDriver{}
 	driver.closeFunc = noopCloseFunc
@@ -415,3 +454,297 @@ func (this *mockDriverEventListener) HandleDriverEvent(e hardware.DriverEvent) {
 		this.eventHandlerFunc(e)
 	}
 }
+
+//---------------------------------Health-----------------------------------
+
+func TestHealthIsUnhealthyAfterFailedBusRead(t *testing.T) {
+	eventTrigger := make(chan time.Time)
+	readDone := make(chan bool)
+	driver := getBaseDriverWithAllNOOP()
+	driver.rxFunc = func(w, r []byte) error {
+		defer func() { readDone <- true }()
+		return errors.New("bus failure")
+	}
+	driver.rxTrigger = eventTrigger
+	driver.Start(&mockDriverEventListener{})
+	eventTrigger <- time.Now()
+	<-readDone
+	//The loop only accepts exit once the failed read has been recorded
+	driver.processLoopExitChan <- true
+
+	if h := driver.Health(); h.Healthy || h.LastError == nil {
+		t.Fail()
+	}
+}
+
+func TestHealthIsHealthyAfterSuccessfulBusRead(t *testing.T) {
+	eventTrigger := make(chan time.Time)
+	driver := getBaseDriverWithAllNOOP()
+	driver.rxTrigger = eventTrigger
+	driver.Start(&mockDriverEventListener{})
+	eventTrigger <- time.Now()
+	driver.processLoopExitChan <- true
+
+	if h := driver.Health(); !h.Healthy || h.LastBusReadTime.IsZero() {
+		t.Fail()
+	}
+}
+
+//---------------------------------TX Verification-----------------------------------
+
+func TestTxVerificationDoesNotRetryWhenChainEchoesPreviousWrite(t *testing.T) {
+	driver, writes, faults := getBaseDriverWithEchoingChain(0, 2)
+	update := switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF)
+	driver.processSMStateUpdate(update)
+	driver.processSMStateUpdate(switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if *writes != 2 || len(faults) != 0 || !driver.Health().Healthy {
+		t.Fail()
+	}
+}
+
+func TestTxVerificationRetriesAndRecoversFromOneBadEcho(t *testing.T) {
+	driver, writes, faults := getBaseDriverWithEchoingChain(1, 2)
+	driver.processSMStateUpdate(switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.processSMStateUpdate(switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if *writes != 3 || len(faults) != 0 || !driver.Health().Healthy {
+		t.Fail()
+	}
+}
+
+func TestTxVerificationRaisesFaultWhenMismatchPersists(t *testing.T) {
+	driver, _, faults := getBaseDriverWithEchoingChain(100, 2)
+	driver.processSMStateUpdate(switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.processSMStateUpdate(switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	fault := <-faults
+	vErr, isVerifyErr := fault.Fault().(*TxVerifyError)
+	if fault.Type() != hardware.DriverFault || !isVerifyErr || !vErr.IsChainBreak || driver.Health().Healthy {
+		t.Fail()
+	}
+}
+
+//getBaseDriverWithEchoingChain gives a driver whose txFunc shifts back the previous write like a real board chain.
+//The first badEchos writes after the first one shift back all 0s instead
+func getBaseDriverWithEchoingChain(badEchos, retries int) (*baseTortoiseControllerDriver, *int, chan hardware.DriverEvent) {
+	faults := make(chan hardware.DriverEvent, 1)
+	writes := 0
+	driver := getBaseDriverWithAllNOOP()
+	chain := make([]byte, MaxNumberAttachableMainControllerBoards*numTxBytesPerBoard)
+	driver.txFunc = func(w, r []byte) error {
+		writes++
+		if writes > 1 && badEchos > 0 {
+			badEchos--
+			for i := range r {
+				r[i] = 0
+			}
+		} else {
+			copy(r, chain)
+		}
+		copy(chain, w)
+		return nil
+	}
+	driver.SetTxVerification(true, retries)
+	driver.driverEventListener = &mockDriverEventListener{eventHandlerFunc: func(de hardware.DriverEvent) {
+		faults <- de
+	}}
+	driver.initBuffers()
+	driver.recordLoopHeartbeat()
+	return driver, &writes, faults
+}
+
+//---------------------------------- tx refresh ----------------------------------
+
+func TestTxRefreshTriggerRewritesWholeTxBuffer(t *testing.T) {
+	refreshTrigger := make(chan time.Time)
+	writes := 0
+	driver := getBaseDriverWithAllNOOP()
+	driver.txFunc = func(w, r []byte) error {
+		writes++
+		return nil
+	}
+	driver.txRefreshTrigger = refreshTrigger
+	driver.Start(&mockDriverEventListener{})
+	refreshTrigger <- time.Now()
+	refreshTrigger <- time.Now()
+	driver.Close()
+
+	if writes != 2 {
+		t.Fail()
+	}
+}
+
+func TestFirstSwitchMachineAddedOnBoardDoesNotRefreshOutputs(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{position0Port03 << port0RxBitOffset})
+
+	driver.handleBusRead()
+
+	if *writes != 0 {
+		t.Fail()
+	}
+}
+
+func TestSwitchMachinesAddedTogetherOnNewBoardDoNotRefreshOutputs(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{position0Port03<<port0RxBitOffset | position0Port03<<port1RxBitOffset})
+
+	driver.handleBusRead()
+
+	if *writes != 0 {
+		t.Fail()
+	}
+}
+
+func TestSwitchMachineAddedOnBoardSeenBeforeRefreshesOutputs(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{position0Port03 << port0RxBitOffset}, []byte{positionDisconnected}, []byte{position0Port03 << port0RxBitOffset})
+
+	driver.handleBusRead()
+	driver.handleBusRead()
+	driver.handleBusRead()
+
+	if *writes != 1 {
+		t.Fail()
+	}
+}
+
+func TestSwitchMachineAddedOnDifferentBoardDoesNotRefreshOutputs(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{position0Port03 << port0RxBitOffset}, []byte{position0Port03 << port0RxBitOffset, position0Port03 << port0RxBitOffset})
+
+	driver.handleBusRead()
+	driver.handleBusRead()
+
+	if *writes != 0 {
+		t.Fail()
+	}
+}
+
+func getBaseDriverWithRxSequence(rxSequence ...[]byte) (*baseTortoiseControllerDriver, *int) {
+	writes := 0
+	driver := getBaseDriverWithAllNOOP()
+	driver.txFunc = func(w, r []byte) error {
+		writes++
+		return nil
+	}
+	driver.rxFunc = func(w, r []byte) error {
+		for i := range r {
+			r[i] = 0
+		}
+		copy(r, rxSequence[0])
+		rxSequence = rxSequence[1:]
+		return nil
+	}
+	driver.driverEventListener = &mockDriverEventListener{}
+	driver.initBuffers()
+	return driver, &writes
+}
+
+//---------------------------------- update queue ----------------------------------
+
+func TestUpdateSwitchMachineBeforeStartReturnsDriverClosed(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+
+	err := driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if err != hardware.ErrDriverClosed {
+		t.Fail()
+	}
+}
+
+func TestUpdateSwitchMachineAfterCloseReturnsDriverClosed(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+	driver.Start(&mockDriverEventListener{})
+	driver.Close()
+
+	err := driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if err != hardware.ErrDriverClosed {
+		t.Fail()
+	}
+}
+
+func TestUpdatesQueuedWhileBusIsBusyAreCoalescedIntoOneWrite(t *testing.T) {
+	driver, releaseRead := getBaseDriverWithBlockedRead()
+	writes := 0
+	driver.txFunc = func(w, r []byte) error {
+		writes++
+		return nil
+	}
+	for id := switchmachine.Id(0); id < 20; id++ {
+		if driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(id, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)) != nil {
+			t.FailNow()
+		}
+	}
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	close(releaseRead)
+	driver.Close()
+
+	if writes != 1 || getTxBitsForId(driver.txBuffer, 3) != motorToPos1Bits|gpio0HighBit {
+		t.Fail()
+	}
+}
+
+func TestUpdatesSentOneAfterTheOtherToAnIdleLoopAreCoalescedIntoOneWrite(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+	driver.rxTrigger = make(chan time.Time)
+	//Long enough that the updates can't miss it however the test is scheduled
+	driver.updateBatchWindow = time.Millisecond * 200
+	writes := make(chan bool, 20)
+	driver.txFunc = func(w, r []byte) error {
+		writes <- true
+		return nil
+	}
+	driver.Start(&mockDriverEventListener{})
+	for id := switchmachine.Id(0); id < 20; id++ {
+		if driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(id, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF)) != nil {
+			t.FailNow()
+		}
+		//As if each came from its own request
+		time.Sleep(time.Millisecond)
+	}
+	<-writes
+	//Long enough for any later batch to have been written
+	time.Sleep(driver.updateBatchWindow * 2)
+	driver.Close()
+
+	if len(writes) != 0 || getTxBitsForId(driver.txBuffer, 19) != motorToPos1Bits {
+		t.Errorf("Expected one write with every update but there were %d more", len(writes))
+	}
+}
+
+func TestUpdateSwitchMachineReturnsErrorWhenQueueStaysFull(t *testing.T) {
+	driver, releaseRead := getBaseDriverWithBlockedRead(1)
+	defer driver.Close()
+	defer close(releaseRead)
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := driver.UpdateSwitchMachine(ctx, switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	//An update for an id that is already queued replaces it so it doesn't need space
+	coalescedErr := driver.UpdateSwitchMachine(ctx, switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	if !errors.Is(err, context.DeadlineExceeded) || coalescedErr != nil {
+		t.Fail()
+	}
+}
+
+//getBaseDriverWithBlockedRead starts a driver whose runLoop is stuck in a bus read until the returned channel is closed
+func getBaseDriverWithBlockedRead(maxQueued ...int) (*baseTortoiseControllerDriver, chan bool) {
+	eventTrigger := make(chan time.Time)
+	readStarted := make(chan bool)
+	releaseRead := make(chan bool)
+	driver := getBaseDriverWithAllNOOP()
+	driver.rxTrigger = eventTrigger
+	driver.rxFunc = func(w, r []byte) error {
+		readStarted <- true
+		<-releaseRead
+		return nil
+	}
+	if len(maxQueued) > 0 {
+		driver.SetMaxQueuedUpdates(maxQueued[0])
+	}
+	driver.Start(&mockDriverEventListener{})
+	eventTrigger <- time.Now()
+	<-readStarted
+	return driver, releaseRead
+}
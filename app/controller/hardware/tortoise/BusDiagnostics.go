@@ -0,0 +1,174 @@
+package tortoise
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//BusDiagnosable is implemented by drivers that can show what is going across their bus
+type BusDiagnosable interface {
+	BusDiagnostics() BusDiagnostics
+}
+
+//BusDiagnostics is a snapshot of the bus buffers along with what they decode to for every port. Buffers are nil until
+//the driver has written or read them
+type BusDiagnostics struct {
+	TxBuffer     []byte
+	RxBuffer     []byte
+	PrevRxBuffer []byte
+	//Time of the last successful write and read, zero when there hasn't been one
+	LastTxTime time.Time
+	LastRxTime time.Time
+	Boards     []BoardDiagnostics
+}
+
+type BoardDiagnostics struct {
+	Number uint
+	//The bytes of the buffers that belong to this board in the order they are in the buffer, nil the same as the buffers
+	TxBytes     []byte
+	RxBytes     []byte
+	PrevRxBytes []byte
+	Ports       []PortDiagnostics
+}
+
+//PortDiagnostics decodes the tx and rx bits for the switch machine on one port
+type PortDiagnostics struct {
+	Id   switchmachine.Id
+	Port uint
+	//Motor and gpio bits that were last written for this port
+	TxBits     byte
+	MotorBits  byte
+	GPIOBits   byte
+	MotorState switchmachine.MotorState
+	GPIO0      switchmachine.GPIOState
+	GPIO1      switchmachine.GPIOState
+	//Position bits from the last two reads
+	RxBits     byte
+	PrevRxBits byte
+	Attached   bool
+	Position   switchmachine.Position
+	//Ports 0 and 3 have their position bits the opposite way around to ports 1 and 2
+	IsPort03Encoding bool
+	//When the tx or rx bits for this port last changed, zero if they haven't since the driver started
+	TxChangeTime time.Time
+	RxChangeTime time.Time
+}
+
+//busSnapshot keeps copies of the buffers for diagnostics as the buffers themselves belong to the runLoop
+type busSnapshot struct {
+	mutex         sync.Mutex
+	txBuffer      []byte
+	rxBuffer      []byte
+	prevRxBuffer  []byte
+	lastTxTime    time.Time
+	lastRxTime    time.Time
+	txChangeTimes [MaxNumberSwitchMachines]time.Time
+	rxChangeTimes [MaxNumberSwitchMachines]time.Time
+}
+
+func (this *busSnapshot) recordTx(txBuffer []byte, now time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	//Outputs start off all 0s the same as the buffer
+	prevTxBuffer := this.txBuffer
+	if len(prevTxBuffer) != len(txBuffer) {
+		prevTxBuffer = make([]byte, len(txBuffer))
+	}
+	for id := switchmachine.Id(0); id < switchmachine.Id(len(txBuffer)*int(numTxPortsPerByte)); id++ {
+		if getTxBitsForId(txBuffer, id) != getTxBitsForId(prevTxBuffer, id) {
+			this.txChangeTimes[id] = now
+		}
+	}
+	this.txBuffer = copyBuffer(this.txBuffer, txBuffer)
+	this.lastTxTime = now
+}
+
+//recordRx is given the buffer that was just read and the one read before it
+func (this *busSnapshot) recordRx(rxBuffer, prevRxBuffer []byte, now time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for id := switchmachine.Id(0); id < switchmachine.Id(len(rxBuffer)*int(numRxPortsPerByte)); id++ {
+		byteIndex, portNumber := getRxIndexAndPortFromId(id)
+		if getRxBitsForPortNumber(rxBuffer[byteIndex], portNumber) != getRxBitsForPortNumber(prevRxBuffer[byteIndex], portNumber) {
+			this.rxChangeTimes[id] = now
+		}
+	}
+	this.rxBuffer = copyBuffer(this.rxBuffer, rxBuffer)
+	this.prevRxBuffer = copyBuffer(this.prevRxBuffer, prevRxBuffer)
+	this.lastRxTime = now
+}
+
+func copyBuffer(dst, src []byte) []byte {
+	if len(dst) != len(src) {
+		dst = make([]byte, len(src))
+	}
+	copy(dst, src)
+	return dst
+}
+
+func (this *baseTortoiseControllerDriver) BusDiagnostics() BusDiagnostics {
+	snapshot := &this.busSnapshot
+	snapshot.mutex.Lock()
+	defer snapshot.mutex.Unlock()
+	diagnostics := BusDiagnostics{
+		LastTxTime: snapshot.lastTxTime,
+		LastRxTime: snapshot.lastRxTime,
+		Boards:     make([]BoardDiagnostics, 0, MaxNumberAttachableMainControllerBoards),
+	}
+	if snapshot.txBuffer != nil {
+		diagnostics.TxBuffer = copyBuffer(nil, snapshot.txBuffer)
+	}
+	if snapshot.rxBuffer != nil {
+		diagnostics.RxBuffer = copyBuffer(nil, snapshot.rxBuffer)
+		diagnostics.PrevRxBuffer = copyBuffer(nil, snapshot.prevRxBuffer)
+	}
+	for boardNumber := uint(0); boardNumber < MaxNumberAttachableMainControllerBoards; boardNumber++ {
+		board := BoardDiagnostics{Number: boardNumber, Ports: make([]PortDiagnostics, 0, numDriverPortsPerBoard)}
+		firstId := switchmachine.Id(boardNumber * numDriverPortsPerBoard)
+		if snapshot.txBuffer != nil {
+			//The tx buffer is written from the last board back so the board's last port is at its lowest index
+			lastTxIndex := getTxIndexFromBufferLengthAndId(len(snapshot.txBuffer), firstId)
+			board.TxBytes = copyBuffer(nil, snapshot.txBuffer[lastTxIndex+1-numTxBytesPerBoard:lastTxIndex+1])
+		}
+		if snapshot.rxBuffer != nil {
+			firstRxIndex, _ := getRxIndexAndPortFromId(firstId)
+			board.RxBytes = copyBuffer(nil, snapshot.rxBuffer[firstRxIndex:firstRxIndex+int(numRxBytesPerBoard)])
+			board.PrevRxBytes = copyBuffer(nil, snapshot.prevRxBuffer[firstRxIndex:firstRxIndex+int(numRxBytesPerBoard)])
+		}
+		for port := uint(0); port < numDriverPortsPerBoard; port++ {
+			board.Ports = append(board.Ports, snapshot.decodePort(switchmachine.Id(boardNumber*numDriverPortsPerBoard+port)))
+		}
+		diagnostics.Boards = append(diagnostics.Boards, board)
+	}
+	return diagnostics
+}
+
+func (this *busSnapshot) decodePort(id switchmachine.Id) PortDiagnostics {
+	byteIndex, portNumber := getRxIndexAndPortFromId(id)
+	port := PortDiagnostics{
+		Id:               id,
+		Port:             uint(id) % numDriverPortsPerBoard,
+		Position:         switchmachine.PositionUnknown,
+		IsPort03Encoding: portNumber == 0 || portNumber == 3,
+		TxChangeTime:     this.txChangeTimes[id],
+		RxChangeTime:     this.rxChangeTimes[id],
+	}
+	if this.txBuffer != nil {
+		port.TxBits = getTxBitsForId(this.txBuffer, id)
+		port.MotorBits = port.TxBits & motorStateBitMask
+		port.GPIOBits = port.TxBits & gpioBitMask
+		port.MotorState = getMotorStateFromTxBits(port.TxBits)
+		port.GPIO0, port.GPIO1 = getGPIOStatesFromTxBits(port.TxBits)
+	}
+	if this.rxBuffer != nil {
+		port.RxBits = getRxBitsForPortNumber(this.rxBuffer[byteIndex], portNumber)
+		port.PrevRxBits = getRxBitsForPortNumber(this.prevRxBuffer[byteIndex], portNumber)
+		port.Attached = isConnectedFromPositionBits(port.RxBits)
+		if port.Attached {
+			port.Position = getSMPositionFromRxBits(port.RxBits, portNumber)
+		}
+	}
+	return port
+}
@@ -0,0 +1,92 @@
+package tortoise
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestBusDiagnosticsBeforeAnyTrafficHasNoBuffers(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+
+	diagnostics := driver.BusDiagnostics()
+
+	if diagnostics.TxBuffer != nil || diagnostics.RxBuffer != nil || !diagnostics.LastRxTime.IsZero() {
+		t.Errorf("Expected no buffers but got %+v", diagnostics)
+	}
+	if len(diagnostics.Boards) != int(MaxNumberAttachableMainControllerBoards) {
+		t.Fatalf("Expected every board but got %d", len(diagnostics.Boards))
+	}
+	if port := diagnostics.Boards[0].Ports[0]; port.Attached || port.Position != switchmachine.PositionUnknown {
+		t.Errorf("Expected nothing attached but got %+v", port)
+	}
+}
+
+func TestBusDiagnosticsDecodesRxPerPort(t *testing.T) {
+	//Board 1: port 0 at position 0 and port 1 at position 1, which use opposite bits
+	rx := []byte{0x00, 0x28}
+	driver, _ := getBaseDriverWithRxSequence(rx)
+
+	driver.handleBusRead()
+	diagnostics := driver.BusDiagnostics()
+
+	if !bytes.Equal(diagnostics.RxBuffer[:2], rx) || diagnostics.PrevRxBuffer[1] != 0 || diagnostics.LastRxTime.IsZero() {
+		t.Errorf("Expected rx buffers to be copied but got rx % X prev % X", diagnostics.RxBuffer, diagnostics.PrevRxBuffer)
+	}
+	port0 := diagnostics.Boards[1].Ports[0]
+	if port0.Id != 4 || port0.Port != 0 || !port0.Attached || port0.RxBits != position0Port03 || !port0.IsPort03Encoding || port0.Position != switchmachine.Position0 {
+		t.Errorf("Unexpected decoding for port 0: %+v", port0)
+	}
+	port1 := diagnostics.Boards[1].Ports[1]
+	if !port1.Attached || port1.RxBits != position1Port12 || port1.IsPort03Encoding || port1.Position != switchmachine.Position1 {
+		t.Errorf("Unexpected decoding for port 1: %+v", port1)
+	}
+	if port0.RxChangeTime.IsZero() || !diagnostics.Boards[1].Ports[2].RxChangeTime.IsZero() {
+		t.Error("Expected only ports whose bits changed to have a change time")
+	}
+}
+
+func TestBusDiagnosticsDecodesTxPerPort(t *testing.T) {
+	driver, _ := getBaseDriverWithRxSequence()
+
+	driver.processSMStateUpdate(switchmachine.NewState(2, switchmachine.Position1, switchmachine.MotorStateToPos1, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	diagnostics := driver.BusDiagnostics()
+
+	port := diagnostics.Boards[0].Ports[2]
+	if port.MotorBits != motorToPos1Bits || port.GPIOBits != gpio0HighBit || port.MotorState != switchmachine.MotorStateToPos1 || !bool(port.GPIO0) || bool(port.GPIO1) {
+		t.Errorf("Unexpected decoding for port 2: %+v", port)
+	}
+	if port.TxChangeTime.IsZero() || !diagnostics.Boards[0].Ports[3].TxChangeTime.IsZero() {
+		t.Error("Expected only the port that was written to have a change time")
+	}
+	firstChange := port.TxChangeTime
+
+	//Writing the same bits again is not a change
+	driver.handleBusWrite()
+	if changeTime := driver.BusDiagnostics().Boards[0].Ports[2].TxChangeTime; !changeTime.Equal(firstChange) {
+		t.Errorf("Expected change time to stay %v but got %v", firstChange, changeTime)
+	}
+}
+
+func TestBusDiagnosticsSplitsBuffersPerBoard(t *testing.T) {
+	rx := []byte{0x00, 0x28}
+	driver, _ := getBaseDriverWithRxSequence(rx)
+
+	driver.handleBusRead()
+	driver.processSMStateUpdate(switchmachine.NewState(2, switchmachine.Position1, switchmachine.MotorStateToPos1, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	diagnostics := driver.BusDiagnostics()
+
+	board0, board1 := diagnostics.Boards[0], diagnostics.Boards[1]
+	if !bytes.Equal(board1.RxBytes, rx[1:]) || !bytes.Equal(board1.PrevRxBytes, []byte{0x00}) || !bytes.Equal(board0.RxBytes, rx[:1]) {
+		t.Errorf("Expected one rx byte per board but got board 0 % X and board 1 % X", board0.RxBytes, board1.RxBytes)
+	}
+	//Board 0 is at the end of the tx buffer with port 2 in the first of its two bytes
+	txBuffer := diagnostics.TxBuffer
+	if !bytes.Equal(board0.TxBytes, txBuffer[len(txBuffer)-2:]) || board0.TxBytes[0] != getTxBitsForId(txBuffer, 2)<<4 || board0.TxBytes[1] != 0 {
+		t.Errorf("Unexpected tx bytes for board 0: % X of % X", board0.TxBytes, txBuffer)
+	}
+	if !bytes.Equal(board1.TxBytes, []byte{0x00, 0x00}) {
+		t.Errorf("Expected nothing written for board 1 but got % X", board1.TxBytes)
+	}
+}
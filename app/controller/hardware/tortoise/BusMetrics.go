@@ -0,0 +1,48 @@
+package tortoise
+
+import (
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/metrics"
+)
+
+const (
+	busOpTx string = "tx"
+	busOpRx string = "rx"
+)
+
+var (
+	busTransactions        = metrics.NewCounterVec("smds_bus_transactions_total", "SPI transactions made with the board chain", "mount", "op")
+	busTransactionErrors   = metrics.NewCounterVec("smds_bus_transaction_errors_total", "SPI transactions that returned an error", "mount", "op")
+	busTransactionDuration = metrics.NewHistogramVec("smds_bus_transaction_duration_seconds", "How long SPI transactions take", nil, "mount", "op")
+	busPollLag             = metrics.NewGaugeVec("smds_bus_poll_lag_seconds", "How late the last bus poll ran compared to when it was due", "mount")
+)
+
+//BusMetricsLabelable is implemented by drivers that report bus metrics. The mount name tells drivers apart
+type BusMetricsLabelable interface {
+	//SetBusMetricsMount must be called before Start
+	SetBusMetricsMount(name string)
+}
+
+func (this *baseTortoiseControllerDriver) SetBusMetricsMount(name string) {
+	this.busMetricsMount = name
+}
+
+//busTransfer makes one transfer over the bus recording how long it took and whether it failed
+func (this *baseTortoiseControllerDriver) busTransfer(op string, xFunc func(w, r []byte) error, w, r []byte) error {
+	start := time.Now()
+	err := xFunc(w, r)
+	busTransactionDuration.With(this.busMetricsMount, op).ObserveDuration(time.Since(start))
+	busTransactions.With(this.busMetricsMount, op).Inc()
+	if err != nil {
+		busTransactionErrors.With(this.busMetricsMount, op).Inc()
+	}
+	return err
+}
+
+//recordPollLag is given the time the poll was due. Triggers that don't carry a time are skipped
+func (this *baseTortoiseControllerDriver) recordPollLag(dueTime time.Time) {
+	if !dueTime.IsZero() {
+		busPollLag.With(this.busMetricsMount).Set(time.Since(dueTime).Seconds())
+	}
+}
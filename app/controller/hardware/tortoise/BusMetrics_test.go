@@ -0,0 +1,55 @@
+package tortoise
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//Metrics are shared by the whole package and kept between runs so tests check how much things changed
+
+func TestBusTransferCountsTransactionsAndErrorsByMount(t *testing.T) {
+	driver := &baseTortoiseControllerDriver{}
+	driver.SetBusMetricsMount("busMetricsTest")
+	txBefore, txErrorsBefore := busTransactions.With("busMetricsTest", busOpTx).Value(), busTransactionErrors.With("busMetricsTest", busOpTx).Value()
+	txDurationsBefore := busTransactionDuration.With("busMetricsTest", busOpTx).Count()
+	rxBefore := busTransactions.With("busMetricsTest", busOpRx).Value()
+	busErr := errors.New("bus fault")
+	failing := false
+	xFunc := func(w, r []byte) error {
+		if failing {
+			return busErr
+		}
+		return nil
+	}
+
+	driver.busTransfer(busOpTx, xFunc, nil, nil)
+	failing = true
+	if err := driver.busTransfer(busOpTx, xFunc, nil, nil); err != busErr {
+		t.Errorf("Expected bus error to be returned but got %v", err)
+	}
+
+	if count := busTransactions.With("busMetricsTest", busOpTx).Value() - txBefore; count != 2 {
+		t.Errorf("Expected 2 transactions but got %v", count)
+	}
+	if count := busTransactionErrors.With("busMetricsTest", busOpTx).Value() - txErrorsBefore; count != 1 {
+		t.Errorf("Expected 1 error but got %v", count)
+	}
+	if count := busTransactionDuration.With("busMetricsTest", busOpTx).Count() - txDurationsBefore; count != 2 {
+		t.Errorf("Expected 2 durations but got %v", count)
+	}
+	if count := busTransactions.With("busMetricsTest", busOpRx).Value() - rxBefore; count != 0 {
+		t.Errorf("Expected no rx transactions but got %v", count)
+	}
+}
+
+func TestPollLagIsHowLateThePollRan(t *testing.T) {
+	driver := &baseTortoiseControllerDriver{}
+	driver.SetBusMetricsMount("pollLagTest")
+
+	driver.recordPollLag(time.Now().Add(-time.Second))
+
+	if lag := busPollLag.With("pollLagTest").Value(); lag < 1 || lag > 2 {
+		t.Errorf("Expected about 1s of lag but got %v", lag)
+	}
+}
@@ -0,0 +1,136 @@
+package tortoise
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type BusRecordKind byte
+
+const (
+	BusRecordTx BusRecordKind = 'T'
+	BusRecordRx BusRecordKind = 'R'
+
+	busRecordingMagic   string = "SMBR"
+	busRecordingVersion byte   = 1
+
+	notABusRecordingMessage        string = "Not a bus recording"
+	unsupportedBusRecordingMessage string = "Unsupported bus recording version %d"
+	unknownBusRecordKindMessage    string = "Unknown bus record kind %c"
+)
+
+//BusRecord is one buffer that went across the bus along with when it happened relative to the start of the recording
+type BusRecord struct {
+	Kind   BusRecordKind
+	Offset time.Duration
+	Data   []byte
+}
+
+//BusRecorder writes bus traffic in a compact binary form:
+//a header of the magic, a version byte and the start time in unix nanos as a varint, then per record
+//the kind byte, the offset from start in nanos as a uvarint, the data length as a uvarint and the data
+type BusRecorder struct {
+	mutex   *sync.Mutex
+	w       *bufio.Writer
+	closer  io.Closer
+	start   time.Time
+	scratch []byte
+}
+
+func NewBusRecorder(w io.Writer) (*BusRecorder, error) {
+	recorder := &BusRecorder{}
+	recorder.mutex = &sync.Mutex{}
+	recorder.w = bufio.NewWriter(w)
+	if closer, isCloser := w.(io.Closer); isCloser {
+		recorder.closer = closer
+	}
+	recorder.start = time.Now()
+	recorder.scratch = make([]byte, binary.MaxVarintLen64)
+
+	recorder.w.WriteString(busRecordingMagic)
+	recorder.w.WriteByte(busRecordingVersion)
+	n := binary.PutVarint(recorder.scratch, recorder.start.UnixNano())
+	recorder.w.Write(recorder.scratch[:n])
+	return recorder, recorder.w.Flush()
+}
+
+//Record writes one buffer. Each record is flushed so a crash loses at most the one being written
+func (this *BusRecorder) Record(kind BusRecordKind, data []byte) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.w.WriteByte(byte(kind))
+	n := binary.PutUvarint(this.scratch, uint64(time.Since(this.start)))
+	this.w.Write(this.scratch[:n])
+	n = binary.PutUvarint(this.scratch, uint64(len(data)))
+	this.w.Write(this.scratch[:n])
+	this.w.Write(data)
+	return this.w.Flush()
+}
+
+func (this *BusRecorder) Close() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	err := this.w.Flush()
+	if this.closer != nil {
+		closeErr := this.closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//ReadBusRecording reads back everything written by a BusRecorder. A record cut short at the end of the stream is
+//dropped rather than being an error, as that is what a power loss during recording looks like
+func ReadBusRecording(r io.Reader) (time.Time, []BusRecord, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(busRecordingMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, []byte(busRecordingMagic)) {
+		return time.Time{}, nil, errors.New(notABusRecordingMessage)
+	}
+	version, err := br.ReadByte()
+	if err != nil {
+		return time.Time{}, nil, errors.New(notABusRecordingMessage)
+	}
+	if version != busRecordingVersion {
+		return time.Time{}, nil, fmt.Errorf(unsupportedBusRecordingMessage, version)
+	}
+	startNanos, err := binary.ReadVarint(br)
+	if err != nil {
+		return time.Time{}, nil, errors.New(notABusRecordingMessage)
+	}
+
+	records := make([]BusRecord, 0)
+	for {
+		kindByte, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return time.Time{}, nil, err
+		}
+		kind := BusRecordKind(kindByte)
+		if kind != BusRecordTx && kind != BusRecordRx {
+			return time.Time{}, nil, fmt.Errorf(unknownBusRecordKindMessage, kindByte)
+		}
+		offset, err := binary.ReadUvarint(br)
+		if err != nil {
+			break
+		}
+		dataLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			break
+		}
+		data := make([]byte, dataLen)
+		if _, err = io.ReadFull(br, data); err != nil {
+			break
+		}
+		records = append(records, BusRecord{Kind: kind, Offset: time.Duration(offset), Data: data})
+	}
+	return time.Unix(0, startNanos), records, nil
+}
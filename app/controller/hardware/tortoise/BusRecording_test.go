@@ -0,0 +1,150 @@
+package tortoise
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//---------------------------------- recording format ----------------------------------
+
+func TestBusRecordingRoundTrips(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder, _ := NewBusRecorder(buf)
+	recorder.Record(BusRecordTx, []byte{0x01, 0x02})
+	recorder.Record(BusRecordRx, []byte{0x20})
+
+	_, records, err := ReadBusRecording(buf)
+
+	if err != nil || len(records) != 2 {
+		t.FailNow()
+	}
+	if records[0].Kind != BusRecordTx || !bytes.Equal(records[0].Data, []byte{0x01, 0x02}) ||
+		records[1].Kind != BusRecordRx || !bytes.Equal(records[1].Data, []byte{0x20}) ||
+		records[1].Offset < records[0].Offset {
+		t.Fail()
+	}
+}
+
+func TestReadBusRecordingDropsTruncatedLastRecord(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recorder, _ := NewBusRecorder(buf)
+	recorder.Record(BusRecordRx, []byte{0x20})
+	recorder.Record(BusRecordRx, []byte{0x20, 0x30, 0x40})
+	truncated := buf.Bytes()[:buf.Len()-1]
+
+	_, records, err := ReadBusRecording(bytes.NewReader(truncated))
+
+	if err != nil || len(records) != 1 {
+		t.Fail()
+	}
+}
+
+func TestReadBusRecordingReturnsErrorForNonRecording(t *testing.T) {
+	if _, _, err := ReadBusRecording(bytes.NewReader([]byte("not a recording"))); err == nil {
+		t.Fail()
+	}
+}
+
+func TestBaseDriverRecordsTxAndRxWhileRecording(t *testing.T) {
+	buf := &bytes.Buffer{}
+	eventTrigger := make(chan time.Time)
+	driver := getBaseDriverWithAllNOOP()
+	driver.rxFunc = func(w, r []byte) error {
+		copy(r, []byte{0x20})
+		return nil
+	}
+	driver.rxTrigger = eventTrigger
+	driver.StartBusRecording(buf)
+	driver.Start(&mockDriverEventListener{})
+	eventTrigger <- time.Now()
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.Close()
+
+	_, records, _ := ReadBusRecording(buf)
+	if len(records) != 2 || records[0].Kind != BusRecordRx || records[0].Data[0] != 0x20 || records[1].Kind != BusRecordTx {
+		t.Fail()
+	}
+}
+
+//---------------------------------- replay ----------------------------------
+
+func TestReplayFeedsRecordedRxThroughDriver(t *testing.T) {
+	records := []BusRecord{
+		{Kind: BusRecordRx, Offset: 0, Data: []byte{position0Port03 << port0RxBitOffset}},
+		{Kind: BusRecordRx, Offset: time.Millisecond, Data: []byte{position1Port03 << port0RxBitOffset}},
+	}
+	driver := NewReplayTortoiseControllerDriver(records, 0)
+	events := make(chan hardware.DriverEvent, 2)
+	driver.Start(&mockDriverEventListener{eventHandlerFunc: func(de hardware.DriverEvent) {
+		events <- de
+	}})
+	<-driver.Done()
+
+	//Each event is sent on its own so they can arrive in either order
+	received := make(map[hardware.DriverEventType]hardware.DriverEvent)
+	for i := 0; i < 2; i++ {
+		curEvent := <-events
+		received[curEvent.Type()] = curEvent
+	}
+	changed, isChanged := received[hardware.SwitchMachinePositionChanged]
+	if _, isAdded := received[hardware.SwitchMachineAdded]; !isAdded || !isChanged || changed.State().Position() != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func TestReplayReportsNoMismatchesWhenTxMatches(t *testing.T) {
+	expected := make([]byte, MaxNumberAttachableMainControllerBoards*numTxBytesPerBoard)
+	expected[len(expected)-1] = gpio0HighBit << 4
+	driver := NewReplayTortoiseControllerDriver([]BusRecord{{Kind: BusRecordTx, Data: expected}}, 0)
+	driver.Start(&mockDriverEventListener{})
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.Close()
+
+	if len(driver.TxMismatches()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestReplayReportsMismatchWhenTxDiffers(t *testing.T) {
+	expected := make([]byte, MaxNumberAttachableMainControllerBoards*numTxBytesPerBoard)
+	driver := NewReplayTortoiseControllerDriver([]BusRecord{{Kind: BusRecordTx, Data: expected}}, 0)
+	driver.Start(&mockDriverEventListener{})
+	driver.UpdateSwitchMachine(context.Background(), switchmachine.NewState(0, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.Close()
+
+	mismatches := driver.TxMismatches()
+	if len(mismatches) != 1 || mismatches[0].Index != 0 {
+		t.Fail()
+	}
+}
+
+func TestReplayReportsRecordedTxThatWasNeverWritten(t *testing.T) {
+	driver := NewReplayTortoiseControllerDriver([]BusRecord{{Kind: BusRecordTx, Data: []byte{0x01}}}, 0)
+	driver.Start(&mockDriverEventListener{})
+	<-driver.Done()
+
+	mismatches := driver.TxMismatches()
+	if len(mismatches) != 1 || mismatches[0].Actual != nil {
+		t.Fail()
+	}
+}
+
+func TestReplayWaitsForRecordedTimingDividedBySpeed(t *testing.T) {
+	records := []BusRecord{{Kind: BusRecordRx, Offset: time.Second * 10, Data: []byte{0}}}
+	driver := NewReplayTortoiseControllerDriver(records, 100).(*replayDriverImpl)
+	var slept time.Duration
+	driver.sleep = func(d time.Duration) {
+		slept = d
+	}
+	driver.Start(&mockDriverEventListener{})
+	<-driver.Done()
+
+	if slept <= time.Millisecond*90 || slept > time.Millisecond*100 {
+		t.Fail()
+	}
+}
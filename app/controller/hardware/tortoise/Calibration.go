@@ -0,0 +1,65 @@
+package tortoise
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+const calibrationIdOutOfRangeErrorMessage string = "Can't calibrate switch machine %d as the driver only has %d ids"
+
+//SetCalibration takes effect on the next bus read so that the runLoop is the only one touching the buffers
+func (this *baseTortoiseControllerDriver) SetCalibration(id switchmachine.Id, calibration hardware.Calibration) error {
+	if uint(id) >= MaxNumberSwitchMachines {
+		return fmt.Errorf(calibrationIdOutOfRangeErrorMessage, id, MaxNumberSwitchMachines)
+	}
+	this.calibrationMutex.Lock()
+	this.calibrations[id] = calibration
+	this.calibrationMutex.Unlock()
+	return nil
+}
+
+func (this *baseTortoiseControllerDriver) calibrationFor(id switchmachine.Id) hardware.Calibration {
+	if uint(id) >= MaxNumberSwitchMachines {
+		return hardware.Calibration{}
+	}
+	this.calibrationMutex.Lock()
+	defer this.calibrationMutex.Unlock()
+	return this.calibrations[id]
+}
+
+//applyCalibrationChanges brings the buffers in line with any calibration that changed since they were worked out. A
+//running motor is flipped to keep going the way it was asked to and an attached machine has its position sent again
+func (this *baseTortoiseControllerDriver) applyCalibrationChanges() {
+	this.calibrationMutex.Lock()
+	calibrations := this.calibrations
+	this.calibrationMutex.Unlock()
+
+	prevTxBuffer := append([]byte(nil), this.txBuffer...)
+	for index, curCalibration := range calibrations {
+		curId := switchmachine.Id(index)
+		if curCalibration.SwapMotor != this.txCalibrations[index].SwapMotor {
+			txBits := getTxBitsForId(this.txBuffer, curId)
+			gpio0, gpio1 := getGPIOStatesFromTxBits(txBits)
+			motorState := this.txCalibrations[index].Motor(getMotorStateFromTxBits(txBits))
+			this.applySMStateToTxBuffer(switchmachine.NewState(curId, switchmachine.PositionUnknown, motorState, gpio0, gpio1))
+		}
+		if curCalibration.SwapFeedback != this.rxCalibrations[index].SwapFeedback {
+			this.rxCalibrations[index] = curCalibration
+			rxIndex, portNumber := getRxIndexAndPortFromId(curId)
+			rxBits := getRxBitsForPortNumber(this.rxBuffer[rxIndex], portNumber)
+			if isConnectedFromPositionBits(rxBits) && this.driverEventListener != nil {
+				logger.Info("Feedback calibration changed, resending position", "id", curId)
+				position := curCalibration.Position(getSMPositionFromRxBits(rxBits, portNumber))
+				state := switchmachine.NewState(curId, position, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
+				this.sendDriverEvent(hardware.NewSwitchMachinePositionChangedEvent(curId, state))
+			}
+		}
+	}
+	if !bytes.Equal(prevTxBuffer, this.txBuffer) {
+		logger.Info("Motor calibration changed while a motor was running, rewriting outputs")
+		this.handleBusWrite()
+	}
+}
@@ -0,0 +1,103 @@
+package tortoise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestSetCalibrationOutsideOfDriversIdsReturnsError(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+
+	if driver.SetCalibration(switchmachine.Id(MaxNumberSwitchMachines), hardware.Calibration{SwapMotor: true}) == nil {
+		t.Fail()
+	}
+}
+
+func TestSwapMotorCalibrationDrivesOppositeMotorBits(t *testing.T) {
+	driver, _ := getBaseDriverWithRxSequence()
+	driver.SetCalibration(1, hardware.Calibration{SwapMotor: true})
+
+	driver.processSMStateUpdate(switchmachine.NewState(1, switchmachine.Position0, switchmachine.MotorStateToPos0, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+	driver.processSMStateUpdate(switchmachine.NewState(2, switchmachine.Position0, switchmachine.MotorStateToPos0, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if getTxBitsForId(driver.txBuffer, 1) != motorToPos1Bits|gpio0HighBit || getTxBitsForId(driver.txBuffer, 2) != motorToPos0Bits|gpio0HighBit {
+		t.Fail()
+	}
+}
+
+func TestSwapFeedbackCalibrationReportsOppositePosition(t *testing.T) {
+	driver, _ := getBaseDriverWithRxSequence([]byte{position0Port03 << port0RxBitOffset})
+	events := listenForDriverEvents(driver)
+	driver.SetCalibration(0, hardware.Calibration{SwapFeedback: true})
+
+	driver.handleBusRead()
+
+	event := waitForDriverEvent(t, events)
+	if event.Type() != hardware.SwitchMachineAdded || event.State().Position() != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func TestChangingFeedbackCalibrationResendsPositionOfAttachedMachine(t *testing.T) {
+	rx := []byte{position0Port03 << port0RxBitOffset}
+	driver, _ := getBaseDriverWithRxSequence(rx, rx)
+	events := listenForDriverEvents(driver)
+	driver.handleBusRead()
+	waitForDriverEvent(t, events)
+
+	driver.SetCalibration(0, hardware.Calibration{SwapFeedback: true})
+	driver.handleBusRead()
+
+	event := waitForDriverEvent(t, events)
+	if event.Type() != hardware.SwitchMachinePositionChanged || event.State().Position() != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func TestChangingMotorCalibrationWhileRunningFlipsMotorBits(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{0}, []byte{0})
+	driver.processSMStateUpdate(switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOn))
+	driver.handleBusRead()
+	writesBefore := *writes
+
+	driver.SetCalibration(3, hardware.Calibration{SwapMotor: true})
+	driver.handleBusRead()
+
+	if *writes != writesBefore+1 || getTxBitsForId(driver.txBuffer, 3) != motorToPos0Bits|gpio1HighBit {
+		t.Fail()
+	}
+}
+
+func TestChangingMotorCalibrationWhileIdleDoesNotWrite(t *testing.T) {
+	driver, writes := getBaseDriverWithRxSequence([]byte{0})
+	driver.processSMStateUpdate(switchmachine.NewState(3, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOn))
+	writesBefore := *writes
+
+	driver.SetCalibration(3, hardware.Calibration{SwapMotor: true})
+	driver.handleBusRead()
+
+	if *writes != writesBefore {
+		t.Fail()
+	}
+}
+
+func listenForDriverEvents(driver *baseTortoiseControllerDriver) chan hardware.DriverEvent {
+	events := make(chan hardware.DriverEvent, 4)
+	driver.driverEventListener = &mockDriverEventListener{eventHandlerFunc: func(de hardware.DriverEvent) {
+		events <- de
+	}}
+	return events
+}
+
+func waitForDriverEvent(t *testing.T, events chan hardware.DriverEvent) hardware.DriverEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for driver event")
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package tortoise
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+type FaultKind string
+
+type FaultId uint64
+
+const (
+	//Feedback for the switch machine freezes at whatever it read when the fault started so it never reaches position
+	FaultKindStuckMotor FaultKind = "stuck-motor"
+	//Feedback for the switch machine randomly reads as disconnected
+	FaultKindIntermittentContact FaultKind = "intermittent-contact"
+	//Bus reads randomly fail with an error
+	FaultKindBusReadError FaultKind = "bus-read-error"
+	//Every bus transaction is delayed
+	FaultKindSlowSPI FaultKind = "slow-spi"
+	//A whole main board stops responding. Its feedback reads as disconnected and writes to it are lost
+	FaultKindBoardDropOff FaultKind = "board-drop-off"
+
+	unknownFaultKindMessage    string = "Unknown fault kind %s"
+	faultMissingTargetMessage  string = "Fault kind %s requires a switch machine id or board"
+	faultTargetOutOfRange      string = "Fault target %d is outside of the drivers range"
+	faultProbabilityMessage    string = "Fault probability must be between 0 and 1"
+	faultNotScheduledMessage   string = "Fault %d is not scheduled"
+	injectedBusReadErrorString string = "Injected bus read error"
+
+	//DefaultFaultSeed is what a drivers fault injector is seeded with until SetSeed is called
+	DefaultFaultSeed int64 = 1
+)
+
+//Fault describes something to go wrong. Timing is counted in bus reads so that a seeded injector always behaves the same
+type Fault struct {
+	Kind FaultKind
+	//Target a single switch machine. Used by stuck-motor and intermittent-contact
+	SMId *switchmachine.Id
+	//Target a whole main board. Used by every kind but slow-spi. Takes the place of SMId for the per machine kinds
+	Board *uint
+	//Number of bus reads before the fault becomes active
+	StartAfterReads uint64
+	//Number of bus reads the fault stays active for. 0 is until cleared
+	DurationReads uint64
+	//Chance per bus read that an intermittent fault happens. 0 is treated as always
+	Probability float64
+	//Added to each bus transaction by slow-spi
+	Delay time.Duration
+}
+
+type ScheduledFault struct {
+	Id FaultId
+	Fault
+	Active bool
+}
+
+//FaultInjector lets tests and non production servers make a driver misbehave on purpose
+type FaultInjector interface {
+	//SetSeed resets the random source used for probabilistic faults
+	SetSeed(int64)
+	ScheduleFault(Fault) (FaultId, error)
+	ClearFault(FaultId) error
+	ClearFaults()
+	Faults() []ScheduledFault
+}
+
+type scheduledFault struct {
+	ScheduledFault
+	//Bus read count that the fault was scheduled at
+	scheduledAt uint64
+	//rx bits captured for stuck-motor faults when they first go active
+	frozenRxBits  byte
+	hasFrozenBits bool
+}
+
+type faultInjectorImpl struct {
+	mutex     *sync.Mutex
+	random    *rand.Rand
+	faults    map[FaultId]*scheduledFault
+	nextId    FaultId
+	readCount uint64
+	//Wrapped functions of the driver
+	txFunc func(w, r []byte) error
+	rxFunc func(w, r []byte) error
+	//Allows tests to skip real delays
+	sleep func(time.Duration)
+}
+
+//installFaultInjector wraps the tx and rx functions of a driver so that faults can be injected into them
+func installFaultInjector(driver *baseTortoiseControllerDriver, seed int64) *faultInjectorImpl {
+	injector := &faultInjectorImpl{}
+	injector.mutex = &sync.Mutex{}
+	injector.random = rand.New(rand.NewSource(seed))
+	injector.faults = make(map[FaultId]*scheduledFault)
+	injector.nextId = 1
+	injector.sleep = time.Sleep
+	injector.txFunc = driver.txFunc
+	injector.rxFunc = driver.rxFunc
+	driver.txFunc = injector.injectTx
+	driver.rxFunc = injector.injectRx
+	return injector
+}
+
+func (this *faultInjectorImpl) SetSeed(seed int64) {
+	this.mutex.Lock()
+	this.random = rand.New(rand.NewSource(seed))
+	this.mutex.Unlock()
+}
+
+func (this *faultInjectorImpl) ScheduleFault(f Fault) (FaultId, error) {
+	err := validateFault(f)
+	if err != nil {
+		return 0, err
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	id := this.nextId
+	this.nextId++
+	this.faults[id] = &scheduledFault{ScheduledFault: ScheduledFault{Id: id, Fault: f}, scheduledAt: this.readCount}
+	this.updateActive()
+	return id, nil
+}
+
+func validateFault(f Fault) error {
+	if f.Probability < 0 || f.Probability > 1 {
+		return errors.New(faultProbabilityMessage)
+	}
+	switch f.Kind {
+	case FaultKindStuckMotor, FaultKindIntermittentContact:
+		if f.SMId == nil && f.Board == nil {
+			return fmt.Errorf(faultMissingTargetMessage, f.Kind)
+		}
+	case FaultKindBoardDropOff:
+		if f.Board == nil {
+			return fmt.Errorf(faultMissingTargetMessage, f.Kind)
+		}
+	case FaultKindBusReadError, FaultKindSlowSPI:
+	default:
+		return fmt.Errorf(unknownFaultKindMessage, f.Kind)
+	}
+	if f.SMId != nil && uint(*f.SMId) >= MaxNumberSwitchMachines {
+		return fmt.Errorf(faultTargetOutOfRange, *f.SMId)
+	}
+	if f.Board != nil && *f.Board >= MaxNumberAttachableMainControllerBoards {
+		return fmt.Errorf(faultTargetOutOfRange, *f.Board)
+	}
+	return nil
+}
+
+func (this *faultInjectorImpl) ClearFault(id FaultId) error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if _, exists := this.faults[id]; !exists {
+		return fmt.Errorf(faultNotScheduledMessage, id)
+	}
+	delete(this.faults, id)
+	return nil
+}
+
+func (this *faultInjectorImpl) ClearFaults() {
+	this.mutex.Lock()
+	this.faults = make(map[FaultId]*scheduledFault)
+	this.mutex.Unlock()
+}
+
+func (this *faultInjectorImpl) Faults() []ScheduledFault {
+	this.mutex.Lock()
+	faults := make([]ScheduledFault, 0, len(this.faults))
+	for _, curFault := range this.faults {
+		faults = append(faults, curFault.ScheduledFault)
+	}
+	this.mutex.Unlock()
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Id < faults[j].Id })
+	return faults
+}
+
+//updateActive works out which faults are active for the read about to happen, dropping expired ones. mutex must be held
+func (this *faultInjectorImpl) updateActive() {
+	for id, curFault := range this.faults {
+		readsSinceScheduled := this.readCount - curFault.scheduledAt
+		if curFault.DurationReads > 0 && readsSinceScheduled >= curFault.StartAfterReads+curFault.DurationReads {
+			delete(this.faults, id)
+			continue
+		}
+		curFault.Active = readsSinceScheduled >= curFault.StartAfterReads
+	}
+}
+
+//happens rolls the dice for a probabilistic fault. mutex must be held
+func (this *faultInjectorImpl) happens(f *scheduledFault) bool {
+	return f.Probability == 0 || this.random.Float64() < f.Probability
+}
+
+func (this *faultInjectorImpl) injectTx(w, r []byte) error {
+	this.mutex.Lock()
+	delay := this.activeDelay()
+	//Writes to dropped boards never make it to them
+	var out []byte
+	for _, curFault := range this.faults {
+		if curFault.Active && curFault.Kind == FaultKindBoardDropOff {
+			if out == nil {
+				out = make([]byte, len(w))
+				copy(out, w)
+			}
+			dropBoardTx(out, *curFault.Board)
+		}
+	}
+	this.mutex.Unlock()
+
+	if delay > 0 {
+		this.sleep(delay)
+	}
+	if out == nil {
+		out = w
+	}
+	return this.txFunc(out, r)
+}
+
+//dropBoardTx clears every tx byte for a board as a board that has dropped off gets nothing
+func dropBoardTx(w []byte, board uint) {
+	for port := uint(0); port < numDriverPortsPerBoard; port += numTxPortsPerByte {
+		index := getTxIndexFromBufferLengthAndId(len(w), switchmachine.Id(board*numDriverPortsPerBoard+port))
+		w[index] = 0
+	}
+}
+
+func (this *faultInjectorImpl) injectRx(w, r []byte) error {
+	this.mutex.Lock()
+	this.updateActive()
+	this.readCount++
+	delay := this.activeDelay()
+	var readErr error
+	for _, curFault := range this.sortedFaults() {
+		if curFault.Active && curFault.Kind == FaultKindBusReadError && this.happens(curFault) {
+			readErr = errors.New(injectedBusReadErrorString)
+		}
+	}
+	this.mutex.Unlock()
+
+	if delay > 0 {
+		this.sleep(delay)
+	}
+	if readErr != nil {
+		return readErr
+	}
+	err := this.rxFunc(w, r)
+	if err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	this.applyRxFaults(r)
+	this.mutex.Unlock()
+	return nil
+}
+
+//applyRxFaults changes the rx buffer in a stable order so a seeded injector always makes the same choices. mutex must be held
+func (this *faultInjectorImpl) applyRxFaults(r []byte) {
+	for _, curFault := range this.sortedFaults() {
+		if !curFault.Active {
+			continue
+		}
+		switch curFault.Kind {
+		case FaultKindStuckMotor:
+			for _, smId := range faultTargetIds(curFault.Fault) {
+				byteIndex, portNumber := getRxIndexAndPortFromId(smId)
+				if byteIndex >= len(r) {
+					continue
+				}
+				if !curFault.hasFrozenBits {
+					curFault.frozenRxBits = r[byteIndex]
+					curFault.hasFrozenBits = true
+				}
+				r[byteIndex] = setRxBitsForPortNumber(r[byteIndex], getRxBitsForPortNumber(curFault.frozenRxBits, portNumber), portNumber)
+			}
+		case FaultKindIntermittentContact:
+			for _, smId := range faultTargetIds(curFault.Fault) {
+				byteIndex, portNumber := getRxIndexAndPortFromId(smId)
+				if byteIndex < len(r) && this.happens(curFault) {
+					r[byteIndex] = setRxBitsForPortNumber(r[byteIndex], positionDisconnected, portNumber)
+				}
+			}
+		case FaultKindBoardDropOff:
+			for _, smId := range faultTargetIds(curFault.Fault) {
+				byteIndex, portNumber := getRxIndexAndPortFromId(smId)
+				if byteIndex < len(r) {
+					r[byteIndex] = setRxBitsForPortNumber(r[byteIndex], positionDisconnected, portNumber)
+				}
+			}
+		}
+	}
+}
+
+//sortedFaults orders faults by id so random draws happen in the same order every time. mutex must be held
+func (this *faultInjectorImpl) sortedFaults() []*scheduledFault {
+	faults := make([]*scheduledFault, 0, len(this.faults))
+	for _, curFault := range this.faults {
+		faults = append(faults, curFault)
+	}
+	sort.Slice(faults, func(i, j int) bool { return faults[i].Id < faults[j].Id })
+	return faults
+}
+
+//activeDelay adds up every active slow-spi fault. mutex must be held
+func (this *faultInjectorImpl) activeDelay() time.Duration {
+	var delay time.Duration
+	for _, curFault := range this.faults {
+		if curFault.Active && curFault.Kind == FaultKindSlowSPI {
+			delay += curFault.Delay
+		}
+	}
+	return delay
+}
+
+//faultTargetIds gives every switch machine id a fault applies to
+func faultTargetIds(f Fault) []switchmachine.Id {
+	if f.SMId != nil {
+		return []switchmachine.Id{*f.SMId}
+	}
+	ids := make([]switchmachine.Id, 0, numDriverPortsPerBoard)
+	if f.Board != nil {
+		for port := uint(0); port < numDriverPortsPerBoard; port++ {
+			ids = append(ids, switchmachine.Id(*f.Board*numDriverPortsPerBoard+port))
+		}
+	}
+	return ids
+}
+
+func ParseFaultKind(kindStr string) (FaultKind, error) {
+	kind := FaultKind(kindStr)
+	switch kind {
+	case FaultKindStuckMotor, FaultKindIntermittentContact, FaultKindBusReadError, FaultKindSlowSPI, FaultKindBoardDropOff:
+		return kind, nil
+	}
+	return kind, fmt.Errorf(unknownFaultKindMessage, kindStr)
+}
@@ -0,0 +1,173 @@
+package tortoise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+func TestScheduleFaultReturnsErrorForUnknownKind(t *testing.T) {
+	_, injector := getDriverWithFaultInjector([]byte{})
+	if _, err := injector.ScheduleFault(Fault{Kind: "melted"}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestScheduleFaultReturnsErrorForStuckMotorWithoutTarget(t *testing.T) {
+	_, injector := getDriverWithFaultInjector([]byte{})
+	if _, err := injector.ScheduleFault(Fault{Kind: FaultKindStuckMotor}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestScheduleFaultReturnsErrorForBoardOutOfRange(t *testing.T) {
+	_, injector := getDriverWithFaultInjector([]byte{})
+	board := MaxNumberAttachableMainControllerBoards
+	if _, err := injector.ScheduleFault(Fault{Kind: FaultKindBoardDropOff, Board: &board}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestBusReadErrorFaultCausesRxFuncToReturnError(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{0x10})
+	injector.ScheduleFault(Fault{Kind: FaultKindBusReadError})
+
+	if driver.rxFunc(nil, make([]byte, 1)) == nil {
+		t.Fail()
+	}
+}
+
+func TestFaultOnlyBecomesActiveAfterStartAfterReads(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{0x10})
+	injector.ScheduleFault(Fault{Kind: FaultKindBusReadError, StartAfterReads: 2})
+	r := make([]byte, 1)
+
+	if driver.rxFunc(nil, r) != nil || driver.rxFunc(nil, r) != nil || driver.rxFunc(nil, r) == nil {
+		t.Fail()
+	}
+}
+
+func TestFaultIsRemovedAfterDurationReads(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{0x10})
+	injector.ScheduleFault(Fault{Kind: FaultKindBusReadError, DurationReads: 1})
+	r := make([]byte, 1)
+
+	if driver.rxFunc(nil, r) == nil || driver.rxFunc(nil, r) != nil || len(injector.Faults()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestClearFaultStopsFault(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{0x10})
+	faultId, _ := injector.ScheduleFault(Fault{Kind: FaultKindBusReadError})
+	injector.ClearFault(faultId)
+
+	if driver.rxFunc(nil, make([]byte, 1)) != nil {
+		t.Fail()
+	}
+}
+
+func TestStuckMotorFaultFreezesFeedbackOfOnePort(t *testing.T) {
+	pos0 := setRxBitsForPortNumber(setRxBitsForPortNumber(0, position0Port03, 0), position0Port12, 1)
+	driver, injector := getDriverWithFaultInjector([]byte{pos0})
+	id := switchmachine.Id(0)
+	injector.ScheduleFault(Fault{Kind: FaultKindStuckMotor, SMId: &id})
+	r := make([]byte, 1)
+	driver.rxFunc(nil, r)
+
+	driver.SetRXData([]byte{setRxBitsForPortNumber(setRxBitsForPortNumber(0, position1Port03, 0), position1Port12, 1)})
+	driver.rxFunc(nil, r)
+
+	if getRxBitsForPortNumber(r[0], 0) != position0Port03 || getRxBitsForPortNumber(r[0], 1) != position1Port12 {
+		t.Fail()
+	}
+}
+
+func TestBoardDropOffReadsEveryPortOfBoardAsDisconnected(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{0xAA, 0xAA})
+	board := uint(1)
+	injector.ScheduleFault(Fault{Kind: FaultKindBoardDropOff, Board: &board})
+	r := make([]byte, 2)
+	driver.rxFunc(nil, r)
+
+	if r[0] != 0xAA || r[1] != 0x00 {
+		t.Fail()
+	}
+}
+
+func TestBoardDropOffLosesWritesToThatBoard(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{})
+	var written []byte
+	injector.txFunc = func(w, r []byte) error {
+		written = w
+		return nil
+	}
+	board := uint(0)
+	injector.ScheduleFault(Fault{Kind: FaultKindBoardDropOff, Board: &board})
+	w := make([]byte, MaxNumberAttachableMainControllerBoards*numTxBytesPerBoard)
+	for i := range w {
+		w[i] = 0xFF
+	}
+	driver.txFunc(w, make([]byte, len(w)))
+
+	if written[len(w)-1] != 0 || written[len(w)-2] != 0 || written[len(w)-3] != 0xFF || w[len(w)-1] != 0xFF {
+		t.Fail()
+	}
+}
+
+func TestSlowSPIFaultDelaysBusTransactions(t *testing.T) {
+	driver, injector := getDriverWithFaultInjector([]byte{})
+	var slept time.Duration
+	injector.sleep = func(d time.Duration) {
+		slept += d
+	}
+	injector.ScheduleFault(Fault{Kind: FaultKindSlowSPI, Delay: time.Millisecond * 50})
+	driver.rxFunc(nil, make([]byte, 1))
+	driver.txFunc(make([]byte, 1), make([]byte, 1))
+
+	if slept != time.Millisecond*100 {
+		t.Fail()
+	}
+}
+
+func TestIntermittentContactIsDeterministicForSeed(t *testing.T) {
+	runWithSeed := func() []byte {
+		driver, injector := getDriverWithFaultInjector([]byte{0xFF})
+		injector.SetSeed(42)
+		id := switchmachine.Id(2)
+		injector.ScheduleFault(Fault{Kind: FaultKindIntermittentContact, SMId: &id, Probability: 0.5})
+		reads := make([]byte, 0, 20)
+		r := make([]byte, 1)
+		for i := 0; i < 20; i++ {
+			driver.rxFunc(nil, r)
+			reads = append(reads, r[0])
+		}
+		return reads
+	}
+	first := runWithSeed()
+	second := runWithSeed()
+	sawDrop := false
+	for i := range first {
+		if first[i] != second[i] {
+			t.FailNow()
+		}
+		sawDrop = sawDrop || first[i] != 0xFF
+	}
+	if !sawDrop {
+		t.Fail()
+	}
+}
+
+func getDriverWithFaultInjector(rxData []byte) (*mockHardwareDriverImpl, *faultInjectorImpl) {
+	driver := NewMockTortoiseControllerDriverWithExternalRXTrigger(nil).(*mockHardwareDriverImpl)
+	driver.SetOutputForTx(&discardWriter{})
+	driver.SetRXData(rxData)
+	return driver, driver.FaultInjector.(*faultInjectorImpl)
+}
+
+type discardWriter struct{}
+
+func (this *discardWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
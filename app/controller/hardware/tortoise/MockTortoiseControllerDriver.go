@@ -3,7 +3,6 @@ package tortoise
 import (
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"sync"
 	"time"
@@ -13,12 +12,14 @@ import (
 
 type MockHardwareDriver interface {
 	hardware.Driver
+	FaultInjector
 	SetRXData([]byte)
 	SetOutputForTx(io.Writer)
 }
 
 type mockHardwareDriverImpl struct {
 	baseTortoiseControllerDriver
+	FaultInjector
 	mockRXData []byte
 	rxMutex    *sync.Mutex
 	txWriter   io.Writer
@@ -70,10 +71,11 @@ func createMockDriverImpl() *mockHardwareDriverImpl {
 		driver.rxMutex.Lock()
 		numCoppied := copy(r, driver.mockRXData)
 		driver.rxMutex.Unlock()
-		log.Println("mock rxFunc Called", numCoppied, r, driver.mockRXData)
+		logger.Debug("Mock rx read", "numCopied", numCoppied, "rx", r)
 		return nil
 	}
 	driver.rxFunc = rxFunc
+	driver.FaultInjector = installFaultInjector(&driver.baseTortoiseControllerDriver, DefaultFaultSeed)
 
 	return driver
 }
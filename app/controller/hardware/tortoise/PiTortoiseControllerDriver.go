@@ -1,7 +1,6 @@
 package tortoise
 
 import (
-	"log"
 	"time"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
@@ -20,6 +19,8 @@ const (
 	spiRxMode         spi.Mode         = spi.Mode0
 	spiBitsPerWord    int              = 8
 	busUpdateDuration time.Duration    = time.Millisecond * 250
+	//DefaultBusPollInterval is how often the pi driver reads the bus unless told otherwise
+	DefaultBusPollInterval time.Duration = busUpdateDuration
 )
 
 type piTortoiseControllerDriver struct {
@@ -44,28 +45,41 @@ func NewPiTortoiseControllerDriver() (piDriver hardware.Driver, err error) {
 }
 
 func NewPiTortoiseControllerDriverWithSPIDevPath(txDevPath, rxDevPath string) (hardware.Driver, error) {
-	driver := &baseTortoiseControllerDriver{}
-	log.Println("NewPiTortoiseControllerDriverWithSPIDevPath called")
-	ticker := time.NewTicker(busUpdateDuration)
-	driver.rxTrigger = ticker.C
+	return NewPiTortoiseControllerDriverWithSPIDevPathAndPollInterval(txDevPath, rxDevPath, DefaultBusPollInterval)
+}
 
+//NewPiTortoiseControllerDriverWithSPIDevPathAndPollInterval uses the default spi devices for empty paths and the
+//default poll interval when pollInterval is 0 or less
+func NewPiTortoiseControllerDriverWithSPIDevPathAndPollInterval(txDevPath, rxDevPath string, pollInterval time.Duration) (hardware.Driver, error) {
+	driver := &baseTortoiseControllerDriver{}
+	if txDevPath == "" {
+		txDevPath = spiTxDevPath
+	}
+	if rxDevPath == "" {
+		rxDevPath = spiRxDevPath
+	}
+	if pollInterval <= 0 {
+		pollInterval = DefaultBusPollInterval
+	}
 	txFunc, txCloseFunc, txOpenErr := setupConnection(txDevPath, spiTxMode)
-	log.Println("TX SPI connections setup.")
 	if txOpenErr != nil {
-		log.Println("Error opening tx line", txOpenErr)
+		logger.Error("Error opening tx line", "path", txDevPath, "err", txOpenErr)
 		return nil, txOpenErr
 	}
 
 	driver.txFunc = txFunc
 
 	rxFunc, rxCloseFunc, rxOpenErr := setupConnection(rxDevPath, spiRxMode)
-	log.Println("RX SPI connections setup.")
 	if rxOpenErr != nil {
-		log.Println("Error opening rx line", rxOpenErr)
+		logger.Error("Error opening rx line", "path", rxDevPath, "err", rxOpenErr)
+		txCloseFunc()
 		return nil, rxOpenErr
 	}
 
 	driver.rxFunc = rxFunc
+	//Only started once both lines are open so a failed open has nothing to stop
+	ticker := time.NewTicker(pollInterval)
+	driver.rxTrigger = ticker.C
 
 	piCloseFunc := func() (clsErr error) {
 		ticker.Stop()
@@ -80,7 +94,7 @@ func NewPiTortoiseControllerDriverWithSPIDevPath(txDevPath, rxDevPath string) (h
 }
 
 func setupConnection(spiDevPath string, m spi.Mode) (xFunc func(w, r []byte) error, clsFunc func() error, err error) {
-	log.Println("Setting up connection to:", spiDevPath)
+	logger.Info("Setting up spi connection", "path", spiDevPath)
 	var initErr error
 
 	var spiPort spi.PortCloser
@@ -93,6 +107,8 @@ func setupConnection(spiDevPath string, m spi.Mode) (xFunc func(w, r []byte) err
 		if initErr == nil {
 			clsFunc = spiPort.Close
 			xFunc = spiConn.Tx
+		} else {
+			spiPort.Close()
 		}
 	}
 
@@ -0,0 +1,179 @@
+package tortoise
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+)
+
+//TxMismatch is a tx buffer written during replay that differs from what was recorded. Expected is nil when more was
+//written than was recorded
+type TxMismatch struct {
+	//Which tx write this was counting from 0
+	Index    int
+	Expected []byte
+	Actual   []byte
+}
+
+func (this TxMismatch) String() string {
+	return fmt.Sprintf("TX %d expected % X got % X", this.Index, this.Expected, this.Actual)
+}
+
+//ReplayDriver feeds a bus recording back through the driver so that field problems can be reproduced
+type ReplayDriver interface {
+	hardware.Driver
+	hardware.HealthReporter
+	//Done is closed once every recorded rx buffer has been read and handled. Events are still sent on their own so
+	//may arrive after
+	Done() <-chan struct{}
+	//TxMismatches compares what has been written so far against the recording
+	TxMismatches() []TxMismatch
+}
+
+type replayDriverImpl struct {
+	baseTortoiseControllerDriver
+	records []BusRecord
+	speed   float64
+	trigger chan time.Time
+	stop    chan struct{}
+	done    chan struct{}
+	//rx buffers waiting to be read. Each trigger sent is matched by one entry
+	rxMutex   *sync.Mutex
+	pendingRx [][]byte
+	rxLeft    int
+	isDone    bool
+	//tx buffers written during the replay compared in order with the recorded ones
+	txMutex    *sync.Mutex
+	expectedTx [][]byte
+	txWritten  int
+	mismatches []TxMismatch
+	//Allows tests to skip waiting
+	sleep func(time.Duration)
+}
+
+//NewReplayTortoiseControllerDriver replays records at their recorded timing divided by speed. A speed of 0 or less
+//replays as fast as the driver can read
+func NewReplayTortoiseControllerDriver(records []BusRecord, speed float64) ReplayDriver {
+	driver := &replayDriverImpl{}
+	driver.records = records
+	driver.speed = speed
+	driver.trigger = make(chan time.Time)
+	driver.stop = make(chan struct{})
+	driver.done = make(chan struct{})
+	driver.rxMutex = &sync.Mutex{}
+	driver.txMutex = &sync.Mutex{}
+	driver.sleep = time.Sleep
+	driver.expectedTx = make([][]byte, 0)
+	for _, curRecord := range records {
+		if curRecord.Kind == BusRecordTx {
+			driver.expectedTx = append(driver.expectedTx, curRecord.Data)
+		} else {
+			driver.rxLeft++
+		}
+	}
+	if driver.rxLeft == 0 {
+		driver.isDone = true
+		close(driver.done)
+	}
+	driver.rxTrigger = driver.trigger
+	driver.rxFunc = driver.replayRx
+	driver.rxHandledFunc = driver.rxHandled
+	driver.txFunc = driver.compareTx
+	driver.closeFunc = func() error {
+		close(driver.stop)
+		return nil
+	}
+	return driver
+}
+
+func (this *replayDriverImpl) Start(driverEventListener hardware.DriverEventListener) {
+	this.baseTortoiseControllerDriver.Start(driverEventListener)
+	go this.schedule()
+}
+
+//schedule triggers a bus read at the recorded time of every rx record
+func (this *replayDriverImpl) schedule() {
+	start := time.Now()
+	for _, curRecord := range this.records {
+		if curRecord.Kind != BusRecordRx {
+			continue
+		}
+		if this.speed > 0 {
+			due := start.Add(time.Duration(float64(curRecord.Offset) / this.speed))
+			if wait := time.Until(due); wait > 0 {
+				this.sleep(wait)
+			}
+		}
+		this.rxMutex.Lock()
+		this.pendingRx = append(this.pendingRx, curRecord.Data)
+		this.rxMutex.Unlock()
+		select {
+		case this.trigger <- time.Now():
+		case <-this.stop:
+			return
+		}
+	}
+}
+
+func (this *replayDriverImpl) replayRx(w, r []byte) error {
+	this.rxMutex.Lock()
+	defer this.rxMutex.Unlock()
+	if len(this.pendingRx) == 0 {
+		return nil
+	}
+	copy(r, this.pendingRx[0])
+	this.pendingRx = this.pendingRx[1:]
+	this.rxLeft--
+	return nil
+}
+
+//rxHandled is only done after the last buffer has been handled rather than read so nothing is still processing it
+func (this *replayDriverImpl) rxHandled() {
+	this.rxMutex.Lock()
+	defer this.rxMutex.Unlock()
+	if this.rxLeft == 0 && !this.isDone {
+		this.isDone = true
+		close(this.done)
+	}
+}
+
+func (this *replayDriverImpl) compareTx(w, r []byte) error {
+	this.txMutex.Lock()
+	defer this.txMutex.Unlock()
+	var expected []byte
+	if this.txWritten < len(this.expectedTx) {
+		expected = this.expectedTx[this.txWritten]
+	}
+	if !bytes.Equal(expected, w) {
+		actual := make([]byte, len(w))
+		copy(actual, w)
+		mismatch := TxMismatch{Index: this.txWritten, Expected: expected, Actual: actual}
+		logger.Warn("Replay tx mismatch", "index", mismatch.Index, "expected", mismatch.Expected, "actual", mismatch.Actual)
+		this.mismatches = append(this.mismatches, mismatch)
+	}
+	this.txWritten++
+	return nil
+}
+
+func (this *replayDriverImpl) Done() <-chan struct{} {
+	return this.done
+}
+
+//TxMismatches also reports recorded tx buffers that were never written once the replay is done
+func (this *replayDriverImpl) TxMismatches() []TxMismatch {
+	this.txMutex.Lock()
+	defer this.txMutex.Unlock()
+	mismatches := make([]TxMismatch, len(this.mismatches))
+	copy(mismatches, this.mismatches)
+	select {
+	case <-this.done:
+		for i := this.txWritten; i < len(this.expectedTx); i++ {
+			mismatches = append(mismatches, TxMismatch{Index: i, Expected: this.expectedTx[i]})
+		}
+	default:
+	}
+	return mismatches
+}
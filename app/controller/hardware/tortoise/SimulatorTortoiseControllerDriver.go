@@ -0,0 +1,285 @@
+package tortoise
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+type SimulatedFault string
+
+const (
+	//DefaultSimulatedTravelTime is roughly how long a real tortoise takes to travel end to end
+	DefaultSimulatedTravelTime time.Duration = time.Second * 3
+	//DefaultSimulatorPollInterval matches the pi drivers bus update rate
+	DefaultSimulatorPollInterval time.Duration = busUpdateDuration
+
+	//A motor that is stuck is a FaultKindStuckMotor from the drivers FaultInjector, which every driver has
+	SimulatedFaultNone SimulatedFault = "none"
+	//Machine moves but its feedback contacts always read as unknown
+	SimulatedFaultNoFeedback SimulatedFault = "no-feedback"
+	//Motor leads are wired the wrong way around so the machine travels away from where it is told to go
+	SimulatedFaultReversedMotor SimulatedFault = "reversed-motor"
+
+	simulatedMachineAlreadyAttachedMessage string = "Simulated switch machine %d is already attached"
+	simulatedMachineNotAttachedMessage     string = "Simulated switch machine %d is not attached"
+	simulatedIdOutOfRangeMessage           string = "Simulated switch machine id %d is outside of the drivers %d ids"
+	unknownSimulatedFaultMessage           string = "Unknown simulated fault %s"
+)
+
+//SimulatedMachine is a snapshot of one virtual tortoise
+type SimulatedMachine struct {
+	Id switchmachine.Id
+	//How far the points are between position 0 (0.0) and position 1 (1.0)
+	Travel   float64
+	Position switchmachine.Position
+	Motor    switchmachine.MotorState
+	GPIO0    switchmachine.GPIOState
+	GPIO1    switchmachine.GPIOState
+	Fault    SimulatedFault
+}
+
+//SimulatorDriver is a driver that models virtual tortoise machines so that clients can be exercised without hardware
+type SimulatorDriver interface {
+	hardware.Driver
+	hardware.HealthReporter
+	FaultInjector
+	AttachMachine(id switchmachine.Id, pos switchmachine.Position) error
+	DetachMachine(id switchmachine.Id) error
+	SetFault(id switchmachine.Id, fault SimulatedFault) error
+	SetTravelTime(time.Duration)
+	TravelTime() time.Duration
+	Machines() []SimulatedMachine
+}
+
+type simulatedMachine struct {
+	travel float64
+	fault  SimulatedFault
+}
+
+type simulatorDriverImpl struct {
+	baseTortoiseControllerDriver
+	FaultInjector
+	machinesMutex *sync.Mutex
+	machines      map[switchmachine.Id]*simulatedMachine
+	//Last tx buffer that was written so the simulator knows what each motor is being told to do
+	lastTx        []byte
+	travelTime    time.Duration
+	lastAdvanceAt time.Time
+	//Allows tests to control time
+	now func() time.Time
+}
+
+func NewSimulatorTortoiseControllerDriver(pollInterval, travelTime time.Duration) SimulatorDriver {
+	if pollInterval <= 0 {
+		pollInterval = DefaultSimulatorPollInterval
+	}
+	ticker := time.NewTicker(pollInterval)
+	driver := createSimulatorDriverImpl(ticker.C, travelTime)
+	driver.closeFunc = func() error {
+		ticker.Stop()
+		return nil
+	}
+	return driver
+}
+
+func createSimulatorDriverImpl(trig <-chan time.Time, travelTime time.Duration) *simulatorDriverImpl {
+	if travelTime <= 0 {
+		travelTime = DefaultSimulatedTravelTime
+	}
+	driver := &simulatorDriverImpl{}
+	driver.machinesMutex = &sync.Mutex{}
+	driver.machines = make(map[switchmachine.Id]*simulatedMachine)
+	driver.lastTx = make([]byte, MaxNumberAttachableMainControllerBoards*numTxBytesPerBoard)
+	driver.travelTime = travelTime
+	driver.now = time.Now
+	driver.rxTrigger = trig
+	driver.closeFunc = func() error {
+		return nil
+	}
+
+	driver.txFunc = func(w, r []byte) error {
+		driver.machinesMutex.Lock()
+		//Move everything using the old motor states before the new ones take effect
+		driver.advanceMachines()
+		//The board chain shifts back out what it was holding as the new bytes go in
+		copy(r, driver.lastTx)
+		copy(driver.lastTx, w)
+		driver.machinesMutex.Unlock()
+		return nil
+	}
+
+	driver.rxFunc = func(w, r []byte) error {
+		driver.machinesMutex.Lock()
+		driver.advanceMachines()
+		driver.fillRxBuffer(r)
+		driver.machinesMutex.Unlock()
+		return nil
+	}
+	driver.FaultInjector = installFaultInjector(&driver.baseTortoiseControllerDriver, DefaultFaultSeed)
+
+	return driver
+}
+
+//advanceMachines moves every machine for the time since it was last called. machinesMutex must be held
+func (this *simulatorDriverImpl) advanceMachines() {
+	now := this.now()
+	if this.lastAdvanceAt.IsZero() {
+		this.lastAdvanceAt = now
+		return
+	}
+	elapsed := now.Sub(this.lastAdvanceAt)
+	this.lastAdvanceAt = now
+	if elapsed <= 0 {
+		return
+	}
+	step := float64(elapsed) / float64(this.travelTime)
+	for id, curMachine := range this.machines {
+		direction := 1.0
+		if curMachine.fault == SimulatedFaultReversedMotor {
+			direction = -1.0
+		}
+		switch getMotorStateFromTxBits(getTxBitsForId(this.lastTx, id)) {
+		case switchmachine.MotorStateToPos0:
+			curMachine.travel -= step * direction
+		case switchmachine.MotorStateToPos1:
+			curMachine.travel += step * direction
+		}
+		if curMachine.travel < 0 {
+			curMachine.travel = 0
+		} else if curMachine.travel > 1 {
+			curMachine.travel = 1
+		}
+	}
+}
+
+//fillRxBuffer writes the feedback bits for every attached machine. Ports without a machine read as disconnected
+func (this *simulatorDriverImpl) fillRxBuffer(r []byte) {
+	for i := range r {
+		r[i] = 0
+	}
+	for id, curMachine := range this.machines {
+		byteIndex, portNumber := getRxIndexAndPortFromId(id)
+		if byteIndex >= len(r) {
+			continue
+		}
+		rxBits := positionUnknown
+		if curMachine.fault != SimulatedFaultNoFeedback {
+			rxBits = getRxBitsFromSMPosition(positionFromTravel(curMachine.travel), portNumber)
+		}
+		r[byteIndex] = setRxBitsForPortNumber(r[byteIndex], rxBits, portNumber)
+	}
+}
+
+//Only at the very ends of travel are the feedback contacts closed
+func positionFromTravel(travel float64) switchmachine.Position {
+	if travel <= 0 {
+		return switchmachine.Position0
+	} else if travel >= 1 {
+		return switchmachine.Position1
+	}
+	return switchmachine.PositionUnknown
+}
+
+func (this *simulatorDriverImpl) AttachMachine(id switchmachine.Id, pos switchmachine.Position) error {
+	if uint(id) >= MaxNumberSwitchMachines {
+		return fmt.Errorf(simulatedIdOutOfRangeMessage, id, MaxNumberSwitchMachines)
+	}
+	this.machinesMutex.Lock()
+	defer this.machinesMutex.Unlock()
+	if _, exists := this.machines[id]; exists {
+		return fmt.Errorf(simulatedMachineAlreadyAttachedMessage, id)
+	}
+	machine := &simulatedMachine{fault: SimulatedFaultNone}
+	switch pos {
+	case switchmachine.Position0:
+		machine.travel = 0
+	case switchmachine.Position1:
+		machine.travel = 1
+	default:
+		machine.travel = 0.5
+	}
+	this.machines[id] = machine
+	return nil
+}
+
+func (this *simulatorDriverImpl) DetachMachine(id switchmachine.Id) error {
+	this.machinesMutex.Lock()
+	defer this.machinesMutex.Unlock()
+	if _, exists := this.machines[id]; !exists {
+		return fmt.Errorf(simulatedMachineNotAttachedMessage, id)
+	}
+	delete(this.machines, id)
+	return nil
+}
+
+func (this *simulatorDriverImpl) SetFault(id switchmachine.Id, fault SimulatedFault) error {
+	if !isKnownSimulatedFault(fault) {
+		return fmt.Errorf(unknownSimulatedFaultMessage, fault)
+	}
+	this.machinesMutex.Lock()
+	defer this.machinesMutex.Unlock()
+	machine, exists := this.machines[id]
+	if !exists {
+		return fmt.Errorf(simulatedMachineNotAttachedMessage, id)
+	}
+	//Settle movement up to now so the fault only applies from here on
+	this.advanceMachines()
+	machine.fault = fault
+	return nil
+}
+
+func isKnownSimulatedFault(fault SimulatedFault) bool {
+	return fault == SimulatedFaultNone || fault == SimulatedFaultNoFeedback || fault == SimulatedFaultReversedMotor
+}
+
+func (this *simulatorDriverImpl) SetTravelTime(travelTime time.Duration) {
+	if travelTime <= 0 {
+		return
+	}
+	this.machinesMutex.Lock()
+	this.advanceMachines()
+	this.travelTime = travelTime
+	this.machinesMutex.Unlock()
+}
+
+func (this *simulatorDriverImpl) TravelTime() time.Duration {
+	this.machinesMutex.Lock()
+	defer this.machinesMutex.Unlock()
+	return this.travelTime
+}
+
+func (this *simulatorDriverImpl) Machines() []SimulatedMachine {
+	this.machinesMutex.Lock()
+	this.advanceMachines()
+	machines := make([]SimulatedMachine, 0, len(this.machines))
+	for id, curMachine := range this.machines {
+		txBits := getTxBitsForId(this.lastTx, id)
+		gpio0, gpio1 := getGPIOStatesFromTxBits(txBits)
+		machines = append(machines, SimulatedMachine{
+			Id:       id,
+			Travel:   curMachine.travel,
+			Position: positionFromTravel(curMachine.travel),
+			Motor:    getMotorStateFromTxBits(txBits),
+			GPIO0:    gpio0,
+			GPIO1:    gpio1,
+			Fault:    curMachine.fault,
+		})
+	}
+	this.machinesMutex.Unlock()
+	sort.Slice(machines, func(i, j int) bool { return machines[i].Id < machines[j].Id })
+	return machines
+}
+
+func ParseSimulatedFault(faultStr string) (SimulatedFault, error) {
+	fault := SimulatedFault(faultStr)
+	if !isKnownSimulatedFault(fault) {
+		return SimulatedFaultNone, errors.New(fmt.Sprintf(unknownSimulatedFaultMessage, faultStr))
+	}
+	return fault, nil
+}
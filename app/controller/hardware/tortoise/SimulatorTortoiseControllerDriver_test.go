@@ -0,0 +1,177 @@
+package tortoise
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Test to see if simulatorDriverImpl implements hardware.Driver. Compile should fail if it doesn't
+func TestSimulatorDriverImplementsDriver(t *testing.T) {
+	var _ hardware.Driver = &simulatorDriverImpl{}
+}
+
+//---------------------------------- bit helpers ----------------------------------
+
+func TestSetRxBitsForPortNumberIsInverseOfGetRxBitsForPortNumber(t *testing.T) {
+	for portNumber := 0; portNumber < int(numRxPortsPerByte); portNumber++ {
+		rxByte := setRxBitsForPortNumber(0x00, position1Port12, portNumber)
+		if getRxBitsForPortNumber(rxByte, portNumber) != position1Port12 {
+			t.Fail()
+		}
+	}
+}
+
+func TestGetRxBitsFromSMPositionIsInverseOfGetSMPositionFromRxBits(t *testing.T) {
+	for portNumber := 0; portNumber < int(numRxPortsPerByte); portNumber++ {
+		for _, pos := range []switchmachine.Position{switchmachine.Position0, switchmachine.Position1, switchmachine.PositionUnknown} {
+			if getSMPositionFromRxBits(getRxBitsFromSMPosition(pos, portNumber), portNumber) != pos {
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestGetTxBitsForIdReadsBackWhatProcessSMStateUpdateWrote(t *testing.T) {
+	driver := getBaseDriverWithAllNOOP()
+	driver.initBuffers()
+	driver.processSMStateUpdate(switchmachine.NewState(5, switchmachine.PositionUnknown, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOn))
+
+	txBits := getTxBitsForId(driver.txBuffer, 5)
+	gpio0, gpio1 := getGPIOStatesFromTxBits(txBits)
+	if getMotorStateFromTxBits(txBits) != switchmachine.MotorStateToPos1 || gpio0 != switchmachine.GPIOOFF || gpio1 != switchmachine.GPIOOn {
+		t.Fail()
+	}
+	if getTxBitsForId(driver.txBuffer, 4) != 0 {
+		t.Fail()
+	}
+}
+
+//---------------------------------- simulation ----------------------------------
+
+func TestSimulatedMachineInPosition0ReportsPosition0(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	sim.AttachMachine(6, switchmachine.Position0)
+	r := make([]byte, MaxNumberAttachableMainControllerBoards*numRxBytesPerBoard)
+	sim.rxFunc(nil, r)
+
+	byteIndex, portNumber := getRxIndexAndPortFromId(6)
+	if getSMPositionFromRxBits(getRxBitsForPortNumber(r[byteIndex], portNumber), portNumber) != switchmachine.Position0 {
+		t.Fail()
+	}
+}
+
+func TestUnattachedPortsReadAsDisconnected(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	sim.AttachMachine(6, switchmachine.Position0)
+	r := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	sim.rxFunc(nil, r)
+
+	if isConnectedFromPositionBits(getRxBitsForPortNumber(r[0], 0)) {
+		t.Fail()
+	}
+}
+
+func TestSimulatedMachineIsUnknownMidTravelAndArrivesAfterTravelTime(t *testing.T) {
+	sim, clock := getSimulatorWithFakeClock()
+	id := switchmachine.Id(1)
+	sim.AttachMachine(id, switchmachine.Position0)
+	sim.Machines()
+	sim.initBuffers()
+	sim.processSMStateUpdate(switchmachine.NewState(id, switchmachine.Position0, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	*clock = clock.Add(sim.TravelTime() / 2)
+	if m := sim.Machines()[0]; m.Position != switchmachine.PositionUnknown || m.Motor != switchmachine.MotorStateToPos1 {
+		t.FailNow()
+	}
+	*clock = clock.Add(sim.TravelTime())
+	if sim.Machines()[0].Position != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func TestSimulatedMachineDoesNotMoveWhenMotorIsIdle(t *testing.T) {
+	sim, clock := getSimulatorWithFakeClock()
+	sim.AttachMachine(1, switchmachine.Position1)
+	sim.Machines()
+	*clock = clock.Add(sim.TravelTime() * 2)
+
+	if sim.Machines()[0].Position != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func TestNoFeedbackFaultReadsUnknown(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	sim.AttachMachine(0, switchmachine.Position0)
+	sim.SetFault(0, SimulatedFaultNoFeedback)
+	r := make([]byte, MaxNumberAttachableMainControllerBoards*numRxBytesPerBoard)
+	sim.rxFunc(nil, r)
+
+	if getRxBitsForPortNumber(r[0], 0) != positionUnknown {
+		t.Fail()
+	}
+}
+
+func TestReversedMotorFaultTravelsAwayFromCommandedPosition(t *testing.T) {
+	sim, clock := getSimulatorWithFakeClock()
+	id := switchmachine.Id(2)
+	sim.AttachMachine(id, switchmachine.Position1)
+	sim.SetFault(id, SimulatedFaultReversedMotor)
+	sim.initBuffers()
+	sim.processSMStateUpdate(switchmachine.NewState(id, switchmachine.Position1, switchmachine.MotorStateToPos1, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+	*clock = clock.Add(sim.TravelTime() * 2)
+
+	if sim.Machines()[0].Position != switchmachine.Position0 {
+		t.Fail()
+	}
+}
+
+func TestAttachMachineTwiceReturnsError(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	sim.AttachMachine(0, switchmachine.Position0)
+	if sim.AttachMachine(0, switchmachine.Position0) == nil {
+		t.Fail()
+	}
+}
+
+func TestAttachMachineOutOfRangeReturnsError(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	if sim.AttachMachine(switchmachine.Id(MaxNumberSwitchMachines), switchmachine.Position0) == nil {
+		t.Fail()
+	}
+}
+
+func TestDetachMachineThatIsNotAttachedReturnsError(t *testing.T) {
+	sim, _ := getSimulatorWithFakeClock()
+	if sim.DetachMachine(0) == nil {
+		t.Fail()
+	}
+}
+
+func TestAttachingMachineCausesSwitchMachineAddedEventFromRunningDriver(t *testing.T) {
+	trigger := make(chan time.Time)
+	sim := createSimulatorDriverImpl(trigger, time.Second)
+	events := make(chan hardware.DriverEvent, 1)
+	sim.Start(&mockDriverEventListener{eventHandlerFunc: func(de hardware.DriverEvent) {
+		events <- de
+	}})
+	sim.AttachMachine(9, switchmachine.Position1)
+	trigger <- time.Now()
+
+	de := <-events
+	if de.Type() != hardware.SwitchMachineAdded || de.Id() != 9 || de.State().Position() != switchmachine.Position1 {
+		t.Fail()
+	}
+}
+
+func getSimulatorWithFakeClock() (*simulatorDriverImpl, *time.Time) {
+	clock := time.Unix(0, 0)
+	sim := createSimulatorDriverImpl(nil, time.Second)
+	sim.now = func() time.Time {
+		return clock
+	}
+	return sim, &clock
+}
@@ -0,0 +1,43 @@
+package operation
+
+import (
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+type Id uint64
+
+type Status string
+
+const (
+	//StatusQueued is waiting for the driver to take the update
+	StatusQueued Status = "queued"
+	//StatusRunning has been taken by the driver and the motor is still running
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+)
+
+//Operation is a snapshot of one update to a switch machine
+type Operation struct {
+	Id     Id
+	SMId   switchmachine.Id
+	Status Status
+	//Where the switch machine was sent, PositionUnknown until the driver has taken the update
+	Target switchmachine.Position
+	//Where the switch machine ended up, PositionUnknown until it has finished
+	Position switchmachine.Position
+	//Why it failed
+	Message    string
+	CreateTime time.Time
+	//Zero until the driver has taken the update
+	StartTime time.Time
+	//Zero until it has finished
+	EndTime time.Time
+}
+
+//IsFinished is whether the operation has completed or failed, after which it never changes again
+func (this Operation) IsFinished() bool {
+	return this.Status == StatusCompleted || this.Status == StatusFailed
+}
@@ -0,0 +1,207 @@
+package operation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/event"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+const (
+	//How many operations are kept to be looked at, oldest are dropped first
+	maxKeptOperations int = 256
+
+	supersededMessage   string = "Superseded by operation %d"
+	removedMessage      string = "Switch machine was removed"
+	missedTargetMessage string = "Motor stopped before the switch machine reached its target position"
+)
+
+var logger = logging.ForSubsystem(logging.SubsystemController)
+
+//Tracker gives every update it makes through the controller an id that can be followed until the switch machine has
+//finished moving. Only one operation is active per switch machine, starting another one supersedes it
+type Tracker struct {
+	controller controller.TortoiseController
+	mutex      sync.Mutex
+	operations map[Id]*trackedOperation
+	//Ids in the order they were made so the oldest can be dropped
+	order  []Id
+	active map[switchmachine.Id]*trackedOperation
+	nextId Id
+}
+
+type trackedOperation struct {
+	Operation
+	//Closed once the operation has finished
+	done chan struct{}
+}
+
+func NewTracker(c controller.TortoiseController) *Tracker {
+	if c == nil {
+		panic("controller is required for NewTracker")
+	}
+	return &Tracker{
+		controller: c,
+		operations: make(map[Id]*trackedOperation),
+		order:      make([]Id, 0),
+		active:     make(map[switchmachine.Id]*trackedOperation),
+		nextId:     1,
+	}
+}
+
+//Update is UpdateSwitchMachine on the controller as an operation
+func (this *Tracker) Update(ctx context.Context, requestState switchmachine.State) (Operation, error) {
+	return this.Run(ctx, requestState.Id(), func(ctx context.Context) (switchmachine.Position, error) {
+		return requestState.Position(), this.controller.UpdateSwitchMachine(ctx, requestState)
+	})
+}
+
+//Toggle is Toggle on the controller as an operation
+func (this *Tracker) Toggle(ctx context.Context, smId switchmachine.Id, version uint64) (Operation, error) {
+	return this.Run(ctx, smId, func(ctx context.Context) (switchmachine.Position, error) {
+		return this.controller.Toggle(ctx, smId, version)
+	})
+}
+
+//Idle is Idle on the controller as an operation. It is sent nowhere so its target is where it stops
+func (this *Tracker) Idle(ctx context.Context, smId switchmachine.Id) (Operation, error) {
+	return this.Run(ctx, smId, func(ctx context.Context) (switchmachine.Position, error) {
+		err := this.controller.Idle(ctx, smId)
+		if err != nil {
+			return switchmachine.PositionUnknown, err
+		}
+		state, err := this.controller.GetSwitchMachineById(smId)
+		if err != nil {
+			return switchmachine.PositionUnknown, err
+		}
+		return state.Position(), nil
+	})
+}
+
+//Run starts an operation on smId and carries it out with update, which returns where the switch machine was sent.
+//The operation is returned as it was once update returned, failed if update returned an error
+func (this *Tracker) Run(ctx context.Context, smId switchmachine.Id, update func(context.Context) (switchmachine.Position, error)) (Operation, error) {
+	op := this.start(smId)
+	target, err := update(ctx)
+	var state switchmachine.State
+	if err == nil {
+		state, err = this.controller.GetSwitchMachineById(smId)
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if op.Status != StatusQueued {
+		//Superseded or removed while the driver was taking it
+		return op.Operation, err
+	}
+	op.Target = target
+	op.StartTime = time.Now()
+	switch {
+	case err != nil:
+		this.finish(op, StatusFailed, switchmachine.PositionUnknown, err.Error())
+	case switchmachine.IsMotorRunning(state.MotorState()):
+		op.Status = StatusRunning
+	default:
+		//Nothing to wait on such as when only the gpio changed
+		this.finish(op, StatusCompleted, state.Position(), "")
+	}
+	return op.Operation, err
+}
+
+func (this *Tracker) start(smId switchmachine.Id) *trackedOperation {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	op := &trackedOperation{
+		Operation: Operation{
+			Id:         this.nextId,
+			SMId:       smId,
+			Status:     StatusQueued,
+			Target:     switchmachine.PositionUnknown,
+			Position:   switchmachine.PositionUnknown,
+			CreateTime: time.Now(),
+		},
+		done: make(chan struct{}),
+	}
+	this.nextId++
+	if prevOp, isActive := this.active[smId]; isActive {
+		this.finish(prevOp, StatusFailed, switchmachine.PositionUnknown, fmt.Sprintf(supersededMessage, op.Id))
+	}
+	this.active[smId] = op
+	this.operations[op.Id] = op
+	this.order = append(this.order, op.Id)
+	if len(this.order) > maxKeptOperations {
+		delete(this.operations, this.order[0])
+		this.order = this.order[1:]
+	}
+	return op
+}
+
+//finish expects mutex to already be held
+func (this *Tracker) finish(op *trackedOperation, status Status, position switchmachine.Position, message string) {
+	op.Status = status
+	op.Position = position
+	op.Message = message
+	op.EndTime = time.Now()
+	if this.active[op.SMId] == op {
+		delete(this.active, op.SMId)
+	}
+	close(op.done)
+	logger.Debug("Operation finished", "operation", op.Id, "id", op.SMId, "status", status, "message", message)
+}
+
+//HandleSwitchMachineEvent has to be given every event from the controller. It returns the operation the event belongs
+//to, if any, and finishes it once the motor has stopped
+func (this *Tracker) HandleSwitchMachineEvent(e event.SwitchMachineEvent) (Id, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	op, isActive := this.active[e.State().Id()]
+	if !isActive {
+		return 0, false
+	}
+	switch e.Type() {
+	case event.SwitchMachineRemoved:
+		this.finish(op, StatusFailed, e.State().Position(), removedMessage)
+	case event.SwitchMachineUpdated:
+		if op.Status == StatusRunning && !switchmachine.IsMotorRunning(e.State().MotorState()) {
+			if e.State().Position() == op.Target {
+				this.finish(op, StatusCompleted, e.State().Position(), "")
+			} else {
+				this.finish(op, StatusFailed, e.State().Position(), missedTargetMessage)
+			}
+		}
+	}
+	return op.Id, true
+}
+
+//Operation returns a snapshot of an operation if it is still kept
+func (this *Tracker) Operation(id Id) (Operation, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	op, exists := this.operations[id]
+	if !exists {
+		return Operation{}, false
+	}
+	return op.Operation, true
+}
+
+//Wait returns the operation once it has finished or ctx is done, whichever is first
+func (this *Tracker) Wait(ctx context.Context, id Id) (Operation, bool) {
+	this.mutex.Lock()
+	op, exists := this.operations[id]
+	this.mutex.Unlock()
+	if !exists {
+		return Operation{}, false
+	}
+	select {
+	case <-ctx.Done():
+	case <-op.done:
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return op.Operation, true
+}
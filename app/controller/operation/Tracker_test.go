@@ -0,0 +1,172 @@
+package operation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/event"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Timings are shrunk so a throw takes a fraction of a second while still leaving the motor longer than the travel
+const (
+	testPollInterval time.Duration = time.Millisecond * 2
+	testTravelTime   time.Duration = time.Millisecond * 40
+	testMotorRunTime time.Duration = time.Millisecond * 100
+	testWaitTimeout  time.Duration = time.Second * 5
+)
+
+func TestThrowRunsUntilMotorStopsAndCompletesAtTarget(t *testing.T) {
+	tracker, eventOps := getTrackerWithSimulatedMachine(t, 1, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	op, err := tracker.Update(context.Background(), switchmachine.NewState(1, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+	if err != nil || op.Status != StatusRunning || op.Target != switchmachine.Position1 || op.StartTime.IsZero() {
+		t.Fatalf("Expected a running operation but got %+v, %v", op, err)
+	}
+	op = waitForOperation(t, tracker, op.Id)
+
+	if op.Status != StatusCompleted || op.Position != switchmachine.Position1 || op.EndTime.Before(op.StartTime) {
+		t.Errorf("Expected completed at position 1 but got %+v", op)
+	}
+	if eventOps()[op.Id] == 0 {
+		t.Error("Expected the events of the throw to belong to the operation")
+	}
+}
+
+func TestGPIOOnlyUpdateCompletesStraightAway(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 2, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	op, err := tracker.Update(context.Background(), switchmachine.NewState(2, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	if err != nil || op.Status != StatusCompleted || op.Position != switchmachine.Position0 {
+		t.Errorf("Expected completed at position 0 but got %+v, %v", op, err)
+	}
+}
+
+func TestThrowThatNeverMovesFails(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 3, switchmachine.Position0, tortoise.SimulatedFaultNone, tortoise.FaultKindStuckMotor)
+
+	op, _ := tracker.Toggle(context.Background(), 3, 0)
+	op = waitForOperation(t, tracker, op.Id)
+
+	if op.Status != StatusFailed || op.Target != switchmachine.Position1 || op.Position != switchmachine.Position0 || op.Message == "" {
+		t.Errorf("Expected failed at position 0 but got %+v", op)
+	}
+}
+
+func TestIdleSupersedesThrowAndCompletesWhereItStopped(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 8, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	throwOp, _ := tracker.Toggle(context.Background(), 8, 0)
+	idleOp, err := tracker.Idle(context.Background(), 8)
+	throwOp, _ = tracker.Operation(throwOp.Id)
+
+	if err != nil || throwOp.Status != StatusFailed || idleOp.Status != StatusCompleted || idleOp.Target != idleOp.Position {
+		t.Errorf("Expected the throw to be superseded by a completed idle but got %+v and %+v, %v", throwOp, idleOp, err)
+	}
+}
+
+func TestUpdateOfUnknownSwitchMachineFails(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 4, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	op, err := tracker.Update(context.Background(), switchmachine.NewState(9, switchmachine.Position1, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+
+	if !errors.Is(err, controller.ErrSwitchMachineNotExist) || op.Status != StatusFailed {
+		t.Errorf("Expected failed with ErrSwitchMachineNotExist but got %+v, %v", op, err)
+	}
+}
+
+func TestNewOperationSupersedesRunningOne(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 5, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	first, _ := tracker.Toggle(context.Background(), 5, 0)
+	second, _ := tracker.Toggle(context.Background(), 5, 0)
+	first, _ = tracker.Operation(first.Id)
+
+	if first.Status != StatusFailed || second.Status != StatusRunning || second.Target != switchmachine.Position0 {
+		t.Errorf("Expected first to be superseded by second but got %+v and %+v", first, second)
+	}
+}
+
+func TestWaitReturnsWhenContextIsDone(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 6, switchmachine.Position0, tortoise.SimulatedFaultNone)
+	op, _ := tracker.Toggle(context.Background(), 6, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	op, exists := tracker.Wait(ctx, op.Id)
+
+	if !exists || op.Status != StatusRunning {
+		t.Errorf("Expected running but got %+v", op)
+	}
+}
+
+func TestUnknownOperationDoesNotExist(t *testing.T) {
+	tracker, _ := getTrackerWithSimulatedMachine(t, 7, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	_, exists := tracker.Operation(42)
+	_, waitExists := tracker.Wait(context.Background(), 42)
+
+	if exists || waitExists {
+		t.Fail()
+	}
+}
+
+func waitForOperation(t *testing.T, tracker *Tracker, id Id) Operation {
+	ctx, cancel := context.WithTimeout(context.Background(), testWaitTimeout)
+	defer cancel()
+	op, exists := tracker.Wait(ctx, id)
+	if !exists || !op.IsFinished() {
+		t.Fatalf("Operation %d never finished: %+v", id, op)
+	}
+	return op
+}
+
+//getTrackerWithSimulatedMachine feeds the tracker every controller event like the api does. The returned func counts
+//the events each operation was given
+//getTrackerWithSimulatedMachine injects each of faultKinds into the switch machine as well as setting the simulated fault
+func getTrackerWithSimulatedMachine(t *testing.T, id switchmachine.Id, pos switchmachine.Position, fault tortoise.SimulatedFault, faultKinds ...tortoise.FaultKind) (*Tracker, func() map[Id]int) {
+	sim := tortoise.NewSimulatorTortoiseControllerDriver(testPollInterval, testTravelTime)
+	c := controller.NewTortoiseControllerWithMotorRunTime(sim, testMotorRunTime)
+	t.Cleanup(func() {
+		sim.Close()
+	})
+	tracker := NewTracker(c)
+	eventOps := make(map[Id]int)
+	eventOpsMutex := &sync.Mutex{}
+	c.SetSwitchMachineEventListenerFunc(func(e event.SwitchMachineEvent) {
+		if opId, hasOp := tracker.HandleSwitchMachineEvent(e); hasOp {
+			eventOpsMutex.Lock()
+			eventOps[opId]++
+			eventOpsMutex.Unlock()
+		}
+	})
+	sim.AttachMachine(id, pos)
+	sim.SetFault(id, fault)
+	for _, curKind := range faultKinds {
+		if _, err := sim.ScheduleFault(tortoise.Fault{Kind: curKind, SMId: &id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(testWaitTimeout)
+	for _, err := c.GetSwitchMachineById(id); err != nil; _, err = c.GetSwitchMachineById(id) {
+		if time.Now().After(deadline) {
+			t.Fatal("Simulated machine was never attached")
+		}
+		time.Sleep(testPollInterval)
+	}
+	return tracker, func() map[Id]int {
+		eventOpsMutex.Lock()
+		defer eventOpsMutex.Unlock()
+		counts := make(map[Id]int)
+		for curId, curCount := range eventOps {
+			counts[curId] = curCount
+		}
+		return counts
+	}
+}
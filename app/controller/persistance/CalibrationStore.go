@@ -0,0 +1,127 @@
+package persistance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/atomicfile"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+const readCalibrationFileErrorMessage string = "Unable to read calibration file %s: %w"
+
+//CalibrationStore keeps the wiring calibration of every switch machine that isn't wired the normal way. Machines
+//without one have the default calibration
+type CalibrationStore interface {
+	Get(switchmachine.Id) hardware.Calibration
+	GetAll() map[switchmachine.Id]hardware.Calibration
+	Set(switchmachine.Id, hardware.Calibration) error
+}
+
+type calibrationStoreImpl struct {
+	calibrations map[switchmachine.Id]hardware.Calibration
+	rwLock       *sync.RWMutex
+	//Empty when the calibrations are only kept in memory
+	filePath string
+}
+
+//calibrationFileEntry is how one calibration is kept in the calibration file
+type calibrationFileEntry struct {
+	Id           switchmachine.Id `json:"id"`
+	SwapMotor    bool             `json:"swapMotor"`
+	SwapFeedback bool             `json:"swapFeedback"`
+}
+
+func NewCalibrationStore() CalibrationStore {
+	cStore := &calibrationStoreImpl{}
+	cStore.rwLock = &sync.RWMutex{}
+	cStore.calibrations = make(map[switchmachine.Id]hardware.Calibration)
+	return cStore
+}
+
+//NewFileCalibrationStore loads the calibrations saved at path and saves them back there every time one is set. A
+//missing file is the same as every machine having the default calibration
+func NewFileCalibrationStore(path string) (CalibrationStore, error) {
+	cStore := NewCalibrationStore().(*calibrationStoreImpl)
+	cStore.filePath = path
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("No calibration file, every switch machine starts with the default calibration", "path", path)
+		return cStore, nil
+	} else if err != nil {
+		return nil, fmt.Errorf(readCalibrationFileErrorMessage, path, err)
+	}
+	entries := make([]calibrationFileEntry, 0)
+	if err = json.Unmarshal(contents, &entries); err != nil {
+		return nil, fmt.Errorf(readCalibrationFileErrorMessage, path, err)
+	}
+	for _, curEntry := range entries {
+		calibration := hardware.Calibration{SwapMotor: curEntry.SwapMotor, SwapFeedback: curEntry.SwapFeedback}
+		if !calibration.IsDefault() {
+			cStore.calibrations[curEntry.Id] = calibration
+		}
+	}
+	logger.Info("Loaded calibrations", "path", path, "count", len(cStore.calibrations))
+	return cStore, nil
+}
+
+func (this *calibrationStoreImpl) Get(id switchmachine.Id) hardware.Calibration {
+	this.rwLock.RLock()
+	defer this.rwLock.RUnlock()
+	return this.calibrations[id]
+}
+
+func (this *calibrationStoreImpl) GetAll() map[switchmachine.Id]hardware.Calibration {
+	this.rwLock.RLock()
+	defer this.rwLock.RUnlock()
+	all := make(map[switchmachine.Id]hardware.Calibration, len(this.calibrations))
+	for curId, curCalibration := range this.calibrations {
+		all[curId] = curCalibration
+	}
+	return all
+}
+
+//Set only keeps the new calibration if it could be saved
+func (this *calibrationStoreImpl) Set(id switchmachine.Id, calibration hardware.Calibration) error {
+	this.rwLock.Lock()
+	defer this.rwLock.Unlock()
+	prevCalibration, hadCalibration := this.calibrations[id]
+	if calibration.IsDefault() {
+		delete(this.calibrations, id)
+	} else {
+		this.calibrations[id] = calibration
+	}
+	if this.filePath == "" {
+		return nil
+	}
+	err := this.save()
+	if err != nil {
+		if hadCalibration {
+			this.calibrations[id] = prevCalibration
+		} else {
+			delete(this.calibrations, id)
+		}
+		return err
+	}
+	logger.Debug("Saved calibration", "id", id, "path", this.filePath)
+	return nil
+}
+
+//save replaces the file atomically so a crash part way through never leaves a half written file. rwLock must be held
+func (this *calibrationStoreImpl) save() error {
+	entries := make([]calibrationFileEntry, 0, len(this.calibrations))
+	for curId, curCalibration := range this.calibrations {
+		entries = append(entries, calibrationFileEntry{Id: curId, SwapMotor: curCalibration.SwapMotor, SwapFeedback: curCalibration.SwapFeedback})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Id < entries[j].Id })
+	contents, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(this.filePath, contents)
+}
@@ -0,0 +1,70 @@
+package persistance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+)
+
+func TestCalibrationStoreGivesDefaultCalibrationForUnsetId(t *testing.T) {
+	cStore := NewCalibrationStore()
+
+	if !cStore.Get(3).IsDefault() || len(cStore.GetAll()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestCalibrationStoreSettingDefaultCalibrationRemovesIt(t *testing.T) {
+	cStore := NewCalibrationStore()
+	cStore.Set(3, hardware.Calibration{SwapMotor: true})
+
+	cStore.Set(3, hardware.Calibration{})
+
+	if len(cStore.GetAll()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFileCalibrationStoreWithMissingFileStartsEmpty(t *testing.T) {
+	cStore, err := NewFileCalibrationStore(filepath.Join(t.TempDir(), "calibration.json"))
+
+	if err != nil || len(cStore.GetAll()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestFileCalibrationStoreLoadsWhatWasSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	cStore, _ := NewFileCalibrationStore(path)
+	cStore.Set(7, hardware.Calibration{SwapMotor: true})
+	cStore.Set(2, hardware.Calibration{SwapFeedback: true})
+	cStore.Set(4, hardware.Calibration{SwapMotor: true})
+	cStore.Set(4, hardware.Calibration{})
+
+	reloaded, err := NewFileCalibrationStore(path)
+
+	if err != nil || len(reloaded.GetAll()) != 2 || !reloaded.Get(7).SwapMotor || !reloaded.Get(2).SwapFeedback {
+		t.Fail()
+	}
+}
+
+func TestFileCalibrationStoreWithMalformedFileReturnsError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calibration.json")
+	os.WriteFile(path, []byte("{not json"), 0644)
+
+	if _, err := NewFileCalibrationStore(path); err == nil {
+		t.Fail()
+	}
+}
+
+func TestFileCalibrationStoreKeepsOldCalibrationWhenSaveFails(t *testing.T) {
+	cStore, _ := NewFileCalibrationStore(filepath.Join(t.TempDir(), "missing-dir", "calibration.json"))
+
+	err := cStore.Set(1, hardware.Calibration{SwapMotor: true})
+
+	if err == nil || !cStore.Get(1).IsDefault() {
+		t.Fail()
+	}
+}
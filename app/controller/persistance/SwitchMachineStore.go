@@ -2,9 +2,11 @@ package persistance
 
 import (
 	"errors"
+	"sort"
 	"sync"
 
 	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
 )
 
 const (
@@ -12,38 +14,52 @@ const (
 	missingSMMessage string = "Switch Machine with id is not in store"
 )
 
+var logger = logging.ForSubsystem(logging.SubsystemPersistance)
+
+//SwitchMachineStore gives every state it stores the next version for its switch machine. Adding and updating return
+//the state as it was stored
 type SwitchMachineStore interface {
-	AddSwitchMachine(switchmachine.State) error
+	AddSwitchMachine(switchmachine.State) (switchmachine.State, error)
 	HasSwitchMachine(switchmachine.Id) bool
 	GetSwitchMachineById(switchmachine.Id) switchmachine.State
+	//GetAll is ordered by id
 	GetAll() []switchmachine.State
 	RemoveSwitchMachine(switchmachine.Id) (switchmachine.State, error)
-	UpdateSwitchMachine(switchmachine.State) error
+	UpdateSwitchMachine(switchmachine.State) (switchmachine.State, error)
 }
 
 type switchMachineStoreImpl struct {
 	switchMachines map[switchmachine.Id]switchmachine.State
-	rwLock         *sync.RWMutex
+	//Last version of every switch machine ever stored so versions keep going up when a switch machine comes back
+	versions map[switchmachine.Id]uint64
+	rwLock   *sync.RWMutex
 }
 
 func NewSwitchMachineStore() SwitchMachineStore {
 	smStore := &switchMachineStoreImpl{}
 	smStore.rwLock = &sync.RWMutex{}
 	smStore.switchMachines = make(map[switchmachine.Id]switchmachine.State)
+	smStore.versions = make(map[switchmachine.Id]uint64)
 	return smStore
 }
 
-func (this *switchMachineStoreImpl) AddSwitchMachine(newSwitchMachine switchmachine.State) error {
-	var err error
-	if this.HasSwitchMachine(newSwitchMachine.Id()) {
-		err = newAlreadyHaveSwitchMachineError()
-	} else {
-		this.rwLock.Lock()
-		this.switchMachines[newSwitchMachine.Id()] = newSwitchMachine
-		this.rwLock.Unlock()
+func (this *switchMachineStoreImpl) AddSwitchMachine(newSwitchMachine switchmachine.State) (switchmachine.State, error) {
+	this.rwLock.Lock()
+	defer this.rwLock.Unlock()
+	if _, exists := this.switchMachines[newSwitchMachine.Id()]; exists {
+		return nil, newAlreadyHaveSwitchMachineError()
 	}
+	storedState := this.storeNextVersion(newSwitchMachine)
+	logger.Debug("Stored switch machine", "id", newSwitchMachine.Id(), "version", storedState.Version())
+	return storedState, nil
+}
 
-	return err
+//storeNextVersion expects rwLock to already be held for writing
+func (this *switchMachineStoreImpl) storeNextVersion(sm switchmachine.State) switchmachine.State {
+	this.versions[sm.Id()]++
+	storedState := switchmachine.WithVersion(sm, this.versions[sm.Id()])
+	this.switchMachines[sm.Id()] = storedState
+	return storedState
 }
 
 func (this *switchMachineStoreImpl) HasSwitchMachine(sMachineId switchmachine.Id) bool {
@@ -71,24 +87,19 @@ func (this *switchMachineStoreImpl) RemoveSwitchMachine(smId switchmachine.Id) (
 		lastState, _ = this.switchMachines[smId]
 		delete(this.switchMachines, smId)
 		this.rwLock.Unlock()
+		logger.Debug("Removed switch machine from store", "id", smId)
 	}
 
 	return lastState, err
 }
 
-func (this *switchMachineStoreImpl) UpdateSwitchMachine(sm switchmachine.State) error {
-	var err error
-	this.rwLock.RLock()
-	contains := this.HasSwitchMachine(sm.Id())
-	this.rwLock.RUnlock()
-	if !contains {
-		err = newDoesNotContainSwitchMacineWithIdError()
-	} else {
-		this.rwLock.Lock()
-		this.switchMachines[sm.Id()] = sm
-		this.rwLock.Unlock()
+func (this *switchMachineStoreImpl) UpdateSwitchMachine(sm switchmachine.State) (switchmachine.State, error) {
+	this.rwLock.Lock()
+	defer this.rwLock.Unlock()
+	if _, exists := this.switchMachines[sm.Id()]; !exists {
+		return nil, newDoesNotContainSwitchMacineWithIdError()
 	}
-	return err
+	return this.storeNextVersion(sm), nil
 }
 
 func (this *switchMachineStoreImpl) GetAll() []switchmachine.State {
@@ -98,6 +109,9 @@ func (this *switchMachineStoreImpl) GetAll() []switchmachine.State {
 		allSM = append(allSM, curState)
 	}
 	this.rwLock.RUnlock()
+	sort.Slice(allSM, func(i, j int) bool {
+		return allSM[i].Id() < allSM[j].Id()
+	})
 	return allSM
 }
 
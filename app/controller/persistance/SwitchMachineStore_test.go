@@ -31,7 +31,7 @@ func TestSwitchMachineStoreHasOneSwitchMachineStateAfterOneIsAdded(t *testing.T)
 
 func TestSwitchMachineStoreAddingUniqueSwitchMachineReturnsNoError(t *testing.T) {
 	smStore := NewSwitchMachineStore()
-	err := smStore.AddSwitchMachine(getSampleSwitchMachineState())
+	_, err := smStore.AddSwitchMachine(getSampleSwitchMachineState())
 
 	if err != nil {
 		t.Error()
@@ -42,7 +42,7 @@ func TestSwitchMachineStoreReturnsErrorWhenAddingADuplicatSwitchMachine(t *testi
 	smStore := NewSwitchMachineStore()
 	newState := getSampleSwitchMachineState()
 	smStore.AddSwitchMachine(newState)
-	err := smStore.AddSwitchMachine(newState)
+	_, err := smStore.AddSwitchMachine(newState)
 
 	if err == nil {
 		t.Error()
@@ -70,7 +70,7 @@ func TestHasSwitchMachineReturnsTrueWhenSwitchMachineWithIdDoesExistInStore(t *t
 func TestUpdateSwitchMachineReturnsErrorWhenSwitchMachineWithIdDoesNotExistInStore(t *testing.T) {
 	sm := getSampleSwitchMachineState()
 	smStore := NewSwitchMachineStore()
-	if smStore.UpdateSwitchMachine(sm) == nil {
+	if _, err := smStore.UpdateSwitchMachine(sm); err == nil {
 		t.Fail()
 	}
 }
@@ -79,7 +79,7 @@ func TestUpdateSwitchMachineDoesNotReturnErrorWhenSwitchMachineDoesExistInStore(
 	sm := getSampleSwitchMachineState()
 	smStore := NewSwitchMachineStore()
 	smStore.AddSwitchMachine(sm)
-	if smStore.UpdateSwitchMachine(sm) != nil {
+	if _, err := smStore.UpdateSwitchMachine(sm); err != nil {
 		t.Fail()
 	}
 }
@@ -129,6 +129,57 @@ func TestRemoveSwitchMachineRemovesSwitchMachineWithId(t *testing.T) {
 
 }
 
+func TestStoredStatesHaveIncreasingVersions(t *testing.T) {
+	smStore := NewSwitchMachineStore()
+	sm := getSampleSwitchMachineState()
+
+	addedState, _ := smStore.AddSwitchMachine(sm)
+	updatedState, _ := smStore.UpdateSwitchMachine(sm)
+
+	if sm.Version() != 0 || addedState.Version() != 1 || updatedState.Version() != 2 || smStore.GetSwitchMachineById(sm.Id()).Version() != 2 {
+		t.Errorf("Expected versions 0, 1 and 2 but got %d, %d and %d", sm.Version(), addedState.Version(), updatedState.Version())
+	}
+}
+
+func TestVersionKeepsIncreasingWhenSwitchMachineIsAddedAgain(t *testing.T) {
+	smStore := NewSwitchMachineStore()
+	sm := getSampleSwitchMachineState()
+
+	smStore.AddSwitchMachine(sm)
+	smStore.RemoveSwitchMachine(sm.Id())
+	readdedState, _ := smStore.AddSwitchMachine(sm)
+
+	if readdedState.Version() != 2 {
+		t.Errorf("Expected version 2 but got %d", readdedState.Version())
+	}
+}
+
+func TestStoredStateKeepsUpdateTime(t *testing.T) {
+	smStore := NewSwitchMachineStore()
+	sm := getSampleSwitchMachineState()
+
+	addedState, _ := smStore.AddSwitchMachine(sm)
+
+	if !addedState.UpdateTime().Equal(sm.UpdateTime()) || !switchmachine.StatesEqual(sm, addedState) {
+		t.Fail()
+	}
+}
+
+func TestGetAllIsOrderedById(t *testing.T) {
+	smStore := NewSwitchMachineStore()
+	for _, curId := range []switchmachine.Id{9, 2, 14, 0, 5} {
+		smStore.AddSwitchMachine(switchmachine.NewState(curId, switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF))
+	}
+
+	allSM := smStore.GetAll()
+
+	for i := 1; i < len(allSM); i++ {
+		if allSM[i-1].Id() >= allSM[i].Id() {
+			t.Fatalf("Expected ids in order but %d came before %d", allSM[i-1].Id(), allSM[i].Id())
+		}
+	}
+}
+
 func getSampleSwitchMachineState() switchmachine.State {
 	return switchmachine.NewState(switchmachine.Id(0), switchmachine.Position0, switchmachine.MotorStateIdle, switchmachine.GPIOOFF, switchmachine.GPIOOFF)
 }
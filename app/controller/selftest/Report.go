@@ -0,0 +1,127 @@
+package selftest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+type RunId uint64
+
+type RunStatus string
+
+type PortResult string
+
+type StepOutcome string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusComplete  RunStatus = "complete"
+	RunStatusCancelled RunStatus = "cancelled"
+
+	PortResultPass PortResult = "pass"
+	PortResultFail PortResult = "fail"
+	//The machine moved the opposite way to every throw, so either its motor or its feedback leads are swapped
+	PortResultReversedWiring PortResult = "reversedWiring"
+	PortResultNotAttached    PortResult = "notAttached"
+
+	StepOutcomeReached StepOutcome = "reached"
+	//Feedback ended at the opposite position to the one thrown to after the machine moved there
+	StepOutcomeContradicted StepOutcome = "contradicted"
+	//Feedback stayed at the opposite position the whole time so the machine never moved
+	StepOutcomeNoMovement StepOutcome = "noMovement"
+	//Feedback never settled on either position
+	StepOutcomeNoFeedback StepOutcome = "noFeedback"
+	//The throw could not be carried out at all, such as the machine being removed part way through
+	StepOutcomeError StepOutcome = "error"
+)
+
+//ThrowStep is the result of throwing a switch machine to one position
+type ThrowStep struct {
+	Target        switchmachine.Position
+	StartPosition switchmachine.Position
+	EndPosition   switchmachine.Position
+	Outcome       StepOutcome
+	//How long after the throw started the machine arrived at the target, 0 when it never did or never left it
+	TravelTime time.Duration
+	Message    string
+}
+
+//GPIOStep is the result of turning one gpio to the opposite of what it was and back again
+type GPIOStep struct {
+	GPIO    uint
+	Ok      bool
+	Message string
+}
+
+//PortReport is everything that was found testing one switch machine
+type PortReport struct {
+	Id        switchmachine.Id
+	Result    PortResult
+	Message   string
+	Throws    []ThrowStep
+	GPIOs     []GPIOStep
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+//Run is a snapshot of one self test across a set of switch machines. Ports only holds the machines that are done
+type Run struct {
+	Id        RunId
+	Ids       []switchmachine.Id
+	Status    RunStatus
+	Ports     []PortReport
+	StartTime time.Time
+	//Zero while the run is still going
+	EndTime time.Time
+}
+
+func (this Run) copy() Run {
+	this.Ids = append([]switchmachine.Id(nil), this.Ids...)
+	this.Ports = append([]PortReport(nil), this.Ports...)
+	return this
+}
+
+//judge works out the result of a port from its steps
+func (this *PortReport) judge() {
+	problems := make([]string, 0)
+	isAllReached, isAnyReached, isAnyContradicted := true, false, false
+	for _, curStep := range this.Throws {
+		switch curStep.Outcome {
+		case StepOutcomeReached:
+			isAnyReached = true
+			continue
+		case StepOutcomeContradicted:
+			isAnyContradicted = true
+		}
+		isAllReached = false
+		problems = append(problems, fmt.Sprintf("Throw to %s: %s", positionName(curStep.Target), curStep.Message))
+	}
+	for _, curStep := range this.GPIOs {
+		if !curStep.Ok {
+			problems = append(problems, fmt.Sprintf("GPIO %d: %s", curStep.GPIO, curStep.Message))
+		}
+	}
+	switch {
+	case isAnyContradicted && !isAnyReached:
+		this.Result = PortResultReversedWiring
+		problems = append([]string{"Feedback contradicts every throw, the motor or feedback wiring is reversed"}, problems...)
+	case isAllReached && len(problems) == 0:
+		this.Result = PortResultPass
+	default:
+		this.Result = PortResultFail
+	}
+	this.Message = strings.Join(problems, "; ")
+}
+
+func positionName(pos switchmachine.Position) string {
+	switch pos {
+	case switchmachine.Position0:
+		return "position 0"
+	case switchmachine.Position1:
+		return "position 1"
+	}
+	return "unknown position"
+}
@@ -0,0 +1,347 @@
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+type ProgressStage string
+
+const (
+	ProgressRunStarted   ProgressStage = "runStarted"
+	ProgressStep         ProgressStage = "step"
+	ProgressPortComplete ProgressStage = "portComplete"
+	ProgressRunComplete  ProgressStage = "runComplete"
+
+	//DefaultPollInterval is how often a switch machine is checked while it is being thrown
+	DefaultPollInterval time.Duration = time.Millisecond * 20
+	//DefaultFeedbackGrace is how long after the motor run time feedback still has to arrive
+	DefaultFeedbackGrace time.Duration = time.Second
+	//DefaultGPIOSettleTime is how long a gpio is left changed before checking the machine is still there
+	DefaultGPIOSettleTime time.Duration = time.Millisecond * 250
+	//How long putting a gpio back can wait on the driver
+	restoreTimeout time.Duration = time.Second * 5
+	//How many finished runs are kept to be looked at
+	maxKeptRuns int = 10
+
+	numGPIOs uint = 2
+)
+
+//ErrRunInProgress is returned when a self test is started while another one is still going
+var ErrRunInProgress = errors.New("A self test is already running")
+
+//ErrNoSwitchMachines is returned when a self test is started without any switch machines to test
+var ErrNoSwitchMachines = errors.New("A self test needs at least one switch machine")
+
+var logger = logging.ForSubsystem(logging.SubsystemController)
+
+//Progress is sent as a self test moves along. Port is only set for ProgressPortComplete
+type Progress struct {
+	RunId      RunId
+	Stage      ProgressStage
+	Id         switchmachine.Id
+	Message    string
+	PortsDone  int
+	PortsTotal int
+	Port       *PortReport
+}
+
+//Runner commissions switch machines by throwing each one both ways and toggling its gpio, one run at a time. It
+//drives the machines through the controller so everything it does shows up as normal switch machine events
+type Runner struct {
+	controller       controller.TortoiseController
+	progressListener func(Progress)
+	pollInterval     time.Duration
+	feedbackGrace    time.Duration
+	gpioSettleTime   time.Duration
+	mutex            sync.Mutex
+	runs             []*Run
+	nextRunId        RunId
+	isRunning        bool
+	cancelRun        context.CancelFunc
+	runDone          *sync.WaitGroup
+}
+
+func NewRunner(c controller.TortoiseController) *Runner {
+	if c == nil {
+		panic("controller is required for NewRunner")
+	}
+	return &Runner{
+		controller:     c,
+		pollInterval:   DefaultPollInterval,
+		feedbackGrace:  DefaultFeedbackGrace,
+		gpioSettleTime: DefaultGPIOSettleTime,
+		runs:           make([]*Run, 0),
+		nextRunId:      1,
+		runDone:        &sync.WaitGroup{},
+	}
+}
+
+//SetProgressListenerFunc must be called before Start. The func is called from the goroutine running the test
+func (this *Runner) SetProgressListenerFunc(progressListenerFunc func(Progress)) {
+	this.progressListener = progressListenerFunc
+}
+
+//Start tests ids in order in the background and returns the run as it started
+func (this *Runner) Start(ids []switchmachine.Id) (Run, error) {
+	if len(ids) == 0 {
+		return Run{}, ErrNoSwitchMachines
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.isRunning {
+		return Run{}, ErrRunInProgress
+	}
+	run := &Run{
+		Id:        this.nextRunId,
+		Ids:       append([]switchmachine.Id(nil), ids...),
+		Status:    RunStatusRunning,
+		Ports:     make([]PortReport, 0, len(ids)),
+		StartTime: time.Now(),
+	}
+	this.nextRunId++
+	this.runs = append(this.runs, run)
+	if len(this.runs) > maxKeptRuns {
+		this.runs = this.runs[len(this.runs)-maxKeptRuns:]
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	this.isRunning = true
+	this.cancelRun = cancel
+	this.runDone.Add(1)
+	go this.runTest(ctx, run)
+	return run.copy(), nil
+}
+
+//Run returns a snapshot of a run if it is still kept
+func (this *Runner) Run(id RunId) (Run, bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, curRun := range this.runs {
+		if curRun.Id == id {
+			return curRun.copy(), true
+		}
+	}
+	return Run{}, false
+}
+
+//Runs returns snapshots of the kept runs, oldest first
+func (this *Runner) Runs() []Run {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	runs := make([]Run, 0, len(this.runs))
+	for _, curRun := range this.runs {
+		runs = append(runs, curRun.copy())
+	}
+	return runs
+}
+
+//Close cancels any running test and waits for it to stop so that nothing is left throwing machines
+func (this *Runner) Close() error {
+	this.mutex.Lock()
+	if this.cancelRun != nil {
+		this.cancelRun()
+	}
+	this.mutex.Unlock()
+	this.runDone.Wait()
+	return nil
+}
+
+func (this *Runner) runTest(ctx context.Context, run *Run) {
+	defer this.runDone.Done()
+	logger.Info("Self test started", "run", run.Id, "ids", run.Ids)
+	this.sendProgress(Progress{RunId: run.Id, Stage: ProgressRunStarted, PortsTotal: len(run.Ids)})
+	for i, curId := range run.Ids {
+		if ctx.Err() != nil {
+			break
+		}
+		report := this.testPort(ctx, Progress{RunId: run.Id, Stage: ProgressStep, Id: curId, PortsDone: i, PortsTotal: len(run.Ids)})
+		this.mutex.Lock()
+		run.Ports = append(run.Ports, report)
+		this.mutex.Unlock()
+		logger.Info("Self test port complete", "run", run.Id, "id", curId, "result", report.Result, "message", report.Message)
+		this.sendProgress(Progress{RunId: run.Id, Stage: ProgressPortComplete, Id: curId, Message: report.Message, PortsDone: i + 1, PortsTotal: len(run.Ids), Port: &report})
+	}
+	this.mutex.Lock()
+	run.Status = RunStatusComplete
+	if ctx.Err() != nil {
+		run.Status = RunStatusCancelled
+	}
+	run.EndTime = time.Now()
+	portsDone := len(run.Ports)
+	this.isRunning = false
+	this.cancelRun()
+	this.cancelRun = nil
+	this.mutex.Unlock()
+	logger.Info("Self test finished", "run", run.Id, "status", run.Status)
+	this.sendProgress(Progress{RunId: run.Id, Stage: ProgressRunComplete, Message: string(run.Status), PortsDone: portsDone, PortsTotal: len(run.Ids)})
+}
+
+//testPort tests the machine in stepProgress, which is sent with a message as each step starts
+func (this *Runner) testPort(ctx context.Context, stepProgress Progress) (report PortReport) {
+	id := stepProgress.Id
+	report = PortReport{Id: id, Throws: make([]ThrowStep, 0, 2), GPIOs: make([]GPIOStep, 0, numGPIOs), StartTime: time.Now()}
+	defer func() {
+		report.EndTime = time.Now()
+	}()
+	state, err := this.controller.GetSwitchMachineById(id)
+	if err != nil {
+		report.Result = PortResultNotAttached
+		report.Message = "No switch machine is attached"
+		return report
+	}
+	//Throwing to the opposite position first means a working machine has to move for both throws
+	targets := []switchmachine.Position{switchmachine.Position0, switchmachine.Position1}
+	if state.Position() == switchmachine.Position0 {
+		targets = []switchmachine.Position{switchmachine.Position1, switchmachine.Position0}
+	}
+	for _, curTarget := range targets {
+		stepProgress.Message = "Throwing to " + positionName(curTarget)
+		this.sendProgress(stepProgress)
+		step := this.throwTo(ctx, id, curTarget)
+		report.Throws = append(report.Throws, step)
+		if step.Outcome == StepOutcomeError {
+			report.Result = PortResultFail
+			report.Message = step.Message
+			return report
+		}
+	}
+	for gpio := uint(0); gpio < numGPIOs; gpio++ {
+		stepProgress.Message = fmt.Sprintf("Toggling GPIO %d", gpio)
+		this.sendProgress(stepProgress)
+		report.GPIOs = append(report.GPIOs, this.toggleGPIO(ctx, id, gpio))
+	}
+	report.judge()
+	return report
+}
+
+//throwTo throws the machine and watches its feedback until the motor stops or the feedback should have arrived
+func (this *Runner) throwTo(ctx context.Context, id switchmachine.Id, target switchmachine.Position) ThrowStep {
+	step := ThrowStep{Target: target, StartPosition: switchmachine.PositionUnknown, EndPosition: switchmachine.PositionUnknown, Outcome: StepOutcomeError}
+	state, err := this.controller.GetSwitchMachineById(id)
+	if err != nil {
+		step.Message = err.Error()
+		return step
+	}
+	step.StartPosition = state.Position()
+	startTime := time.Now()
+	if err = this.controller.Throw(ctx, id, target); err != nil {
+		step.Message = "Unable to throw: " + err.Error()
+		return step
+	}
+	deadline := startTime.Add(this.controller.MotorRunTime() + this.feedbackGrace)
+	//Travel is only timed once the machine is seen arriving, not while it sits at the target before moving
+	hasLeftTarget := step.StartPosition != target
+	ticker := time.NewTicker(this.pollInterval)
+	defer ticker.Stop()
+	for {
+		state, err = this.controller.GetSwitchMachineById(id)
+		if err != nil {
+			step.Message = "Switch machine went away part way through the throw"
+			return step
+		}
+		if state.Position() != target {
+			hasLeftTarget = true
+		} else if hasLeftTarget && step.TravelTime == 0 {
+			step.TravelTime = time.Since(startTime)
+		}
+		if !switchmachine.IsMotorRunning(state.MotorState()) || time.Now().After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			step.Message = "Self test was cancelled"
+			return step
+		case <-ticker.C:
+		}
+	}
+	step.EndPosition = state.Position()
+	switch {
+	case step.EndPosition == target:
+		step.Outcome = StepOutcomeReached
+	case step.EndPosition == switchmachine.PositionUnknown:
+		step.Outcome = StepOutcomeNoFeedback
+		step.Message = "Feedback never reported either position"
+	case step.EndPosition == step.StartPosition:
+		step.Outcome = StepOutcomeNoMovement
+		step.Message = "Feedback stayed at " + positionName(step.EndPosition)
+	default:
+		step.Outcome = StepOutcomeContradicted
+		step.Message = "Feedback moved to " + positionName(step.EndPosition)
+	}
+	if step.TravelTime > 0 && step.Outcome != StepOutcomeReached {
+		step.Message += " after briefly reporting " + positionName(target)
+	}
+	return step
+}
+
+//toggleGPIO turns the gpio to the opposite of what it is, checks that nothing else about the machine changed and then
+//puts it back
+func (this *Runner) toggleGPIO(ctx context.Context, id switchmachine.Id, gpio uint) GPIOStep {
+	step := GPIOStep{GPIO: gpio}
+	original, err := this.controller.GetSwitchMachineById(id)
+	if err != nil {
+		step.Message = err.Error()
+		return step
+	}
+	toggled := withGPIO(original, gpio, !gpioState(original, gpio))
+	if err = this.controller.UpdateSwitchMachine(ctx, toggled); err != nil {
+		step.Message = "Unable to change: " + err.Error()
+		return step
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(this.gpioSettleTime):
+	}
+	current, err := this.controller.GetSwitchMachineById(id)
+	switch {
+	case err != nil:
+		step.Message = "Switch machine went away after changing it"
+	case gpioState(current, gpio) != gpioState(toggled, gpio):
+		step.Message = "Did not keep its new state"
+	case current.Position() != original.Position():
+		step.Message = "Position changed from " + positionName(original.Position()) + " to " + positionName(current.Position())
+	default:
+		step.Ok = true
+	}
+	//Always try to put it back even if the test failed or was cancelled
+	if err == nil {
+		restoreCtx, cancel := context.WithTimeout(context.Background(), restoreTimeout)
+		defer cancel()
+		if err = this.controller.UpdateSwitchMachine(restoreCtx, withGPIO(current, gpio, gpioState(original, gpio))); err != nil {
+			step.Ok = false
+			step.Message = "Unable to change back: " + err.Error()
+		}
+	}
+	return step
+}
+
+func (this *Runner) sendProgress(p Progress) {
+	if this.progressListener != nil {
+		this.progressListener(p)
+	}
+}
+
+func gpioState(state switchmachine.State, gpio uint) switchmachine.GPIOState {
+	if gpio == 0 {
+		return state.GPIO0State()
+	}
+	return state.GPIO1State()
+}
+
+//withGPIO is state with one gpio changed. The position is kept so the update never starts a throw
+func withGPIO(state switchmachine.State, gpio uint, gpioState switchmachine.GPIOState) switchmachine.State {
+	gpio0, gpio1 := state.GPIO0State(), state.GPIO1State()
+	if gpio == 0 {
+		gpio0 = gpioState
+	} else {
+		gpio1 = gpioState
+	}
+	return switchmachine.NewState(state.Id(), state.Position(), switchmachine.MotorStateIdle, gpio0, gpio1)
+}
@@ -0,0 +1,193 @@
+package selftest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+//Timings are shrunk so a run takes a fraction of a second while still leaving the motor longer than the travel
+const (
+	testPollInterval time.Duration = time.Millisecond * 2
+	testTravelTime   time.Duration = time.Millisecond * 40
+	testMotorRunTime time.Duration = time.Millisecond * 100
+	testRunTimeout   time.Duration = time.Second * 5
+)
+
+func TestWorkingMachinePassesWithTravelTimes(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 1, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	port := runSingleTest(t, runner, 1)
+
+	if port.Result != PortResultPass {
+		t.Fatalf("Expected pass but got %s: %s", port.Result, port.Message)
+	}
+	if len(port.Throws) != 2 || port.Throws[0].Target != switchmachine.Position1 || port.Throws[1].Target != switchmachine.Position0 {
+		t.Fatalf("Expected to throw to position 1 and then back to position 0 but got %+v", port.Throws)
+	}
+	for _, curStep := range port.Throws {
+		if curStep.TravelTime < testTravelTime/2 || curStep.TravelTime > testMotorRunTime {
+			t.Errorf("Expected travel time near %v but got %v", testTravelTime, curStep.TravelTime)
+		}
+	}
+	if len(port.GPIOs) != 2 || !port.GPIOs[0].Ok || !port.GPIOs[1].Ok {
+		t.Errorf("Expected both gpio to pass but got %+v", port.GPIOs)
+	}
+}
+
+func TestGPIOIsPutBackAfterTest(t *testing.T) {
+	runner, c := getRunnerWithSimulatedMachine(t, 2, switchmachine.Position1, tortoise.SimulatedFaultNone)
+	state, _ := c.GetSwitchMachineById(2)
+	c.UpdateSwitchMachine(context.Background(), switchmachine.NewState(2, state.Position(), switchmachine.MotorStateIdle, switchmachine.GPIOOn, switchmachine.GPIOOFF))
+
+	runSingleTest(t, runner, 2)
+
+	state, _ = c.GetSwitchMachineById(2)
+	if state.GPIO0State() != switchmachine.GPIOOn || state.GPIO1State() != switchmachine.GPIOOFF {
+		t.Fail()
+	}
+}
+
+func TestReversedMotorIsReportedAsReversedWiring(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 3, switchmachine.Position0, tortoise.SimulatedFaultReversedMotor)
+
+	port := runSingleTest(t, runner, 3)
+
+	if port.Result != PortResultReversedWiring {
+		t.Fatalf("Expected reversed wiring but got %s: %s", port.Result, port.Message)
+	}
+	if port.Throws[1].Outcome != StepOutcomeContradicted {
+		t.Errorf("Expected throw back to position 0 to be contradicted but got %s", port.Throws[1].Outcome)
+	}
+}
+
+func TestStuckMotorFails(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 4, switchmachine.Position0, tortoise.SimulatedFaultNone, tortoise.FaultKindStuckMotor)
+
+	port := runSingleTest(t, runner, 4)
+
+	if port.Result != PortResultFail || port.Throws[0].Outcome != StepOutcomeNoMovement {
+		t.Fatalf("Expected fail with no movement but got %s: %+v", port.Result, port.Throws)
+	}
+}
+
+func TestNoFeedbackFails(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 5, switchmachine.Position0, tortoise.SimulatedFaultNoFeedback)
+
+	port := runSingleTest(t, runner, 5)
+
+	if port.Result != PortResultFail || port.Throws[0].Outcome != StepOutcomeNoFeedback || port.Throws[1].Outcome != StepOutcomeNoFeedback {
+		t.Fatalf("Expected fail with no feedback but got %s: %+v", port.Result, port.Throws)
+	}
+}
+
+func TestMissingMachineIsNotAttached(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 6, switchmachine.Position0, tortoise.SimulatedFaultNone)
+
+	port := runSingleTest(t, runner, 7)
+
+	if port.Result != PortResultNotAttached {
+		t.Fail()
+	}
+}
+
+func TestStartWhileRunningReturnsErrRunInProgress(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 8, switchmachine.Position0, tortoise.SimulatedFaultNone)
+	if _, err := runner.Start([]switchmachine.Id{8}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Start([]switchmachine.Id{8}); err != ErrRunInProgress {
+		t.Fail()
+	}
+	runner.Close()
+}
+
+func TestCloseCancelsRun(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 9, switchmachine.Position0, tortoise.SimulatedFaultNone)
+	run, _ := runner.Start([]switchmachine.Id{9, 9, 9})
+	runner.Close()
+
+	run, _ = runner.Run(run.Id)
+	if run.Status != RunStatusCancelled || len(run.Ports) == 3 {
+		t.Fail()
+	}
+}
+
+func TestProgressIsSentForEachStageInOrder(t *testing.T) {
+	runner, _ := getRunnerWithSimulatedMachine(t, 10, switchmachine.Position0, tortoise.SimulatedFaultNone)
+	stages := make(chan ProgressStage, 16)
+	runner.SetProgressListenerFunc(func(p Progress) {
+		stages <- p.Stage
+	})
+
+	runSingleTest(t, runner, 10)
+	//The last progress is sent after the run is marked complete so wait for it to be sent
+	runner.Close()
+
+	close(stages)
+	expected := []ProgressStage{ProgressRunStarted, ProgressStep, ProgressStep, ProgressStep, ProgressStep, ProgressPortComplete, ProgressRunComplete}
+	i := 0
+	for curStage := range stages {
+		if i >= len(expected) || curStage != expected[i] {
+			t.Fatalf("Unexpected stage %s at %d", curStage, i)
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Fail()
+	}
+}
+
+func runSingleTest(t *testing.T, runner *Runner, id switchmachine.Id) PortReport {
+	run, err := runner.Start([]switchmachine.Id{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(testRunTimeout)
+	for run.Status == RunStatusRunning {
+		if time.Now().After(deadline) {
+			t.Fatal("Self test did not finish")
+		}
+		time.Sleep(testPollInterval)
+		run, _ = runner.Run(run.Id)
+	}
+	if len(run.Ports) != 1 {
+		t.Fatalf("Expected 1 port report but got %d", len(run.Ports))
+	}
+	return run.Ports[0]
+}
+
+//getRunnerWithSimulatedMachine injects each of faultKinds into the switch machine as well as setting the simulated fault
+func getRunnerWithSimulatedMachine(t *testing.T, id switchmachine.Id, pos switchmachine.Position, fault tortoise.SimulatedFault, faultKinds ...tortoise.FaultKind) (*Runner, controller.TortoiseController) {
+	sim := tortoise.NewSimulatorTortoiseControllerDriver(testPollInterval, testTravelTime)
+	c := controller.NewTortoiseControllerWithMotorRunTime(sim, testMotorRunTime)
+	t.Cleanup(func() {
+		sim.Close()
+	})
+	sim.AttachMachine(id, pos)
+	sim.SetFault(id, fault)
+	for _, curKind := range faultKinds {
+		if _, err := sim.ScheduleFault(tortoise.Fault{Kind: curKind, SMId: &id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(testRunTimeout)
+	for _, err := c.GetSwitchMachineById(id); err != nil; _, err = c.GetSwitchMachineById(id) {
+		if time.Now().After(deadline) {
+			t.Fatal("Simulated machine was never attached")
+		}
+		time.Sleep(testPollInterval)
+	}
+	runner := NewRunner(c)
+	runner.pollInterval = testPollInterval
+	runner.feedbackGrace = testMotorRunTime
+	runner.gpioSettleTime = testPollInterval * 5
+	t.Cleanup(func() {
+		runner.Close()
+	})
+	return runner, c
+}
@@ -34,6 +34,9 @@ type State interface {
 	GPIO0State() GPIOState
 	GPIO1State() GPIOState
 	UpdateTime() time.Time
+	//Version goes up every time the stored state of the switch machine changes. It is 0 for states that were never
+	//stored, which updates treat as not caring what version the switch machine is at
+	Version() uint64
 }
 
 type switchMachineStateImpl struct {
@@ -42,6 +45,7 @@ type switchMachineStateImpl struct {
 	motor        MotorState
 	gpio0, gpio1 GPIOState
 	updateTime   time.Time
+	version      uint64
 }
 
 func NewState(id Id, pos Position, m MotorState, g0, g1 GPIOState) State {
@@ -55,6 +59,19 @@ func NewState(id Id, pos Position, m MotorState, g0, g1 GPIOState) State {
 	return s
 }
 
+//WithVersion copies state with version, keeping its update time
+func WithVersion(state State, version uint64) State {
+	return &switchMachineStateImpl{
+		id:         state.Id(),
+		pos:        state.Position(),
+		motor:      state.MotorState(),
+		gpio0:      state.GPIO0State(),
+		gpio1:      state.GPIO1State(),
+		updateTime: state.UpdateTime(),
+		version:    version,
+	}
+}
+
 func (this *switchMachineStateImpl) Id() Id {
 	return this.id
 }
@@ -79,6 +96,10 @@ func (this *switchMachineStateImpl) UpdateTime() time.Time {
 	return this.updateTime
 }
 
+func (this *switchMachineStateImpl) Version() uint64 {
+	return this.version
+}
+
 func StatesEqual(sm1, sm2 State) bool {
 	return sm1.Id() == sm2.Id() &&
 		sm1.GPIO0State() == sm2.GPIO0State() &&
@@ -87,6 +108,23 @@ func StatesEqual(sm1, sm2 State) bool {
 		sm1.Position() == sm2.Position()
 }
 
+//IsMotorRunning is whether m drives the motor towards a position. Brake and idle both leave it stopped
+func IsMotorRunning(m MotorState) bool {
+	return m == MotorStateToPos0 || m == MotorStateToPos1
+}
+
+//TargetPosition is where the switch machine is heading while its motor is running and where it is otherwise
+func TargetPosition(state State) Position {
+	switch state.MotorState() {
+	case MotorStateToPos0:
+		return Position0
+	case MotorStateToPos1:
+		return Position1
+	default:
+		return state.Position()
+	}
+}
+
 //----------------------------------- Printing functions for convience
 func StateToString(state State) string {
 	if state == nil {
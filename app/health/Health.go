@@ -0,0 +1,124 @@
+package health
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/smdsconfig"
+)
+
+const (
+	//MaxLoopHeartbeatAge is how old a driver loop heartbeat can get before the loop is considered stuck. It allows
+	//for a few missed heartbeats as the loop can be busy with a slow bus transaction
+	MaxLoopHeartbeatAge time.Duration = 5 * hardware.LoopHeartbeatInterval
+
+	CheckLoopHeartbeat string = "loopHeartbeat"
+	CheckBusOpen       string = "busOpen"
+	CheckBusRead       string = "busRead"
+	CheckConfigLoaded  string = "configLoaded"
+)
+
+//Check is the result of one thing that was checked. Mount is empty for checks that aren't about a driver mount
+type Check struct {
+	Name    string
+	Mount   string
+	Ok      bool
+	Message string
+}
+
+//Report is the result of every check along with the health of each mount they were based on
+type Report struct {
+	Ok     bool
+	Checks []Check
+	Mounts []composite.MountHealth
+}
+
+func (this *Report) add(check Check) {
+	this.Checks = append(this.Checks, check)
+	this.Ok = this.Ok && check.Ok
+}
+
+//Checker works out whether the server is alive and whether it is ready to drive switch machines
+type Checker struct {
+	driver         composite.CompositeDriver
+	isConfigLoaded func() bool
+	now            func() time.Time
+}
+
+func NewChecker(driver composite.CompositeDriver) *Checker {
+	return &Checker{driver: driver, isConfigLoaded: smdsconfig.IsLoaded, now: time.Now}
+}
+
+//Liveness checks that the loop of every driver mount is still running. A failure means the server needs restarting
+func (this *Checker) Liveness() Report {
+	report := Report{Ok: true, Checks: make([]Check, 0), Mounts: this.driver.MountHealth()}
+	for _, curMount := range report.Mounts {
+		if curMount.Reported {
+			report.add(this.checkLoopHeartbeat(curMount))
+		}
+	}
+	return report
+}
+
+//Readiness checks that the config is loaded and that every driver mount has its bus open, its loop running and its
+//last bus transaction succeeding after at least one successful read. Mounts that don't report their health are
+//assumed to be ready
+func (this *Checker) Readiness() Report {
+	report := Report{Ok: true, Checks: make([]Check, 0), Mounts: this.driver.MountHealth()}
+	configCheck := Check{Name: CheckConfigLoaded, Ok: this.isConfigLoaded(), Message: "Config is loaded"}
+	if !configCheck.Ok {
+		configCheck.Message = "Config has not been loaded"
+	}
+	report.add(configCheck)
+	for _, curMount := range report.Mounts {
+		if !curMount.Reported {
+			continue
+		}
+		report.add(checkBusOpen(curMount))
+		report.add(this.checkLoopHeartbeat(curMount))
+		report.add(this.checkBusRead(curMount))
+	}
+	return report
+}
+
+func (this *Checker) checkLoopHeartbeat(mount composite.MountHealth) Check {
+	check := Check{Name: CheckLoopHeartbeat, Mount: mount.Name}
+	lastLoopTime := mount.Health.LastLoopTime
+	if lastLoopTime.IsZero() {
+		check.Message = "Driver loop has not started"
+		return check
+	}
+	age := this.now().Sub(lastLoopTime)
+	check.Ok = age <= MaxLoopHeartbeatAge
+	if check.Ok {
+		check.Message = fmt.Sprintf("Last heartbeat %v ago", age.Round(time.Millisecond))
+	} else {
+		check.Message = fmt.Sprintf("No heartbeat for %v, the driver loop is stuck", age.Round(time.Millisecond))
+	}
+	return check
+}
+
+func checkBusOpen(mount composite.MountHealth) Check {
+	check := Check{Name: CheckBusOpen, Mount: mount.Name, Ok: mount.Health.Running, Message: "Bus is open"}
+	if !check.Ok {
+		check.Message = "Driver is not running so its bus is not open"
+	}
+	return check
+}
+
+func (this *Checker) checkBusRead(mount composite.MountHealth) Check {
+	check := Check{Name: CheckBusRead, Mount: mount.Name}
+	health := mount.Health
+	switch {
+	case health.LastError != nil:
+		check.Message = "Last bus transaction failed: " + health.LastError.Error()
+	case health.LastBusReadTime.IsZero():
+		check.Message = "Bus has not been read yet"
+	default:
+		check.Ok = true
+		check.Message = fmt.Sprintf("Last read %v ago", this.now().Sub(health.LastBusReadTime).Round(time.Millisecond))
+	}
+	return check
+}
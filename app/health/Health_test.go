@@ -0,0 +1,168 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/composite"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/switchmachine"
+)
+
+var testNow = time.Unix(10000, 0)
+
+type healthyDriver struct {
+	health hardware.DriverHealth
+}
+
+func (this *healthyDriver) Start(hardware.DriverEventListener) {}
+
+func (this *healthyDriver) UpdateSwitchMachine(context.Context, switchmachine.State) error {
+	return nil
+}
+
+func (this *healthyDriver) Close() error {
+	return nil
+}
+
+func (this *healthyDriver) Health() hardware.DriverHealth {
+	return this.health
+}
+
+//silentDriver doesn't report its health
+type silentDriver struct{}
+
+func (this *silentDriver) Start(hardware.DriverEventListener) {}
+
+func (this *silentDriver) UpdateSwitchMachine(context.Context, switchmachine.State) error {
+	return nil
+}
+
+func (this *silentDriver) Close() error {
+	return nil
+}
+
+func newTestChecker(t *testing.T, health hardware.DriverHealth) *Checker {
+	driver, err := composite.NewCompositeDriver(
+		composite.Mount{Name: "main", IdOffset: 0, NumIds: 32, Driver: &healthyDriver{health: health}},
+		composite.Mount{Name: "silent", IdOffset: 32, NumIds: 32, Driver: &silentDriver{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := NewChecker(driver)
+	checker.now = func() time.Time {
+		return testNow
+	}
+	checker.isConfigLoaded = func() bool {
+		return true
+	}
+	return checker
+}
+
+func goodHealth() hardware.DriverHealth {
+	return hardware.DriverHealth{
+		Healthy:         true,
+		Running:         true,
+		LastLoopTime:    testNow.Add(-hardware.LoopHeartbeatInterval),
+		LastBusReadTime: testNow.Add(-10 * time.Millisecond),
+	}
+}
+
+func findCheck(report Report, name string) (Check, bool) {
+	for _, curCheck := range report.Checks {
+		if curCheck.Name == name {
+			return curCheck, true
+		}
+	}
+	return Check{}, false
+}
+
+func TestHealthyDriverIsAliveAndReady(t *testing.T) {
+	checker := newTestChecker(t, goodHealth())
+
+	if liveness := checker.Liveness(); !liveness.Ok || len(liveness.Checks) != 1 {
+		t.Errorf("Expected a single passing liveness check but got %+v", liveness.Checks)
+	}
+	readiness := checker.Readiness()
+	if !readiness.Ok {
+		t.Errorf("Expected to be ready but got %+v", readiness.Checks)
+	}
+	//Config plus three checks for the mount that reports its health
+	if len(readiness.Checks) != 4 || len(readiness.Mounts) != 2 {
+		t.Errorf("Expected 4 checks over 2 mounts but got %d checks over %d mounts", len(readiness.Checks), len(readiness.Mounts))
+	}
+}
+
+func TestStaleHeartbeatFailsLivenessAndReadiness(t *testing.T) {
+	health := goodHealth()
+	health.LastLoopTime = testNow.Add(-MaxLoopHeartbeatAge - time.Millisecond)
+	checker := newTestChecker(t, health)
+
+	liveness := checker.Liveness()
+	if liveness.Ok {
+		t.Error("Expected a stuck loop to not be alive")
+	}
+	if check, _ := findCheck(liveness, CheckLoopHeartbeat); check.Ok || check.Mount != "main" {
+		t.Errorf("Expected failed heartbeat check for main but got %+v", check)
+	}
+	if checker.Readiness().Ok {
+		t.Error("Expected a stuck loop to not be ready")
+	}
+}
+
+func TestLoopThatHasNotStartedIsNotAlive(t *testing.T) {
+	health := goodHealth()
+	health.LastLoopTime = time.Time{}
+	checker := newTestChecker(t, health)
+
+	if checker.Liveness().Ok {
+		t.Error("Expected a loop that hasn't started to not be alive")
+	}
+}
+
+func TestBusErrorIsAliveButNotReady(t *testing.T) {
+	health := goodHealth()
+	health.LastError = errors.New("spi fault")
+	checker := newTestChecker(t, health)
+
+	if !checker.Liveness().Ok {
+		t.Error("Expected a bus error to not affect liveness")
+	}
+	readiness := checker.Readiness()
+	if check, _ := findCheck(readiness, CheckBusRead); readiness.Ok || check.Ok {
+		t.Errorf("Expected failed bus read check but got %+v", check)
+	}
+}
+
+func TestNoBusReadYetIsNotReady(t *testing.T) {
+	health := goodHealth()
+	health.LastBusReadTime = time.Time{}
+	checker := newTestChecker(t, health)
+
+	if check, _ := findCheck(checker.Readiness(), CheckBusRead); check.Ok {
+		t.Errorf("Expected failed bus read check but got %+v", check)
+	}
+}
+
+func TestClosedDriverIsNotReady(t *testing.T) {
+	health := goodHealth()
+	health.Running = false
+	checker := newTestChecker(t, health)
+
+	if check, _ := findCheck(checker.Readiness(), CheckBusOpen); check.Ok {
+		t.Errorf("Expected failed bus open check but got %+v", check)
+	}
+}
+
+func TestConfigNotLoadedIsNotReady(t *testing.T) {
+	checker := newTestChecker(t, goodHealth())
+	checker.isConfigLoaded = func() bool {
+		return false
+	}
+
+	if check, _ := findCheck(checker.Readiness(), CheckConfigLoaded); check.Ok {
+		t.Errorf("Expected failed config check but got %+v", check)
+	}
+}
@@ -0,0 +1,108 @@
+package health
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+//Messages understood by systemd, see sd_notify(3)
+const (
+	NotifyReady    string = "READY=1"
+	NotifyStopping string = "STOPPING=1"
+	NotifyWatchdog string = "WATCHDOG=1"
+
+	notifySocketEnvName string = "NOTIFY_SOCKET"
+	watchdogUsecEnvName string = "WATCHDOG_USEC"
+	watchdogPidEnvName  string = "WATCHDOG_PID"
+)
+
+var logger = logging.ForSubsystem(logging.SubsystemApi)
+
+var errNoNotifySocket = errors.New("Not run by systemd as there is no " + notifySocketEnvName)
+
+//Notifier sends sd_notify messages to systemd over the socket it gave the server
+type Notifier struct {
+	socketAddr *net.UnixAddr
+}
+
+//NewNotifierFromEnvironment returns an error when the server was not started by systemd as a notify service
+func NewNotifierFromEnvironment() (*Notifier, error) {
+	socketPath := os.Getenv(notifySocketEnvName)
+	if socketPath == "" {
+		return nil, errNoNotifySocket
+	}
+	//Abstract sockets are given with a leading @ in place of the null byte
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+	return &Notifier{socketAddr: &net.UnixAddr{Name: socketPath, Net: "unixgram"}}, nil
+}
+
+func (this *Notifier) Notify(state string) error {
+	conn, err := net.DialUnix(this.socketAddr.Net, nil, this.socketAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+//WatchdogInterval returns how often systemd expects to hear from the server, which is 0 when it isn't watching
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(watchdogUsecEnvName), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	//The watchdog may be meant for another process, such as when the server is started by a script
+	if pid := os.Getenv(watchdogPidEnvName); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+//RunWatchdog feeds the systemd watchdog at half of interval for as long as checker finds the server alive, so systemd
+//restarts the server if a driver loop gets stuck. It returns once stop is closed
+func RunWatchdog(notifier *Notifier, checker *Checker, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	wasAlive := true
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		report := checker.Liveness()
+		if !report.Ok {
+			if wasAlive {
+				logger.Error("Server is not alive, no longer feeding the systemd watchdog", "checks", failedChecks(report))
+			}
+			wasAlive = false
+			continue
+		}
+		if !wasAlive {
+			logger.Info("Server is alive again, feeding the systemd watchdog")
+		}
+		wasAlive = true
+		if err := notifier.Notify(NotifyWatchdog); err != nil {
+			logger.Warn("Unable to feed the systemd watchdog", "err", err)
+		}
+	}
+}
+
+func failedChecks(report Report) string {
+	messages := make([]string, 0)
+	for _, curCheck := range report.Checks {
+		if !curCheck.Ok {
+			messages = append(messages, curCheck.Mount+": "+curCheck.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
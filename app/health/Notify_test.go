@@ -0,0 +1,101 @@
+package health
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func listenForNotify(t *testing.T) *net.UnixConn {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	t.Setenv(notifySocketEnvName, socketPath)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifierSendsToSocketFromEnvironment(t *testing.T) {
+	conn := listenForNotify(t)
+	notifier, err := NewNotifierFromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = notifier.Notify(NotifyReady); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := readNotify(t, conn); msg != NotifyReady {
+		t.Errorf("Expected %q but got %q", NotifyReady, msg)
+	}
+}
+
+func TestNoNotifierWithoutSocket(t *testing.T) {
+	t.Setenv(notifySocketEnvName, "")
+	if _, err := NewNotifierFromEnvironment(); err == nil {
+		t.Error("Expected an error when not run by systemd")
+	}
+}
+
+func TestWatchdogIntervalIsOnlyForThisProcess(t *testing.T) {
+	t.Setenv(watchdogUsecEnvName, "3000000")
+	t.Setenv(watchdogPidEnvName, strconv.Itoa(os.Getpid()))
+	if interval := WatchdogInterval(); interval != 3*time.Second {
+		t.Errorf("Expected 3s but got %v", interval)
+	}
+
+	t.Setenv(watchdogPidEnvName, strconv.Itoa(os.Getpid()+1))
+	if interval := WatchdogInterval(); interval != 0 {
+		t.Errorf("Expected no watchdog for another process but got %v", interval)
+	}
+}
+
+func TestWatchdogIsOnlyFedWhileAlive(t *testing.T) {
+	conn := listenForNotify(t)
+	notifier, _ := NewNotifierFromEnvironment()
+	health := goodHealth()
+	checker := newTestChecker(t, health)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(notifier, checker, 20*time.Millisecond, stop)
+		close(done)
+	}()
+
+	if msg := readNotify(t, conn); msg != NotifyWatchdog {
+		t.Errorf("Expected %q but got %q", NotifyWatchdog, msg)
+	}
+	close(stop)
+	<-done
+
+	//A fresh socket so nothing sent while alive is read back
+	conn = listenForNotify(t)
+	notifier, _ = NewNotifierFromEnvironment()
+	health.LastLoopTime = testNow.Add(-2 * MaxLoopHeartbeatAge)
+	stop = make(chan struct{})
+	go RunWatchdog(notifier, newTestChecker(t, health), 20*time.Millisecond, stop)
+	defer close(stop)
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, err := conn.Read(buf); err == nil {
+		t.Errorf("Expected watchdog not to be fed while stuck but got %q", buf[:n])
+	}
+}
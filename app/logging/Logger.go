@@ -0,0 +1,331 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (this Level) String() string {
+	if this < LevelDebug || this > LevelError {
+		return "level(" + strconv.Itoa(int(this)) + ")"
+	}
+	return levelNames[this]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, curName := range levelNames {
+		if strings.EqualFold(name, curName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("%q is not a log level, use one of %s", name, strings.Join(levelNames, ", "))
+}
+
+type Format string
+
+const (
+	FormatText Format = "text"
+	//FormatJSON writes one JSON object per line for log shippers
+	FormatJSON Format = "json"
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return FormatText, fmt.Errorf("%q is not a log format, use %s or %s", name, FormatText, FormatJSON)
+}
+
+//Subsystems each have their own level so one noisy part of the server can be turned up without the rest
+const (
+	SubsystemApi         string = "api"
+	SubsystemConfig      string = "config"
+	SubsystemController  string = "controller"
+	SubsystemHardware    string = "hardware"
+	SubsystemPersistance string = "persistance"
+
+	//Used for values that were logged without a key
+	badKey string = "!BADKEY"
+)
+
+var ErrUnknownSubsystem = errors.New("Unknown logging subsystem")
+
+//Logger logs a message along with pairs of keys and values
+type Logger interface {
+	Debug(msg string, keyVals ...interface{})
+	Info(msg string, keyVals ...interface{})
+	Warn(msg string, keyVals ...interface{})
+	Error(msg string, keyVals ...interface{})
+	//With returns a logger that adds keyVals to everything it logs
+	With(keyVals ...interface{}) Logger
+	//Enabled is for skipping work that is only needed to build a log message
+	Enabled(level Level) bool
+}
+
+type subsystem struct {
+	name string
+	//Read atomically on every log call
+	level int32
+	//Guarded by registryMutex. Subsystems without their own level follow the default
+	hasOwnLevel bool
+}
+
+var (
+	registryMutex sync.Mutex
+	subsystems    = newSubsystems(SubsystemApi, SubsystemConfig, SubsystemController, SubsystemHardware, SubsystemPersistance)
+	defaultLevel  = LevelInfo
+
+	outputMutex sync.Mutex
+	output      io.Writer = os.Stderr
+	format                = FormatText
+	now                   = time.Now
+)
+
+func newSubsystems(names ...string) map[string]*subsystem {
+	newSubsystems := make(map[string]*subsystem, len(names))
+	for _, curName := range names {
+		newSubsystems[curName] = &subsystem{name: curName, level: int32(LevelInfo)}
+	}
+	return newSubsystems
+}
+
+//ForSubsystem returns the logger for one of the Subsystem names
+func ForSubsystem(name string) Logger {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	s, isKnown := subsystems[name]
+	if !isKnown {
+		panic(fmt.Sprintf("%v %q", ErrUnknownSubsystem, name))
+	}
+	return &subsystemLogger{subsystem: s}
+}
+
+func IsSubsystem(name string) bool {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	_, isKnown := subsystems[name]
+	return isKnown
+}
+
+//Configure replaces every level and the format. Subsystems missing from levels follow the default level
+func Configure(newFormat Format, newDefaultLevel Level, levels map[string]Level) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for name := range levels {
+		if _, isKnown := subsystems[name]; !isKnown {
+			return fmt.Errorf("%w %q", ErrUnknownSubsystem, name)
+		}
+	}
+	defaultLevel = newDefaultLevel
+	for name, s := range subsystems {
+		level, hasOwnLevel := levels[name]
+		if !hasOwnLevel {
+			level = newDefaultLevel
+		}
+		s.hasOwnLevel = hasOwnLevel
+		atomic.StoreInt32(&s.level, int32(level))
+	}
+	outputMutex.Lock()
+	format = newFormat
+	outputMutex.Unlock()
+	return nil
+}
+
+//SetLevel changes the level of one subsystem until the next Configure
+func SetLevel(name string, level Level) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	s, isKnown := subsystems[name]
+	if !isKnown {
+		return fmt.Errorf("%w %q", ErrUnknownSubsystem, name)
+	}
+	s.hasOwnLevel = true
+	atomic.StoreInt32(&s.level, int32(level))
+	return nil
+}
+
+//SetDefaultLevel changes the level of every subsystem that doesn't have its own
+func SetDefaultLevel(level Level) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	defaultLevel = level
+	for _, s := range subsystems {
+		if !s.hasOwnLevel {
+			atomic.StoreInt32(&s.level, int32(level))
+		}
+	}
+}
+
+func DefaultLevel() Level {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	return defaultLevel
+}
+
+//Levels returns the level each subsystem is logging at
+func Levels() map[string]Level {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	levels := make(map[string]Level, len(subsystems))
+	for name, s := range subsystems {
+		levels[name] = Level(atomic.LoadInt32(&s.level))
+	}
+	return levels
+}
+
+func CurrentFormat() Format {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	return format
+}
+
+//SetOutput changes where every logger writes to, os.Stderr by default
+func SetOutput(w io.Writer) {
+	outputMutex.Lock()
+	output = w
+	outputMutex.Unlock()
+}
+
+type subsystemLogger struct {
+	subsystem *subsystem
+	keyVals   []interface{}
+}
+
+func (this *subsystemLogger) Debug(msg string, keyVals ...interface{}) {
+	this.log(LevelDebug, msg, keyVals)
+}
+
+func (this *subsystemLogger) Info(msg string, keyVals ...interface{}) {
+	this.log(LevelInfo, msg, keyVals)
+}
+
+func (this *subsystemLogger) Warn(msg string, keyVals ...interface{}) {
+	this.log(LevelWarn, msg, keyVals)
+}
+
+func (this *subsystemLogger) Error(msg string, keyVals ...interface{}) {
+	this.log(LevelError, msg, keyVals)
+}
+
+func (this *subsystemLogger) With(keyVals ...interface{}) Logger {
+	allKeyVals := make([]interface{}, 0, len(this.keyVals)+len(keyVals))
+	allKeyVals = append(allKeyVals, this.keyVals...)
+	return &subsystemLogger{subsystem: this.subsystem, keyVals: append(allKeyVals, keyVals...)}
+}
+
+func (this *subsystemLogger) Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&this.subsystem.level))
+}
+
+func (this *subsystemLogger) log(level Level, msg string, keyVals []interface{}) {
+	if !this.Enabled(level) {
+		return
+	}
+	fields := make([]field, 0, 4+(len(this.keyVals)+len(keyVals)+1)/2)
+	fields = append(fields,
+		field{"time", now().UTC().Format(time.RFC3339Nano)},
+		field{"level", strings.ToUpper(level.String())},
+		field{"subsystem", this.subsystem.name},
+		field{"msg", msg})
+	fields = appendKeyVals(fields, this.keyVals)
+	fields = appendKeyVals(fields, keyVals)
+
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if format == FormatJSON {
+		output.Write(jsonLine(fields))
+	} else {
+		output.Write(textLine(fields))
+	}
+}
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+func appendKeyVals(fields []field, keyVals []interface{}) []field {
+	for i := 0; i < len(keyVals); i += 2 {
+		if i+1 == len(keyVals) {
+			fields = append(fields, field{badKey, keyVals[i]})
+		} else {
+			fields = append(fields, field{fmt.Sprint(keyVals[i]), keyVals[i+1]})
+		}
+	}
+	return fields
+}
+
+//plainValue turns values that would otherwise log badly into strings
+func plainValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		return hex.EncodeToString(v)
+	}
+	return value
+}
+
+func textLine(fields []field) []byte {
+	line := &bytes.Buffer{}
+	for i, curField := range fields {
+		if i > 0 {
+			line.WriteByte(' ')
+		}
+		line.WriteString(curField.key)
+		line.WriteByte('=')
+		value := fmt.Sprint(plainValue(curField.value))
+		if value == "" || strings.ContainsAny(value, " =\"\n\t") {
+			value = strconv.Quote(value)
+		}
+		line.WriteString(value)
+	}
+	line.WriteByte('\n')
+	return line.Bytes()
+}
+
+func jsonLine(fields []field) []byte {
+	line := &bytes.Buffer{}
+	line.WriteByte('{')
+	for i, curField := range fields {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(curField.key)
+		line.Write(key)
+		line.WriteByte(':')
+		value, err := json.Marshal(plainValue(curField.value))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(curField.value))
+		}
+		line.Write(value)
+	}
+	line.WriteString("}\n")
+	return line.Bytes()
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func setupTestOutput(t *testing.T, testFormat Format) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	SetOutput(buf)
+	now = func() time.Time {
+		return time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	}
+	Configure(testFormat, LevelInfo, nil)
+	return buf
+}
+
+func TestTextLineHasKeyValues(t *testing.T) {
+	buf := setupTestOutput(t, FormatText)
+
+	ForSubsystem(SubsystemHardware).Info("Error writing to bus", "err", errors.New("bus gone"), "tx", []byte{0x0a, 0xff})
+
+	expected := `time=2022-05-01T12:00:00Z level=INFO subsystem=hardware msg="Error writing to bus" err="bus gone" tx=0aff` + "\n"
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+}
+
+func TestJSONLineIsOneObject(t *testing.T) {
+	buf := setupTestOutput(t, FormatJSON)
+
+	ForSubsystem(SubsystemApi).With("mount", "main").Warn("Odd", "id", 5, "dangling")
+
+	line := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["level"] != "WARN" || line["subsystem"] != "api" || line["msg"] != "Odd" || line["mount"] != "main" ||
+		line["id"] != float64(5) || line[badKey] != "dangling" {
+		t.Error(buf.String())
+	}
+}
+
+func TestLevelsFilterPerSubsystem(t *testing.T) {
+	buf := setupTestOutput(t, FormatText)
+	SetLevel(SubsystemHardware, LevelDebug)
+
+	ForSubsystem(SubsystemHardware).Debug("shown")
+	ForSubsystem(SubsystemApi).Debug("hidden")
+
+	if !bytes.Contains(buf.Bytes(), []byte("shown")) || bytes.Contains(buf.Bytes(), []byte("hidden")) {
+		t.Error(buf.String())
+	}
+}
+
+func TestSetDefaultLevelLeavesSubsystemsWithTheirOwnLevel(t *testing.T) {
+	setupTestOutput(t, FormatText)
+	SetLevel(SubsystemHardware, LevelDebug)
+
+	SetDefaultLevel(LevelError)
+
+	levels := Levels()
+	if levels[SubsystemHardware] != LevelDebug || levels[SubsystemApi] != LevelError {
+		t.Fail()
+	}
+}
+
+func TestConfigureResetsLevelsAndRejectsUnknownSubsystems(t *testing.T) {
+	setupTestOutput(t, FormatText)
+	SetLevel(SubsystemHardware, LevelDebug)
+
+	Configure(FormatText, LevelWarn, map[string]Level{SubsystemApi: LevelError})
+	err := Configure(FormatJSON, LevelDebug, map[string]Level{"nope": LevelError})
+
+	levels := Levels()
+	if levels[SubsystemHardware] != LevelWarn || levels[SubsystemApi] != LevelError ||
+		!errors.Is(err, ErrUnknownSubsystem) || CurrentFormat() != FormatText {
+		t.Fail()
+	}
+}
+
+func TestParseLevelIgnoresCase(t *testing.T) {
+	level, err := ParseLevel("DEBUG")
+	_, badErr := ParseLevel("loud")
+
+	if err != nil || level != LevelDebug || badErr == nil {
+		t.Fail()
+	}
+}
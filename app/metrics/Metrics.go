@@ -0,0 +1,397 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//ContentType is the Prometheus text exposition format that WriteText produces
+	ContentType string = "text/plain; version=0.0.4; charset=utf-8"
+
+	typeCounter   string = "counter"
+	typeGauge     string = "gauge"
+	typeHistogram string = "histogram"
+
+	//Separates label values in a child's key as it can't appear in a valid label value
+	labelValueSeparator string = "\xff"
+
+	duplicateMetricErrorMessage   string = "Metric %s is already registered"
+	wrongLabelCountErrorMessage   string = "Metric %s has %d labels but was given %d values"
+	invalidMetricNameErrorMessage string = "Metric name %q is not valid"
+)
+
+//DefaultDurationBuckets suit things measured in seconds that usually take milliseconds
+var DefaultDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+//Registry holds metric families so they can all be written out together
+type Registry struct {
+	mutex    sync.Mutex
+	families map[string]*family
+}
+
+//Default is the registry the package level constructors register with and that the server exposes
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+//family is every child of one metric, one per set of label values
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	newChild   func() child
+	mutex      sync.RWMutex
+	children   map[string]child
+}
+
+type child interface {
+	writeSamples(w *bufio.Writer, name string, labels string)
+}
+
+func (this *Registry) register(name, help, metricType string, labelNames []string, newChild func() child) *family {
+	if !isValidName(name) {
+		panic(fmt.Sprintf(invalidMetricNameErrorMessage, name))
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if _, isRegistered := this.families[name]; isRegistered {
+		panic(fmt.Sprintf(duplicateMetricErrorMessage, name))
+	}
+	f := &family{name: name, help: help, metricType: metricType, labelNames: labelNames, newChild: newChild, children: make(map[string]child)}
+	this.families[name] = f
+	return f
+}
+
+func (this *family) with(labelValues []string) child {
+	if len(labelValues) != len(this.labelNames) {
+		panic(fmt.Sprintf(wrongLabelCountErrorMessage, this.name, len(this.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, labelValueSeparator)
+	this.mutex.RLock()
+	c, exists := this.children[key]
+	this.mutex.RUnlock()
+	if exists {
+		return c
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if c, exists = this.children[key]; !exists {
+		c = this.newChild()
+		this.children[key] = c
+	}
+	return c
+}
+
+//WriteText writes every metric in the Prometheus text exposition format, sorted so the output is stable
+func (this *Registry) WriteText(w io.Writer) error {
+	this.mutex.Lock()
+	families := make([]*family, 0, len(this.families))
+	for _, curFamily := range this.families {
+		families = append(families, curFamily)
+	}
+	this.mutex.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	bufW := bufio.NewWriter(w)
+	for _, curFamily := range families {
+		curFamily.writeText(bufW)
+	}
+	return bufW.Flush()
+}
+
+func (this *family) writeText(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", this.name, escapeHelp(this.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", this.name, this.metricType)
+	this.mutex.RLock()
+	keys := make([]string, 0, len(this.children))
+	for key := range this.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		this.children[key].writeSamples(w, this.name, formatLabels(this.labelNames, key))
+	}
+	this.mutex.RUnlock()
+}
+
+//formatLabels returns the labels ready to go between braces, empty when there are none
+func formatLabels(labelNames []string, key string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	labelValues := strings.Split(key, labelValueSeparator)
+	pairs := make([]string, len(labelNames))
+	for i, name := range labelNames {
+		pairs[i] = name + `="` + escapeLabelValue(labelValues[i]) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func isValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		isLetter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || r == ':'
+		if !isLetter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+//atomicFloat is a float64 that can be changed from many goroutines without a lock
+type atomicFloat struct {
+	bits uint64
+}
+
+func (this *atomicFloat) add(delta float64) {
+	for {
+		oldBits := atomic.LoadUint64(&this.bits)
+		newBits := math.Float64bits(math.Float64frombits(oldBits) + delta)
+		if atomic.CompareAndSwapUint64(&this.bits, oldBits, newBits) {
+			return
+		}
+	}
+}
+
+func (this *atomicFloat) set(value float64) {
+	atomic.StoreUint64(&this.bits, math.Float64bits(value))
+}
+
+func (this *atomicFloat) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&this.bits))
+}
+
+//---------------------------------- counter ----------------------------------
+
+//Counter only ever goes up
+type Counter struct {
+	value atomicFloat
+}
+
+func (this *Counter) Inc() {
+	this.value.add(1)
+}
+
+//Add ignores negative values as counters can't go down
+func (this *Counter) Add(delta float64) {
+	if delta > 0 {
+		this.value.add(delta)
+	}
+}
+
+func (this *Counter) Value() float64 {
+	return this.value.get()
+}
+
+func (this *Counter) writeSamples(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, this.Value())
+}
+
+type CounterVec struct {
+	family *family
+}
+
+func (this *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{family: this.register(name, help, typeCounter, labelNames, func() child {
+		return &Counter{}
+	})}
+}
+
+//With returns the counter for labelValues, given in the same order as the label names
+func (this *CounterVec) With(labelValues ...string) *Counter {
+	return this.family.with(labelValues).(*Counter)
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labelNames...)
+}
+
+func NewCounter(name, help string) *Counter {
+	return Default.NewCounterVec(name, help).With()
+}
+
+//---------------------------------- gauge ----------------------------------
+
+//Gauge can go up and down
+type Gauge struct {
+	value atomicFloat
+}
+
+func (this *Gauge) Set(value float64) {
+	this.value.set(value)
+}
+
+func (this *Gauge) Add(delta float64) {
+	this.value.add(delta)
+}
+
+func (this *Gauge) Inc() {
+	this.value.add(1)
+}
+
+func (this *Gauge) Dec() {
+	this.value.add(-1)
+}
+
+func (this *Gauge) Value() float64 {
+	return this.value.get()
+}
+
+func (this *Gauge) writeSamples(w *bufio.Writer, name, labels string) {
+	writeSample(w, name, labels, this.Value())
+}
+
+type GaugeVec struct {
+	family *family
+}
+
+func (this *Registry) NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{family: this.register(name, help, typeGauge, labelNames, func() child {
+		return &Gauge{}
+	})}
+}
+
+func (this *GaugeVec) With(labelValues ...string) *Gauge {
+	return this.family.with(labelValues).(*Gauge)
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labelNames...)
+}
+
+func NewGauge(name, help string) *Gauge {
+	return Default.NewGaugeVec(name, help).With()
+}
+
+//---------------------------------- histogram ----------------------------------
+
+//Histogram counts observations into buckets by their upper bound
+type Histogram struct {
+	mutex        sync.Mutex
+	upperBounds  []float64
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+func (this *Histogram) Observe(value float64) {
+	//Buckets are inclusive of their upper bound
+	bucket := sort.SearchFloat64s(this.upperBounds, value)
+	this.mutex.Lock()
+	if bucket < len(this.bucketCounts) {
+		this.bucketCounts[bucket]++
+	}
+	this.count++
+	this.sum += value
+	this.mutex.Unlock()
+}
+
+func (this *Histogram) ObserveDuration(d time.Duration) {
+	this.Observe(d.Seconds())
+}
+
+func (this *Histogram) Count() uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.count
+}
+
+func (this *Histogram) Sum() float64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.sum
+}
+
+func (this *Histogram) writeSamples(w *bufio.Writer, name, labels string) {
+	this.mutex.Lock()
+	bucketCounts := make([]uint64, len(this.bucketCounts))
+	copy(bucketCounts, this.bucketCounts)
+	count, sum := this.count, this.sum
+	this.mutex.Unlock()
+
+	labelPrefix := labels
+	if labelPrefix != "" {
+		labelPrefix += ","
+	}
+	var cumulative uint64
+	for i, upperBound := range this.upperBounds {
+		cumulative += bucketCounts[i]
+		writeSample(w, name+"_bucket", labelPrefix+`le="`+formatValue(upperBound)+`"`, float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", labelPrefix+`le="+Inf"`, float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+type HistogramVec struct {
+	family *family
+}
+
+//NewHistogramVec uses DefaultDurationBuckets when buckets is empty
+func (this *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultDurationBuckets
+	}
+	upperBounds := make([]float64, len(buckets))
+	copy(upperBounds, buckets)
+	sort.Float64s(upperBounds)
+	return &HistogramVec{family: this.register(name, help, typeHistogram, labelNames, func() child {
+		return &Histogram{upperBounds: upperBounds, bucketCounts: make([]uint64, len(upperBounds))}
+	})}
+}
+
+func (this *HistogramVec) With(labelValues ...string) *Histogram {
+	return this.family.with(labelValues).(*Histogram)
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labelNames...)
+}
+
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return Default.NewHistogramVec(name, help, buckets).With()
+}
@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	helpLineRegex   = regexp.MustCompile(`^# HELP ([a-zA-Z_:][a-zA-Z0-9_:]*) (.*)$`)
+	typeLineRegex   = regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) (counter|gauge|histogram|summary|untyped)$`)
+	sampleLineRegex = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{((?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*",?)*)\})? (\S+)$`)
+	labelRegex      = regexp.MustCompile(`([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\\n]|\\.)*)"`)
+)
+
+//validateExposition checks text against the Prometheus text exposition format: every line parses, every family is
+//typed once before its samples, samples belong to the family above them and histogram buckets add up
+func validateExposition(t *testing.T, text string) {
+	t.Helper()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		t.Error("output must end with a newline")
+	}
+	typed := make(map[string]bool)
+	curFamily, curType := "", ""
+	bucketCounts := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if match := helpLineRegex.FindStringSubmatch(line); match != nil {
+			continue
+		}
+		if match := typeLineRegex.FindStringSubmatch(line); match != nil {
+			if typed[match[1]] {
+				t.Errorf("%s is typed more than once", match[1])
+			}
+			typed[match[1]] = true
+			curFamily, curType = match[1], match[2]
+			continue
+		}
+		match := sampleLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("line does not parse: %q", line)
+			continue
+		}
+		name, labels, value := match[1], match[3], match[4]
+		if _, err := strconv.ParseFloat(strings.TrimPrefix(value, "+"), 64); err != nil {
+			t.Errorf("bad value in %q", line)
+		}
+		floatValue, _ := strconv.ParseFloat(strings.TrimPrefix(value, "+"), 64)
+		switch {
+		case name == curFamily:
+			if curType == typeHistogram {
+				t.Errorf("histogram sample without suffix %q", line)
+			}
+		case curType == typeHistogram && name == curFamily+"_bucket":
+			if !strings.Contains(labels, `le="`) {
+				t.Errorf("bucket without le %q", line)
+			}
+			if floatValue < bucketCounts[seriesKey(labels)] {
+				t.Errorf("buckets are not cumulative at %q", line)
+			}
+			bucketCounts[seriesKey(labels)] = floatValue
+		case curType == typeHistogram && name == curFamily+"_count":
+			if floatValue != bucketCounts[seriesKey(labels)] {
+				t.Errorf("count does not match +Inf bucket at %q", line)
+			}
+		case curType == typeHistogram && name == curFamily+"_sum":
+		default:
+			t.Errorf("sample %q is not part of family %s", line, curFamily)
+		}
+	}
+}
+
+//seriesKey identifies a histogram series by every label apart from le
+func seriesKey(labels string) string {
+	key := ""
+	for _, label := range labelRegex.FindAllStringSubmatch(labels, -1) {
+		if label[1] != "le" {
+			key += label[0] + ","
+		}
+	}
+	return key
+}
+
+func TestWriteTextFormatsEveryMetricType(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("test_total", "A counter", "id")
+	counter.With("2").Add(3)
+	counter.With("10").Inc()
+	registry.NewGaugeVec("test_gauge", "A gauge").With().Set(-1.5)
+	histogram := registry.NewHistogramVec("test_seconds", "A histogram", []float64{1, 0.1}, "op")
+	histogram.With("tx").Observe(0.05)
+	histogram.With("tx").Observe(0.1)
+	histogram.With("tx").Observe(5)
+
+	buf := &bytes.Buffer{}
+	registry.WriteText(buf)
+
+	expected := `# HELP test_gauge A gauge
+# TYPE test_gauge gauge
+test_gauge -1.5
+# HELP test_seconds A histogram
+# TYPE test_seconds histogram
+test_seconds_bucket{op="tx",le="0.1"} 2
+test_seconds_bucket{op="tx",le="1"} 2
+test_seconds_bucket{op="tx",le="+Inf"} 3
+test_seconds_sum{op="tx"} 5.15
+test_seconds_count{op="tx"} 3
+# HELP test_total A counter
+# TYPE test_total counter
+test_total{id="10"} 1
+test_total{id="2"} 3
+`
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+	validateExposition(t, buf.String())
+}
+
+func TestWriteTextEscapesHelpAndLabelValues(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "Line one\nline two \\", "route").With(`/a"b` + "\n").Inc()
+
+	buf := &bytes.Buffer{}
+	registry.WriteText(buf)
+
+	if !strings.Contains(buf.String(), `# HELP test_total Line one\nline two \\`) ||
+		!strings.Contains(buf.String(), `test_total{route="/a\"b\n"} 1`) {
+		t.Error(buf.String())
+	}
+	validateExposition(t, buf.String())
+}
+
+func TestCounterIgnoresNegativeAdds(t *testing.T) {
+	counter := NewRegistry().NewCounterVec("test_total", "A counter").With()
+
+	counter.Add(2)
+	counter.Add(-1)
+
+	if counter.Value() != 2 {
+		t.Fail()
+	}
+}
+
+func TestRegisteringSameNameTwicePanics(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("test_total", "A counter")
+	defer func() {
+		if recover() == nil {
+			t.Fail()
+		}
+	}()
+
+	registry.NewGaugeVec("test_total", "A gauge")
+}
+
+func TestWithWrongNumberOfLabelValuesPanics(t *testing.T) {
+	counter := NewRegistry().NewCounterVec("test_total", "A counter", "id")
+	defer func() {
+		if recover() == nil {
+			t.Fail()
+		}
+	}()
+
+	counter.With()
+}
+
+func TestInvalidNamePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fail()
+		}
+	}()
+
+	NewRegistry().NewCounterVec("1bad-name", "A counter")
+}
@@ -0,0 +1,137 @@
+package smdsconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/atomicfile"
+)
+
+const (
+	configFileVersionLength int = 16
+)
+
+//ErrVersionMismatch is returned when the config file changed after the version an edit was based on was read
+var ErrVersionMismatch = errors.New("Config file has changed since it was read")
+
+//Only one edit can be checking and writing the config file at a time
+var saveMutex sync.Mutex
+
+//ConfigFile is the contents of the loaded config file along with a version that changes whenever they do
+type ConfigFile struct {
+	Contents []byte
+	Version  string
+}
+
+//ReadConfigFile returns what is in the file the config was loaded from. Environment and flag overrides are not
+//included as they are never saved
+func ReadConfigFile() (ConfigFile, error) {
+	configMutex.RLock()
+	configPath, isLoaded := loadedConfigPath, curSMDSConfig != nil
+	configMutex.RUnlock()
+	if !isLoaded {
+		return ConfigFile{}, errors.New(notLoadedErrorMessage)
+	}
+	contents, err := os.ReadFile(configPath)
+	if err != nil {
+		return ConfigFile{}, err
+	}
+	return ConfigFile{Contents: contents, Version: versionOf(contents)}, nil
+}
+
+//Effective returns the config in use with every default filled in. Settings that need a restart keep the values
+//they were started with even when the file has changed
+func Effective() SMDSConfig {
+	return GetSMDSConfig().(*smdsConfig).resolved()
+}
+
+func (this *smdsConfig) resolved() *smdsConfig {
+	features := this.Features()
+	shutdown := this.Shutdown()
+	loggingSettings := this.Logging()
+	//0 in a config means the default wait so not waiting at all is written as below 0
+	if shutdown.ThrowWaitMillis == 0 {
+		shutdown.ThrowWaitMillis = -1
+	}
+	return &smdsConfig{
+		Id:              this.Id,
+		Listen:          this.ListenAddress(),
+		WebContent:      this.WebContentDir(),
+		CalibrationFile: this.CalibrationFilePath(),
+		Timings: &TimingConfig{
+			BusPollIntervalMillis: this.BusPollInterval().Milliseconds(),
+			MotorRunTimeMillis:    this.MotorRunTime().Milliseconds(),
+		},
+		FeatureSettings: &FeatureConfig{
+			FaultInjectionApi: &features.FaultInjectionApi,
+			SimulatorApi:      &features.SimulatorApi,
+			MockRxDataApi:     &features.MockRxDataApi,
+			WebContent:        &features.WebContent,
+			ConfigFileWatch:   &features.ConfigFileWatch,
+			ConfigEditApi:     &features.ConfigEditApi,
+			SystemdNotify:     &features.SystemdNotify,
+		},
+		Mounts:           this.DriverMounts(),
+		ShutdownSettings: &shutdown,
+		LoggingSettings:  &loggingSettings,
+	}
+}
+
+//SaveConfigFile replaces the config file with contents as long as the file is still at version. contents are
+//checked the same way Load checks the file, with the same environment and flag overrides, and nothing is written
+//unless they are valid. The file is replaced atomically and then reloaded
+func SaveConfigFile(contents []byte, version string) (ConfigFile, ReloadResult, error) {
+	saveMutex.Lock()
+	defer saveMutex.Unlock()
+	configMutex.RLock()
+	configPath, args, isLoaded := loadedConfigPath, loadedArgs, curSMDSConfig != nil
+	configMutex.RUnlock()
+	if !isLoaded {
+		return ConfigFile{}, ReloadResult{}, errors.New(notLoadedErrorMessage)
+	}
+
+	currentContents, err := os.ReadFile(configPath)
+	if err != nil {
+		return ConfigFile{}, ReloadResult{}, err
+	}
+	if versionOf(currentContents) != version {
+		return ConfigFile{}, ReloadResult{}, ErrVersionMismatch
+	}
+
+	config := &smdsConfig{}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return ConfigFile{}, ReloadResult{}, &ConfigError{Problems: []string{err.Error()}}
+	}
+	//Written out before the overrides are applied as those only ever come from the environment and flags
+	fileContents, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return ConfigFile{}, ReloadResult{}, err
+	}
+	fileContents = append(fileContents, '\n')
+	flagValues, _, err := parseFlags(args)
+	if err != nil {
+		return ConfigFile{}, ReloadResult{}, err
+	}
+	if err = applyOverridesAndValidate(config, flagValues); err != nil {
+		return ConfigFile{}, ReloadResult{}, err
+	}
+
+	if err = atomicfile.WriteFile(configPath, fileContents); err != nil {
+		return ConfigFile{}, ReloadResult{}, err
+	}
+	savedFile := ConfigFile{Contents: fileContents, Version: versionOf(fileContents)}
+	result, err := Reload()
+	return savedFile, result, err
+}
+
+func versionOf(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])[:configFileVersionLength]
+}
@@ -0,0 +1,94 @@
+package smdsconfig
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSaveConfigFileWritesAndReloadsWhenVersionMatches(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	file, _ := ReadConfigFile()
+
+	saved, result, err := SaveConfigFile([]byte(`{"id":"a","timings":{"motorRunTimeMillis":2000}}`), file.Version)
+
+	reread, _ := ReadConfigFile()
+	if err != nil || saved.Version == file.Version || reread.Version != saved.Version ||
+		len(result.Applied) != 1 || GetSMDSConfig().MotorRunTime() != 2*time.Second {
+		t.Fail()
+	}
+}
+
+func TestSaveConfigFileRejectsStaleVersion(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	file, _ := ReadConfigFile()
+	os.WriteFile(path, []byte(`{"id":"b"}`), 0644)
+
+	_, _, err := SaveConfigFile([]byte(`{"id":"a","webContentDir":"x"}`), file.Version)
+
+	contents, _ := os.ReadFile(path)
+	if !errors.Is(err, ErrVersionMismatch) || string(contents) != `{"id":"b"}` {
+		t.Fail()
+	}
+}
+
+func TestSaveConfigFileLeavesFileAloneWhenInvalid(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	file, _ := ReadConfigFile()
+
+	_, _, err := SaveConfigFile([]byte(`{"id":"","listenAdress":":1"}`), file.Version)
+	_, _, validationErr := SaveConfigFile([]byte(`{"id":"","listenAddress":"nope"}`), file.Version)
+
+	var configErr *ConfigError
+	contents, _ := os.ReadFile(path)
+	if !errors.As(err, &configErr) || !errors.As(validationErr, &configErr) || len(configErr.Problems) != 2 ||
+		string(contents) != `{"id":"a"}` {
+		t.Fail()
+	}
+}
+
+func TestSaveConfigFileValidatesWithOverridesButDoesNotSaveThem(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path, "-web-content-dir", "flag"})
+	file, _ := ReadConfigFile()
+
+	SaveConfigFile([]byte(`{"id":"a","webContentDir":"file","timings":{"motorRunTimeMillis":2000}}`), file.Version)
+
+	saved, _ := ReadConfigFile()
+	config, _ := readConfigFile(path)
+	if GetSMDSConfig().WebContentDir() != "flag" || config.WebContent != "file" || saved.Version == file.Version {
+		t.Fail()
+	}
+}
+
+func TestEffectiveFillsInDefaults(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","shutdown":{"throwWaitMillis":-1}}`)
+	Load([]string{"-config", path})
+
+	effective := Effective().(*smdsConfig)
+
+	if effective.Listen != DefaultListenAddress || effective.Timings.MotorRunTimeMillis != 4000 ||
+		effective.FeatureSettings.WebContent == nil || !*effective.FeatureSettings.WebContent ||
+		len(effective.Mounts) != 1 || effective.ShutdownSettings.ThrowWaitMillis != -1 ||
+		effective.ShutdownSettings.TimeoutMillis != DefaultShutdownTimeoutMillis {
+		t.Fail()
+	}
+}
+
+func TestEffectiveKeepsStartupValuesOfSettingsThatNeedARestart(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","timings":{"busPollIntervalMillis":100,"motorRunTimeMillis":1000}}`)
+	Load([]string{"-config", path})
+	os.WriteFile(path, []byte(`{"id":"a","listenAddress":":9000","timings":{"busPollIntervalMillis":200,"motorRunTimeMillis":2000}}`), 0644)
+	Reload()
+
+	effective := Effective()
+
+	if effective.ListenAddress() != DefaultListenAddress || effective.BusPollInterval() != 100*time.Millisecond ||
+		effective.MotorRunTime() != 2*time.Second {
+		t.Errorf("Effective config was %+v", effective)
+	}
+}
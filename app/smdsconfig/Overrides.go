@@ -0,0 +1,188 @@
+package smdsconfig
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	configPathFlagName string = "config"
+	configPathEnvName  string = "SMDS_CONFIG"
+	overrideEnvPrefix  string = "SMDS_"
+)
+
+//override is one config value that can be set from the environment and the command line. The environment variable
+//name is the flag name upper cased with - swapped for _ and prefixed with SMDS_
+type override struct {
+	name  string
+	usage string
+	apply func(config *smdsConfig, value string) error
+}
+
+var overrides = []override{
+	{"listen-address", "Address the server listens on", func(c *smdsConfig, v string) error {
+		c.Listen = v
+		return nil
+	}},
+	{"web-content-dir", "Directory the web pages are served from", func(c *smdsConfig, v string) error {
+		c.WebContent = v
+		return nil
+	}},
+	{"calibration-file-path", "File the switch machine wiring calibrations are saved in", func(c *smdsConfig, v string) error {
+		c.CalibrationFile = v
+		return nil
+	}},
+	{"driver-type", "Driver type for driver mounts that don't set one", func(c *smdsConfig, v string) error {
+		if len(c.Mounts) == 0 {
+			c.Mounts = defaultDriverMounts()
+		}
+		for i := range c.Mounts {
+			if c.Mounts[i].Type == DriverTypeDefault {
+				c.Mounts[i].Type = v
+			}
+		}
+		return nil
+	}},
+	{"bus-poll-interval-millis", "How often the bus is read", func(c *smdsConfig, v string) error {
+		return parseMillis(v, &timingsOf(c).BusPollIntervalMillis)
+	}},
+	{"motor-run-time-millis", "How long a motor is driven for each throw", func(c *smdsConfig, v string) error {
+		return parseMillis(v, &timingsOf(c).MotorRunTimeMillis)
+	}},
+	{"shutdown-timeout-millis", "How long shutting down may take", func(c *smdsConfig, v string) error {
+		return parseMillis(v, &shutdownOf(c).TimeoutMillis)
+	}},
+	{"feature-fault-injection-api", "Turn the fault injection api on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).FaultInjectionApi)
+	}},
+	{"feature-simulator-api", "Turn the simulator api on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).SimulatorApi)
+	}},
+	{"feature-mock-rx-data-api", "Turn the mock rx data api on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).MockRxDataApi)
+	}},
+	{"feature-web-content", "Turn serving the web pages on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).WebContent)
+	}},
+	{"feature-config-file-watch", "Turn reloading the config when its file changes on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).ConfigFileWatch)
+	}},
+	{"feature-config-edit-api", "Turn changing the config through the api on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).ConfigEditApi)
+	}},
+	{"feature-systemd-notify", "Turn telling systemd about readiness and feeding its watchdog on or off", func(c *smdsConfig, v string) error {
+		return parseFeature(v, &featuresOf(c).SystemdNotify)
+	}},
+	{"log-level", "Level every subsystem logs at unless the config gives it its own", func(c *smdsConfig, v string) error {
+		loggingOf(c).Level = v
+		return nil
+	}},
+	{"log-format", "Log as text or json", func(c *smdsConfig, v string) error {
+		loggingOf(c).Format = v
+		return nil
+	}},
+}
+
+func (this override) envName() string {
+	return overrideEnvPrefix + strings.ToUpper(strings.ReplaceAll(this.name, "-", "_"))
+}
+
+func timingsOf(c *smdsConfig) *TimingConfig {
+	if c.Timings == nil {
+		c.Timings = &TimingConfig{}
+	}
+	return c.Timings
+}
+
+func shutdownOf(c *smdsConfig) *ShutdownConfig {
+	if c.ShutdownSettings == nil {
+		c.ShutdownSettings = &ShutdownConfig{}
+	}
+	return c.ShutdownSettings
+}
+
+func featuresOf(c *smdsConfig) *FeatureConfig {
+	if c.FeatureSettings == nil {
+		c.FeatureSettings = &FeatureConfig{}
+	}
+	return c.FeatureSettings
+}
+
+func loggingOf(c *smdsConfig) *LoggingConfig {
+	if c.LoggingSettings == nil {
+		c.LoggingSettings = &LoggingConfig{}
+	}
+	return c.LoggingSettings
+}
+
+func parseMillis(v string, millis *int64) error {
+	parsed, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a whole number of milliseconds", v)
+	}
+	*millis = parsed
+	return nil
+}
+
+func parseFeature(v string, feature **bool) error {
+	parsed, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", v)
+	}
+	*feature = &parsed
+	return nil
+}
+
+//parseFlags returns only the flags that were given along with the config path flag
+func parseFlags(args []string) (map[string]string, string, error) {
+	flagSet := flag.NewFlagSet("smds", flag.ContinueOnError)
+	configPath := flagSet.String(configPathFlagName, "", "Path of the config file. Can also be set with "+configPathEnvName)
+	for _, curOverride := range overrides {
+		flagSet.String(curOverride.name, "", curOverride.usage+". Can also be set with "+curOverride.envName())
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return nil, "", err
+	}
+	if flagSet.NArg() > 0 {
+		return nil, "", fmt.Errorf("Unexpected arguments %v", flagSet.Args())
+	}
+	flagValues := make(map[string]string)
+	flagSet.Visit(func(f *flag.Flag) {
+		flagValues[f.Name] = f.Value.String()
+	})
+	return flagValues, *configPath, nil
+}
+
+func applyEnvOverrides(config *smdsConfig) []string {
+	return applyOverrides(config, func(o override) (string, bool) {
+		return os.LookupEnv(o.envName())
+	}, func(o override) string {
+		return "environment variable " + o.envName()
+	})
+}
+
+func applyFlagOverrides(config *smdsConfig, flagValues map[string]string) []string {
+	return applyOverrides(config, func(o override) (string, bool) {
+		v, isSet := flagValues[o.name]
+		return v, isSet
+	}, func(o override) string {
+		return "flag -" + o.name
+	})
+}
+
+func applyOverrides(config *smdsConfig, lookup func(override) (string, bool), describe func(override) string) []string {
+	problems := make([]string, 0)
+	for _, curOverride := range overrides {
+		v, isSet := lookup(curOverride)
+		if !isSet {
+			continue
+		}
+		if err := curOverride.apply(config, v); err != nil {
+			problems = append(problems, describe(curOverride)+": "+err.Error())
+		}
+	}
+	return problems
+}
@@ -0,0 +1,192 @@
+package smdsconfig
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+const (
+	//DefaultConfigWatchInterval is how often the config file is checked for changes
+	DefaultConfigWatchInterval time.Duration = time.Second * 2
+
+	notLoadedErrorMessage string = "Config has not been loaded yet"
+)
+
+var logger = logging.ForSubsystem(logging.SubsystemConfig)
+
+//ReloadResult names the settings that changed. Applied ones are live already, the rest only take effect on restart
+type ReloadResult struct {
+	Applied         []string
+	RestartRequired []string
+}
+
+func (this ReloadResult) HasChanges() bool {
+	return len(this.Applied) > 0 || len(this.RestartRequired) > 0
+}
+
+//reloadableSetting is one part of the config compared across a reload. Settings that are runtime are picked up by
+//reload listeners, anything else was used once at startup
+type reloadableSetting struct {
+	name      string
+	isRuntime bool
+	value     func(*smdsConfig) interface{}
+}
+
+var reloadableSettings = []reloadableSetting{
+	{"id", false, func(c *smdsConfig) interface{} { return c.SMDSId() }},
+	{"listenAddress", false, func(c *smdsConfig) interface{} { return c.ListenAddress() }},
+	{"webContentDir", true, func(c *smdsConfig) interface{} { return c.WebContentDir() }},
+	{"calibrationFilePath", false, func(c *smdsConfig) interface{} { return c.CalibrationFilePath() }},
+	{"timings.busPollIntervalMillis", false, func(c *smdsConfig) interface{} { return c.BusPollInterval() }},
+	{"timings.motorRunTimeMillis", true, func(c *smdsConfig) interface{} { return c.MotorRunTime() }},
+	{"features.faultInjectionApi", false, func(c *smdsConfig) interface{} { return c.Features().FaultInjectionApi }},
+	{"features.simulatorApi", false, func(c *smdsConfig) interface{} { return c.Features().SimulatorApi }},
+	{"features.mockRxDataApi", false, func(c *smdsConfig) interface{} { return c.Features().MockRxDataApi }},
+	{"features.webContent", true, func(c *smdsConfig) interface{} { return c.Features().WebContent }},
+	{"features.configFileWatch", false, func(c *smdsConfig) interface{} { return c.Features().ConfigFileWatch }},
+	{"features.configEditApi", false, func(c *smdsConfig) interface{} { return c.Features().ConfigEditApi }},
+	{"features.systemdNotify", false, func(c *smdsConfig) interface{} { return c.Features().SystemdNotify }},
+	{"driverMounts", false, func(c *smdsConfig) interface{} { return c.DriverMounts() }},
+	{"shutdown", true, func(c *smdsConfig) interface{} { return c.Shutdown() }},
+	{"logging", true, func(c *smdsConfig) interface{} { return c.Logging() }},
+}
+
+//reloadListener is held by pointer so the function returned by AddReloadListener removes only its own listener
+type reloadListener struct {
+	notify func(SMDSConfig)
+}
+
+var reloadListeners []*reloadListener
+
+//Reloads come from the file watcher, signals and the api so each is finished before the next starts
+var reloadMutex sync.Mutex
+
+//AddReloadListener has listener called with the new config after every reload that changed something. The returned
+//function removes it again
+func AddReloadListener(listener func(SMDSConfig)) func() {
+	added := &reloadListener{notify: listener}
+	configMutex.Lock()
+	reloadListeners = append(reloadListeners, added)
+	configMutex.Unlock()
+	return func() {
+		configMutex.Lock()
+		for i, curListener := range reloadListeners {
+			if curListener == added {
+				reloadListeners = append(reloadListeners[:i:i], reloadListeners[i+1:]...)
+				break
+			}
+		}
+		configMutex.Unlock()
+	}
+}
+
+//Reload reads the config again the same way Load did. If the new config is invalid the current one is kept. Settings
+//that need a restart are compared with what was loaded at startup so they are reported until the server is restarted
+func Reload() (ReloadResult, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	configMutex.RLock()
+	startConfig, oldConfig, args := startupConfig, curSMDSConfig, loadedArgs
+	configMutex.RUnlock()
+	if oldConfig == nil {
+		return ReloadResult{}, errors.New(notLoadedErrorMessage)
+	}
+
+	newConfig, _, err := loadConfig(args, false)
+	if err != nil {
+		logger.Error("Config reload failed, keeping current config", "err", err)
+		return ReloadResult{}, err
+	}
+	result := diffConfigs(startConfig, oldConfig, newConfig)
+	if !result.HasChanges() {
+		return result, nil
+	}
+
+	runningConfig := withRuntimeSettings(startConfig, newConfig)
+	configMutex.Lock()
+	curSMDSConfig = runningConfig
+	listeners := make([]*reloadListener, len(reloadListeners))
+	copy(listeners, reloadListeners)
+	configMutex.Unlock()
+	for _, curListener := range listeners {
+		curListener.notify(runningConfig)
+	}
+	logger.Info("Config reloaded", "applied", result.Applied, "restartRequired", result.RestartRequired)
+	return result, nil
+}
+
+//withRuntimeSettings is startConfig with the runtime settings of reloaded, which is what the server runs with once
+//reload listeners have applied them. It has to cover every runtime setting in reloadableSettings
+func withRuntimeSettings(startConfig, reloaded *smdsConfig) *smdsConfig {
+	running := *startConfig
+	running.WebContent = reloaded.WebContent
+	timings := TimingConfig{}
+	if startConfig.Timings != nil {
+		timings = *startConfig.Timings
+	}
+	timings.MotorRunTimeMillis = 0
+	if reloaded.Timings != nil {
+		timings.MotorRunTimeMillis = reloaded.Timings.MotorRunTimeMillis
+	}
+	running.Timings = &timings
+	features := FeatureConfig{}
+	if startConfig.FeatureSettings != nil {
+		features = *startConfig.FeatureSettings
+	}
+	features.WebContent = nil
+	if reloaded.FeatureSettings != nil {
+		features.WebContent = reloaded.FeatureSettings.WebContent
+	}
+	running.FeatureSettings = &features
+	running.ShutdownSettings = reloaded.ShutdownSettings
+	running.LoggingSettings = reloaded.LoggingSettings
+	return &running
+}
+
+//diffConfigs compares runtime settings with oldConfig, which has the ones applied last, and the rest with startConfig
+//as that is what is still running
+func diffConfigs(startConfig, oldConfig, newConfig *smdsConfig) ReloadResult {
+	result := ReloadResult{Applied: make([]string, 0), RestartRequired: make([]string, 0)}
+	for _, curSetting := range reloadableSettings {
+		if curSetting.isRuntime {
+			if !reflect.DeepEqual(curSetting.value(oldConfig), curSetting.value(newConfig)) {
+				result.Applied = append(result.Applied, curSetting.name)
+			}
+		} else if !reflect.DeepEqual(curSetting.value(startConfig), curSetting.value(newConfig)) {
+			result.RestartRequired = append(result.RestartRequired, curSetting.name)
+		}
+	}
+	return result
+}
+
+//WatchConfigFile reloads whenever the loaded config file's modification time or size changes until stop is closed
+func WatchConfigFile(interval time.Duration, stop <-chan struct{}) {
+	configMutex.RLock()
+	configPath := loadedConfigPath
+	configMutex.RUnlock()
+	lastInfo, _ := os.Stat(configPath)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		curInfo, err := os.Stat(configPath)
+		if err != nil {
+			//Editors often replace the file so it can be missing for a moment
+			continue
+		}
+		if lastInfo == nil || !curInfo.ModTime().Equal(lastInfo.ModTime()) || curInfo.Size() != lastInfo.Size() {
+			lastInfo = curInfo
+			logger.Info("Config file changed, reloading", "path", configPath)
+			Reload()
+		}
+	}
+}
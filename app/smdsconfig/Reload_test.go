@@ -0,0 +1,122 @@
+package smdsconfig
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReloadAppliesRuntimeChangesAndReportsRestartRequired(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","timings":{"motorRunTimeMillis":1000}}`)
+	Load([]string{"-config", path})
+	os.WriteFile(path, []byte(`{"id":"a","listenAddress":":9000","timings":{"motorRunTimeMillis":2000}}`), 0644)
+
+	result, err := Reload()
+
+	if err != nil || len(result.Applied) != 1 || result.Applied[0] != "timings.motorRunTimeMillis" ||
+		len(result.RestartRequired) != 1 || result.RestartRequired[0] != "listenAddress" ||
+		GetSMDSConfig().MotorRunTime() != 2*time.Second {
+		t.Fail()
+	}
+}
+
+func TestReloadKeepsCurrentConfigWhenNewOneIsInvalid(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","timings":{"motorRunTimeMillis":1000}}`)
+	Load([]string{"-config", path})
+	os.WriteFile(path, []byte(`{"id":"a","timings":{"motorRunTimeMillis":-5}}`), 0644)
+
+	_, err := Reload()
+
+	if err == nil || GetSMDSConfig().MotorRunTime() != time.Second {
+		t.Fail()
+	}
+}
+
+func TestReloadKeepsFlagOverrides(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path, "-web-content-dir", "flag"})
+	os.WriteFile(path, []byte(`{"id":"a","webContentDir":"file","timings":{"motorRunTimeMillis":2000}}`), 0644)
+
+	Reload()
+
+	if GetSMDSConfig().WebContentDir() != "flag" {
+		t.Fail()
+	}
+}
+
+func TestReloadCallsListenersOnlyWhenSomethingChanged(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	calls := 0
+	AddReloadListener(func(SMDSConfig) {
+		calls++
+	})
+	defer func() {
+		reloadListeners = nil
+	}()
+
+	Reload()
+	os.WriteFile(path, []byte(`{"id":"a","webContentDir":"other"}`), 0644)
+	Reload()
+
+	if calls != 1 {
+		t.Fail()
+	}
+}
+
+func TestWatchConfigFileReloadsWhenFileChanges(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	stop := make(chan struct{})
+	defer close(stop)
+	go WatchConfigFile(time.Millisecond*5, stop)
+	time.Sleep(time.Millisecond * 10)
+
+	os.WriteFile(path, []byte(`{"id":"a","webContentDir":"watched"}`), 0644)
+
+	deadline := time.Now().Add(time.Second)
+	for GetSMDSConfig().WebContentDir() != "watched" {
+		if time.Now().After(deadline) {
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestReloadKeepsReportingRestartRequiredUntilRestart(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	os.WriteFile(path, []byte(`{"id":"a","listenAddress":":9000"}`), 0644)
+	Reload()
+	os.WriteFile(path, []byte(`{"id":"a","listenAddress":":9000","webContentDir":"other"}`), 0644)
+
+	result, err := Reload()
+
+	if err != nil || len(result.Applied) != 1 || result.Applied[0] != "webContentDir" ||
+		len(result.RestartRequired) != 1 || result.RestartRequired[0] != "listenAddress" {
+		t.Errorf("Reload was %+v %v", result, err)
+	}
+}
+
+func TestRemovedReloadListenerIsNotCalled(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+	Load([]string{"-config", path})
+	removedCalls, keptCalls := 0, 0
+	removeListener := AddReloadListener(func(SMDSConfig) {
+		removedCalls++
+	})
+	AddReloadListener(func(SMDSConfig) {
+		keptCalls++
+	})
+	defer func() {
+		reloadListeners = nil
+	}()
+
+	removeListener()
+	os.WriteFile(path, []byte(`{"id":"a","webContentDir":"other"}`), 0644)
+	Reload()
+
+	if removedCalls != 0 || keptCalls != 1 {
+		t.Fail()
+	}
+}
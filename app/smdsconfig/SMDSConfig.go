@@ -2,44 +2,311 @@ package smdsconfig
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/environment"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+	env "github.com/ZacharyDuve/apireg/environment"
 	"github.com/google/uuid"
 )
 
 const (
-	configFilePath string = "server-config.json"
+	//DefaultConfigFilePath is used when neither the -config flag nor SMDS_CONFIG say where the config is
+	DefaultConfigFilePath string = "server-config.json"
+	DefaultListenAddress  string = ":8080"
+	DefaultWebContentDir  string = "web-content"
+	//DefaultCalibrationFilePath is where switch machine wiring calibrations are saved
+	DefaultCalibrationFilePath string = "calibration.json"
+	//DriverTypeDefault lets the environment pick between the pi and mock drivers
+	DriverTypeDefault string = ""
+	DriverTypePi      string = "pi"
+	DriverTypeMock    string = "mock"
+	//DriverTypeSimulator models virtual tortoise machines that move over time
+	DriverTypeSimulator string = "simulator"
+	//DriverTypeReplay plays back a bus recording made with BusRecordingPath
+	DriverTypeReplay string = "replay"
+
+	DefaultShutdownTimeoutMillis   int64 = 10000
+	DefaultShutdownThrowWaitMillis int64 = 4000
+
+	DefaultLogFormat string = string(logging.FormatText)
+	DefaultLogLevel  string = "info"
 )
 
 type SMDSConfig interface {
 	SMDSId() string
+	ListenAddress() string
+	WebContentDir() string
+	CalibrationFilePath() string
+	//How often drivers that poll the bus read it
+	BusPollInterval() time.Duration
+	//How long a motor is driven for each throw
+	MotorRunTime() time.Duration
+	Features() Features
+	DriverMounts() []DriverMountConfig
+	Shutdown() ShutdownConfig
+	Logging() LoggingConfig
+}
+
+//DriverMountConfig describes one hardware driver and where its switch machines sit in the servers id space
+type DriverMountConfig struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	IdOffset uint16 `json:"idOffset"`
+	//How many main controller boards are chained on this mount. 0 means as many as a driver can control
+	Boards uint16 `json:"boards,omitempty"`
+	//Only used by pi drivers. Empty uses the default spi devices
+	SPITxDevPath string `json:"spiTxDevPath,omitempty"`
+	SPIRxDevPath string `json:"spiRxDevPath,omitempty"`
+	//Only used by simulator drivers. Machines are attached in position 0 at ids 0 through SimulatedMachines-1
+	SimulatedMachines         uint16 `json:"simulatedMachines,omitempty"`
+	SimulatedTravelTimeMillis int64  `json:"simulatedTravelTimeMillis,omitempty"`
+	//When set every buffer sent over the bus is recorded to this file. A timestamp is added to the name for each run
+	BusRecordingPath string `json:"busRecordingPath,omitempty"`
+	//Only used by replay drivers. ReplaySpeed multiplies how fast the recording plays back, 0 plays as fast as possible
+	ReplayPath  string  `json:"replayPath,omitempty"`
+	ReplaySpeed float64 `json:"replaySpeed,omitempty"`
+	//Check the bytes the board chain shifts back on each write, retrying the write up to TxVerifyRetries times
+	VerifyTx        bool `json:"verifyTx,omitempty"`
+	TxVerifyRetries int  `json:"txVerifyRetries,omitempty"`
+	//How often every output is rewritten so boards that reset recover on their own. 0 turns this off
+	TxRefreshIntervalMillis int64 `json:"txRefreshIntervalMillis,omitempty"`
+	//How many switch machines can be waiting to be written before updates wait. 0 uses the driver default
+	MaxQueuedUpdates int `json:"maxQueuedUpdates,omitempty"`
+}
+
+//NumIds is how many switch machine ids the mount takes up
+func (this DriverMountConfig) NumIds() uint {
+	boards := uint(this.Boards)
+	if boards == 0 {
+		boards = tortoise.MaxNumberAttachableMainControllerBoards
+	}
+	return boards * tortoise.SwitchMachinesPerBoard
+}
+
+//TimingConfig values of 0 use the defaults
+type TimingConfig struct {
+	BusPollIntervalMillis int64 `json:"busPollIntervalMillis,omitempty"`
+	MotorRunTimeMillis    int64 `json:"motorRunTimeMillis,omitempty"`
+}
+
+//FeatureConfig turns parts of the server on or off. Anything left unset uses its default
+type FeatureConfig struct {
+	//Defaults to on outside of production
+	FaultInjectionApi *bool `json:"faultInjectionApi,omitempty"`
+	SimulatorApi      *bool `json:"simulatorApi,omitempty"`
+	MockRxDataApi     *bool `json:"mockRxDataApi,omitempty"`
+	WebContent        *bool `json:"webContent,omitempty"`
+	//Reload the config when its file changes
+	ConfigFileWatch *bool `json:"configFileWatch,omitempty"`
+	//Allow the config to be changed through the api. Defaults to on outside of production so it is read only there
+	ConfigEditApi *bool `json:"configEditApi,omitempty"`
+	//Tell systemd when the server is ready and feed its watchdog. Only does anything when run by systemd
+	SystemdNotify *bool `json:"systemdNotify,omitempty"`
+}
+
+//Features is FeatureConfig with the defaults filled in
+type Features struct {
+	FaultInjectionApi bool
+	SimulatorApi      bool
+	MockRxDataApi     bool
+	WebContent        bool
+	ConfigFileWatch   bool
+	ConfigEditApi     bool
+	SystemdNotify     bool
+}
+
+//ShutdownConfig controls what happens to the hardware when the server is stopped
+type ShutdownConfig struct {
+	//How long the whole shutdown may take before the server exits anyway
+	TimeoutMillis int64 `json:"timeoutMillis,omitempty"`
+	//How long throws that are already running get to finish before they are braked. Below 0 brakes them straight away
+	ThrowWaitMillis int64 `json:"throwWaitMillis,omitempty"`
+	//When set every switch machine has its gpio set to this before the driver is closed
+	SafeGPIO *SafeGPIOConfig `json:"safeGPIO,omitempty"`
+	//When set the last known state of every switch machine is written to this file as JSON, and each switch machine
+	//gets the gpio it had there back on the next start
+	StateFilePath string `json:"stateFilePath,omitempty"`
+}
+
+//LoggingConfig sets how much each subsystem logs and whether it is logged as text or JSON
+type LoggingConfig struct {
+	Format string `json:"format,omitempty"`
+	//Level of every subsystem not listed in Subsystems
+	Level      string            `json:"level,omitempty"`
+	Subsystems map[string]string `json:"subsystems,omitempty"`
+}
+
+type SafeGPIOConfig struct {
+	GPIO0 bool `json:"gpio0"`
+	GPIO1 bool `json:"gpio1"`
 }
 
 type smdsConfig struct {
-	id string
+	Id               string              `json:"id"`
+	Listen           string              `json:"listenAddress,omitempty"`
+	WebContent       string              `json:"webContentDir,omitempty"`
+	CalibrationFile  string              `json:"calibrationFilePath,omitempty"`
+	Timings          *TimingConfig       `json:"timings,omitempty"`
+	FeatureSettings  *FeatureConfig      `json:"features,omitempty"`
+	Mounts           []DriverMountConfig `json:"driverMounts,omitempty"`
+	ShutdownSettings *ShutdownConfig     `json:"shutdown,omitempty"`
+	LoggingSettings  *LoggingConfig      `json:"logging,omitempty"`
 }
 
 func (this *smdsConfig) SMDSId() string {
-	return this.id
+	return this.Id
 }
 
-var curSMDSConfig *smdsConfig
+func (this *smdsConfig) ListenAddress() string {
+	if this.Listen == "" {
+		return DefaultListenAddress
+	}
+	return this.Listen
+}
 
-func writeSMDSConfigToJSONFile(config *smdsConfig) error {
-	configFile, err := os.Create(configFilePath)
-	defer configFile.Close()
+func (this *smdsConfig) WebContentDir() string {
+	if this.WebContent == "" {
+		return DefaultWebContentDir
+	}
+	return this.WebContent
+}
 
-	if err == nil {
-		err = writeSMDSConfigAsJSONToWriter(config, configFile)
+func (this *smdsConfig) CalibrationFilePath() string {
+	if this.CalibrationFile == "" {
+		return DefaultCalibrationFilePath
 	}
+	return this.CalibrationFile
+}
 
-	return err
+func (this *smdsConfig) BusPollInterval() time.Duration {
+	if this.Timings == nil || this.Timings.BusPollIntervalMillis <= 0 {
+		return tortoise.DefaultBusPollInterval
+	}
+	return time.Duration(this.Timings.BusPollIntervalMillis) * time.Millisecond
+}
+
+func (this *smdsConfig) MotorRunTime() time.Duration {
+	if this.Timings == nil || this.Timings.MotorRunTimeMillis <= 0 {
+		return controller.DefaultMotorRunTime
+	}
+	return time.Duration(this.Timings.MotorRunTimeMillis) * time.Millisecond
+}
+
+func (this *smdsConfig) Features() Features {
+	featureSettings := FeatureConfig{}
+	if this.FeatureSettings != nil {
+		featureSettings = *this.FeatureSettings
+	}
+	return Features{
+		//Breaking things on purpose is never wanted in production unless asked for
+		FaultInjectionApi: boolOrDefault(featureSettings.FaultInjectionApi, environment.GetCurrent() != env.Prod),
+		SimulatorApi:      boolOrDefault(featureSettings.SimulatorApi, true),
+		MockRxDataApi:     boolOrDefault(featureSettings.MockRxDataApi, true),
+		WebContent:        boolOrDefault(featureSettings.WebContent, true),
+		ConfigFileWatch:   boolOrDefault(featureSettings.ConfigFileWatch, true),
+		ConfigEditApi:     boolOrDefault(featureSettings.ConfigEditApi, environment.GetCurrent() != env.Prod),
+		SystemdNotify:     boolOrDefault(featureSettings.SystemdNotify, true),
+	}
 }
 
+func boolOrDefault(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+func (this *smdsConfig) DriverMounts() []DriverMountConfig {
+	if len(this.Mounts) == 0 {
+		return defaultDriverMounts()
+	}
+	mounts := make([]DriverMountConfig, len(this.Mounts))
+	copy(mounts, this.Mounts)
+	return mounts
+}
+
+//Shutdown fills in defaults for anything not configured
+func (this *smdsConfig) Shutdown() ShutdownConfig {
+	shutdown := ShutdownConfig{}
+	if this.ShutdownSettings != nil {
+		shutdown = *this.ShutdownSettings
+	}
+	if shutdown.TimeoutMillis <= 0 {
+		shutdown.TimeoutMillis = DefaultShutdownTimeoutMillis
+	}
+	if shutdown.ThrowWaitMillis < 0 {
+		shutdown.ThrowWaitMillis = 0
+	} else if shutdown.ThrowWaitMillis == 0 {
+		shutdown.ThrowWaitMillis = DefaultShutdownThrowWaitMillis
+	}
+	return shutdown
+}
+
+//Logging fills in the default format and level
+func (this *smdsConfig) Logging() LoggingConfig {
+	loggingSettings := LoggingConfig{}
+	if this.LoggingSettings != nil {
+		loggingSettings = *this.LoggingSettings
+	}
+	if loggingSettings.Format == "" {
+		loggingSettings.Format = DefaultLogFormat
+	}
+	if loggingSettings.Level == "" {
+		loggingSettings.Level = DefaultLogLevel
+	}
+	subsystems := make(map[string]string, len(loggingSettings.Subsystems))
+	for name, level := range loggingSettings.Subsystems {
+		subsystems[name] = level
+	}
+	loggingSettings.Subsystems = subsystems
+	return loggingSettings
+}
+
+//ConfigureLogging sets every logger up the way config says. Levels changed through the api since are replaced
+func ConfigureLogging(config LoggingConfig) error {
+	format, err := logging.ParseFormat(config.Format)
+	if err != nil {
+		return err
+	}
+	defaultLevel, err := logging.ParseLevel(config.Level)
+	if err != nil {
+		return err
+	}
+	levels := make(map[string]logging.Level, len(config.Subsystems))
+	for name, levelName := range config.Subsystems {
+		if levels[name], err = logging.ParseLevel(levelName); err != nil {
+			return err
+		}
+	}
+	return logging.Configure(format, defaultLevel, levels)
+}
+
+func defaultDriverMounts() []DriverMountConfig {
+	return []DriverMountConfig{{Name: "main", Type: DriverTypeDefault, IdOffset: 0}}
+}
+
+var (
+	//Guards the loaded config and what it was loaded from as reloads swap them while the server runs. startupConfig
+	//is what Load read, which settings that need a restart are still running with. curSMDSConfig is that with the
+	//runtime settings of the last reload
+	configMutex      sync.RWMutex
+	curSMDSConfig    *smdsConfig
+	startupConfig    *smdsConfig
+	loadedArgs       []string
+	loadedConfigPath string
+)
+
 func newDefaultConfig() *smdsConfig {
 	config := &smdsConfig{}
-	config.id = uuid.New().String()
+	config.Id = uuid.New().String()
 	return config
 }
 
@@ -53,47 +320,110 @@ func writeSMDSConfigAsJSONToWriter(config *smdsConfig, w io.Writer) error {
 	return err
 }
 
-func GetSMDSConfig() SMDSConfig {
-	if curSMDSConfig == nil {
-		if configFileExists() {
-			curSMDSConfig = readSMDSConfigFromFile()
-		} else {
-			//Need to generate configuration
-			curSMDSConfig = newDefaultConfig()
-			configFile, err := os.Create(configFilePath)
-			defer configFile.Close()
-			if err != nil {
-				panic(err)
-			}
-			writeSMDSConfigAsJSONToWriter(curSMDSConfig, configFile)
-		}
+//Load reads the config file, applies SMDS_ environment variables over it and then any command line flags in args
+//over those. The result is validated and every problem found is returned together as a *ConfigError
+func Load(args []string) (SMDSConfig, error) {
+	config, configPath, err := loadConfig(args, true)
+	if err != nil {
+		return nil, err
+	}
+	configMutex.Lock()
+	curSMDSConfig = config
+	startupConfig = config
+	loadedArgs = args
+	loadedConfigPath = configPath
+	configMutex.Unlock()
+	return config, nil
+}
 
+func loadConfig(args []string, createIfMissing bool) (*smdsConfig, string, error) {
+	flagValues, configPath, err := parseFlags(args)
+	if err != nil {
+		return nil, "", err
+	}
+	if configPath == "" {
+		configPath = os.Getenv(configPathEnvName)
+	}
+	if configPath == "" {
+		configPath = DefaultConfigFilePath
 	}
 
-	return curSMDSConfig
+	var config *smdsConfig
+	if createIfMissing {
+		config, err = readOrCreateConfigFile(configPath)
+	} else {
+		config, err = readConfigFile(configPath)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err = applyOverridesAndValidate(config, flagValues); err != nil {
+		return nil, "", err
+	}
+	return config, configPath, nil
 }
 
-func configFileExists() bool {
-	_, err := os.Stat(configFilePath)
+func applyOverridesAndValidate(config *smdsConfig, flagValues map[string]string) error {
+	problems := applyEnvOverrides(config)
+	problems = append(problems, applyFlagOverrides(config, flagValues)...)
+	problems = append(problems, validate(config)...)
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
 
-	return !os.IsNotExist(err)
+//IsLoaded is whether Load has succeeded
+func IsLoaded() bool {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return curSMDSConfig != nil
 }
 
-func readSMDSConfigFromFile() *smdsConfig {
-	config := &smdsConfig{}
+//GetSMDSConfig returns the config from Load, loading it without any flags if that hasn't happened yet. It panics if
+//that load fails, so the server should call Load itself at startup to report problems
+func GetSMDSConfig() SMDSConfig {
+	configMutex.RLock()
+	config := curSMDSConfig
+	configMutex.RUnlock()
+	if config == nil {
+		loaded, err := Load(nil)
+		if err != nil {
+			panic(err)
+		}
+		return loaded
+	}
 
-	configFile, err := os.Open(configFilePath)
-	defer configFile.Close()
+	return config
+}
 
-	if err != nil {
-		panic(err)
+//readOrCreateConfigFile generates a config with a new id when there isn't one so the id stays the same across runs
+func readOrCreateConfigFile(configPath string) (*smdsConfig, error) {
+	config, err := readConfigFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		config = newDefaultConfig()
+		newConfigFile, err := os.Create(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to create config file %s: %w", configPath, err)
+		}
+		defer newConfigFile.Close()
+		return config, writeSMDSConfigAsJSONToWriter(config, newConfigFile)
 	}
+	return config, err
+}
 
-	err = json.NewDecoder(configFile).Decode(config)
-
+func readConfigFile(configPath string) (*smdsConfig, error) {
+	configFile, err := os.Open(configPath)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("Unable to open config file %s: %w", configPath, err)
 	}
+	defer configFile.Close()
 
-	return config
+	config := &smdsConfig{}
+	decoder := json.NewDecoder(configFile)
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("Unable to read config file %s: %w", configPath, err)
+	}
+	return config, nil
 }
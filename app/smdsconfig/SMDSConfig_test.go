@@ -1 +1,157 @@
 package smdsconfig
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+func writeTestConfigFile(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "server-config.json")
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCreatesConfigFileWithIdAndDefaultsWhenMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server-config.json")
+
+	config, err := Load([]string{"-config", path})
+
+	if err != nil || config.SMDSId() == "" || config.ListenAddress() != DefaultListenAddress ||
+		config.WebContentDir() != DefaultWebContentDir || config.MotorRunTime() != 4*time.Second {
+		t.FailNow()
+	}
+	reloaded, err := Load([]string{"-config", path})
+	if err != nil || reloaded.SMDSId() != config.SMDSId() {
+		t.Fail()
+	}
+}
+
+func TestLoadReadsValuesFromFile(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","listenAddress":":9000","timings":{"busPollIntervalMillis":100},
+		"features":{"simulatorApi":false},"driverMounts":[{"name":"sim","type":"simulator","boards":2}]}`)
+
+	config, err := Load([]string{"-config", path})
+
+	if err != nil || config.ListenAddress() != ":9000" || config.BusPollInterval() != 100*time.Millisecond ||
+		config.Features().SimulatorApi || config.DriverMounts()[0].NumIds() != 8 {
+		t.Fail()
+	}
+}
+
+func TestEnvironmentOverridesFileAndFlagOverridesEnvironment(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","listenAddress":":9000","webContentDir":"file"}`)
+	t.Setenv("SMDS_LISTEN_ADDRESS", ":9001")
+	t.Setenv("SMDS_WEB_CONTENT_DIR", "env")
+
+	config, err := Load([]string{"-config", path, "-web-content-dir", "flag"})
+
+	if err != nil || config.ListenAddress() != ":9001" || config.WebContentDir() != "flag" {
+		t.Fail()
+	}
+}
+
+func TestConfigPathCanComeFromEnvironment(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"fromEnv"}`)
+	t.Setenv(configPathEnvName, path)
+
+	config, err := Load(nil)
+
+	if err != nil || config.SMDSId() != "fromEnv" {
+		t.Fail()
+	}
+}
+
+func TestDriverTypeOverrideOnlyChangesMountsWithoutAType(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","driverMounts":[{"name":"a"},{"name":"b","type":"pi","idOffset":100}]}`)
+
+	config, err := Load([]string{"-config", path, "-driver-type", "mock"})
+
+	if err != nil || config.DriverMounts()[0].Type != DriverTypeMock || config.DriverMounts()[1].Type != DriverTypePi {
+		t.Fail()
+	}
+}
+
+func TestLoadReportsEveryProblemTogether(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","listenAddress":"nope",
+		"driverMounts":[{"name":"a","type":"unknown"},{"name":"a","idOffset":4},{"name":"r","type":"replay","idOffset":200}]}`)
+	t.Setenv("SMDS_MOTOR_RUN_TIME_MILLIS", "soon")
+
+	_, err := Load([]string{"-config", path})
+
+	var configErr *ConfigError
+	//bad address, bad env value, unknown type, duplicate name, overlapping ids and missing replay path
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 6 {
+		t.Fail()
+	}
+}
+
+func TestLoadReportsUnreadableReplayPath(t *testing.T) {
+	replayPath := filepath.Join(t.TempDir(), "missing.smdsrec")
+	path := writeTestConfigFile(t, `{"id":"a","driverMounts":[{"name":"r","type":"replay","replayPath":"`+replayPath+`"}]}`)
+
+	_, err := Load([]string{"-config", path})
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+		t.Fail()
+	}
+}
+
+func TestLoadRejectsMountsNotStartingOnABoard(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","driverMounts":[{"name":"a","boards":1},{"name":"b","idOffset":6,"boards":1}]}`)
+
+	_, err := Load([]string{"-config", path})
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 1 {
+		t.Fail()
+	}
+}
+
+func TestLoadRejectsUnknownFieldsInFile(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","listenAdress":":9000"}`)
+
+	if _, err := Load([]string{"-config", path}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestLoadRejectsUnknownFlags(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a"}`)
+
+	if _, err := Load([]string{"-config", path, "-not-a-flag", "x"}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestLoadChecksLoggingSettings(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","logging":{"format":"xml","subsystems":{"hardware":"loud","nope":"debug"}}}`)
+
+	_, err := Load([]string{"-config", path, "-log-level", "quiet"})
+
+	var configErr *ConfigError
+	//bad format, bad default level, bad hardware level and unknown subsystem
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 4 {
+		t.Fail()
+	}
+}
+
+func TestConfigureLoggingAppliesLevels(t *testing.T) {
+	path := writeTestConfigFile(t, `{"id":"a","logging":{"level":"warn","subsystems":{"hardware":"debug"}}}`)
+	config, _ := Load([]string{"-config", path})
+
+	err := ConfigureLogging(config.Logging())
+
+	levels := logging.Levels()
+	if err != nil || levels[logging.SubsystemHardware] != logging.LevelDebug || levels[logging.SubsystemApi] != logging.LevelWarn {
+		t.Fail()
+	}
+	ConfigureLogging(LoggingConfig{Format: DefaultLogFormat, Level: DefaultLogLevel})
+}
@@ -0,0 +1,93 @@
+package smdsconfig
+
+//Schema is the JSON schema of the config file. Values left out use their defaults
+const Schema string = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "title": "Switch Machine Driver Server config",
+  "type": "object",
+  "additionalProperties": false,
+  "required": ["id"],
+  "properties": {
+    "id": {"type": "string", "minLength": 1, "description": "Id of this server, generated on first start"},
+    "listenAddress": {"type": "string", "default": ":8080", "description": "host:port the server listens on"},
+    "webContentDir": {"type": "string", "default": "web-content", "description": "Directory the web pages are served from"},
+    "calibrationFilePath": {"type": "string", "default": "calibration.json", "description": "File the switch machine wiring calibrations are saved in"},
+    "timings": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "busPollIntervalMillis": {"type": "integer", "minimum": 0, "description": "How often the bus is read, 0 uses the default"},
+        "motorRunTimeMillis": {"type": "integer", "minimum": 0, "description": "How long a motor is driven for each throw, 0 uses the default"}
+      }
+    },
+    "features": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "faultInjectionApi": {"type": "boolean", "description": "Defaults to on outside of production"},
+        "simulatorApi": {"type": "boolean", "default": true},
+        "mockRxDataApi": {"type": "boolean", "default": true},
+        "webContent": {"type": "boolean", "default": true},
+        "configFileWatch": {"type": "boolean", "default": true, "description": "Reload the config when its file changes"},
+        "configEditApi": {"type": "boolean", "description": "Allow the config to be changed through the api. Defaults to on outside of production"},
+        "systemdNotify": {"type": "boolean", "default": true, "description": "Tell systemd when the server is ready and feed its watchdog. Only does anything when run by systemd"}
+      }
+    },
+    "driverMounts": {
+      "type": "array",
+      "description": "Hardware drivers and where their switch machines sit in the id space. Defaults to one mount named main",
+      "items": {
+        "type": "object",
+        "additionalProperties": false,
+        "required": ["name"],
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "type": {"type": "string", "enum": ["", "pi", "mock", "simulator", "replay"], "description": "Empty lets the environment pick between pi and mock"},
+          "idOffset": {"type": "integer", "minimum": 0, "maximum": 65532, "multipleOf": 4, "description": "Starts on a board so board numbers line up with ids"},
+          "boards": {"type": "integer", "minimum": 0, "maximum": 8, "description": "0 means as many as a driver can control"},
+          "spiTxDevPath": {"type": "string"},
+          "spiRxDevPath": {"type": "string"},
+          "simulatedMachines": {"type": "integer", "minimum": 0},
+          "simulatedTravelTimeMillis": {"type": "integer", "minimum": 0},
+          "busRecordingPath": {"type": "string"},
+          "replayPath": {"type": "string"},
+          "replaySpeed": {"type": "number", "minimum": 0},
+          "verifyTx": {"type": "boolean"},
+          "txVerifyRetries": {"type": "integer", "minimum": 0},
+          "txRefreshIntervalMillis": {"type": "integer", "minimum": 0},
+          "maxQueuedUpdates": {"type": "integer", "minimum": 0}
+        }
+      }
+    },
+    "shutdown": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "timeoutMillis": {"type": "integer", "minimum": 0},
+        "throwWaitMillis": {"type": "integer", "description": "Below 0 brakes running throws straight away, 0 uses the default"},
+        "safeGPIO": {
+          "type": "object",
+          "additionalProperties": false,
+          "properties": {
+            "gpio0": {"type": "boolean"},
+            "gpio1": {"type": "boolean"}
+          }
+        },
+        "stateFilePath": {"type": "string"}
+      }
+    },
+    "logging": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "format": {"type": "string", "enum": ["text", "json"], "default": "text"},
+        "level": {"type": "string", "enum": ["debug", "info", "warn", "error"], "default": "info", "description": "Level of every subsystem not listed in subsystems"},
+        "subsystems": {
+          "type": "object",
+          "propertyNames": {"enum": ["api", "config", "controller", "hardware", "persistance"]},
+          "additionalProperties": {"type": "string", "enum": ["debug", "info", "warn", "error"]}
+        }
+      }
+    }
+  }
+}`
@@ -0,0 +1,45 @@
+package smdsconfig
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//Every field that can be in the config file has to be in the schema, and nothing else, or edits made from the schema
+//will be rejected
+func TestSchemaMatchesConfigFields(t *testing.T) {
+	schema := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(Schema), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSchemaProperties(t, "", schema, reflect.TypeOf(smdsConfig{}))
+}
+
+func checkSchemaProperties(t *testing.T, path string, schema map[string]interface{}, structType reflect.Type) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	if len(properties) != structType.NumField() {
+		t.Errorf("%s has %d properties in the schema but %d fields", path, len(properties), structType.NumField())
+	}
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		property, isInSchema := properties[name].(map[string]interface{})
+		if !isInSchema {
+			t.Errorf("%s.%s is missing from the schema", path, name)
+			continue
+		}
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice {
+			if fieldType.Kind() == reflect.Slice {
+				property, _ = property["items"].(map[string]interface{})
+			}
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct {
+			checkSchemaProperties(t, path+"."+name, property, fieldType)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package smdsconfig
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/controller/hardware/tortoise"
+	"github.com/ZacharyDuve/SwitchMachineDriverServer/app/logging"
+)
+
+//ConfigError lists everything wrong with the config so it can all be fixed at once
+type ConfigError struct {
+	Problems []string
+}
+
+func (this *ConfigError) Error() string {
+	return "Invalid configuration: " + strings.Join(this.Problems, "; ")
+}
+
+func validate(config *smdsConfig) []string {
+	problems := make([]string, 0)
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if config.Id == "" {
+		addProblem("id is required")
+	}
+	if _, port, err := net.SplitHostPort(config.ListenAddress()); err != nil {
+		addProblem("listenAddress %q is not a host:port", config.ListenAddress())
+	} else if portNum, err := strconv.ParseUint(port, 10, 16); err != nil || portNum == 0 {
+		addProblem("listenAddress %q needs a port between 1 and 65535", config.ListenAddress())
+	}
+	if config.Timings != nil {
+		if config.Timings.BusPollIntervalMillis < 0 {
+			addProblem("timings.busPollIntervalMillis can not be negative")
+		}
+		if config.Timings.MotorRunTimeMillis < 0 {
+			addProblem("timings.motorRunTimeMillis can not be negative")
+		}
+	}
+	if config.ShutdownSettings != nil && config.ShutdownSettings.TimeoutMillis < 0 {
+		addProblem("shutdown.timeoutMillis can not be negative")
+	}
+
+	validateLogging(config.Logging(), addProblem)
+
+	mounts := config.DriverMounts()
+	for i, curMount := range mounts {
+		validateMount(curMount, addProblem)
+		for _, otherMount := range mounts[:i] {
+			if curMount.Name == otherMount.Name {
+				addProblem("driver mount name %q is used more than once", curMount.Name)
+			}
+			if mountIdsOverlap(curMount, otherMount) {
+				addProblem("driver mounts %q and %q have overlapping ids", otherMount.Name, curMount.Name)
+			}
+		}
+	}
+	return problems
+}
+
+func validateMount(mount DriverMountConfig, addProblem func(string, ...interface{})) {
+	if mount.Name == "" {
+		addProblem("driver mounts need a name")
+	}
+	switch mount.Type {
+	case DriverTypeDefault, DriverTypePi, DriverTypeMock, DriverTypeSimulator:
+	case DriverTypeReplay:
+		if mount.ReplayPath == "" {
+			addProblem("driver mount %q is a replay so needs a replayPath", mount.Name)
+		} else if replayFile, err := os.Open(mount.ReplayPath); err != nil {
+			addProblem("driver mount %q replayPath can't be read: %v", mount.Name, err)
+		} else {
+			replayFile.Close()
+		}
+	default:
+		addProblem("driver mount %q has unknown type %q", mount.Name, mount.Type)
+	}
+	if uint(mount.Boards) > tortoise.MaxNumberAttachableMainControllerBoards {
+		addProblem("driver mount %q has %d boards but at most %d are supported", mount.Name, mount.Boards, tortoise.MaxNumberAttachableMainControllerBoards)
+	}
+	if uint(mount.SimulatedMachines) > mount.NumIds() {
+		addProblem("driver mount %q simulates %d machines but only has %d ids", mount.Name, mount.SimulatedMachines, mount.NumIds())
+	}
+	//Boards are numbered from ids so a mount starting part way through a board would share board numbers with another
+	if uint(mount.IdOffset)%tortoise.SwitchMachinesPerBoard != 0 {
+		addProblem("driver mount %q idOffset %d has to be a multiple of %d to start on a board", mount.Name, mount.IdOffset, tortoise.SwitchMachinesPerBoard)
+	}
+	if uint(mount.IdOffset)+mount.NumIds() > 1<<16 {
+		addProblem("driver mount %q ids go past the largest switch machine id", mount.Name)
+	}
+	if mount.ReplaySpeed < 0 {
+		addProblem("driver mount %q replaySpeed can not be negative", mount.Name)
+	}
+	if mount.TxVerifyRetries < 0 || mount.TxRefreshIntervalMillis < 0 || mount.MaxQueuedUpdates < 0 || mount.SimulatedTravelTimeMillis < 0 {
+		addProblem("driver mount %q has a negative value where only positive ones make sense", mount.Name)
+	}
+}
+
+func validateLogging(loggingSettings LoggingConfig, addProblem func(string, ...interface{})) {
+	if _, err := logging.ParseFormat(loggingSettings.Format); err != nil {
+		addProblem("logging.format %v", err)
+	}
+	if _, err := logging.ParseLevel(loggingSettings.Level); err != nil {
+		addProblem("logging.level %v", err)
+	}
+	for name, level := range loggingSettings.Subsystems {
+		if !logging.IsSubsystem(name) {
+			addProblem("logging.subsystems has unknown subsystem %q", name)
+		} else if _, err := logging.ParseLevel(level); err != nil {
+			addProblem("logging.subsystems.%s %v", name, err)
+		}
+	}
+}
+
+func mountIdsOverlap(m0, m1 DriverMountConfig) bool {
+	m0Start, m1Start := uint(m0.IdOffset), uint(m1.IdOffset)
+	return m0Start < m1Start+m1.NumIds() && m1Start < m0Start+m0.NumIds()
+}